@@ -3,12 +3,15 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"mad/middleware"
 	"mad/models"
+	"mad/models/email"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -151,3 +154,138 @@ func ToggleSignupStatusHandler(db *sql.DB) http.HandlerFunc {
 		})
 	}
 }
+
+// AdminRetryOutboxHandler puts a dead-lettered email back in the outbox queue
+func AdminRetryOutboxHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid email ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := email.RetryOutboxMessage(db, id); err != nil {
+			log.Printf("Error retrying outbox email %d: %v", id, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Email queued for another attempt",
+		})
+	}
+}
+
+// AdminSubscribeSegmentHandler enrolls every member of a segment in a campaign
+func AdminSubscribeSegmentHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		segmentID := r.FormValue("segment")
+		campaignID := r.FormValue("campaign")
+		enrolled, err := models.SubscribeSegment(db, segmentID, campaignID, time.Now())
+		if err != nil {
+			log.Printf("Error subscribing segment %s to campaign %s: %v", segmentID, campaignID, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"enrolled": enrolled,
+			"message":  fmt.Sprintf("Enrolled %d users in the campaign", enrolled),
+		})
+	}
+}
+
+// AdminLiftSuppressionHandler takes an address off the suppression list
+func AdminLiftSuppressionHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		address := r.FormValue("email")
+		if err := email.LiftSuppression(db, address); err != nil {
+			log.Printf("Error lifting suppression of %s: %v", address, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Lifted suppression of %s", address)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": fmt.Sprintf("%s will be sent email again", address),
+		})
+	}
+}
+
+// AdminSuppressHandler puts an address on the suppression list by hand
+func AdminSuppressHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		address := r.FormValue("email")
+		if err := email.Suppress(db, address, email.SuppressManual, r.FormValue("detail"), "admin"); err != nil {
+			log.Printf("Error suppressing %s: %v", address, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": fmt.Sprintf("%s won't be sent email", address),
+		})
+	}
+}
+
+// AdminTestTemplateHandler sends an email template, rendered with sample data, to the admin
+func AdminTestTemplateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		admin := middleware.GetUser(r)
+		if admin == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		svc, ok := emailService.(*email.SMTPEmailService)
+		if !ok || svc == nil {
+			http.Error(w, "Email service not available", http.StatusInternalServerError)
+			return
+		}
+
+		id := r.FormValue("id")
+		if err := svc.SendTemplateTest(admin.Email, id); err != nil {
+			log.Printf("Error sending test of template %s to %s: %v", id, admin.Email, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": fmt.Sprintf("Test sent to %s", admin.Email),
+		})
+	}
+}
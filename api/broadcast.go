@@ -0,0 +1,211 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mad/middleware"
+	"mad/models"
+	"mad/models/email"
+)
+
+// AdminPreviewBroadcastHandler renders a draft broadcast as the admin would get it and counts its recipients
+func AdminPreviewBroadcastHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		admin := middleware.GetUser(r)
+		if admin == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		svc, ok := emailService.(*email.SMTPEmailService)
+		if !ok || svc == nil {
+			http.Error(w, "Email service not available", http.StatusInternalServerError)
+			return
+		}
+
+		subject, body := r.FormValue("subject"), r.FormValue("body")
+		if err := email.ValidateBroadcast(subject, body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		recipients, err := models.GetBroadcastRecipients(db, r.FormValue("segment"), time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := models.BroadcastData(db, admin.ID, admin.Email, admin.FirstName, subject)
+		if err != nil {
+			log.Printf("Error preparing broadcast preview: %v", err)
+			http.Error(w, "Error rendering the preview", http.StatusInternalServerError)
+			return
+		}
+		msg, err := svc.Render(admin.Email, email.BroadcastTemplate(subject, body), data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"subject":    msg.Subject,
+			"html":       msg.HTML,
+			"text":       msg.Text,
+			"recipients": len(recipients),
+		})
+	}
+}
+
+// AdminTestBroadcastHandler emails a draft broadcast to the admin writing it
+func AdminTestBroadcastHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		admin := middleware.GetUser(r)
+		if admin == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if emailService == nil {
+			http.Error(w, "Email service not available", http.StatusInternalServerError)
+			return
+		}
+
+		if err := models.SendBroadcastTest(db, emailService, admin, r.FormValue("subject"), r.FormValue("body")); err != nil {
+			log.Printf("Error sending test broadcast to %s: %v", admin.Email, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": fmt.Sprintf("Test sent to %s", admin.Email),
+		})
+	}
+}
+
+// AdminScheduleBroadcastHandler schedules a broadcast for the time picked, given as a datetime-local value in
+// the admin's timezone, or for straight away when none is
+func AdminScheduleBroadcastHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		admin := middleware.GetUser(r)
+		if admin == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var sendAt time.Time
+		if value := r.FormValue("send_at"); value != "" {
+			loc, err := time.LoadLocation(admin.Timezone)
+			if err != nil {
+				loc = time.UTC
+			}
+			sendAt, err = time.ParseInLocation("2006-01-02T15:04", value, loc)
+			if err != nil {
+				http.Error(w, "Invalid send time", http.StatusBadRequest)
+				return
+			}
+		}
+
+		broadcast, err := models.ScheduleBroadcast(db, r.FormValue("subject"), r.FormValue("body"),
+			r.FormValue("segment"), sendAt, admin.ID, time.Now())
+		if err != nil {
+			log.Printf("Error scheduling broadcast: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		message := "Broadcast will start sending within a minute"
+		if !sendAt.IsZero() && broadcast.ScheduledAt.Equal(sendAt) {
+			message = fmt.Sprintf("Broadcast scheduled for %s", sendAt.Format("Mon 2 Jan 15:04 MST"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"id":      broadcast.ID,
+			"message": message,
+		})
+	}
+}
+
+// AdminCancelBroadcastHandler stops a broadcast that hasn't finished sending
+func AdminCancelBroadcastHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid broadcast ID", http.StatusBadRequest)
+			return
+		}
+		if err := models.CancelBroadcast(db, id, time.Now()); err != nil {
+			log.Printf("Error cancelling broadcast %d: %v", id, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Broadcast cancelled",
+		})
+	}
+}
+
+// AdminBroadcastProgressHandler returns the progress of the recent broadcasts, for the broadcasts page to
+// follow while they send
+func AdminBroadcastProgressHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		broadcasts, err := models.GetBroadcasts(db, 20)
+		if err != nil {
+			log.Printf("Error getting broadcasts: %v", err)
+			http.Error(w, "Error getting broadcasts", http.StatusInternalServerError)
+			return
+		}
+
+		progress := map[int64]interface{}{}
+		for _, b := range broadcasts {
+			progress[b.ID] = map[string]interface{}{
+				"status":   b.Status,
+				"total":    b.Total,
+				"queued":   b.Queued,
+				"sent":     b.Sent,
+				"failed":   b.Failed,
+				"skipped":  b.Skipped,
+				"progress": b.Progress(),
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"broadcasts": progress,
+		})
+	}
+}
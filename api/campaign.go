@@ -1,13 +1,16 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"mad/middleware"
+	"mad/models"
 	"mad/models/email"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -74,8 +77,15 @@ func checkRateLimit(ip, action string, limit int) bool {
 	return rateLimit[ip][action] <= limit
 }
 
-// SubscribeToCampaign handles POST /api/campaigns/subscribe
-func SubscribeToCampaign(w http.ResponseWriter, r *http.Request) {
+// SubscribeToCampaign handles POST /api/campaigns/subscribe. Addresses other than a signed-in user's verified
+// one are subscribed pending double opt-in, and get an email to confirm the subscription.
+func SubscribeToCampaign(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscribeToCampaign(w, r, db)
+	}
+}
+
+func subscribeToCampaign(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	// Rate limiting - 10 attempts per hour per IP
 	ip := middleware.GetIPAddress(r)
 	if !checkRateLimit(ip, "subscribe", 10) {
@@ -131,24 +141,43 @@ func SubscribeToCampaign(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get user ID if authenticated
+	// Get user ID if authenticated and subscribing their own address; anyone else's isn't tied to the account
 	userID := 0
-	if user := middleware.GetUser(r); user != nil {
+	user := middleware.GetUser(r)
+	if user != nil && strings.EqualFold(user.Email, req.Email) {
 		userID = int(user.ID)
 	}
 
-	// Subscribe the user to the campaign
-	err = campaignManager.SubscribeUser(req.Email, req.CampaignID, userID)
+	// A signed-in user's own verified address is already confirmed
+	if userID != 0 && user.EmailVerified {
+		err = campaignManager.SubscribeUser(strings.ToLower(req.Email), req.CampaignID, userID)
+		if err != nil {
+			log.Printf("Error subscribing user: %v", err)
+			http.Error(w, `{"error":"Failed to subscribe to campaign"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"success":true,"pending":false,"message":"Successfully subscribed to the campaign"}`)
+		return
+	}
+
+	err = models.SubscribeWithConfirmation(db, emailService, req.Email, req.FirstName, req.CampaignID, userID, time.Now())
+	if err == models.ErrTooManyVerificationEmails {
+		http.Error(w, `{"error":"We've already sent this address several confirmation emails today. Please check your inbox."}`, http.StatusTooManyRequests)
+		return
+	}
 	if err != nil {
-		log.Printf("Error subscribing user: %v", err)
+		log.Printf("Error subscribing %s pending confirmation: %v", req.Email, err)
 		http.Error(w, `{"error":"Failed to subscribe to campaign"}`, http.StatusInternalServerError)
 		return
 	}
 
-	// Return success response
-	w.WriteHeader(http.StatusCreated)
+	// Return the same response whether or not the address was already subscribed
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprint(w, `{"success":true,"message":"Successfully subscribed to the campaign"}`)
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(w, `{"success":true,"pending":true,"message":"Almost there! Check your email to confirm your subscription."}`)
 }
 
 // UnsubscribeFromCampaign handles POST /api/campaigns/unsubscribe
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"mad/models/email"
+)
+
+// maxFeedbackBody limits the size of a webhook post
+const maxFeedbackBody = 1 << 20
+
+// EmailFeedbackHandler receives bounces and complaints from the mail provider and suppresses the addresses.
+// The provider authenticates with EMAIL_WEBHOOK_SECRET, as a bearer token or the token query parameter, and
+// posts events in the format of email.WebhookEvent. Without a secret the webhook is off.
+func EmailFeedbackHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		secret := os.Getenv("EMAIL_WEBHOOK_SECRET")
+		if secret == "" {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFeedbackBody))
+		if err != nil {
+			http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		feedback, err := email.ParseWebhookEvents(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		suppressed, err := email.ProcessFeedback(db, feedback, "webhook")
+		if err != nil {
+			log.Printf("Error processing email feedback: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"received":   len(feedback),
+			"suppressed": suppressed,
+		})
+	}
+}
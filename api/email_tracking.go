@@ -0,0 +1,52 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"mad/models/email"
+)
+
+// transparentGIF is a 1x1 transparent GIF served as the open pixel
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// EmailOpenHandler serves the open pixel of a tracked campaign email and records the open. The pixel is
+// served either way so a bad link doesn't show a broken image.
+func EmailOpenHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendID, err := strconv.ParseInt(r.URL.Query().Get("s"), 10, 64)
+		if err == nil && email.ValidTrackingPixel(sendID, r.URL.Query().Get("sig")) {
+			if err := email.RecordEmailEvent(db, sendID, email.EventOpen, ""); err != nil {
+				log.Printf("Error recording email open: %v", err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "image/gif")
+		w.Header().Set("Cache-Control", "no-store, max-age=0")
+		w.Write(transparentGIF)
+	}
+}
+
+// EmailClickHandler records a click on a link in a tracked campaign email and redirects to the link. Only
+// signed links are followed, so it can't be used to redirect anywhere else.
+func EmailClickHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("u")
+		sendID, err := strconv.ParseInt(r.URL.Query().Get("s"), 10, 64)
+		if err != nil || !email.ValidTrackingClick(sendID, target, r.URL.Query().Get("sig")) {
+			http.Error(w, "Invalid link", http.StatusBadRequest)
+			return
+		}
+
+		if err := email.RecordEmailEvent(db, sendID, email.EventClick, target); err != nil {
+			log.Printf("Error recording email click: %v", err)
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
@@ -6,25 +6,33 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"mad/middleware"
 	"mad/models"
 )
 
 type CreateGoalRequest struct {
-	HabitID      int     `json:"habit_id"`
-	Name         string  `json:"name"`
-	StartDate    string  `json:"start_date"`
-	EndDate      string  `json:"end_date"`
-	TargetNumber float64 `json:"target_number"`
+	HabitID      int                    `json:"habit_id"`
+	Name         string                 `json:"name"`
+	StartDate    string                 `json:"start_date"`
+	EndDate      string                 `json:"end_date"`
+	TargetNumber float64                `json:"target_number"`
+	Kind         string                 `json:"kind"`
+	TargetOption *models.HabitOption    `json:"target_option"`
+	Comparison   string                 `json:"comparison"`
+	Recurrence   string                 `json:"recurrence"`
+	Habits       []models.GoalHabit     `json:"habits"` // several habits with weights; HabitID is used when empty
+	Milestones   []models.GoalMilestone `json:"milestones"`
 }
 
 type UpdateGoalRequest struct {
-	ID           int     `json:"id"`
-	Name         string  `json:"name"`
-	StartDate    string  `json:"start_date"`
-	EndDate      string  `json:"end_date"`
-	TargetNumber float64 `json:"target_number"`
+	ID           int                     `json:"id"`
+	Name         string                  `json:"name"`
+	StartDate    string                  `json:"start_date"`
+	EndDate      string                  `json:"end_date"`
+	TargetNumber float64                 `json:"target_number"`
+	Milestones   *[]models.GoalMilestone `json:"milestones"` // left unchanged when omitted
 }
 
 type ReorderGoalsRequest struct {
@@ -77,6 +85,19 @@ func CreateGoalHandler(db *sql.DB) http.HandlerFunc {
 			StartDate:    req.StartDate,
 			EndDate:      req.EndDate,
 			TargetNumber: req.TargetNumber,
+			Kind:         req.Kind,
+			TargetOption: req.TargetOption,
+			Comparison:   req.Comparison,
+			Recurrence:   req.Recurrence,
+			Habits:       req.Habits,
+			Milestones:   req.Milestones,
+		}
+
+		// Recurring goals can leave out the end date and get one period
+		if goal.Recurrence != "" && goal.EndDate == "" {
+			if start, err := time.Parse("2006-01-02", goal.StartDate); err == nil {
+				goal.EndDate = models.RecurrenceEndDate(goal.Recurrence, start).Format("2006-01-02")
+			}
 		}
 
 		// Validate the goal before creating it
@@ -109,8 +130,8 @@ func CreateGoalHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Calculate initial progress and status in memory
-		if err := goal.CalculateProgressInMemory(db); err != nil {
+		// Calculate and store the initial progress and status
+		if err := goal.CalculateProgress(db); err != nil {
 			log.Printf("Error calculating initial goal progress: %v", err)
 			// Don't fail the request, just log the error
 		}
@@ -160,6 +181,18 @@ func UpdateGoalHandler(db *sql.DB) http.HandlerFunc {
 		goal.StartDate = req.StartDate
 		goal.EndDate = req.EndDate
 		goal.TargetNumber = req.TargetNumber
+		if req.Milestones != nil {
+			goal.Milestones = *req.Milestones
+		}
+
+		if err := goal.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
 
 		if err := goal.Update(db); err != nil {
 			log.Printf("Error updating goal: %v", err)
@@ -171,6 +204,23 @@ func UpdateGoalHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		if req.Milestones != nil {
+			if err := goal.SaveMilestones(db); err != nil {
+				log.Printf("Error saving goal milestones: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(APIResponse{
+					Success: false,
+					Message: "Error saving milestones",
+				})
+				return
+			}
+		}
+
+		// Dates and target changed, so the stored progress is out of date
+		if err := goal.CalculateProgress(db); err != nil {
+			log.Printf("Error calculating goal progress: %v", err)
+		}
+
 		json.NewEncoder(w).Encode(APIResponse{
 			Success: true,
 			Message: "Goal updated successfully",
@@ -179,6 +229,108 @@ func UpdateGoalHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
+// GetGoalHistoryHandler returns every period of a recurring goal with the hit rate across finished periods
+func GetGoalHistoryHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		goalID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Invalid goal ID",
+			})
+			return
+		}
+
+		userID := middleware.GetUserID(r)
+		goal, err := models.GetGoal(db, goalID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Goal not found",
+			})
+			return
+		}
+
+		if goal.UserID != userID {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Unauthorized access to goal",
+			})
+			return
+		}
+
+		history, err := models.GetGoalHistory(db, goal, time.Now())
+		if err != nil {
+			log.Printf("Error getting goal history: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Error getting goal history",
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: true,
+			Data:    history,
+		})
+	}
+}
+
+// GetGoalProgressHandler returns the daily progress snapshots of a goal's current period with a projected completion date
+func GetGoalProgressHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		goalID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Invalid goal ID",
+			})
+			return
+		}
+
+		userID := middleware.GetUserID(r)
+		goal, err := models.GetGoal(db, goalID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Goal not found",
+			})
+			return
+		}
+
+		if goal.UserID != userID {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Unauthorized access to goal",
+			})
+			return
+		}
+
+		series, err := models.GetGoalProgressSeries(db, goal, time.Now())
+		if err != nil {
+			log.Printf("Error getting goal progress: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Error getting goal progress",
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: true,
+			Data:    series,
+		})
+	}
+}
+
 // DeleteGoalHandler deletes a goal
 func DeleteGoalHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
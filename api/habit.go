@@ -221,10 +221,120 @@ func DeleteHabitHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
+// idempotencyKeyHeader is the request header clients use to deduplicate replayed habit log writes
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds the size of client supplied idempotency keys
+const maxIdempotencyKeyLength = 128
+
+// writeHabitLogResponse writes a successful habit log response and stores it under the request's idempotency key
+func writeHabitLogResponse(w http.ResponseWriter, r *http.Request, db *sql.DB, response APIResponse) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error encoding habit log response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Message: "Error encoding response",
+		})
+		return
+	}
+
+	if key := r.Header.Get(idempotencyKeyHeader); key != "" {
+		if err := models.SaveHabitLogIdempotentResponse(db, middleware.GetUserID(r), key, string(body)); err != nil {
+			// The log is already saved; replays of the request get a conflict until the key expires
+			log.Printf("Error saving idempotency key: %v", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(body, '\n'))
+}
+
+// writeIdempotentReplay answers a request whose idempotency key was already used with the original response, or
+// with a conflict while the first request is still being saved
+func writeIdempotentReplay(w http.ResponseWriter, stored string) {
+	if stored == "" {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Message: "This request is still being processed",
+		})
+		return
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(stored))
+}
+
+// saveHabitLog creates or updates the log, claiming the request's idempotency key in the same transaction. It
+// writes the response and returns false when the log wasn't saved by this request.
+func saveHabitLog(w http.ResponseWriter, r *http.Request, db *sql.DB, habitLog *models.HabitLog) bool {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		err := habitLog.CreateOrUpdate(db)
+		if err == nil {
+			return true
+		}
+		log.Printf("Error saving habit log: %v", err)
+	} else {
+		claimed, stored, err := habitLog.CreateOrUpdateOnce(db, middleware.GetUserID(r), key)
+		if err == nil {
+			if !claimed {
+				writeIdempotentReplay(w, stored)
+			}
+			return claimed
+		}
+		log.Printf("Error saving habit log: %v", err)
+	}
+
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: false,
+		Message: "Error saving habit log",
+	})
+	return false
+}
+
+// recalculateGoalsAfterLogWrite updates the stored progress of the goals counting the habit and returns the
+// milestones the write completed. Failures are logged; the log itself has already been saved.
+func recalculateGoalsAfterLogWrite(db *sql.DB, habitID int) []models.MilestoneReached {
+	now := time.Now()
+	if err := models.RecalculateGoalsForHabit(db, habitID, now); err != nil {
+		log.Printf("Error recalculating goals for habit %d: %v", habitID, err)
+	}
+	milestones, err := models.CheckGoalMilestones(db, habitID, now)
+	if err != nil {
+		log.Printf("Error checking goal milestones: %v", err)
+	}
+	return milestones
+}
+
 // CreateOrUpdateHabitLogHandler handles creating or updating a habit log
 func CreateOrUpdateHabitLogHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
+		userID := middleware.GetUserID(r)
+
+		// Replayed requests (e.g. from the offline queue) return the original response
+		if key := r.Header.Get(idempotencyKeyHeader); key != "" {
+			if len(key) > maxIdempotencyKeyLength {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(APIResponse{
+					Success: false,
+					Message: "Idempotency key is too long",
+				})
+				return
+			}
+
+			stored, found, err := models.GetHabitLogIdempotentResponse(db, userID, key)
+			if err != nil {
+				log.Printf("Error looking up idempotency key: %v", err)
+			} else if found {
+				writeIdempotentReplay(w, stored)
+				return
+			}
+		}
 
 		// Parse request body
 		var request struct {
@@ -255,7 +365,6 @@ func CreateOrUpdateHabitLogHandler(db *sql.DB) http.HandlerFunc {
 		}
 
 		// Verify habit belongs to user
-		userID := middleware.GetUserID(r)
 		var habitUserID int
 		err = db.QueryRow("SELECT user_id FROM habits WHERE id = ?", request.HabitID).Scan(&habitUserID)
 		if err != nil || habitUserID != userID {
@@ -433,18 +542,13 @@ func CreateOrUpdateHabitLogHandler(db *sql.DB) http.HandlerFunc {
 				}
 				habitLog.Status = request.Status
 
-				if err := habitLog.CreateOrUpdate(db); err != nil {
-					log.Printf("Error saving habit log: %v", err)
-					w.WriteHeader(http.StatusInternalServerError)
-					json.NewEncoder(w).Encode(APIResponse{
-						Success: false,
-						Message: "Error saving habit log",
-					})
+				if !saveHabitLog(w, r, db, habitLog) {
 					return
 				}
 
-				w.WriteHeader(http.StatusOK)
-				json.NewEncoder(w).Encode(APIResponse{
+				recalculateGoalsAfterLogWrite(db, habitLog.HabitID)
+
+				writeHabitLogResponse(w, r, db, APIResponse{
 					Success: true,
 					Message: "Habit log saved successfully",
 					Data: SetRepsResponse{
@@ -538,21 +642,21 @@ func CreateOrUpdateHabitLogHandler(db *sql.DB) http.HandlerFunc {
 		}
 
 		// Create or update the log
-		if err := habitLog.CreateOrUpdate(db); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(APIResponse{
-				Success: false,
-				Message: "Error saving habit log",
-			})
+		if !saveHabitLog(w, r, db, habitLog) {
 			return
 		}
 
+		// Milestones reached by this log are returned so the client can celebrate them
+		milestones := recalculateGoalsAfterLogWrite(db, habitLog.HabitID)
+
 		// Return success response
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(APIResponse{
+		writeHabitLogResponse(w, r, db, APIResponse{
 			Success: true,
 			Message: "Habit log saved successfully",
-			Data:    habitLog,
+			Data: struct {
+				*models.HabitLog
+				MilestonesReached []models.MilestoneReached `json:"milestones_reached,omitempty"`
+			}{habitLog, milestones},
 		})
 	}
 }
@@ -900,6 +1004,8 @@ func DeleteHabitLogHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		recalculateGoalsAfterLogWrite(db, habitID)
+
 		// Return success response
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(APIResponse{
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"mad/middleware"
+	"mad/models"
+)
+
+// NotificationSettings is everything the notification preference center shows
+type NotificationSettings struct {
+	Preferences *models.NotificationPreferences `json:"preferences"`
+	Types       []models.NotificationType       `json:"types"`
+	Channels    []string                        `json:"channels"`
+	Campaigns   []models.CampaignPreference     `json:"campaigns"`
+}
+
+type UpdateCampaignPreferenceRequest struct {
+	CampaignID string `json:"campaign_id"`
+	Subscribed bool   `json:"subscribed"`
+}
+
+// GetNotificationSettings loads the preference center for the user
+func GetNotificationSettings(db *sql.DB, userID int, emailAddr string) (*NotificationSettings, error) {
+	prefs, err := models.GetNotificationPreferences(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	campaigns, err := models.GetCampaignPreferences(db, emailAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &NotificationSettings{
+		Preferences: prefs,
+		Types:       models.NotificationTypes,
+		Channels:    models.NotificationChannels,
+		Campaigns:   campaigns,
+	}, nil
+}
+
+// GetNotificationPreferencesHandler returns the user's notification preferences and email courses
+func GetNotificationPreferencesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := middleware.GetUserID(r)
+
+		user, err := models.GetUserByID(db, int64(userID))
+		if err != nil {
+			log.Printf("Error getting user: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Error getting notification preferences",
+			})
+			return
+		}
+
+		settings, err := GetNotificationSettings(db, userID, user.Email)
+		if err != nil {
+			log.Printf("Error getting notification preferences: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Error getting notification preferences",
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: true,
+			Data:    settings,
+		})
+	}
+}
+
+// UpdateNotificationPreferencesHandler replaces the user's notification preferences
+func UpdateNotificationPreferencesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var prefs models.NotificationPreferences
+		if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Invalid request format",
+			})
+			return
+		}
+		prefs.UserID = middleware.GetUserID(r)
+
+		if err := prefs.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+
+		if err := prefs.Save(db); err != nil {
+			log.Printf("Error saving notification preferences: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Error saving notification preferences",
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: true,
+			Message: "Notification preferences updated successfully",
+		})
+	}
+}
+
+// UpdateCampaignPreferenceHandler subscribes or unsubscribes the user from an email course
+func UpdateCampaignPreferenceHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req UpdateCampaignPreferenceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Invalid request format",
+			})
+			return
+		}
+
+		userID := middleware.GetUserID(r)
+		user, err := models.GetUserByID(db, int64(userID))
+		if err != nil {
+			log.Printf("Error getting user: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Error updating subscription",
+			})
+			return
+		}
+
+		if err := models.SetCampaignPreference(db, user.Email, req.CampaignID, userID, req.Subscribed); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: true,
+			Message: "Subscription updated successfully",
+		})
+	}
+}
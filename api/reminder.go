@@ -0,0 +1,236 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mad/middleware"
+	"mad/models"
+)
+
+type CreateReminderRequest struct {
+	HabitID *int   `json:"habit_id"` // omitted for the daily reminder of every included habit
+	Time    string `json:"time"`     // HH:MM in the user's timezone
+}
+
+type UpdateTimezoneRequest struct {
+	Timezone string `json:"timezone"`
+}
+
+type UpdateHabitReminderRequest struct {
+	HabitID int  `json:"habit_id"`
+	Enabled bool `json:"enabled"`
+}
+
+// ReminderSettings is everything the reminder settings need: the timezone, the reminders and which habits
+// the daily reminders include
+type ReminderSettings struct {
+	Timezone  string            `json:"timezone"`
+	Reminders []models.Reminder `json:"reminders"`
+	Habits    []models.Habit    `json:"habits"`
+}
+
+// GetRemindersHandler returns the user's reminder settings
+func GetRemindersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := middleware.GetUserID(r)
+
+		user, err := models.GetUserByID(db, int64(userID))
+		if err != nil {
+			log.Printf("Error getting user: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Error getting reminders",
+			})
+			return
+		}
+
+		reminders, err := models.GetRemindersByUser(db, userID)
+		if err != nil {
+			log.Printf("Error getting reminders: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Error getting reminders",
+			})
+			return
+		}
+
+		habits, err := models.GetHabitsByUserID(db, userID)
+		if err != nil {
+			log.Printf("Error getting habits: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Error getting reminders",
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: true,
+			Data: ReminderSettings{
+				Timezone:  user.Timezone,
+				Reminders: reminders,
+				Habits:    habits,
+			},
+		})
+	}
+}
+
+// CreateReminderHandler adds a daily or per-habit reminder
+func CreateReminderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateReminderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Invalid request format",
+			})
+			return
+		}
+
+		reminder := &models.Reminder{
+			UserID:  middleware.GetUserID(r),
+			HabitID: req.HabitID,
+			Time:    req.Time,
+		}
+		if err := reminder.Create(db, time.Now()); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: true,
+			Message: "Reminder created successfully",
+			Data:    reminder,
+		})
+	}
+}
+
+// DeleteReminderHandler removes one of the user's reminders
+func DeleteReminderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reminderID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Invalid reminder ID",
+			})
+			return
+		}
+
+		reminder := &models.Reminder{ID: reminderID, UserID: middleware.GetUserID(r)}
+		if err := reminder.Delete(db); err != nil {
+			if err == sql.ErrNoRows {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(APIResponse{
+					Success: false,
+					Message: "Reminder not found",
+				})
+				return
+			}
+			log.Printf("Error deleting reminder: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Error deleting reminder",
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: true,
+			Message: "Reminder deleted successfully",
+		})
+	}
+}
+
+// UpdateTimezoneHandler sets the user's timezone and reschedules their reminders
+func UpdateTimezoneHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req UpdateTimezoneRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Invalid request format",
+			})
+			return
+		}
+
+		if err := models.ValidateTimezone(req.Timezone); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+
+		if err := models.SetUserTimezone(db, middleware.GetUserID(r), req.Timezone, time.Now()); err != nil {
+			log.Printf("Error updating timezone: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Error updating timezone",
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: true,
+			Message: "Timezone updated successfully",
+		})
+	}
+}
+
+// UpdateHabitReminderHandler sets whether a habit is included in the user's daily reminders
+func UpdateHabitReminderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req UpdateHabitReminderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Invalid request format",
+			})
+			return
+		}
+
+		err := models.SetHabitReminderEnabled(db, middleware.GetUserID(r), req.HabitID, req.Enabled)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(APIResponse{
+					Success: false,
+					Message: "Habit not found",
+				})
+				return
+			}
+			log.Printf("Error updating habit reminder: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Message: "Error updating habit reminder",
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: true,
+			Message: "Habit reminder updated successfully",
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"mad/middleware"
 	"mad/models"
@@ -84,3 +85,276 @@ func HandleGetHabitStats(db *sql.DB) http.HandlerFunc {
 		sendResponse(http.StatusOK, true, "", stats)
 	}
 }
+
+// HandleQueryHabitStats returns time series stats for a habit over a date range,
+// bucketed by granularity and optionally compared against another period
+func HandleQueryHabitStats(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		sendResponse := func(status int, success bool, message string, data interface{}) {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: success,
+				Message: message,
+				Data:    data,
+			})
+		}
+
+		query := r.URL.Query()
+
+		habitID, err := strconv.Atoi(query.Get("id"))
+		if err != nil {
+			sendResponse(http.StatusBadRequest, false, "Invalid habit ID", nil)
+			return
+		}
+
+		// Verify habit belongs to user
+		userID := middleware.GetUserID(r)
+		var habitUserID int
+		err = db.QueryRow("SELECT user_id FROM habits WHERE id = ?", habitID).Scan(&habitUserID)
+		if err == sql.ErrNoRows {
+			sendResponse(http.StatusNotFound, false, "Habit not found", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting habit user ID: %v", err)
+			sendResponse(http.StatusInternalServerError, false, "Error getting habit", nil)
+			return
+		}
+		if habitUserID != userID {
+			sendResponse(http.StatusForbidden, false, "Unauthorized access to habit", nil)
+			return
+		}
+
+		granularity, err := models.ParseStatsGranularity(query.Get("granularity"))
+		if err != nil {
+			sendResponse(http.StatusBadRequest, false, "Invalid granularity. Use day, week, month or year", nil)
+			return
+		}
+
+		// Default to the last 30 days
+		to := time.Now().UTC().Truncate(24 * time.Hour)
+		if value := query.Get("to"); value != "" {
+			if to, err = time.Parse("2006-01-02", value); err != nil {
+				sendResponse(http.StatusBadRequest, false, "Invalid to date format. Use YYYY-MM-DD", nil)
+				return
+			}
+		}
+		from := to.AddDate(0, 0, -29)
+		if value := query.Get("from"); value != "" {
+			if from, err = time.Parse("2006-01-02", value); err != nil {
+				sendResponse(http.StatusBadRequest, false, "Invalid from date format. Use YYYY-MM-DD", nil)
+				return
+			}
+		}
+
+		statsQuery := models.StatsQuery{
+			HabitID:     habitID,
+			From:        from,
+			To:          to,
+			Granularity: granularity,
+		}
+
+		// The comparison period is either a preset or an explicit range
+		switch compare := query.Get("compare"); compare {
+		case "":
+			if query.Get("compare_from") != "" || query.Get("compare_to") != "" {
+				compareFrom, err := time.Parse("2006-01-02", query.Get("compare_from"))
+				if err != nil {
+					sendResponse(http.StatusBadRequest, false, "Invalid compare_from date format. Use YYYY-MM-DD", nil)
+					return
+				}
+				compareTo, err := time.Parse("2006-01-02", query.Get("compare_to"))
+				if err != nil {
+					sendResponse(http.StatusBadRequest, false, "Invalid compare_to date format. Use YYYY-MM-DD", nil)
+					return
+				}
+				statsQuery.CompareFrom = &compareFrom
+				statsQuery.CompareTo = &compareTo
+			}
+		case "previous":
+			compareFrom, compareTo := models.PreviousPeriod(from, to)
+			statsQuery.CompareFrom = &compareFrom
+			statsQuery.CompareTo = &compareTo
+		case "previous_year":
+			compareFrom, compareTo := from.AddDate(-1, 0, 0), to.AddDate(-1, 0, 0)
+			statsQuery.CompareFrom = &compareFrom
+			statsQuery.CompareTo = &compareTo
+		default:
+			sendResponse(http.StatusBadRequest, false, "Invalid compare value. Use previous or previous_year", nil)
+			return
+		}
+
+		if err := statsQuery.Validate(); err != nil {
+			sendResponse(http.StatusBadRequest, false, err.Error(), nil)
+			return
+		}
+
+		result, err := models.QueryHabitStats(db, statsQuery)
+		if err != nil {
+			log.Printf("Error querying habit stats: %v", err)
+			sendResponse(http.StatusInternalServerError, false, "Error getting habit stats", nil)
+			return
+		}
+
+		sendResponse(http.StatusOK, true, "", result)
+	}
+}
+
+// HandleGetHabitPatterns returns day-of-week and time-of-day patterns for a habit
+func HandleGetHabitPatterns(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		sendResponse := func(status int, success bool, message string, data interface{}) {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: success,
+				Message: message,
+				Data:    data,
+			})
+		}
+
+		habitID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			sendResponse(http.StatusBadRequest, false, "Invalid habit ID", nil)
+			return
+		}
+
+		// Verify habit belongs to user
+		userID := middleware.GetUserID(r)
+		var habitUserID int
+		err = db.QueryRow("SELECT user_id FROM habits WHERE id = ?", habitID).Scan(&habitUserID)
+		if err == sql.ErrNoRows {
+			sendResponse(http.StatusNotFound, false, "Habit not found", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting habit user ID: %v", err)
+			sendResponse(http.StatusInternalServerError, false, "Error getting habit", nil)
+			return
+		}
+		if habitUserID != userID {
+			sendResponse(http.StatusForbidden, false, "Unauthorized access to habit", nil)
+			return
+		}
+
+		patterns, err := models.GetHabitPatterns(db, habitID, time.Now())
+		if err != nil {
+			log.Printf("Error getting habit patterns: %v", err)
+			sendResponse(http.StatusInternalServerError, false, "Error getting habit patterns", nil)
+			return
+		}
+
+		sendResponse(http.StatusOK, true, "", patterns)
+	}
+}
+
+// HandleGetHabitCorrelations returns statistically meaningful relationships between the user's habits
+func HandleGetHabitCorrelations(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		sendResponse := func(status int, success bool, message string, data interface{}) {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: success,
+				Message: message,
+				Data:    data,
+			})
+		}
+
+		query := r.URL.Query()
+		var err error
+
+		// Default to the year up to yesterday; today may not be logged yet
+		to := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+		if value := query.Get("to"); value != "" {
+			if to, err = time.Parse("2006-01-02", value); err != nil {
+				sendResponse(http.StatusBadRequest, false, "Invalid to date format. Use YYYY-MM-DD", nil)
+				return
+			}
+		}
+		from := to.AddDate(-1, 0, 1)
+		if value := query.Get("from"); value != "" {
+			if from, err = time.Parse("2006-01-02", value); err != nil {
+				sendResponse(http.StatusBadRequest, false, "Invalid from date format. Use YYYY-MM-DD", nil)
+				return
+			}
+		}
+		if to.Before(from) {
+			sendResponse(http.StatusBadRequest, false, "to date must not be before from date", nil)
+			return
+		}
+
+		correlations, err := models.GetHabitCorrelations(db, middleware.GetUserID(r), from, to)
+		if err != nil {
+			log.Printf("Error getting habit correlations: %v", err)
+			sendResponse(http.StatusInternalServerError, false, "Error getting habit correlations", nil)
+			return
+		}
+
+		sendResponse(http.StatusOK, true, "", correlations)
+	}
+}
+
+// HandleGetStrengthStats returns personal records, 1RM trends, weekly volume and an overload suggestion for a set-reps habit
+func HandleGetStrengthStats(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		sendResponse := func(status int, success bool, message string, data interface{}) {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: success,
+				Message: message,
+				Data:    data,
+			})
+		}
+
+		habitID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			sendResponse(http.StatusBadRequest, false, "Invalid habit ID", nil)
+			return
+		}
+
+		unit := r.URL.Query().Get("unit")
+		if !models.IsValidWeightUnit(unit) {
+			sendResponse(http.StatusBadRequest, false, "Invalid unit. Use kg or lbs", nil)
+			return
+		}
+
+		// Verify habit belongs to user
+		userID := middleware.GetUserID(r)
+		var habitUserID int
+		var habitType models.HabitType
+		err = db.QueryRow("SELECT user_id, habit_type FROM habits WHERE id = ?", habitID).Scan(&habitUserID, &habitType)
+		if err == sql.ErrNoRows {
+			sendResponse(http.StatusNotFound, false, "Habit not found", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting habit user ID: %v", err)
+			sendResponse(http.StatusInternalServerError, false, "Error getting habit", nil)
+			return
+		}
+		if habitUserID != userID {
+			sendResponse(http.StatusForbidden, false, "Unauthorized access to habit", nil)
+			return
+		}
+		if habitType != models.SetRepsHabit {
+			sendResponse(http.StatusBadRequest, false, "Strength stats are only available for set-reps habits", nil)
+			return
+		}
+
+		stats, err := models.GetStrengthStats(db, habitID, unit)
+		if err != nil {
+			log.Printf("Error getting strength stats: %v", err)
+			sendResponse(http.StatusInternalServerError, false, "Error getting strength stats", nil)
+			return
+		}
+
+		sendResponse(http.StatusOK, true, "", stats)
+	}
+}
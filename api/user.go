@@ -236,6 +236,15 @@ func RegisterHandler(db *sql.DB, tmpl *template.Template) http.HandlerFunc {
 
 		log.Println("User registered successfully:", email)
 
+		// Reminders and campaign emails wait until the address is verified
+		if emailService != nil {
+			go func() {
+				if err := models.SendAccountVerification(db, emailService, user.ID, time.Now()); err != nil {
+					log.Printf("Failed to send verification email to %s: %v", email, err)
+				}
+			}()
+		}
+
 		// Auto-subscribe user to campaigns
 		if emailService != nil {
 			go func() {
@@ -362,6 +371,7 @@ func UpdateProfileHandler(db *sql.DB) http.HandlerFunc {
 		}
 
 		// Update user information
+		previousEmail := user.Email
 		user.FirstName = r.FormValue("first_name")
 		user.LastName = r.FormValue("last_name")
 		user.Email = r.FormValue("email")
@@ -378,11 +388,61 @@ func UpdateProfileHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		if !strings.EqualFold(user.Email, previousEmail) && emailService != nil {
+			if err := models.SendAccountVerification(db, emailService, user.ID, time.Now()); err != nil {
+				log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+			}
+			middleware.SetFlash(r, "Profile updated! Check your inbox to verify your new email address ✉️")
+			http.Redirect(w, r, "/settings", http.StatusSeeOther)
+			return
+		}
+
 		middleware.SetFlash(r, "Profile updated successfully! ✨")
 		http.Redirect(w, r, "/settings", http.StatusSeeOther)
 	}
 }
 
+// ResendVerificationHandler emails the signed-in user a new link to verify their email address
+func ResendVerificationHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID := middleware.GetUserID(r)
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if emailService == nil {
+			http.Error(w, "Email service not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		err := models.SendAccountVerification(db, emailService, int64(userID), time.Now())
+		if err == models.ErrTooManyVerificationEmails {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": "You've had the most verification emails we send in a day. Please check your inbox, including spam.",
+			})
+			return
+		}
+		if err != nil {
+			log.Printf("Error resending verification email to user %d: %v", userID, err)
+			http.Error(w, "Error sending verification email", http.StatusInternalServerError)
+			return
+		}
+
+		respondJSON(w, map[string]interface{}{
+			"success": true,
+			"message": "Verification email sent. Please check your inbox.",
+		})
+	}
+}
+
 // UpdatePasswordHandler handles password updates
 func UpdatePasswordHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -552,12 +612,17 @@ func UpdateSettingsHandler(db *sql.DB) http.HandlerFunc {
 			ShowConfetti        bool `json:"showConfetti"`
 			ShowWeekdays        bool `json:"showWeekdays"`
 			NotificationEnabled bool `json:"notificationEnabled"`
+			GoalDeadlineDays    int  `json:"goalDeadlineDays"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
 			log.Printf("Error decoding settings JSON: %v", err)
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
+		if settings.GoalDeadlineDays < 1 || settings.GoalDeadlineDays > 30 {
+			http.Error(w, "Goal deadline days must be between 1 and 30", http.StatusBadRequest)
+			return
+		}
 
 		log.Printf("Updating settings for user %d: confetti=%v, weekdays=%v, notifications=%v",
 			userID, settings.ShowConfetti, settings.ShowWeekdays, settings.NotificationEnabled)
@@ -565,9 +630,10 @@ func UpdateSettingsHandler(db *sql.DB) http.HandlerFunc {
 		// Update settings in database
 		result, err := db.Exec(`
 			UPDATE users 
-			SET show_confetti = ?, show_weekdays = ?, notification_enabled = ?
+			SET show_confetti = ?, show_weekdays = ?, notification_enabled = ?, goal_deadline_days = ?
 			WHERE id = ?
-		`, settings.ShowConfetti, settings.ShowWeekdays, settings.NotificationEnabled, userID)
+		`, settings.ShowConfetti, settings.ShowWeekdays, settings.NotificationEnabled,
+			settings.GoalDeadlineDays, userID)
 
 		if err != nil {
 			log.Printf("Error updating settings in database: %v", err)
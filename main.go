@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"mad/api"
+	"mad/masterclass"
 	"mad/middleware"
 	"mad/models"
 	"mad/models/email"
@@ -67,18 +68,26 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Load the email campaigns, refusing to start with broken ones, and pick up changes to them
+	if err := email.LoadCampaigns("./ui/email"); err != nil {
+		log.Fatalf("Invalid email campaigns: %v", err)
+	}
+	email.WatchCampaigns("./ui/email", 10*time.Second)
+
+	// Lifecycle triggers need the size of each masterclass module to tell when one is finished
+	moduleLessons := map[string]int{}
+	for _, module := range masterclass.GetCourseStructure() {
+		moduleLessons[module.Slug] = len(module.Lessons)
+	}
+	models.SetCourseModules(moduleLessons)
+
+	// Signed links in emails have to survive a restart
+	if err := email.CheckSigningKey(); err != nil {
+		log.Fatal(err)
+	}
+
 	// Initialize email service
-	emailService, err := email.NewSMTPEmailService(email.SMTPConfig{
-		Host:        os.Getenv("SMTP_HOST"),
-		Port:        587, // Default SMTP port
-		Username:    os.Getenv("SMTP_USERNAME"),
-		Password:    os.Getenv("SMTP_PASSWORD"),
-		FromName:    os.Getenv("SMTP_FROM_NAME"),
-		FromEmail:   os.Getenv("SMTP_FROM_EMAIL"),
-		TemplateDir: "./ui/email",
-		Secure:      true,
-		RequireTLS:  true,
-	})
+	emailService, err := email.NewSMTPEmailService(email.SMTPConfigFromEnv("./ui/email"))
 	if err != nil {
 		log.Printf("Warning: Could not initialize email service: %v", err)
 	}
@@ -93,6 +102,17 @@ func main() {
 		// Set the campaign manager in the email service if it's the SMTP implementation
 		if smtpService, ok := emailService.(*email.SMTPEmailService); ok {
 			smtpService.SetCampaignManager(campaignManager)
+
+			// Never mail addresses that hard-bounced or complained
+			smtpService.SetSuppressionList(db)
+
+			// Queue all email so failed deliveries are retried instead of lost
+			outbox := email.NewOutbox(db, smtpService.Deliver, email.DefaultOutboxConfig())
+			if err := outbox.Start(); err != nil {
+				log.Printf("Warning: Could not start email outbox, sending directly: %v", err)
+			} else {
+				smtpService.SetOutbox(outbox)
+			}
 		}
 	}
 
@@ -307,7 +327,7 @@ func main() {
 	}))))
 
 	// Campaign API handlers
-	http.Handle("/api/campaigns/subscribe", middleware.SessionManager.LoadAndSave(http.HandlerFunc(api.SubscribeToCampaign)))
+	http.Handle("/api/campaigns/subscribe", middleware.SessionManager.LoadAndSave(api.SubscribeToCampaign(db)))
 	http.Handle("/api/campaigns/unsubscribe", middleware.SessionManager.LoadAndSave(http.HandlerFunc(api.UnsubscribeFromCampaign)))
 	http.Handle("/api/campaigns/subscriptions", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(api.GetSubscriptions))))
 	http.Handle("/api/campaigns/preferences", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(api.UpdateSubscriptionPreferences))))
@@ -331,6 +351,34 @@ func main() {
 	}))))
 
 	http.Handle("/api/habits/stats", middleware.SessionManager.LoadAndSave(http.HandlerFunc(api.HandleGetHabitStats(db))))
+	http.Handle("/api/habits/stats/query", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			handleNotAllowed(w, http.MethodGet)
+			return
+		}
+		api.HandleQueryHabitStats(db)(w, r)
+	}))))
+	http.Handle("/api/habits/patterns", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			handleNotAllowed(w, http.MethodGet)
+			return
+		}
+		api.HandleGetHabitPatterns(db)(w, r)
+	}))))
+	http.Handle("/api/habits/correlations", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			handleNotAllowed(w, http.MethodGet)
+			return
+		}
+		api.HandleGetHabitCorrelations(db)(w, r)
+	}))))
+	http.Handle("/api/habits/strength", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			handleNotAllowed(w, http.MethodGet)
+			return
+		}
+		api.HandleGetStrengthStats(db)(w, r)
+	}))))
 
 	// Habit Name Update
 	http.Handle("/api/habits/update-name", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -399,6 +447,76 @@ func main() {
 		api.UpdateGoalHandler(db)(w, r)
 	}))))
 
+	http.Handle("/api/goals/history", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			handleNotAllowed(w, http.MethodGet)
+			return
+		}
+		api.GetGoalHistoryHandler(db)(w, r)
+	}))))
+
+	http.Handle("/api/goals/progress", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			handleNotAllowed(w, http.MethodGet)
+			return
+		}
+		api.GetGoalProgressHandler(db)(w, r)
+	}))))
+
+	http.Handle("/api/reminders", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			api.GetRemindersHandler(db)(w, r)
+		case http.MethodPost:
+			api.CreateReminderHandler(db)(w, r)
+		default:
+			handleNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	}))))
+
+	http.Handle("/api/reminders/delete", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			handleNotAllowed(w, http.MethodDelete)
+			return
+		}
+		api.DeleteReminderHandler(db)(w, r)
+	}))))
+
+	http.Handle("/api/reminders/timezone", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			handleNotAllowed(w, http.MethodPut)
+			return
+		}
+		api.UpdateTimezoneHandler(db)(w, r)
+	}))))
+
+	http.Handle("/api/reminders/habits", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			handleNotAllowed(w, http.MethodPut)
+			return
+		}
+		api.UpdateHabitReminderHandler(db)(w, r)
+	}))))
+
+	http.Handle("/api/notifications/preferences", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			api.GetNotificationPreferencesHandler(db)(w, r)
+		case http.MethodPut:
+			api.UpdateNotificationPreferencesHandler(db)(w, r)
+		default:
+			handleNotAllowed(w, http.MethodGet, http.MethodPut)
+		}
+	}))))
+
+	http.Handle("/api/notifications/campaigns", middleware.SessionManager.LoadAndSave(middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			handleNotAllowed(w, http.MethodPut)
+			return
+		}
+		api.UpdateCampaignPreferenceHandler(db)(w, r)
+	}))))
+
 	// Unsubscribe handler - Now moved to web/unsubscribe_handler.go
 
 	// Changelog route is now in web/routes.go
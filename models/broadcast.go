@@ -0,0 +1,400 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"mad/models/email"
+)
+
+// Broadcast statuses
+const (
+	BroadcastScheduled = "scheduled"
+	BroadcastSending   = "sending"
+	BroadcastSent      = "sent"
+	BroadcastCancelled = "cancelled"
+)
+
+// broadcastCampaignSegment prefixes the IDs of the segments of each campaign's subscribers
+const broadcastCampaignSegment = "campaign:"
+
+// Broadcast is a one-off announcement emailed to a segment. Recipients are picked when it starts sending,
+// with one broadcast_sends row each recording how their email went.
+type Broadcast struct {
+	ID          int64
+	Subject     string
+	Body        string // markdown
+	SegmentID   string
+	SegmentName string
+	Status      string
+	ScheduledAt time.Time
+	CreatedBy   sql.NullInt64
+	CreatedAt   time.Time
+	StartedAt   sql.NullTime
+	CompletedAt sql.NullTime
+
+	Total   int
+	Pending int
+	Queued  int // waiting in the outbox
+	Sent    int
+	Failed  int
+	Skipped int // suppressed addresses and cancelled sends
+}
+
+// Progress returns the percentage of recipients the broadcast is done with, counting queued emails as not done
+func (b Broadcast) Progress() float64 {
+	if b.Total == 0 {
+		return 0
+	}
+	return float64(b.Total-b.Pending-b.Queued) / float64(b.Total) * 100
+}
+
+// broadcastSend is a recipient of a broadcast still to be emailed
+type broadcastSend struct {
+	ID        int64
+	UserID    sql.NullInt64
+	Email     string
+	FirstName string
+	Subject   string
+	Body      string
+}
+
+// BroadcastSegments returns the segments broadcasts can go to: the lifecycle segments, then the users subscribed
+// to each campaign. Subscribers without an account are left out, as they have no preferences to leave
+// announcements with.
+func BroadcastSegments() []email.Segment {
+	segments := email.GetSegments()
+	for _, campaign := range email.GetAllCampaigns() {
+		segments = append(segments, email.Segment{
+			ID:          broadcastCampaignSegment + campaign.ID,
+			Name:        fmt.Sprintf("%s %s subscribers with an account", campaign.Emoji, campaign.Name),
+			Description: fmt.Sprintf("Users subscribed to %s. Subscribers without an account aren't included.", campaign.Name),
+			Rules:       email.SegmentRules{SubscribedTo: campaign.ID},
+		})
+	}
+	return segments
+}
+
+// GetBroadcastSegment returns a segment broadcasts can go to by ID or an error if not found
+func GetBroadcastSegment(segmentID string) (email.Segment, error) {
+	for _, segment := range BroadcastSegments() {
+		if segment.ID == segmentID {
+			return segment, nil
+		}
+	}
+	return email.Segment{}, fmt.Errorf("segment with ID %s not found", segmentID)
+}
+
+// GetBroadcastRecipients returns the members of the segment a broadcast would go to now: those with a
+// verified address who haven't turned announcement emails off
+func GetBroadcastRecipients(db *sql.DB, segmentID string, now time.Time) ([]SegmentMember, error) {
+	segment, err := GetBroadcastSegment(segmentID)
+	if err != nil {
+		return nil, err
+	}
+	members, err := GetSegmentMembers(db, segment, now)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := []SegmentMember{}
+	for _, member := range members {
+		if !member.EmailVerified {
+			continue
+		}
+		prefs, err := GetNotificationPreferences(db, int(member.UserID))
+		if err != nil {
+			return nil, err
+		}
+		if prefs.Allows(NotificationAnnouncement, ChannelEmail) {
+			recipients = append(recipients, member)
+		}
+	}
+	return recipients, nil
+}
+
+// BroadcastData returns the data a broadcast is rendered with for a recipient, with a link to their
+// notification preferences when they have an account
+func BroadcastData(db *sql.DB, userID int64, emailAddr, firstName, subject string) (map[string]interface{}, error) {
+	preferencesLink := ""
+	if userID > 0 {
+		token, err := PreferencesToken(db, int(userID))
+		if err != nil {
+			return nil, err
+		}
+		preferencesLink = email.GeneratePreferencesLink(emailAddr, token)
+	}
+	return email.BroadcastEmailData(firstName, emailAddr, subject, preferencesLink), nil
+}
+
+// ScheduleBroadcast saves a broadcast to be sent to the segment at scheduledAt, or straight away when it's
+// zero. The message has to render first.
+func ScheduleBroadcast(db *sql.DB, subject, body, segmentID string, scheduledAt time.Time, createdBy int64, now time.Time) (*Broadcast, error) {
+	subject = strings.TrimSpace(subject)
+	if err := email.ValidateBroadcast(subject, body); err != nil {
+		return nil, err
+	}
+	segment, err := GetBroadcastSegment(segmentID)
+	if err != nil {
+		return nil, err
+	}
+	if scheduledAt.IsZero() || scheduledAt.Before(now) {
+		scheduledAt = now
+	}
+	var createdByValue sql.NullInt64
+	if createdBy > 0 {
+		createdByValue = sql.NullInt64{Int64: createdBy, Valid: true}
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO broadcasts (subject, body, segment_id, status, scheduled_at, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		subject, body, segmentID, BroadcastScheduled, scheduledAt.UTC(), createdByValue, now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error saving broadcast: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Scheduled broadcast %d %q to %s for %s", id, subject, segmentID, scheduledAt.Format(time.RFC3339))
+	return &Broadcast{
+		ID:          id,
+		Subject:     subject,
+		Body:        body,
+		SegmentID:   segmentID,
+		SegmentName: segment.Name,
+		Status:      BroadcastScheduled,
+		ScheduledAt: scheduledAt,
+		CreatedBy:   createdByValue,
+		CreatedAt:   now,
+	}, nil
+}
+
+// CancelBroadcast stops a broadcast that hasn't finished sending. Recipients already emailed stay sent.
+func CancelBroadcast(db *sql.DB, id int64, now time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE broadcasts SET status = ?, completed_at = ?
+		WHERE id = ? AND status IN (?, ?)`,
+		BroadcastCancelled, now.UTC(), id, BroadcastScheduled, BroadcastSending)
+	if err != nil {
+		return fmt.Errorf("error cancelling broadcast: %v", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return errors.New("only scheduled or sending broadcasts can be cancelled")
+	}
+	_, err = tx.Exec("UPDATE broadcast_sends SET status = 'cancelled' WHERE broadcast_id = ? AND status = 'pending'", id)
+	if err != nil {
+		return fmt.Errorf("error cancelling broadcast sends: %v", err)
+	}
+	return tx.Commit()
+}
+
+// GetBroadcasts returns the most recent broadcasts with their progress, newest first
+func GetBroadcasts(db *sql.DB, limit int) ([]Broadcast, error) {
+	rows, err := db.Query(`
+		SELECT b.id, b.subject, b.body, b.segment_id, b.status, b.scheduled_at, b.created_by, b.created_at,
+			b.started_at, b.completed_at,
+			COUNT(s.id),
+			COALESCE(SUM(s.status = 'pending'), 0),
+			COALESCE(SUM(s.status = 'queued'), 0),
+			COALESCE(SUM(s.status = 'sent'), 0),
+			COALESCE(SUM(s.status = 'failed'), 0),
+			COALESCE(SUM(s.status IN ('skipped', 'cancelled')), 0)
+		FROM broadcasts b
+		LEFT JOIN broadcast_sends s ON s.broadcast_id = b.id
+		GROUP BY b.id
+		ORDER BY b.id DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error getting broadcasts: %v", err)
+	}
+	defer rows.Close()
+
+	broadcasts := []Broadcast{}
+	for rows.Next() {
+		var b Broadcast
+		err := rows.Scan(&b.ID, &b.Subject, &b.Body, &b.SegmentID, &b.Status, &b.ScheduledAt, &b.CreatedBy,
+			&b.CreatedAt, &b.StartedAt, &b.CompletedAt, &b.Total, &b.Pending, &b.Queued, &b.Sent, &b.Failed, &b.Skipped)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning broadcast: %v", err)
+		}
+		b.SegmentName = b.SegmentID
+		if segment, err := GetBroadcastSegment(b.SegmentID); err == nil {
+			b.SegmentName = segment.Name
+		}
+		broadcasts = append(broadcasts, b)
+	}
+	return broadcasts, rows.Err()
+}
+
+// StartDueBroadcasts picks the recipients of the broadcasts scheduled for now or earlier and marks them
+// sending. It returns the number of broadcasts started.
+func StartDueBroadcasts(db *sql.DB, now time.Time) (int, error) {
+	rows, err := db.Query("SELECT id, segment_id FROM broadcasts WHERE status = ? AND scheduled_at <= ? ORDER BY id",
+		BroadcastScheduled, now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("error getting due broadcasts: %v", err)
+	}
+	type dueBroadcast struct {
+		id        int64
+		segmentID string
+	}
+	var due []dueBroadcast
+	for rows.Next() {
+		var b dueBroadcast
+		if err := rows.Scan(&b.id, &b.segmentID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning due broadcast: %v", err)
+		}
+		due = append(due, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	started := 0
+	for _, b := range due {
+		recipients, err := GetBroadcastRecipients(db, b.segmentID, now)
+		if err != nil {
+			return started, fmt.Errorf("error getting recipients of broadcast %d: %v", b.id, err)
+		}
+		ok, err := startBroadcast(db, b.id, recipients, now)
+		if err != nil {
+			return started, err
+		}
+		if !ok {
+			continue // cancelled meanwhile
+		}
+		log.Printf("Started broadcast %d to %d recipients", b.id, len(recipients))
+		started++
+	}
+	return started, nil
+}
+
+// startBroadcast records the recipients of a broadcast and marks it sending. It reports false when the
+// broadcast was cancelled meanwhile.
+func startBroadcast(db *sql.DB, id int64, recipients []SegmentMember, now time.Time) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE broadcasts SET status = ?, started_at = ? WHERE id = ? AND status = ?",
+		BroadcastSending, now.UTC(), id, BroadcastScheduled)
+	if err != nil {
+		return false, fmt.Errorf("error starting broadcast %d: %v", id, err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return false, err
+	}
+	for _, r := range recipients {
+		_, err := tx.Exec(`
+			INSERT OR IGNORE INTO broadcast_sends (broadcast_id, user_id, email, first_name)
+			VALUES (?, ?, ?, ?)`,
+			id, r.UserID, strings.ToLower(r.Email), r.FirstName)
+		if err != nil {
+			return false, fmt.Errorf("error recording broadcast recipient: %v", err)
+		}
+	}
+	return true, tx.Commit()
+}
+
+// SendBroadcastBatch emails up to limit recipients of the broadcasts being sent, recording each outcome, and
+// marks broadcasts with nobody left sent. It returns the number of recipients processed, 0 once none are left.
+func SendBroadcastBatch(db *sql.DB, emailSvc email.EmailService, limit int, now time.Time) (int, error) {
+	rows, err := db.Query(`
+		SELECT s.id, s.user_id, s.email, s.first_name, b.subject, b.body
+		FROM broadcast_sends s
+		JOIN broadcasts b ON b.id = s.broadcast_id
+		WHERE s.status = 'pending' AND b.status = ?
+		ORDER BY s.id
+		LIMIT ?`, BroadcastSending, limit)
+	if err != nil {
+		return 0, fmt.Errorf("error getting broadcast recipients: %v", err)
+	}
+	var sends []broadcastSend
+	for rows.Next() {
+		var s broadcastSend
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Email, &s.FirstName, &s.Subject, &s.Body); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning broadcast recipient: %v", err)
+		}
+		sends = append(sends, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// With the outbox on, sends stay queued until the outbox records whether they were delivered. They're
+	// marked queued before the email is, so the outbox can't finish with one before it's marked.
+	queued := email.QueuesEmails(emailSvc)
+	for _, s := range sends {
+		if queued {
+			if _, err := db.Exec("UPDATE broadcast_sends SET status = 'queued' WHERE id = ?", s.ID); err != nil {
+				return 0, fmt.Errorf("error recording broadcast send: %v", err)
+			}
+		}
+		status, errorMsg := "sent", ""
+		if err := sendBroadcastEmail(db, emailSvc, s); err != nil {
+			status, errorMsg = "failed", err.Error()
+			if errors.Is(err, email.ErrSuppressed) {
+				status = "skipped"
+			} else {
+				log.Printf("❌ Failed to send broadcast to %s: %v", s.Email, err)
+			}
+		} else if queued {
+			continue
+		}
+		_, err := db.Exec("UPDATE broadcast_sends SET status = ?, error_message = NULLIF(?, ''), sent_at = ? WHERE id = ?",
+			status, errorMsg, now.UTC(), s.ID)
+		if err != nil {
+			return 0, fmt.Errorf("error recording broadcast send: %v", err)
+		}
+	}
+
+	_, err = db.Exec(`
+		UPDATE broadcasts SET status = ?, completed_at = ?
+		WHERE status = ?
+		AND NOT EXISTS (SELECT 1 FROM broadcast_sends s WHERE s.broadcast_id = broadcasts.id AND s.status = 'pending')`,
+		BroadcastSent, now.UTC(), BroadcastSending)
+	if err != nil {
+		return len(sends), fmt.Errorf("error completing broadcasts: %v", err)
+	}
+	return len(sends), nil
+}
+
+// sendBroadcastEmail renders a broadcast for one recipient and sends it
+func sendBroadcastEmail(db *sql.DB, emailSvc email.EmailService, s broadcastSend) error {
+	data, err := BroadcastData(db, s.UserID.Int64, s.Email, s.FirstName, s.Subject)
+	if err != nil {
+		return err
+	}
+	return emailSvc.SendTypedEmail(s.Email, email.BroadcastSendTemplate(s.Subject, s.Body, s.ID), data)
+}
+
+// SendBroadcastTest emails a draft broadcast to the admin writing it, with "[Test]" before the subject
+func SendBroadcastTest(db *sql.DB, emailSvc email.EmailService, admin *User, subject, body string) error {
+	subject = strings.TrimSpace(subject)
+	if err := email.ValidateBroadcast(subject, body); err != nil {
+		return err
+	}
+	data, err := BroadcastData(db, admin.ID, admin.Email, admin.FirstName, subject)
+	if err != nil {
+		return err
+	}
+	return emailSvc.SendTypedEmail(admin.Email, email.BroadcastTemplate("[Test] "+subject, body), data)
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"mad/models/email"
+)
+
+func TestBroadcasts(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := email.LoadCampaigns("../ui/email"); err != nil {
+		t.Fatalf("LoadCampaigns failed: %v", err)
+	}
+	svc, err := email.NewSMTPEmailService(email.SMTPConfig{
+		Transport:   email.TransportMemory,
+		FromName:    "The Habits Company",
+		FromEmail:   "hello@example.com",
+		TemplateDir: "../ui/email",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create email service: %v", err)
+	}
+	svc.(*email.SMTPEmailService).SetSuppressionList(db)
+	now := time.Now()
+
+	createUser := func(firstName, address string, verified bool) *User {
+		t.Helper()
+		user := &User{FirstName: firstName, LastName: "Smith", Email: address}
+		if err := user.Create(db, "hash"); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if _, err := db.Exec("UPDATE users SET email_verified = ? WHERE id = ?", verified, user.ID); err != nil {
+			t.Fatalf("Failed to set verification: %v", err)
+		}
+		return user
+	}
+	admin := createUser("Ada", "ada@example.com", true)
+	ben := createUser("Ben", "ben@example.com", true)
+	createUser("Cam", "cam@example.com", true)
+	createUser("Dee", "dee@example.com", false)
+	optedOut := createUser("Eve", "eve@example.com", true)
+	if err := SetNotificationPreference(db, int(optedOut.ID), NotificationAnnouncement, ChannelEmail, false); err != nil {
+		t.Fatalf("SetNotificationPreference failed: %v", err)
+	}
+	if err := email.Suppress(db, "cam@example.com", email.SuppressManual, "", "test"); err != nil {
+		t.Fatalf("Suppress failed: %v", err)
+	}
+
+	// Unverified addresses and readers who turned announcements off are left out
+	t.Run("recipients leave out unverified and opted-out readers", func(t *testing.T) {
+		recipients, err := GetBroadcastRecipients(db, email.SegmentAll, now)
+		if err != nil {
+			t.Fatalf("GetBroadcastRecipients failed: %v", err)
+		}
+		if len(recipients) != 3 {
+			t.Errorf("Expected Ada, Ben and Cam to get broadcasts to all users, got %+v", recipients)
+		}
+		cm := email.NewCampaignManager(db, nil)
+		if err := cm.SubscribeUser(ben.Email, "onboarding", int(ben.ID)); err != nil {
+			t.Fatalf("SubscribeUser failed: %v", err)
+		}
+		subscribers, err := GetBroadcastRecipients(db, "campaign:onboarding", now)
+		if err != nil {
+			t.Fatalf("GetBroadcastRecipients failed: %v", err)
+		}
+		if len(subscribers) != 1 || subscribers[0].FirstName != "Ben" {
+			t.Errorf("Expected Ben to be the onboarding subscriber, got %+v", subscribers)
+		}
+
+	})
+
+	// Messages that don't render can't be scheduled
+	t.Run("messages that don't render can't be scheduled", func(t *testing.T) {
+		if _, err := ScheduleBroadcast(db, "News", "Hi {{ .Nickname }}", email.SegmentAll, time.Time{}, admin.ID, now); err == nil {
+			t.Error("Expected an unknown field to be rejected")
+		}
+		if _, err := ScheduleBroadcast(db, " ", "Hi", email.SegmentAll, time.Time{}, admin.ID, now); err == nil {
+			t.Error("Expected an empty subject to be rejected")
+		}
+		if _, err := ScheduleBroadcast(db, "News", "Hi", "nobody", time.Time{}, admin.ID, now); err == nil {
+			t.Error("Expected an unknown segment to be rejected")
+		}
+
+	})
+
+	body := "Hi {{ .FirstName }},\n\nWe shipped **broadcasts**."
+	var later *Broadcast
+
+	// The test goes to the admin only
+	t.Run("the test goes to the admin only", func(t *testing.T) {
+		if err := SendBroadcastTest(db, svc, admin, "Big news", body); err != nil {
+			t.Fatalf("SendBroadcastTest failed: %v", err)
+		}
+		messages, _ := svc.(*email.SMTPEmailService).Mailbox().Messages()
+		if len(messages) != 1 || messages[0].To != "ada@example.com" || messages[0].Subject != "[Test] Big news" {
+			t.Fatalf("Expected a test to Ada, got %+v", messages)
+		}
+
+	})
+
+	// Scheduled broadcasts wait for their time, then go out in batches
+	t.Run("scheduled broadcasts go out in batches", func(t *testing.T) {
+		later, err = ScheduleBroadcast(db, "Big news", body, email.SegmentAll, now.Add(time.Hour), admin.ID, now)
+		if err != nil {
+			t.Fatalf("ScheduleBroadcast failed: %v", err)
+		}
+		if started, err := StartDueBroadcasts(db, now); err != nil || started != 0 {
+			t.Errorf("Expected nothing to start before the scheduled time, got %d (%v)", started, err)
+		}
+		sendAt := now.Add(time.Hour + time.Minute)
+		if started, err := StartDueBroadcasts(db, sendAt); err != nil || started != 1 {
+			t.Fatalf("Expected the broadcast to start, got %d (%v)", started, err)
+		}
+		if sent, err := SendBroadcastBatch(db, svc, 2, sendAt); err != nil || sent != 2 {
+			t.Fatalf("Expected a batch of 2, got %d (%v)", sent, err)
+		}
+		broadcasts, _ := GetBroadcasts(db, 10)
+		if b := broadcasts[0]; b.ID != later.ID || b.Status != BroadcastSending || b.Total != 3 || b.Pending != 1 {
+			t.Errorf("Expected 1 of 3 recipients left, got %+v", b)
+		}
+		if sent, err := SendBroadcastBatch(db, svc, 2, sendAt); err != nil || sent != 1 {
+			t.Fatalf("Expected the last recipient, got %d (%v)", sent, err)
+		}
+		broadcasts, _ = GetBroadcasts(db, 10)
+		if b := broadcasts[0]; b.Status != BroadcastSent || b.Sent != 2 || b.Skipped != 1 || b.Progress() != 100 {
+			t.Errorf("Expected 2 sent and the suppressed address skipped, got %+v", b)
+		}
+	})
+
+	t.Run("recipients get the rendered message", func(t *testing.T) {
+		messages, _ := svc.(*email.SMTPEmailService).Mailbox().Messages()
+		var received *email.CapturedMessage
+		for i, m := range messages {
+			if m.To == "ben@example.com" {
+				received = &messages[i]
+			}
+		}
+		if received == nil {
+			t.Fatal("Expected Ben to get the broadcast")
+		}
+		if !strings.Contains(received.HTML, "Hi Ben,") || !strings.Contains(received.HTML, "<strong>broadcasts</strong>") {
+			t.Errorf("Expected the markdown rendered for Ben, got %s", received.HTML)
+		}
+		if !strings.Contains(received.HTML, "announcements from The Habits Company") || !strings.Contains(received.Text, "**broadcasts**") {
+			t.Error("Expected the campaign layout with the markdown as text")
+		}
+		if !strings.Contains(received.Unsubscribe, "list=notification%3Aannouncement") {
+			t.Errorf("Expected a one-click unsubscribe from announcements, got %q", received.Unsubscribe)
+		}
+
+	})
+
+	// Cancelling stops the recipients not emailed yet
+	t.Run("cancelling stops the recipients not emailed yet", func(t *testing.T) {
+		if later == nil {
+			t.Skip("no sent broadcast to compare with")
+		}
+		next, _ := ScheduleBroadcast(db, "More news", "Hi", email.SegmentAll, time.Time{}, admin.ID, now)
+		StartDueBroadcasts(db, now)
+		if err := CancelBroadcast(db, next.ID, now); err != nil {
+			t.Fatalf("CancelBroadcast failed: %v", err)
+		}
+		if sent, err := SendBroadcastBatch(db, svc, 10, now); err != nil || sent != 0 {
+			t.Errorf("Expected nothing sent after cancelling, got %d (%v)", sent, err)
+		}
+		if err := CancelBroadcast(db, later.ID, now); err == nil {
+			t.Error("Expected a sent broadcast not to be cancellable")
+		}
+		broadcasts, _ := GetBroadcasts(db, 10)
+		if b := broadcasts[0]; b.Status != BroadcastCancelled || b.Skipped != 3 {
+			t.Errorf("Expected the cancelled broadcast's 3 recipients skipped, got %+v", b)
+		}
+	})
+}
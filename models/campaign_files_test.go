@@ -0,0 +1,101 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mad/models/email"
+)
+
+func TestCampaignFiles(t *testing.T) {
+	// Every campaign in the repo is valid
+	campaigns, err := email.ReadCampaigns("../ui/email")
+	if err != nil {
+		t.Fatalf("ReadCampaigns failed: %v", err)
+	}
+	onboarding, ok := campaigns["onboarding"]
+	if !ok || !onboarding.AutoSubscribe || len(onboarding.Emails) != 4 {
+		t.Fatalf("Expected the auto-subscribe onboarding campaign with 4 emails, got %+v", onboarding)
+	}
+	if e := onboarding.Emails[1]; e.Number != 2 || e.SendDay != 1 || e.TemplateName != "courses/onboarding/2-first-week" {
+		t.Errorf("Expected the markdown email second, got %+v", e)
+	}
+
+	// Markdown emails render into the base template, with the markdown as the text part
+	if err := email.LoadCampaigns("../ui/email"); err != nil {
+		t.Fatalf("LoadCampaigns failed: %v", err)
+	}
+	svc, err := email.NewSMTPEmailService(email.SMTPConfig{Transport: email.TransportMemory, TemplateDir: "../ui/email"})
+	if err != nil {
+		t.Fatalf("Failed to create email service: %v", err)
+	}
+	data := map[string]interface{}{
+		"FirstName":       "Sam",
+		"Title":           onboarding.Emails[1].Title,
+		"AppName":         "The Habits Company",
+		"CampaignName":    onboarding.Name,
+		"UnsubscribeLink": "https://example.com/unsubscribe",
+	}
+	template := email.EmailTemplate{Name: onboarding.Emails[1].TemplateName, Subject: onboarding.Emails[1].Subject}
+	if err := svc.SendTypedEmail("sam@example.com", template, data); err != nil {
+		t.Fatalf("SendTypedEmail failed: %v", err)
+	}
+	messages, _ := svc.(*email.SMTPEmailService).Mailbox().Messages()
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(messages))
+	}
+	if html := messages[0].HTML; !strings.Contains(html, "<strong>Start small.</strong>") || !strings.Contains(html, "Hi Sam,") {
+		t.Errorf("Expected the markdown rendered as HTML, got %q", html)
+	}
+	if text := messages[0].Text; !strings.Contains(text, "**Start small.**") {
+		t.Errorf("Expected the markdown in the text part, got %q", text)
+	}
+
+	// Broken campaigns are reported together and don't replace the loaded ones
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, "courses", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("missing/campaign.yaml", "name: Missing\nemails:\n  - number: 1\n    subject: Hi\n    template: 1-hi\n")
+	write("typo/campaign.yaml", "name: Typo\nauto_subscrbe: true\n")
+	write("twice/campaign.yaml", "name: Twice\n")
+	write("twice/1-a.md", "---\nnumber: 1\nsubject: A\nsend_day: 2\n---\nA\n")
+	write("twice/1-b.md", "---\nnumber: 1\nsubject: B\nsend_day: 1\n---\nB {{.FirstName\n")
+	write("birthday/campaign.yaml", "name: Birthday\ntrigger:\n  event: birthday\n")
+	write("birthday/1-cake.md", "---\nnumber: 1\nsubject: Cake\n---\nCake\n")
+
+	_, err = email.ReadCampaigns(dir)
+	if err == nil {
+		t.Fatal("Expected errors for the broken campaigns")
+	}
+	for _, want := range []string{"campaign missing: email 1", "campaign typo", "auto_subscrbe", "1-b.md", "campaign twice", "unknown trigger event \"birthday\""} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in the error, got: %v", want, err)
+		}
+	}
+	if err := email.LoadCampaigns(dir); err == nil {
+		t.Error("Expected LoadCampaigns to fail")
+	}
+	if _, err := email.GetCampaign("onboarding"); err != nil {
+		t.Errorf("Expected the previous campaigns to be kept, got %v", err)
+	}
+
+	write("typo/campaign.yaml", "name: Typo\nemails:\n  - number: 1\n    subject: Hi\n    template: 1-hi\n")
+	write("typo/1-hi.html", "<p>Hi {{.FirstName}}</p>")
+	write("typo/1-hi.txt", "Hi {{.FirstName}}")
+	os.RemoveAll(filepath.Join(dir, "courses", "missing"))
+	os.RemoveAll(filepath.Join(dir, "courses", "twice"))
+	write("birthday/campaign.yaml", "name: Birthday\ntrigger:\n  event: streak\n  days: 365\n")
+	if _, err := email.ReadCampaigns(dir); err != nil {
+		t.Errorf("Expected the fixed campaigns to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,413 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Correlation kinds
+const (
+	CorrelationCoOccurrence     = "co_occurrence"     // completion vs completion
+	CorrelationPearson          = "pearson"           // numeric value vs numeric value
+	CorrelationOptionCompletion = "option_completion" // option-select choice vs completion
+)
+
+const (
+	// CorrelationSignificance is the false discovery rate used to decide which pairs are reported
+	CorrelationSignificance = 0.05
+	// minCorrelationSamples is the minimum number of aligned days for a pair to be tested
+	minCorrelationSamples = 14
+	// minExpectedCount is the minimum expected cell count for a chi-squared test to be valid
+	minExpectedCount = 5.0
+)
+
+// CorrelationHabit identifies a habit in a correlation result
+type CorrelationHabit struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Emoji string `json:"emoji"`
+}
+
+// HabitCorrelation is a statistically meaningful relationship between two habits
+type HabitCorrelation struct {
+	Kind        string           `json:"kind"`
+	HabitA      CorrelationHabit `json:"habit_a"`
+	HabitB      CorrelationHabit `json:"habit_b"`
+	Option      *HabitOption     `json:"option,omitempty"` // the habit A option, for option_completion
+	SampleSize  int              `json:"sample_size"`      // aligned days the test was run on
+	Coefficient float64          `json:"coefficient"`      // phi for 2x2 tables, r for pearson
+	Lift        *float64         `json:"lift,omitempty"`   // percent more often A happens on days B is done
+	PValue      float64          `json:"p_value"`
+	Insight     string           `json:"insight"`
+}
+
+// correlationSeries holds one habit's daily signals
+type correlationSeries struct {
+	habit   CorrelationHabit
+	kind    HabitType
+	start   time.Time
+	done    map[string]bool
+	values  map[string]float64
+	options map[string]HabitOption
+	choices []HabitOption
+}
+
+// GetHabitCorrelations tests every pair of a user's habits over aligned days between from and to
+// and returns the pairs that remain significant after a Benjamini-Hochberg correction
+func GetHabitCorrelations(db *sql.DB, userID int, from, to time.Time) ([]HabitCorrelation, error) {
+	from, to = truncateToDay(from), truncateToDay(to)
+	if to.Before(from) {
+		return nil, fmt.Errorf("to date must not be before from date")
+	}
+
+	series, err := loadCorrelationSeries(db, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []HabitCorrelation{}
+	for i := 0; i < len(series); i++ {
+		for j := 0; j < len(series); j++ {
+			if i == j {
+				continue
+			}
+			a, b := series[i], series[j]
+			start := maxTime(maxTime(a.start, b.start), from)
+
+			// Symmetric tests run once per unordered pair
+			if i < j && isCompletionHabit(a.kind) && isCompletionHabit(b.kind) {
+				if c, ok := coOccurrence(a, b, start, to); ok {
+					candidates = append(candidates, c)
+				}
+			}
+			if i < j && a.kind == NumericHabit && b.kind == NumericHabit {
+				if c, ok := pearson(a, b, start, to); ok {
+					candidates = append(candidates, c)
+				}
+			}
+			if a.kind == OptionSelectHabit && isCompletionHabit(b.kind) {
+				candidates = append(candidates, optionCompletion(a, b, start, to)...)
+			}
+		}
+	}
+
+	return significantCorrelations(candidates, CorrelationSignificance), nil
+}
+
+// loadCorrelationSeries loads every habit of a user with its logs between from and to
+func loadCorrelationSeries(db *sql.DB, userID int, from, to time.Time) ([]*correlationSeries, error) {
+	rows, err := db.Query(`
+		SELECT id, name, emoji, habit_type, habit_options, created_at
+		FROM habits
+		WHERE user_id = ?
+		ORDER BY display_order, id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting habits: %v", err)
+	}
+	defer rows.Close()
+
+	series := []*correlationSeries{}
+	byID := make(map[int]*correlationSeries)
+	for rows.Next() {
+		var s correlationSeries
+		var options sql.NullString
+		var createdAt time.Time
+		if err := rows.Scan(&s.habit.ID, &s.habit.Name, &s.habit.Emoji, &s.kind, &options, &createdAt); err != nil {
+			return nil, fmt.Errorf("error scanning habit: %v", err)
+		}
+		if options.Valid && options.String != "" {
+			if err := json.Unmarshal([]byte(options.String), &s.choices); err != nil {
+				return nil, fmt.Errorf("invalid habit options format: %v", err)
+			}
+		}
+		s.start = truncateToDay(createdAt.UTC())
+		s.done = make(map[string]bool)
+		s.values = make(map[string]float64)
+		s.options = make(map[string]HabitOption)
+		series = append(series, &s)
+		byID[s.habit.ID] = &s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	logRows, err := db.Query(`
+		SELECT hl.habit_id, hl.date, hl.value
+		FROM habit_logs hl
+		JOIN habits h ON h.id = hl.habit_id
+		WHERE h.user_id = ? AND hl.status = 'done' AND hl.date BETWEEN ? AND ?
+	`, userID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error getting habit logs: %v", err)
+	}
+	defer logRows.Close()
+
+	for logRows.Next() {
+		var habitID int
+		var date time.Time
+		var value sql.NullString
+		if err := logRows.Scan(&habitID, &date, &value); err != nil {
+			return nil, fmt.Errorf("error scanning habit log: %v", err)
+		}
+		s, ok := byID[habitID]
+		if !ok {
+			continue
+		}
+		key := truncateToDay(date.UTC()).Format("2006-01-02")
+		s.done[key] = true
+
+		// Logs from before the habit's creation date (imports, backfills) extend its range
+		if day := truncateToDay(date.UTC()); day.Before(s.start) {
+			s.start = day
+		}
+
+		if !value.Valid {
+			continue
+		}
+		switch s.kind {
+		case NumericHabit:
+			var v struct {
+				Value float64 `json:"value"`
+			}
+			if err := json.Unmarshal([]byte(value.String), &v); err == nil {
+				s.values[key] = v.Value
+			}
+		case OptionSelectHabit:
+			var v HabitOption
+			if err := json.Unmarshal([]byte(value.String), &v); err == nil {
+				s.options[key] = v
+			}
+		}
+	}
+	return series, logRows.Err()
+}
+
+// isCompletionHabit reports whether a habit's daily signal is simply done or not done
+func isCompletionHabit(habitType HabitType) bool {
+	return habitType == BinaryHabit || habitType == SetRepsHabit
+}
+
+// coOccurrence tests whether two completion habits tend to be done on the same days
+func coOccurrence(a, b *correlationSeries, from, to time.Time) (HabitCorrelation, bool) {
+	var table [2][2]int // [a done][b done]
+	n := 0
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		table[boolIndex(a.done[key])][boolIndex(b.done[key])]++
+		n++
+	}
+	if n < minCorrelationSamples {
+		return HabitCorrelation{}, false
+	}
+
+	phi, p, ok := chiSquared2x2(table)
+	if !ok {
+		return HabitCorrelation{}, false
+	}
+
+	c := HabitCorrelation{
+		Kind:        CorrelationCoOccurrence,
+		HabitA:      a.habit,
+		HabitB:      b.habit,
+		SampleSize:  n,
+		Coefficient: roundTo2(phi),
+		PValue:      p,
+	}
+
+	// How much more often A is done on days B is done
+	withB := ratio(table[1][1], table[1][1]+table[0][1])
+	withoutB := ratio(table[1][0], table[1][0]+table[0][0])
+	if lift := percentChange(withB, withoutB); lift != nil {
+		c.Lift = lift
+		c.Insight = fmt.Sprintf("You do %s %s %.0f%% %s on days you do %s %s.",
+			a.habit.Emoji, a.habit.Name, math.Abs(*lift), moreOrLess(*lift), b.habit.Emoji, b.habit.Name)
+	} else {
+		c.Insight = fmt.Sprintf("You only do %s %s on days you do %s %s.", a.habit.Emoji, a.habit.Name, b.habit.Emoji, b.habit.Name)
+	}
+	return c, true
+}
+
+// pearson tests whether two numeric habits' values move together on days both are logged
+func pearson(a, b *correlationSeries, from, to time.Time) (HabitCorrelation, bool) {
+	xs, ys := []float64{}, []float64{}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		x, okA := a.values[key]
+		y, okB := b.values[key]
+		if okA && okB {
+			xs = append(xs, x)
+			ys = append(ys, y)
+		}
+	}
+	n := len(xs)
+	if n < minCorrelationSamples {
+		return HabitCorrelation{}, false
+	}
+
+	meanX, meanY := mean(xs), mean(ys)
+	var sxy, sxx, syy float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return HabitCorrelation{}, false
+	}
+	r := sxy / math.Sqrt(sxx*syy)
+
+	// Fisher z-transform gives an approximately normal test statistic
+	clamped := math.Max(math.Min(r, 0.999999), -0.999999)
+	z := math.Atanh(clamped) * math.Sqrt(float64(n-3))
+	p := math.Erfc(math.Abs(z) / math.Sqrt2)
+
+	direction := "higher"
+	if r < 0 {
+		direction = "lower"
+	}
+	return HabitCorrelation{
+		Kind:        CorrelationPearson,
+		HabitA:      a.habit,
+		HabitB:      b.habit,
+		SampleSize:  n,
+		Coefficient: roundTo2(r),
+		PValue:      p,
+		Insight: fmt.Sprintf("Days with more %s %s tend to have %s %s %s (r = %.2f).",
+			b.habit.Emoji, b.habit.Name, direction, a.habit.Emoji, a.habit.Name, r),
+	}, true
+}
+
+// optionCompletion tests, for each option of an option-select habit, whether it is chosen more often on days a completion habit is done
+func optionCompletion(a, b *correlationSeries, from, to time.Time) []HabitCorrelation {
+	results := []HabitCorrelation{}
+	for _, option := range a.choices {
+		var table [2][2]int // [option chosen][b done]
+		n := 0
+		for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+			key := day.Format("2006-01-02")
+			chosen, logged := a.options[key]
+			if !logged {
+				continue
+			}
+			isOption := chosen.Emoji == option.Emoji && chosen.Label == option.Label
+			table[boolIndex(isOption)][boolIndex(b.done[key])]++
+			n++
+		}
+		if n < minCorrelationSamples {
+			continue
+		}
+
+		phi, p, ok := chiSquared2x2(table)
+		if !ok {
+			continue
+		}
+
+		opt := option
+		c := HabitCorrelation{
+			Kind:        CorrelationOptionCompletion,
+			HabitA:      a.habit,
+			HabitB:      b.habit,
+			Option:      &opt,
+			SampleSize:  n,
+			Coefficient: roundTo2(phi),
+			PValue:      p,
+		}
+		withB := ratio(table[1][1], table[1][1]+table[0][1])
+		withoutB := ratio(table[1][0], table[1][0]+table[0][0])
+		if lift := percentChange(withB, withoutB); lift != nil {
+			c.Lift = lift
+			c.Insight = fmt.Sprintf("You log %s '%s' %.0f%% %s on days you do %s %s.",
+				option.Emoji, option.Label, math.Abs(*lift), moreOrLess(*lift), b.habit.Emoji, b.habit.Name)
+		} else {
+			c.Insight = fmt.Sprintf("You only log %s '%s' on days you do %s %s.", option.Emoji, option.Label, b.habit.Emoji, b.habit.Name)
+		}
+		results = append(results, c)
+	}
+	return results
+}
+
+// chiSquared2x2 runs a chi-squared test of independence on a 2x2 table and returns phi and the p-value.
+// It returns false if any expected count is too small for the test to be valid.
+func chiSquared2x2(table [2][2]int) (float64, float64, bool) {
+	rows := [2]float64{float64(table[0][0] + table[0][1]), float64(table[1][0] + table[1][1])}
+	cols := [2]float64{float64(table[0][0] + table[1][0]), float64(table[0][1] + table[1][1])}
+	n := rows[0] + rows[1]
+	if n == 0 {
+		return 0, 1, false
+	}
+
+	chi2 := 0.0
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			expected := rows[i] * cols[j] / n
+			if expected < minExpectedCount {
+				return 0, 1, false
+			}
+			diff := float64(table[i][j]) - expected
+			chi2 += diff * diff / expected
+		}
+	}
+
+	phi := (float64(table[1][1])*float64(table[0][0]) - float64(table[1][0])*float64(table[0][1])) /
+		math.Sqrt(rows[0]*rows[1]*cols[0]*cols[1])
+	// Survival function of the chi-squared distribution with one degree of freedom
+	p := math.Erfc(math.Sqrt(chi2 / 2))
+	return phi, p, true
+}
+
+// significantCorrelations applies the Benjamini-Hochberg procedure and returns the discoveries, strongest first
+func significantCorrelations(candidates []HabitCorrelation, fdr float64) []HabitCorrelation {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].PValue < candidates[j].PValue
+	})
+
+	cutoff := -1
+	m := float64(len(candidates))
+	for i, c := range candidates {
+		if c.PValue <= float64(i+1)/m*fdr {
+			cutoff = i
+		}
+	}
+
+	results := []HabitCorrelation{}
+	for i := 0; i <= cutoff; i++ {
+		candidates[i].PValue = math.Round(candidates[i].PValue*1e6) / 1e6
+		results = append(results, candidates[i])
+	}
+	return results
+}
+
+func boolIndex(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func moreOrLess(lift float64) string {
+	if lift < 0 {
+		return "less often"
+	}
+	return "more often"
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestGetHabitCorrelations tests that related habits are reported and unrelated ones are not
+func TestGetHabitCorrelations(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	days := 60
+
+	exercise := createTestHabitForTests(t, db, userID, BinaryHabit, "Exercise")
+	stretch := createTestHabitForTests(t, db, userID, BinaryHabit, "Stretch")
+	reading := createTestHabitForTests(t, db, userID, BinaryHabit, "Reading")
+	mood := createTestHabitForTests(t, db, userID, OptionSelectHabit, "Mood")
+	pages := createTestHabitForTests(t, db, userID, NumericHabit, "Pages")
+	minutes := createTestHabitForTests(t, db, userID, NumericHabit, "Minutes")
+
+	if _, err := db.Exec("UPDATE habits SET created_at = ?", start); err != nil {
+		t.Fatalf("Failed to backdate habits: %v", err)
+	}
+
+	for i := 0; i < days; i++ {
+		day := start.AddDate(0, 0, i)
+		exercised := i%2 == 0
+		if exercised {
+			createHabitLog(t, db, exercise.ID, day, "done", nil)
+		}
+		// Stretching mostly happens on exercise days
+		if (exercised && i%8 != 0) || i%15 == 1 {
+			createHabitLog(t, db, stretch.ID, day, "done", nil)
+		}
+		// Reading every third day is independent of exercise
+		if i%3 == 0 {
+			createHabitLog(t, db, reading.ID, day, "done", nil)
+		}
+		// Good mood mostly on exercise days
+		if (exercised && i%10 != 0) || (!exercised && i%9 == 1) {
+			createHabitLog(t, db, mood.ID, day, "done", HabitOption{Emoji: "🙂", Label: "Good"})
+		} else {
+			createHabitLog(t, db, mood.ID, day, "done", HabitOption{Emoji: "☹️", Label: "Bad"})
+		}
+		// Minutes grow with pages
+		x := float64(i%7 + 1)
+		createHabitLog(t, db, pages.ID, day, "done", map[string]interface{}{"value": x})
+		createHabitLog(t, db, minutes.ID, day, "done", map[string]interface{}{"value": 2*x + float64(i%3)})
+	}
+
+	correlations, err := GetHabitCorrelations(db, int(userID), start, start.AddDate(0, 0, days-1))
+	if err != nil {
+		t.Fatalf("GetHabitCorrelations failed: %v", err)
+	}
+
+	find := func(kind string, a, b int, label string) *HabitCorrelation {
+		for i, c := range correlations {
+			if c.Kind != kind {
+				continue
+			}
+			if c.Option != nil && c.Option.Label != label {
+				continue
+			}
+			if (c.HabitA.ID == a && c.HabitB.ID == b) || (kind != CorrelationOptionCompletion && c.HabitA.ID == b && c.HabitB.ID == a) {
+				return &correlations[i]
+			}
+		}
+		return nil
+	}
+
+	if c := find(CorrelationCoOccurrence, exercise.ID, stretch.ID, ""); c == nil {
+		t.Error("Expected exercise and stretch to co-occur")
+	} else {
+		if c.SampleSize != days || c.Coefficient <= 0 || c.PValue >= CorrelationSignificance {
+			t.Errorf("Unexpected co-occurrence: %+v", c)
+		}
+	}
+
+	if c := find(CorrelationCoOccurrence, exercise.ID, reading.ID, ""); c != nil {
+		t.Errorf("Expected no correlation between exercise and reading, got %+v", c)
+	}
+
+	if c := find(CorrelationOptionCompletion, mood.ID, exercise.ID, "Good"); c == nil {
+		t.Error("Expected good mood to correlate with exercise")
+	} else {
+		if c.Lift == nil || *c.Lift <= 0 {
+			t.Errorf("Expected positive lift, got %+v", c)
+		}
+		if !strings.Contains(c.Insight, "'Good'") || !strings.Contains(c.Insight, "more often on days you do") {
+			t.Errorf("Unexpected insight: %q", c.Insight)
+		}
+	}
+
+	if c := find(CorrelationPearson, pages.ID, minutes.ID, ""); c == nil {
+		t.Error("Expected pages and minutes to correlate")
+	} else if c.Coefficient < 0.9 || c.SampleSize != days {
+		t.Errorf("Unexpected pearson correlation: %+v", c)
+	}
+
+	// Too little data is never reported
+	correlations, err = GetHabitCorrelations(db, int(userID), start, start.AddDate(0, 0, 6))
+	if err != nil {
+		t.Fatalf("GetHabitCorrelations failed: %v", err)
+	}
+	if len(correlations) != 0 {
+		t.Errorf("Expected no correlations for a week of data, got %d", len(correlations))
+	}
+}
@@ -1,8 +1,12 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -20,6 +24,15 @@ func InitDB(db *sql.DB) error {
 			show_confetti BOOLEAN NOT NULL DEFAULT 1,
 			show_weekdays BOOLEAN NOT NULL DEFAULT false,
 			notification_enabled BOOLEAN NOT NULL DEFAULT true,
+			goal_deadline_days INTEGER NOT NULL DEFAULT 3,
+			timezone TEXT NOT NULL DEFAULT 'UTC',
+			quiet_hours_start TEXT NOT NULL DEFAULT '',
+			quiet_hours_end TEXT NOT NULL DEFAULT '',
+			digest_frequency TEXT NOT NULL DEFAULT 'off',
+			webhook_url TEXT NOT NULL DEFAULT '',
+			preferences_token TEXT,
+			last_digest_end TEXT NOT NULL DEFAULT '',
+			email_verified BOOLEAN NOT NULL DEFAULT true, -- Create inserts sign-ups unverified
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			is_admin BOOLEAN NOT NULL DEFAULT 0
 		)
@@ -40,6 +53,7 @@ func InitDB(db *sql.DB) error {
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			display_order INTEGER NOT NULL DEFAULT 0,
 			habit_options TEXT,
+			reminder_enabled BOOLEAN NOT NULL DEFAULT true,
 			FOREIGN KEY (user_id) REFERENCES users(id),
 			UNIQUE(user_id, name)
 		)
@@ -205,9 +219,14 @@ func InitDB(db *sql.DB) error {
 			target_number REAL NOT NULL,
 			current_number REAL DEFAULT 0,
 			status TEXT CHECK(status IN ('on_track', 'at_risk', 'off_track', 'done', 'failed')) DEFAULT 'on_track',
+			progress REAL NOT NULL DEFAULT 0,
 			position INTEGER NOT NULL,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			kind TEXT NOT NULL DEFAULT 'total',
+			target_option TEXT,
+			comparison TEXT NOT NULL DEFAULT '',
+			recurrence TEXT NOT NULL DEFAULT '',
 			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
 			FOREIGN KEY (habit_id) REFERENCES habits(id) ON DELETE CASCADE
 		)
@@ -216,11 +235,125 @@ func InitDB(db *sql.DB) error {
 		return err
 	}
 
+	// Create goal_periods table for finished periods of recurring goals
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS goal_periods (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			goal_id INTEGER NOT NULL,
+			start_date TEXT NOT NULL,
+			end_date TEXT NOT NULL,
+			target_number REAL NOT NULL,
+			current_number REAL NOT NULL,
+			status TEXT NOT NULL,
+			archived_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (goal_id) REFERENCES goals(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
 	// Create indexes for goals table
 	_, err = db.Exec(`
 		CREATE INDEX IF NOT EXISTS idx_goals_user_id ON goals(user_id);
 		CREATE INDEX IF NOT EXISTS idx_goals_habit_id ON goals(habit_id);
-		CREATE INDEX IF NOT EXISTS idx_goals_position ON goals(position)
+		CREATE INDEX IF NOT EXISTS idx_goals_position ON goals(position);
+		CREATE INDEX IF NOT EXISTS idx_goal_periods_goal_id ON goal_periods(goal_id)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create goal_habits table linking goals to the habits they count, with weights
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS goal_habits (
+			goal_id INTEGER NOT NULL,
+			habit_id INTEGER NOT NULL,
+			weight REAL NOT NULL DEFAULT 1,
+			PRIMARY KEY (goal_id, habit_id),
+			FOREIGN KEY (goal_id) REFERENCES goals(id) ON DELETE CASCADE,
+			FOREIGN KEY (habit_id) REFERENCES habits(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_goal_habits_habit_id ON goal_habits(habit_id)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create goal_milestones table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS goal_milestones (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			goal_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			target_number REAL NOT NULL,
+			due_date TEXT,
+			reached_at DATETIME,
+			FOREIGN KEY (goal_id) REFERENCES goals(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_goal_milestones_goal_id ON goal_milestones(goal_id)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create reminders table; a reminder without a habit lists every habit included in reminders
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS reminders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			habit_id INTEGER,
+			kind TEXT NOT NULL DEFAULT 'daily' CHECK (kind IN ('daily', 'streak_nudge')),
+			time TEXT NOT NULL,
+			next_due_at DATETIME NOT NULL,
+			last_sent_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (habit_id) REFERENCES habits(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_reminders_next_due_at ON reminders(next_due_at)`)
+	if err != nil {
+		return err
+	}
+
+	// Create notification_preferences table; a missing row means the channel's default
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS notification_preferences (
+			user_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			channel TEXT NOT NULL CHECK (channel IN ('email', 'webhook')),
+			enabled BOOLEAN NOT NULL,
+			PRIMARY KEY (user_id, type, channel),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create goal_notifications table for queued goal emails
+	_, err = db.Exec(fmt.Sprintf(goalNotificationsTable, "goal_notifications"))
+	if err != nil {
+		return err
+	}
+
+	// Create goal_snapshots table for daily goal progress
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS goal_snapshots (
+			goal_id INTEGER NOT NULL,
+			date TEXT NOT NULL,
+			current_number REAL NOT NULL,
+			expected_number REAL NOT NULL,
+			status TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (goal_id, date),
+			FOREIGN KEY (goal_id) REFERENCES goals(id) ON DELETE CASCADE
+		)
 	`)
 	if err != nil {
 		return err
@@ -247,21 +380,7 @@ func InitDB(db *sql.DB) error {
 	}
 
 	// Create email_subscriptions table
-	_, err = db.Exec(`
-	CREATE TABLE IF NOT EXISTS email_subscriptions (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER NULL REFERENCES users(id) ON DELETE CASCADE,
-		email TEXT NOT NULL,
-		campaign_id TEXT NOT NULL,
-		token TEXT NOT NULL,
-		subscribed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		status TEXT NOT NULL CHECK (status IN ('active', 'unsubscribed')) DEFAULT 'active',
-		last_email_sent INTEGER DEFAULT 0,
-		unsubscribed_at TIMESTAMP NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		UNIQUE(email, campaign_id)
-	)`)
+	_, err = db.Exec(fmt.Sprintf(emailSubscriptionsTable, "email_subscriptions"))
 	if err != nil {
 		return fmt.Errorf("error creating email_subscriptions table: %w", err)
 	}
@@ -298,6 +417,150 @@ func InitDB(db *sql.DB) error {
 		return fmt.Errorf("error creating email campaign indexes: %w", err)
 	}
 
+	// Create email_events table for opens and clicks on tracked campaign emails, and unsubscribes from any
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS email_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email_send_id INTEGER NOT NULL REFERENCES email_sends(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL CHECK (kind IN ('open', 'click', 'unsubscribe')),
+		url TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_email_events_send ON email_events(email_send_id, kind);
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating email_events table: %w", err)
+	}
+
+	// Create email_outbox table
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS email_outbox (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		to_email TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		template_name TEXT NOT NULL,
+		html_body TEXT NOT NULL,
+		text_body TEXT NOT NULL,
+		list_unsubscribe TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sending', 'sent', 'dead')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NOT NULL,
+		last_error TEXT,
+		email_send_id INTEGER REFERENCES email_sends(id) ON DELETE SET NULL,
+		broadcast_send_id INTEGER REFERENCES broadcast_sends(id) ON DELETE SET NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		sent_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_email_outbox_due ON email_outbox(status, next_attempt_at);
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating email_outbox table: %w", err)
+	}
+
+	// Create email_suppressions table of addresses that hard-bounced, complained or were blocked by an admin
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS email_suppressions (
+		email TEXT PRIMARY KEY,
+		reason TEXT NOT NULL CHECK (reason IN ('bounce', 'complaint', 'manual')),
+		detail TEXT,
+		source TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating email_suppressions table: %w", err)
+	}
+
+	// Create email_verification_tokens table for account verification and double opt-in campaign subscriptions
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS email_verification_tokens (
+		token TEXT PRIMARY KEY,
+		user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+		email TEXT NOT NULL,
+		campaign_id TEXT NOT NULL DEFAULT '', -- empty for account verification
+		expiry TIMESTAMP NOT NULL,
+		used BOOLEAN NOT NULL DEFAULT false,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_email ON email_verification_tokens(email);
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating email_verification_tokens table: %w", err)
+	}
+
+	// Create broadcasts table for one-off announcements, and broadcast_sends with one row per recipient
+	// snapshotted when the broadcast starts sending
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS broadcasts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		subject TEXT NOT NULL,
+		body TEXT NOT NULL, -- markdown
+		segment_id TEXT NOT NULL,
+		status TEXT NOT NULL CHECK (status IN ('scheduled', 'sending', 'sent', 'cancelled')) DEFAULT 'scheduled',
+		scheduled_at TIMESTAMP NOT NULL,
+		created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMP NOT NULL,
+		started_at TIMESTAMP NULL,
+		completed_at TIMESTAMP NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_broadcasts_status ON broadcasts(status, scheduled_at);
+
+	CREATE TABLE IF NOT EXISTS broadcast_sends (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		broadcast_id INTEGER NOT NULL REFERENCES broadcasts(id) ON DELETE CASCADE,
+		user_id INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+		email TEXT NOT NULL,
+		first_name TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL CHECK (status IN ('pending', 'queued', 'sent', 'failed', 'skipped', 'cancelled')) DEFAULT 'pending',
+		error_message TEXT NULL,
+		sent_at TIMESTAMP NULL,
+		UNIQUE(broadcast_id, email)
+	);
+	CREATE INDEX IF NOT EXISTS idx_broadcast_sends_status ON broadcast_sends(broadcast_id, status);
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating broadcasts tables: %w", err)
+	}
+
+	// Create lifecycle_triggers table recording when triggered campaigns started for each user
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS lifecycle_triggers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		campaign_id TEXT NOT NULL,
+		event_at TEXT NOT NULL,
+		triggered_at TEXT NOT NULL,
+		enrolled BOOLEAN NOT NULL DEFAULT false
+	);
+	CREATE INDEX IF NOT EXISTS idx_lifecycle_triggers_user_campaign ON lifecycle_triggers(user_id, campaign_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating lifecycle_triggers table: %w", err)
+	}
+
+	// Create habit_log_idempotency_keys table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS habit_log_idempotency_keys (
+			user_id INTEGER NOT NULL,
+			idempotency_key TEXT NOT NULL,
+			response TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, idempotency_key),
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating habit_log_idempotency_keys table: %w", err)
+	}
+
+	// Create index for purging old idempotency keys
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_habit_log_idempotency_keys_created_at ON habit_log_idempotency_keys(created_at)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating habit_log_idempotency_keys index: %w", err)
+	}
+
 	// Create user_lesson_completion table
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS user_lesson_completion (
@@ -356,6 +619,41 @@ func InitDB(db *sql.DB) error {
 	return nil
 }
 
+// goalNotificationsTable creates the goal_notifications table under the given name. Each kind is sent once per
+// goal period, and milestone notifications once per milestone; other kinds have a milestone_id of 0.
+const goalNotificationsTable = `
+	CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		goal_id INTEGER NOT NULL,
+		kind TEXT NOT NULL CHECK (kind IN ('behind', 'deadline', 'done', 'milestone')),
+		period_end TEXT NOT NULL,
+		milestone_id INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		sent_at DATETIME,
+		skipped BOOLEAN NOT NULL DEFAULT false,
+		UNIQUE (goal_id, kind, period_end, milestone_id),
+		FOREIGN KEY (goal_id) REFERENCES goals(id) ON DELETE CASCADE
+	)
+`
+
+// emailSubscriptionsTable creates the email_subscriptions table under the given name. Anonymous subscriptions
+// are pending until the address is confirmed.
+const emailSubscriptionsTable = `
+	CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NULL REFERENCES users(id) ON DELETE CASCADE,
+		email TEXT NOT NULL,
+		campaign_id TEXT NOT NULL,
+		token TEXT NOT NULL,
+		subscribed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		status TEXT NOT NULL CHECK (status IN ('pending', 'active', 'paused', 'unsubscribed')) DEFAULT 'active',
+		last_email_sent INTEGER DEFAULT 0,
+		unsubscribed_at TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(email, campaign_id)
+	)`
+
 // Add this new function after the InitDB function
 func SeedUsers(db *sql.DB) error {
 	// Admin user
@@ -427,6 +725,126 @@ func MigrateDB(db *sql.DB) error {
 		}
 	}
 
+	// Add notification preference columns if they don't exist
+	userColumns := []struct {
+		name       string
+		definition string
+	}{
+		{"goal_deadline_days", "goal_deadline_days INTEGER NOT NULL DEFAULT 3"},
+		{"quiet_hours_start", "quiet_hours_start TEXT NOT NULL DEFAULT ''"},
+		{"quiet_hours_end", "quiet_hours_end TEXT NOT NULL DEFAULT ''"},
+		{"digest_frequency", "digest_frequency TEXT NOT NULL DEFAULT 'off'"},
+		{"webhook_url", "webhook_url TEXT NOT NULL DEFAULT ''"},
+		{"preferences_token", "preferences_token TEXT"},
+		{"last_digest_end", "last_digest_end TEXT NOT NULL DEFAULT ''"},
+		{"email_verified", "email_verified BOOLEAN NOT NULL DEFAULT true"}, // accounts from before verification count as verified
+	}
+	for _, column := range userColumns {
+		err = db.QueryRow(`
+			SELECT COUNT(*) > 0 
+			FROM pragma_table_info('users') 
+			WHERE name = ?
+		`, column.name).Scan(&columnExists)
+
+		if err != nil {
+			return err
+		}
+
+		if !columnExists {
+			_, err = db.Exec("ALTER TABLE users ADD COLUMN " + column.definition)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Every email links to the user's preference page, which needs their token
+	if err := backfillPreferencesTokens(db); err != nil {
+		return err
+	}
+
+	// Reminders used to go out to everyone at one time; users who predate per-user reminders keep that
+	// time as their daily reminder
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0 
+		FROM pragma_table_info('users') 
+		WHERE name = 'timezone'
+	`).Scan(&columnExists)
+	if err != nil {
+		return err
+	}
+	if !columnExists {
+		_, err = db.Exec("ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC'")
+		if err != nil {
+			return err
+		}
+		nextDue, err := nextReminderDue(DefaultReminderTime, time.UTC, time.Now())
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec(`
+			INSERT INTO reminders (user_id, time, next_due_at)
+			SELECT id, ?, ? FROM users`,
+			DefaultReminderTime, nextDue,
+		)
+		if err != nil {
+			return err
+		}
+		if err := backfillStreakNudges(db); err != nil {
+			return err
+		}
+	}
+
+	// Users who predate streak nudges get one too
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0 
+		FROM pragma_table_info('reminders') 
+		WHERE name = 'kind'
+	`).Scan(&columnExists)
+	if err != nil {
+		return err
+	}
+	if !columnExists {
+		_, err = db.Exec("ALTER TABLE reminders ADD COLUMN kind TEXT NOT NULL DEFAULT 'daily' CHECK (kind IN ('daily', 'streak_nudge'))")
+		if err != nil {
+			return err
+		}
+		if err := backfillStreakNudges(db); err != nil {
+			return err
+		}
+	}
+
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0 
+		FROM pragma_table_info('habits') 
+		WHERE name = 'reminder_enabled'
+	`).Scan(&columnExists)
+	if err != nil {
+		return err
+	}
+	if !columnExists {
+		_, err = db.Exec("ALTER TABLE habits ADD COLUMN reminder_enabled BOOLEAN NOT NULL DEFAULT true")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Queued emails keep their one-click unsubscribe URL for the List-Unsubscribe header
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0 
+		FROM pragma_table_info('email_outbox') 
+		WHERE name = 'list_unsubscribe'
+	`).Scan(&columnExists)
+	if err != nil {
+		return err
+	}
+	if !columnExists {
+		_, err = db.Exec("ALTER TABLE email_outbox ADD COLUMN list_unsubscribe TEXT NOT NULL DEFAULT ''")
+		if err != nil {
+			return err
+		}
+	}
+
 	// Check if rating column exists in user_lesson_completion table
 	err = db.QueryRow(`
 		SELECT COUNT(*) > 0 
@@ -471,5 +889,149 @@ func MigrateDB(db *sql.DB) error {
 		}
 	}
 
+	// Add goal kind columns if they don't exist
+	goalColumns := []struct {
+		name       string
+		definition string
+	}{
+		{"kind", "kind TEXT NOT NULL DEFAULT 'total'"},
+		{"target_option", "target_option TEXT"},
+		{"comparison", "comparison TEXT NOT NULL DEFAULT ''"},
+		{"recurrence", "recurrence TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, column := range goalColumns {
+		err = db.QueryRow(`
+			SELECT COUNT(*) > 0 
+			FROM pragma_table_info('goals') 
+			WHERE name = ?
+		`, column.name).Scan(&columnExists)
+
+		if err != nil {
+			return err
+		}
+
+		if !columnExists {
+			_, err = db.Exec("ALTER TABLE goals ADD COLUMN " + column.definition)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Goals created before multi-habit support count only their own habit
+	_, err = db.Exec(`
+		INSERT OR IGNORE INTO goal_habits (goal_id, habit_id, weight)
+		SELECT id, habit_id, 1 FROM goals
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Goal progress used to be computed on every read; store it once for existing goals
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0 
+		FROM pragma_table_info('goals') 
+		WHERE name = 'progress'
+	`).Scan(&columnExists)
+	if err != nil {
+		return err
+	}
+	if !columnExists {
+		_, err = db.Exec("ALTER TABLE goals ADD COLUMN progress REAL NOT NULL DEFAULT 0")
+		if err != nil {
+			return err
+		}
+		// A goal that fails to recalculate keeps the defaults until the nightly job retries it
+		if _, err := backfillGoalProgress(db, time.Now()); err != nil {
+			log.Printf("Error calculating progress of existing goals: %v", err)
+		}
+	}
+
+	// Subscriptions gained the pending status for double opt-in. SQLite can't change a CHECK constraint, so
+	// older tables are copied into a new one.
+	var tableSQL string
+	err = db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'email_subscriptions'").Scan(&tableSQL)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(tableSQL, "'pending'") {
+		if err := rebuildEmailSubscriptions(db); err != nil {
+			return fmt.Errorf("error migrating email_subscriptions: %w", err)
+		}
+	}
+
+	// Goal notifications gained the milestone kind, which is sent once per milestone rather than per period
+	err = db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'goal_notifications'").Scan(&tableSQL)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(tableSQL, "'milestone'") {
+		err := rebuildTable(db, []string{
+			fmt.Sprintf(goalNotificationsTable, "goal_notifications_new"),
+			`INSERT INTO goal_notifications_new (id, goal_id, kind, period_end, created_at, sent_at, skipped)
+			SELECT id, goal_id, kind, period_end, created_at, sent_at, skipped
+			FROM goal_notifications`,
+			"DROP TABLE goal_notifications",
+			"ALTER TABLE goal_notifications_new RENAME TO goal_notifications",
+		})
+		if err != nil {
+			return fmt.Errorf("error migrating goal_notifications: %w", err)
+		}
+	}
+
 	return nil
 }
+
+// rebuildEmailSubscriptions recreates email_subscriptions with the current schema, keeping its rows
+func rebuildEmailSubscriptions(db *sql.DB) error {
+	return rebuildTable(db, []string{
+		fmt.Sprintf(emailSubscriptionsTable, "email_subscriptions_new"),
+		`INSERT INTO email_subscriptions_new (id, user_id, email, campaign_id, token, subscribed_at, status,
+			last_email_sent, unsubscribed_at, created_at, updated_at)
+		SELECT id, user_id, email, campaign_id, token, subscribed_at, status,
+			last_email_sent, unsubscribed_at, created_at, updated_at
+		FROM email_subscriptions`,
+		"DROP TABLE email_subscriptions",
+		"ALTER TABLE email_subscriptions_new RENAME TO email_subscriptions",
+		"CREATE INDEX IF NOT EXISTS idx_email_subscriptions_user_id ON email_subscriptions(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_email_subscriptions_email ON email_subscriptions(email)",
+		"CREATE INDEX IF NOT EXISTS idx_email_subscriptions_campaign_id ON email_subscriptions(campaign_id)",
+		"CREATE INDEX IF NOT EXISTS idx_email_subscriptions_status ON email_subscriptions(status)",
+	})
+}
+
+// rebuildTable runs the statements that copy a table into one with a new schema in a single transaction.
+// SQLite can't change constraints in place. Foreign keys are off while it runs so dropping the old table
+// doesn't cascade to the tables referencing it.
+func rebuildTable(db *sql.DB, statements []string) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var foreignKeys bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return err
+	}
+	if foreignKeys {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,330 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"mad/models/email"
+)
+
+// digestHour is the local hour from which a due digest is sent
+const digestHour = 8
+
+// digestPeriod returns the period covered by the digest due on day, a date in the user's timezone: the previous
+// Monday to Sunday on Mondays for weekly digests, the previous month on the 1st for monthly ones
+func digestPeriod(frequency string, day time.Time) (from, to time.Time, due bool) {
+	switch frequency {
+	case DigestWeekly:
+		if day.Weekday() != time.Monday {
+			return time.Time{}, time.Time{}, false
+		}
+		return day.AddDate(0, 0, -7), day.AddDate(0, 0, -1), true
+	case DigestMonthly:
+		if day.Day() != 1 {
+			return time.Time{}, time.Time{}, false
+		}
+		return day.AddDate(0, -1, 0), day.AddDate(0, 0, -1), true
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// digestRecipient is a user with a digest due
+type digestRecipient struct {
+	userID    int
+	email     string
+	firstName string
+	frequency string
+	from      time.Time
+	to        time.Time
+}
+
+// getDueDigests returns the users whose digest is due at now: it is past digestHour on the digest day in their
+// timezone and the period hasn't been sent yet
+func getDueDigests(db *sql.DB, now time.Time) ([]digestRecipient, error) {
+	rows, err := db.Query(`
+		SELECT id, email, first_name, digest_frequency, timezone, last_digest_end
+		FROM users
+		WHERE digest_frequency != 'off'
+		AND notification_enabled = true
+		AND email_verified = true`)
+	if err != nil {
+		return nil, fmt.Errorf("error getting digest recipients: %v", err)
+	}
+	defer rows.Close()
+
+	var due []digestRecipient
+	for rows.Next() {
+		var r digestRecipient
+		var tz, lastEnd string
+		if err := rows.Scan(&r.userID, &r.email, &r.firstName, &r.frequency, &tz, &lastEnd); err != nil {
+			return nil, err
+		}
+
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			loc = time.UTC
+		}
+		if now.In(loc).Hour() < digestHour {
+			continue
+		}
+		from, to, ok := digestPeriod(r.frequency, localDay(tz, now))
+		if !ok || lastEnd == to.Format("2006-01-02") {
+			continue
+		}
+		r.from, r.to = from, to
+		due = append(due, r)
+	}
+	return due, rows.Err()
+}
+
+// BuildDigest summarizes the user's habits and goals between from and to, both dates inclusive
+func BuildDigest(db *sql.DB, userID int, from, to time.Time) (email.DigestEmailData, error) {
+	data := email.DigestEmailData{
+		Period:      "week",
+		PeriodLabel: periodLabel(from, to),
+		AppName:     "The Habits Company",
+	}
+	if to.Sub(from) > 7*24*time.Hour {
+		data.Period = "month"
+	}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		if data.Period == "week" {
+			data.Days = append(data.Days, day.Format("Mon"))
+		} else {
+			data.Days = append(data.Days, strconv.Itoa(day.Day()))
+		}
+	}
+
+	habits, err := GetHabitsByUserID(db, userID)
+	if err != nil {
+		return data, fmt.Errorf("error getting habits: %v", err)
+	}
+
+	doneByDay := map[string]int{}
+	for _, h := range habits {
+		created := truncateToDay(h.CreatedAt.UTC())
+		if created.After(to) {
+			continue
+		}
+		info, err := digestHabit(db, h, maxTime(from, created), from, to, doneByDay)
+		if err != nil {
+			return data, err
+		}
+		data.Habits = append(data.Habits, info)
+	}
+
+	bestDay := ""
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		if doneByDay[key] > data.BestDayDone {
+			bestDay, data.BestDayDone = key, doneByDay[key]
+		}
+	}
+	if bestDay != "" {
+		t, _ := time.Parse("2006-01-02", bestDay)
+		data.BestDay = t.Format("Monday 2 Jan")
+	}
+
+	data.Goals, err = digestGoalChanges(db, userID, from, to)
+	if err != nil {
+		return data, err
+	}
+	return data, nil
+}
+
+// digestHabit summarizes one habit over the period, counting its completion rate from start, the later of the
+// period start and the day the habit was created. Done days are added to doneByDay for the best day.
+func digestHabit(db *sql.DB, h Habit, start, from, to time.Time, doneByDay map[string]int) (email.DigestHabitInfo, error) {
+	info := email.DigestHabitInfo{Name: h.Name, Emoji: h.Emoji}
+
+	stats, err := QueryHabitStats(db, StatsQuery{HabitID: h.ID, From: start, To: to, Granularity: GranularityDay})
+	if err != nil {
+		return info, fmt.Errorf("error getting stats for habit %d: %v", h.ID, err)
+	}
+	summary := stats.Current.Summary
+	info.CompletionRate = summary.CompletionRate
+
+	switch h.HabitType {
+	case NumericHabit:
+		info.Total = "Total " + formatDigestNumber(summary.ValueTotal)
+	case SetRepsHabit:
+		info.Total = fmt.Sprintf("%d sets · %d reps", summary.Sets, summary.Reps)
+		if summary.Volume > 0 {
+			info.Total += " · " + formatDigestNumber(summary.Volume) + " volume"
+		}
+	}
+
+	statuses := map[string]string{}
+	for _, b := range stats.Current.Buckets {
+		key := b.Start.Format("2006-01-02")
+		switch {
+		case b.Done > 0:
+			statuses[key] = "done"
+			doneByDay[key]++
+		case b.Skipped > 0:
+			statuses[key] = "skipped"
+		case b.Missed > 0:
+			statuses[key] = "missed"
+		}
+	}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		info.Heatmap = append(info.Heatmap, email.NewHeatmapCell(key, statuses[key]))
+	}
+
+	if info.StreakStart, err = streakBefore(db, h.ID, from); err != nil {
+		return info, err
+	}
+	if info.StreakEnd, err = streakBefore(db, h.ID, to.AddDate(0, 0, 1)); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+// digestGoalChanges returns the user's goals whose status changed between from and to, going by the daily
+// snapshots, and the recurring goal periods that ended in between
+func digestGoalChanges(db *sql.DB, userID int, from, to time.Time) ([]email.DigestGoalInfo, error) {
+	fromDay, toDay := from.Format("2006-01-02"), to.Format("2006-01-02")
+	rows, err := db.Query(`
+		SELECT g.name, h.emoji,
+			COALESCE((
+				SELECT s.status FROM goal_snapshots s
+				WHERE s.goal_id = g.id AND s.date < ?
+				ORDER BY s.date DESC LIMIT 1
+			), ''),
+			COALESCE((
+				SELECT s.status FROM goal_snapshots s
+				WHERE s.goal_id = g.id AND s.date <= ?
+				ORDER BY s.date DESC LIMIT 1
+			), '')
+		FROM goals g
+		JOIN habits h ON h.id = g.habit_id
+		WHERE g.user_id = ?
+		ORDER BY g.position`,
+		fromDay, toDay, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting goal changes: %v", err)
+	}
+	defer rows.Close()
+
+	changes := []email.DigestGoalInfo{}
+	for rows.Next() {
+		var g email.DigestGoalInfo
+		if err := rows.Scan(&g.Name, &g.HabitEmoji, &g.From, &g.To); err != nil {
+			return nil, err
+		}
+		if g.To == "" || g.From == g.To {
+			continue
+		}
+		g.From = strings.ReplaceAll(g.From, "_", " ")
+		g.To = strings.ReplaceAll(g.To, "_", " ")
+		changes = append(changes, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	periodRows, err := db.Query(`
+		SELECT g.name, h.emoji, p.status
+		FROM goal_periods p
+		JOIN goals g ON g.id = p.goal_id
+		JOIN habits h ON h.id = g.habit_id
+		WHERE g.user_id = ?
+		AND date(p.end_date) BETWEEN date(?) AND date(?)
+		ORDER BY p.end_date`,
+		userID, fromDay, toDay,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting finished goal periods: %v", err)
+	}
+	defer periodRows.Close()
+	for periodRows.Next() {
+		var g email.DigestGoalInfo
+		if err := periodRows.Scan(&g.Name, &g.HabitEmoji, &g.To); err != nil {
+			return nil, err
+		}
+		g.To = strings.ReplaceAll(g.To, "_", " ")
+		changes = append(changes, g)
+	}
+	return changes, periodRows.Err()
+}
+
+// SendDigests sends the weekly and monthly digests that are due, skipping users in their quiet hours until the
+// next run. It returns the number sent.
+func SendDigests(db *sql.DB, emailSvc email.EmailService, now time.Time) (int, error) {
+	due, err := getDueDigests(db, now)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, r := range due {
+		prefs, err := GetNotificationPreferences(db, r.userID)
+		if err != nil {
+			return sent, err
+		}
+		if prefs.InQuietHours(now) {
+			continue
+		}
+
+		data, err := BuildDigest(db, r.userID, r.from, r.to)
+		if err != nil {
+			log.Printf("Error building digest for user %d: %v", r.userID, err)
+			continue
+		}
+		if len(data.Habits) == 0 {
+			// Nothing to report; don't check again until the next period
+			if err := markDigestSent(db, r.userID, r.to); err != nil {
+				return sent, err
+			}
+			continue
+		}
+		data.FirstName = r.firstName
+		data.PreferencesLink = PreferencesLink(db, r.userID, r.email)
+
+		template := email.WeeklyDigestEmail
+		if r.frequency == DigestMonthly {
+			template = email.MonthlyDigestEmail
+		}
+		if err := emailSvc.SendTypedEmail(r.email, template, data); err != nil {
+			log.Printf("Error sending digest to %s: %v", r.email, err)
+			continue
+		}
+		if err := markDigestSent(db, r.userID, r.to); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// markDigestSent records the end of the last period a digest went out for
+func markDigestSent(db *sql.DB, userID int, to time.Time) error {
+	_, err := db.Exec("UPDATE users SET last_digest_end = ? WHERE id = ?", to.Format("2006-01-02"), userID)
+	if err != nil {
+		return fmt.Errorf("error recording digest: %v", err)
+	}
+	return nil
+}
+
+// periodLabel formats a date range compactly, e.g. "6 – 12 Jan 2025" or "27 Jan – 2 Feb 2025"
+func periodLabel(from, to time.Time) string {
+	switch {
+	case from.Year() != to.Year():
+		return from.Format("2 Jan 2006") + " – " + to.Format("2 Jan 2006")
+	case from.Month() != to.Month():
+		return from.Format("2 Jan") + " – " + to.Format("2 Jan 2006")
+	default:
+		return from.Format("2") + " – " + to.Format("2 Jan 2006")
+	}
+}
+
+// formatDigestNumber formats a total without trailing zeros, e.g. 12.5 or 1200
+func formatDigestNumber(v float64) string {
+	return strconv.FormatFloat(roundTo2(v), 'f', -1, 64)
+}
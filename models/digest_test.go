@@ -0,0 +1,142 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDigestPeriod(t *testing.T) {
+	tests := []struct {
+		name      string
+		frequency string
+		day       time.Time
+		due       bool
+		from      time.Time
+		to        time.Time
+	}{
+		{
+			name:      "weekly on a Monday",
+			frequency: DigestWeekly,
+			day:       time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			due:       true,
+			from:      time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC),
+			to:        time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "weekly on another day",
+			frequency: DigestWeekly,
+			day:       time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "monthly on the 1st",
+			frequency: DigestMonthly,
+			day:       time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			due:       true,
+			from:      time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			to:        time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "off",
+			frequency: DigestOff,
+			day:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, due := digestPeriod(tt.frequency, tt.day)
+			if due != tt.due {
+				t.Fatalf("Expected due %v, got %v", tt.due, due)
+			}
+			if due && (!from.Equal(tt.from) || !to.Equal(tt.to)) {
+				t.Errorf("Expected %v to %v, got %v to %v", tt.from, tt.to, from, to)
+			}
+		})
+	}
+}
+
+func TestDigest(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	read := createTestHabitForTests(t, db, userID, BinaryHabit, "Read")
+	pushups := createTestHabitForTests(t, db, userID, SetRepsHabit, "Push-ups")
+	if _, err := db.Exec("UPDATE habits SET created_at = '2023-12-01 00:00:00'"); err != nil {
+		t.Fatalf("Failed to backdate habits: %v", err)
+	}
+
+	// Read is done the whole week of 8 Jan after two days going in; push-ups twice
+	for d := 6; d <= 14; d++ {
+		createHabitLog(t, db, read.ID, time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC), "done", nil)
+	}
+	sets := SetRepsValue{Sets: []SetRep{{Set: 1, Reps: 20}, {Set: 2, Reps: 15}}}
+	createHabitLog(t, db, pushups.ID, time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), "done", sets)
+	createHabitLog(t, db, pushups.ID, time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC), "done", sets)
+	createHabitLog(t, db, pushups.ID, time.Date(2024, 1, 13, 0, 0, 0, 0, time.UTC), "missed", nil)
+
+	from := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC)
+	data, err := BuildDigest(db, int(userID), from, to)
+	if err != nil {
+		t.Fatalf("BuildDigest failed: %v", err)
+	}
+	if data.Period != "week" || len(data.Days) != 7 || len(data.Habits) != 2 {
+		t.Fatalf("Expected a week with 2 habits, got %+v", data)
+	}
+
+	readInfo, pushupInfo := data.Habits[0], data.Habits[1]
+	if readInfo.Name != "Read" {
+		readInfo, pushupInfo = pushupInfo, readInfo
+	}
+	if readInfo.CompletionRate != 100 || readInfo.StreakStart != 2 || readInfo.StreakEnd != 9 {
+		t.Errorf("Expected Read at 100%% with the streak going from 2 to 9, got %+v", readInfo)
+	}
+	if pushupInfo.Total != "4 sets · 70 reps" {
+		t.Errorf("Expected the set-reps totals, got %q", pushupInfo.Total)
+	}
+	if len(pushupInfo.Heatmap) != 7 || pushupInfo.Heatmap[2].Status != "done" || pushupInfo.Heatmap[5].Status != "missed" || pushupInfo.Heatmap[0].Status != "none" {
+		t.Errorf("Unexpected heatmap %+v", pushupInfo.Heatmap)
+	}
+	if data.BestDay != "Wednesday 10 Jan" || data.BestDayDone != 2 {
+		t.Errorf("Expected Wednesday 10 Jan as the best day, got %q with %d", data.BestDay, data.BestDayDone)
+	}
+
+	// Only users who opted in get a digest, on the Monday morning after the week, once
+	mock := NewMockEmailService()
+	monday := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	if sent, _ := SendDigests(db, mock, monday); sent != 0 {
+		t.Errorf("Expected no digests with the digest off, got %d", sent)
+	}
+
+	prefs, _ := GetNotificationPreferences(db, int(userID))
+	prefs.DigestFrequency = DigestWeekly
+	if err := prefs.Save(db); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if sent, _ := SendDigests(db, mock, time.Date(2024, 1, 15, 7, 0, 0, 0, time.UTC)); sent != 0 {
+		t.Errorf("Expected no digest before %d:00, got %d", digestHour, sent)
+	}
+	if _, err := db.Exec("UPDATE users SET email_verified = false WHERE id = ?", userID); err != nil {
+		t.Fatalf("Failed to unverify user: %v", err)
+	}
+	if sent, _ := SendDigests(db, mock, monday); sent != 0 {
+		t.Errorf("Expected no digest to an unverified address, got %d", sent)
+	}
+	if _, err := db.Exec("UPDATE users SET email_verified = true WHERE id = ?", userID); err != nil {
+		t.Fatalf("Failed to verify user: %v", err)
+	}
+	sent, err := SendDigests(db, mock, monday)
+	if err != nil {
+		t.Fatalf("SendDigests failed: %v", err)
+	}
+	if sent != 1 {
+		t.Errorf("Expected 1 digest, got %d", sent)
+	}
+	if _, ok := mock.sentEmails["testhabit@example.com-digest"]; !ok {
+		t.Errorf("Expected a digest email, got %v", mock.sentEmails)
+	}
+	if sent, _ := SendDigests(db, mock, monday.Add(time.Hour)); sent != 0 {
+		t.Errorf("Expected the digest to be sent once, got %d more", sent)
+	}
+}
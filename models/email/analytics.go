@@ -0,0 +1,142 @@
+package email
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// EmailAnalytics are the numbers for one email of a campaign
+type EmailAnalytics struct {
+	Number       int
+	Subject      string
+	Sent         int // delivered
+	Pending      int // queued, or waiting for a retry
+	Failed       int
+	Opened       int // sends opened or clicked, for tracked campaigns
+	Clicked      int // sends with a click, for tracked campaigns
+	Unsubscribed int // sends whose unsubscribe link was used
+	Reached      int // subscribers who got at least this far through the sequence
+}
+
+// FailureRate is the percentage of attempted sends that failed
+func (a EmailAnalytics) FailureRate() float64 { return percentage(a.Failed, a.Sent+a.Failed) }
+
+// OpenRate is the percentage of sends opened
+func (a EmailAnalytics) OpenRate() float64 { return percentage(a.Opened, a.Sent) }
+
+// ClickRate is the percentage of sends with a click
+func (a EmailAnalytics) ClickRate() float64 { return percentage(a.Clicked, a.Sent) }
+
+// UnsubscribeRate is the percentage of sends that led to an unsubscribe
+func (a EmailAnalytics) UnsubscribeRate() float64 { return percentage(a.Unsubscribed, a.Sent) }
+
+// CampaignAnalytics are the numbers for a campaign and each of its emails
+type CampaignAnalytics struct {
+	Campaign     EmailCampaign
+	Subscribers  int // everyone ever subscribed, including those who left
+	Active       int
+	Unsubscribed int // before getting any email
+	Emails       []EmailAnalytics
+}
+
+// ReachedRate is the percentage of subscribers who got at least as far as the email, for the funnel
+func (c CampaignAnalytics) ReachedRate(e EmailAnalytics) float64 {
+	return percentage(e.Reached, c.Subscribers)
+}
+
+// percentage returns part as a percentage of total, or 0 without a total
+func percentage(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+// GetCampaignAnalytics returns the analytics for every campaign
+func GetCampaignAnalytics(db *sql.DB) ([]CampaignAnalytics, error) {
+	var all []CampaignAnalytics
+	for _, campaign := range GetAllCampaigns() {
+		analytics, err := getCampaignAnalytics(db, campaign)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, analytics)
+	}
+	return all, nil
+}
+
+// getCampaignAnalytics returns the analytics for a campaign
+func getCampaignAnalytics(db *sql.DB, campaign EmailCampaign) (CampaignAnalytics, error) {
+	analytics := CampaignAnalytics{Campaign: campaign}
+	byNumber := map[int]*EmailAnalytics{}
+	for _, e := range campaign.Emails {
+		analytics.Emails = append(analytics.Emails, EmailAnalytics{Number: e.Number, Subject: e.Subject})
+	}
+	for i := range analytics.Emails {
+		byNumber[analytics.Emails[i].Number] = &analytics.Emails[i]
+	}
+
+	rows, err := db.Query(`
+	SELECT es.email_number,
+	       SUM(es.status = 'success'),
+	       SUM(es.status = 'retry'),
+	       SUM(es.status = 'failed'),
+	       SUM(EXISTS (SELECT 1 FROM email_events e WHERE e.email_send_id = es.id AND e.kind IN ('open', 'click'))),
+	       SUM(EXISTS (SELECT 1 FROM email_events e WHERE e.email_send_id = es.id AND e.kind = 'click')),
+	       SUM(EXISTS (SELECT 1 FROM email_events e WHERE e.email_send_id = es.id AND e.kind = 'unsubscribe'))
+	FROM email_sends es
+	JOIN email_subscriptions s ON s.id = es.subscription_id
+	WHERE s.campaign_id = ?
+	GROUP BY es.email_number`, campaign.ID)
+	if err != nil {
+		return analytics, fmt.Errorf("error getting campaign sends: %w", err)
+	}
+	for rows.Next() {
+		var number, sent, pending, failed, opened, clicked, unsubscribed int
+		if err := rows.Scan(&number, &sent, &pending, &failed, &opened, &clicked, &unsubscribed); err != nil {
+			rows.Close()
+			return analytics, fmt.Errorf("error scanning campaign sends: %w", err)
+		}
+		if e, ok := byNumber[number]; ok {
+			e.Sent, e.Pending, e.Failed = sent, pending, failed
+			e.Opened, e.Clicked, e.Unsubscribed = opened, clicked, unsubscribed
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return analytics, fmt.Errorf("error getting campaign sends: %w", err)
+	}
+
+	// Subscribers by how far they got and whether they left, for the funnel
+	rows, err = db.Query(`
+	SELECT last_email_sent, status, COUNT(*)
+	FROM email_subscriptions
+	WHERE campaign_id = ?
+	GROUP BY last_email_sent, status`, campaign.ID)
+	if err != nil {
+		return analytics, fmt.Errorf("error getting campaign subscribers: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lastEmailSent, count int
+		var status string
+		if err := rows.Scan(&lastEmailSent, &status, &count); err != nil {
+			return analytics, fmt.Errorf("error scanning campaign subscribers: %w", err)
+		}
+		analytics.Subscribers += count
+		switch status {
+		case "active":
+			analytics.Active += count
+		case "unsubscribed":
+			if lastEmailSent == 0 {
+				analytics.Unsubscribed += count
+			}
+		}
+		for i := range analytics.Emails {
+			if analytics.Emails[i].Number <= lastEmailSent {
+				analytics.Emails[i].Reached += count
+			}
+		}
+	}
+	return analytics, rows.Err()
+}
@@ -0,0 +1,339 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Feedback is a bounce or complaint about an email we sent
+type Feedback struct {
+	Email      string
+	Kind       string // SuppressBounce or SuppressComplaint
+	Permanent  bool   // a hard bounce; soft bounces and delays are left to the outbox retries
+	Status     string // the DSN status code, e.g. 5.1.1
+	Diagnostic string // the server's reason for a bounce, or the feedback type of a complaint
+}
+
+// suppresses reports whether the feedback puts the address on the suppression list
+func (f Feedback) suppresses() bool {
+	return f.Kind == SuppressComplaint || (f.Kind == SuppressBounce && f.Permanent)
+}
+
+// ProcessFeedback suppresses the addresses that hard-bounced or complained and returns how many there were
+func ProcessFeedback(db *sql.DB, feedback []Feedback, source string) (int, error) {
+	suppressed := 0
+	for _, f := range feedback {
+		if !f.suppresses() {
+			log.Printf("Ignoring %s for %s (status %s)", f.Kind, f.Email, f.Status)
+			continue
+		}
+		detail := f.Diagnostic
+		if f.Status != "" {
+			detail = strings.TrimSpace(f.Status + " " + detail)
+		}
+		if err := Suppress(db, f.Email, f.Kind, detail, source); err != nil {
+			return suppressed, err
+		}
+		log.Printf("🚫 Suppressed %s after a %s: %s", f.Email, f.Kind, detail)
+		suppressed++
+	}
+	return suppressed, nil
+}
+
+// ParseFeedbackMessage reads the bounces and complaints from an email: delivery status notifications
+// (RFC 3464) and feedback loop reports (RFC 5965). Other messages have none.
+func ParseFeedbackMessage(r io.Reader) ([]Feedback, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading message: %w", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" {
+		return nil, nil
+	}
+
+	var feedback []Feedback
+	var complaint *Feedback
+	var originalTo string
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading report: %w", err)
+		}
+		var body io.Reader = part
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			body = base64.NewDecoder(base64.StdEncoding, part)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status":
+			blocks, err := readFieldBlocks(body)
+			if err != nil {
+				return nil, fmt.Errorf("error reading delivery status: %w", err)
+			}
+			// The first block is about the message, the rest about each recipient
+			for _, fields := range blocks[min(1, len(blocks)):] {
+				address := fieldValue(fields.Get("Final-Recipient"))
+				if address == "" {
+					address = fieldValue(fields.Get("Original-Recipient"))
+				}
+				action := strings.ToLower(fields.Get("Action"))
+				if address == "" || (action != "failed" && action != "delayed") {
+					continue
+				}
+				status := fields.Get("Status")
+				feedback = append(feedback, Feedback{
+					Email:      normalizeAddress(address),
+					Kind:       SuppressBounce,
+					Permanent:  action == "failed" && strings.HasPrefix(status, "5"),
+					Status:     status,
+					Diagnostic: fieldValue(fields.Get("Diagnostic-Code")),
+				})
+			}
+		case "message/feedback-report":
+			blocks, err := readFieldBlocks(body)
+			if err != nil {
+				return nil, fmt.Errorf("error reading feedback report: %w", err)
+			}
+			if len(blocks) == 0 {
+				continue
+			}
+			fields := blocks[0]
+			feedbackType := strings.ToLower(fields.Get("Feedback-Type"))
+			if feedbackType == "not-spam" {
+				continue
+			}
+			complaint = &Feedback{
+				Email:      normalizeAddress(fields.Get("Original-Rcpt-To")),
+				Kind:       SuppressComplaint,
+				Diagnostic: feedbackType,
+			}
+		case "message/rfc822", "text/rfc822-headers":
+			// The returned message says who it was sent to when the report doesn't
+			header, err := textproto.NewReader(bufio.NewReader(body)).ReadMIMEHeader()
+			if err != nil && err != io.EOF {
+				continue
+			}
+			originalTo = header.Get("To")
+		}
+	}
+
+	if complaint != nil {
+		if complaint.Email == "" {
+			if to, err := mail.ParseAddress(originalTo); err == nil {
+				complaint.Email = normalizeAddress(to.Address)
+			}
+		}
+		if complaint.Email != "" {
+			feedback = append(feedback, *complaint)
+		}
+	}
+	return feedback, nil
+}
+
+// readFieldBlocks reads the groups of header-style fields, separated by blank lines, in a report part
+func readFieldBlocks(r io.Reader) ([]textproto.MIMEHeader, error) {
+	reader := textproto.NewReader(bufio.NewReader(r))
+	var blocks []textproto.MIMEHeader
+	for {
+		fields, err := reader.ReadMIMEHeader()
+		if len(fields) > 0 {
+			blocks = append(blocks, fields)
+		}
+		if err == io.EOF {
+			return blocks, nil
+		}
+		if err != nil {
+			return blocks, err
+		}
+	}
+}
+
+// fieldValue strips the type from a typed DSN field, e.g. "rfc822; sam@example.com"
+func fieldValue(value string) string {
+	if _, rest, ok := strings.Cut(value, ";"); ok {
+		return strings.TrimSpace(rest)
+	}
+	return strings.TrimSpace(value)
+}
+
+// ProcessBounceMailbox reads the bounces and complaints delivered to a maildir or mbox and suppresses the
+// addresses. Maildir messages are moved to cur once read; an mbox is emptied. It returns the number of
+// addresses suppressed.
+func ProcessBounceMailbox(db *sql.DB, path string) (int, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir() {
+		return processMaildir(db, path)
+	}
+	return processMbox(db, path)
+}
+
+// processMaildir processes the new messages in a maildir and moves them to cur
+func processMaildir(db *sql.DB, dir string) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		return 0, fmt.Errorf("error reading maildir: %w", err)
+	}
+	suppressed := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, "new", entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return suppressed, err
+		}
+		n, err := processFeedbackMessage(db, data, entry.Name())
+		suppressed += n
+		if err != nil {
+			return suppressed, err
+		}
+		// Mark it seen, as a mail client would
+		if err := os.Rename(path, filepath.Join(dir, "cur", entry.Name()+":2,S")); err != nil {
+			return suppressed, fmt.Errorf("error moving processed message: %w", err)
+		}
+	}
+	return suppressed, nil
+}
+
+// processMbox processes the messages in an mbox. The file is moved aside first, so mail delivered meanwhile
+// starts a new mbox instead of being lost, and an interrupted run is picked up next time.
+func processMbox(db *sql.DB, path string) (int, error) {
+	processing := path + ".processing"
+	if _, err := os.Stat(processing); errors.Is(err, os.ErrNotExist) {
+		if err := os.Rename(path, processing); err != nil {
+			return 0, fmt.Errorf("error moving mbox aside: %w", err)
+		}
+	}
+	data, err := os.ReadFile(processing)
+	if err != nil {
+		return 0, err
+	}
+
+	suppressed := 0
+	for i, message := range splitMbox(data) {
+		n, err := processFeedbackMessage(db, message, fmt.Sprintf("%s #%d", filepath.Base(path), i+1))
+		suppressed += n
+		if err != nil {
+			return suppressed, err
+		}
+	}
+	return suppressed, os.Remove(processing)
+}
+
+// splitMbox splits an mbox into its messages, undoing the >From quoting of body lines
+func splitMbox(data []byte) [][]byte {
+	var messages [][]byte
+	var current []byte
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("From ")) {
+			if len(bytes.TrimSpace(current)) > 0 {
+				messages = append(messages, current)
+			}
+			current = nil
+			continue
+		}
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			line = line[1:]
+		}
+		current = append(current, line...)
+	}
+	if len(bytes.TrimSpace(current)) > 0 {
+		messages = append(messages, current)
+	}
+	return messages
+}
+
+// processFeedbackMessage suppresses the addresses in one message from the mailbox. Messages that can't be
+// parsed are logged and skipped so they don't block the ones after them.
+func processFeedbackMessage(db *sql.DB, data []byte, name string) (int, error) {
+	feedback, err := ParseFeedbackMessage(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Skipping unreadable bounce message %s: %v", name, err)
+		return 0, nil
+	}
+	return ProcessFeedback(db, feedback, "mailbox")
+}
+
+// WebhookEvent is a bounce or complaint posted to the feedback webhook. Providers' notifications are mapped
+// to this format, e.g. by a small relay:
+//
+//	{"type": "bounce", "email": "sam@example.com", "bounce_type": "hard", "status": "5.1.1", "diagnostic": "user unknown"}
+//	{"type": "complaint", "email": "sam@example.com", "feedback_type": "abuse"}
+type WebhookEvent struct {
+	Type         string `json:"type"` // bounce or complaint
+	Email        string `json:"email"`
+	BounceType   string `json:"bounce_type"` // hard or soft; without it the status decides
+	Status       string `json:"status"`
+	Diagnostic   string `json:"diagnostic"`
+	FeedbackType string `json:"feedback_type"`
+}
+
+// ParseWebhookEvents reads one webhook event or an array of them
+func ParseWebhookEvents(body []byte) ([]Feedback, error) {
+	var events []WebhookEvent
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("[")) {
+		if err := json.Unmarshal(body, &events); err != nil {
+			return nil, fmt.Errorf("invalid events: %w", err)
+		}
+	} else {
+		var event WebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, fmt.Errorf("invalid event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	feedback := make([]Feedback, 0, len(events))
+	for _, event := range events {
+		if event.Email == "" {
+			return nil, errors.New("event without an email address")
+		}
+		f := Feedback{Email: normalizeAddress(event.Email), Status: event.Status, Diagnostic: event.Diagnostic}
+		switch strings.ToLower(event.Type) {
+		case "bounce":
+			f.Kind = SuppressBounce
+			switch strings.ToLower(event.BounceType) {
+			case "hard", "permanent":
+				f.Permanent = true
+			case "soft", "transient":
+			default:
+				f.Permanent = strings.HasPrefix(event.Status, "5")
+			}
+		case "complaint":
+			f.Kind = SuppressComplaint
+			f.Diagnostic = event.FeedbackType
+		default:
+			return nil, fmt.Errorf("unknown event type %q", event.Type)
+		}
+		feedback = append(feedback, f)
+	}
+	return feedback, nil
+}
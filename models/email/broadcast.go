@@ -0,0 +1,104 @@
+package email
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// BroadcastList is the list one-off announcements are sent on, which readers can leave like any notification
+var BroadcastList = NotificationList("announcement")
+
+// BroadcastTemplate returns the template for an announcement written by an admin. The body is markdown and a
+// template itself, so it can greet readers with {{ .FirstName }}; it's shown in the campaign email layout.
+func BroadcastTemplate(subject, body string) EmailTemplate {
+	return EmailTemplate{
+		Name:     "broadcast",
+		Subject:  subject,
+		markdown: body,
+		list:     BroadcastList,
+	}
+}
+
+// BroadcastSendTemplate returns the template for one recipient of an announcement. When the email is queued,
+// the outbox records whether it was delivered on the recipient's broadcast_sends row.
+func BroadcastSendTemplate(subject, body string, sendID int64) EmailTemplate {
+	template := BroadcastTemplate(subject, body)
+	template.broadcastSendID = sendID
+	return template
+}
+
+// completeBroadcastSend records the outcome of a queued announcement. Retries leave the send queued.
+func completeBroadcastSend(db *sql.DB, sendID int64, status, errorMsg string) error {
+	var err error
+	switch status {
+	case "success":
+		_, err = db.Exec(`
+			UPDATE broadcast_sends SET status = 'sent', error_message = NULL, sent_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND status = 'queued'`, sendID)
+	case "failed":
+		_, err = db.Exec(`
+			UPDATE broadcast_sends SET status = 'failed', error_message = ?
+			WHERE id = ? AND status = 'queued'`, errorMsg, sendID)
+	}
+	if err != nil {
+		return fmt.Errorf("error updating broadcast send: %w", err)
+	}
+	return nil
+}
+
+// BroadcastEmailData returns the data for an announcement to the address. The preferences link may be empty.
+func BroadcastEmailData(firstName, address, subject, preferencesLink string) map[string]interface{} {
+	if firstName == "" {
+		firstName = "there"
+	}
+	return map[string]interface{}{
+		"FirstName":       firstName,
+		"Email":           address,
+		"Title":           subject,
+		"Subject":         subject,
+		"AppName":         "The Habits Company",
+		"CampaignName":    "announcements from The Habits Company",
+		"CampaignEmoji":   "📣",
+		"UnsubscribeLink": OneClickUnsubscribeURL(address, BroadcastList),
+		"PreferencesLink": preferencesLink,
+	}
+}
+
+// ValidateBroadcast checks that an announcement has a subject and a body that renders, so mistakes show up in
+// the preview rather than in every recipient's inbox
+func ValidateBroadcast(subject, body string) error {
+	if strings.TrimSpace(subject) == "" {
+		return errors.New("the subject is empty")
+	}
+	if strings.TrimSpace(body) == "" {
+		return errors.New("the message is empty")
+	}
+	_, _, err := renderBroadcastBody(body, BroadcastEmailData("Sam", "sam@example.com", subject, ""))
+	return err
+}
+
+// renderBroadcast renders an announcement's markdown into the campaign layout
+func (s *SMTPEmailService) renderBroadcast(body string, data interface{}) (htmlContent, textContent string, err error) {
+	fields, ok := data.(map[string]interface{})
+	if !ok {
+		return "", "", fmt.Errorf("broadcast data must be a map, got %T", data)
+	}
+	contentHTML, contentText, err := renderBroadcastBody(body, fields)
+	if err != nil {
+		return "", "", err
+	}
+	return s.renderBaseTemplates(contentHTML, contentText, fields)
+}
+
+// renderBroadcastBody renders an announcement's markdown as HTML and as text. Unknown fields are an error
+// rather than "<no value>" in the email.
+func renderBroadcastBody(body string, data map[string]interface{}) (htmlContent, textContent string, err error) {
+	tmpl, err := template.New("broadcast").Option("missingkey=error").Parse(body)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid message: %w", err)
+	}
+	return renderMarkdownBody(tmpl, data)
+}
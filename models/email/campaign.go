@@ -1,12 +1,14 @@
 package email
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/url"
 	"os"
+	"sort"
 	"time"
 )
 
@@ -16,7 +18,10 @@ type EmailCampaign struct {
 	Name          string
 	Description   string
 	Emoji         string
-	AutoSubscribe bool // Whether new users should be auto-subscribed
+	AutoSubscribe bool             // Whether new users should be auto-subscribed
+	Trigger       *CampaignTrigger // When set, users are enrolled when the scheduler sees the event
+	Segment       string           // When set, only users in this segment are enrolled by the trigger
+	Tracking      bool             // Whether emails count opens and clicks
 	Emails        []CampaignEmail
 }
 
@@ -36,7 +41,7 @@ type EmailSubscription struct {
 	Email          string
 	CampaignID     string
 	SubscribedAt   time.Time
-	Status         string // "active" or "unsubscribed"
+	Status         string // "pending", "active", "paused" or "unsubscribed"
 	LastEmailSent  int    // The last email number sent
 	UnsubscribedAt sql.NullTime
 	CreatedAt      time.Time
@@ -57,107 +62,6 @@ type EmailSend struct {
 	CreatedAt      time.Time
 }
 
-// Define all available campaigns
-var Campaigns = map[string]EmailCampaign{
-	"onboarding": {
-		ID:            "onboarding",
-		Name:          "Getting Started with Habits",
-		Description:   "Learn the basics of using Habits to build consistent routines.",
-		Emoji:         "🚀",
-		AutoSubscribe: true, // Auto-subscribe all new users
-		Emails: []CampaignEmail{
-			{
-				Number:       1,
-				Subject:      "Welcome to Habits!",
-				Title:        "Welcome to Your Habit Journey",
-				TemplateName: "courses/onboarding/1-welcome",
-				SendDay:      0, // Send immediately after registration
-			},
-			{
-				Number:       2,
-				Subject:      "Track Your First Week of Habits",
-				Title:        "Building Momentum: Your First Week",
-				TemplateName: "courses/onboarding/2-first-week",
-				SendDay:      1, // Send 1 day after registration
-			},
-			{
-				Number:       3,
-				Subject:      "Setting Effective Goals",
-				Title:        "The Power of Goal Setting",
-				TemplateName: "courses/onboarding/3-goals",
-				SendDay:      3, // Send 3 days after registration
-			},
-			{
-				Number:       4,
-				Subject:      "Creating a Habit Routine",
-				Title:        "Building a Routine for Success",
-				TemplateName: "courses/onboarding/4-routine",
-				SendDay:      7, // Send 7 days after registration
-			},
-		},
-	},
-	"digital-detox": {
-		ID:            "digital-detox",
-		Name:          "Digital Detox",
-		Description:   "Break free from digital dependence and reclaim your focus.",
-		Emoji:         "📱",
-		AutoSubscribe: false, // Opt-in only
-		Emails: []CampaignEmail{
-			{
-				Number:       1,
-				Subject:      "Day 1: The Phone Addiction Pandemic",
-				Title:        "Day 1: The Phone Addiction Pandemic",
-				TemplateName: "courses/digital-detox/1-phone-addiction",
-				SendDay:      0, // Send immediately
-			},
-		},
-	},
-	"phone-addiction": {
-		ID:            "phone-addiction",
-		Name:          "Breaking Phone Addiction",
-		Description:   "Break the cycle of smartphone addiction and regain control of your time and attention.",
-		Emoji:         "📵",
-		AutoSubscribe: false, // Opt-in only
-		Emails: []CampaignEmail{
-			{
-				Number:       1,
-				Subject:      "Day 1: The Phone Addiction Pandemic",
-				Title:        "Day 1: The Phone Addiction Pandemic",
-				TemplateName: "courses/phone-addiction/1-phone-addiction",
-				SendDay:      0, // Send immediately
-			},
-			{
-				Number:       2,
-				Subject:      "Day 2: How Social Media Exploits Your Caveman Brain",
-				Title:        "Day 2: How Social Media Exploits Your Caveman Brain",
-				TemplateName: "courses/phone-addiction/2-caveman-brain",
-				SendDay:      1, // Send 1 day after signup
-			},
-			{
-				Number:       3,
-				Subject:      "Day 3: 3 Ways to Break Free from Phone Addiction",
-				Title:        "Day 3: 3 Ways to Break Free from Phone Addiction",
-				TemplateName: "courses/phone-addiction/3-break-free-phone-addiction",
-				SendDay:      2, // Send 2 days after signup
-			},
-			{
-				Number:       4,
-				Subject:      "Day 4: Remix Your Routine",
-				Title:        "Day 4: Remix Your Routine",
-				TemplateName: "courses/phone-addiction/4-routine",
-				SendDay:      3, // Send 3 days after signup
-			},
-			{
-				Number:       5,
-				Subject:      "Day 5: Do a 24-Hour Digital Detox",
-				Title:        "Day 5: Do a 24-Hour Digital Detox",
-				TemplateName: "courses/phone-addiction/5-detox",
-				SendDay:      4, // Send 4 days after signup
-			},
-		},
-	},
-}
-
 // CampaignManager handles database operations for email campaigns
 type CampaignManager struct {
 	db       *sql.DB
@@ -174,26 +78,33 @@ func NewCampaignManager(db *sql.DB, emailSvc EmailService) *CampaignManager {
 
 // GetCampaign returns a campaign by ID or an error if not found
 func GetCampaign(campaignID string) (EmailCampaign, error) {
-	campaign, exists := Campaigns[campaignID]
+	campaignsMu.RLock()
+	defer campaignsMu.RUnlock()
+
+	campaign, exists := campaigns[campaignID]
 	if !exists {
 		return EmailCampaign{}, fmt.Errorf("campaign with ID %s not found", campaignID)
 	}
 	return campaign, nil
 }
 
-// GetAllCampaigns returns a slice of all available campaigns
+// GetAllCampaigns returns a slice of all available campaigns, sorted by ID
 func GetAllCampaigns() []EmailCampaign {
-	campaigns := make([]EmailCampaign, 0, len(Campaigns))
-	for _, campaign := range Campaigns {
-		campaigns = append(campaigns, campaign)
+	campaignsMu.RLock()
+	defer campaignsMu.RUnlock()
+
+	all := make([]EmailCampaign, 0, len(campaigns))
+	for _, campaign := range campaigns {
+		all = append(all, campaign)
 	}
-	return campaigns
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all
 }
 
 // GetAutoSubscribeCampaigns returns all campaigns that should auto-subscribe new users
 func GetAutoSubscribeCampaigns() []EmailCampaign {
 	var autoSubscribeCampaigns []EmailCampaign
-	for _, campaign := range Campaigns {
+	for _, campaign := range GetAllCampaigns() {
 		if campaign.AutoSubscribe {
 			autoSubscribeCampaigns = append(autoSubscribeCampaigns, campaign)
 		}
@@ -201,8 +112,19 @@ func GetAutoSubscribeCampaigns() []EmailCampaign {
 	return autoSubscribeCampaigns
 }
 
-// GenerateUnsubscribeLink creates a unique unsubscribe link for a campaign subscription
-func GenerateUnsubscribeLink(email string, campaignID string, token string) string {
+// GetTriggeredCampaigns returns all campaigns started by a trigger rather than by subscribing
+func GetTriggeredCampaigns() []EmailCampaign {
+	var triggered []EmailCampaign
+	for _, campaign := range GetAllCampaigns() {
+		if campaign.Trigger != nil {
+			triggered = append(triggered, campaign)
+		}
+	}
+	return triggered
+}
+
+// linkBaseURL returns the base URL for links in emails
+func linkBaseURL() string {
 	// Check for explicit BASE_URL first
 	baseURL := os.Getenv("BASE_URL")
 
@@ -216,14 +138,29 @@ func GenerateUnsubscribeLink(email string, campaignID string, token string) stri
 			baseURL = "http://localhost:8080"
 		}
 	}
+	return baseURL
+}
 
+// GenerateUnsubscribeLink creates a unique unsubscribe link for a campaign subscription
+func GenerateUnsubscribeLink(email string, campaignID string, token string) string {
 	// Use url.QueryEscape to properly encode the parameters
 	emailEncoded := url.QueryEscape(email)
 	campaignEncoded := url.QueryEscape(campaignID)
 	tokenEncoded := url.QueryEscape(token)
 
 	return fmt.Sprintf("%s/unsubscribe?email=%s&campaign=%s&token=%s",
-		baseURL, emailEncoded, campaignEncoded, tokenEncoded)
+		linkBaseURL(), emailEncoded, campaignEncoded, tokenEncoded)
+}
+
+// GenerateVerificationLink creates the link confirming an email address, for an account or a subscription
+func GenerateVerificationLink(token string) string {
+	return fmt.Sprintf("%s/verify-email?token=%s", linkBaseURL(), url.QueryEscape(token))
+}
+
+// GeneratePreferencesLink creates the link to a user's notification preferences, usable without logging in
+func GeneratePreferencesLink(email string, token string) string {
+	return fmt.Sprintf("%s/unsubscribe?email=%s&token=%s",
+		linkBaseURL(), url.QueryEscape(email), url.QueryEscape(token))
 }
 
 // CampaignEmailData returns the data needed for a campaign email template
@@ -248,100 +185,8 @@ func CampaignEmailData(firstName, email, campaignID string, emailNumber int) (ma
 		return nil, fmt.Errorf("email number %d not found in campaign %s", emailNumber, campaignID)
 	}
 
-	// Use the secure token from the database
-	unsubscribeToken := ""
-
-	// Get the database path from environment
-	dbPath := os.Getenv("DATABASE_PATH")
-	if dbPath == "" {
-		dbPath = "habits.db" // Default fallback
-		log.Printf("Warning: DATABASE_PATH not set, using default path 'habits.db'")
-	}
-
-	log.Printf("Opening database at: %s", dbPath)
-	db, err := sql.Open("sqlite3", dbPath)
-	if err != nil {
-		log.Printf("Error opening database to retrieve token: %v", err)
-		// Don't fall back to a timestamp - log the error and return it
-		return nil, fmt.Errorf("could not open database to get unsubscribe token: %w", err)
-	}
-	defer db.Close()
-
-	// First check if the subscription exists and what the token is
-	log.Printf("Querying token for email=%s, campaign=%s", email, campaignID)
-
-	err = db.QueryRow(`
-		SELECT token 
-		FROM email_subscriptions
-		WHERE email = ?
-		AND campaign_id = ?
-		AND status = 'active'
-	`, email, campaignID).Scan(&unsubscribeToken)
-
-	if err != nil {
-		log.Printf("Error retrieving token from database: %v", err)
-
-		// Check if subscription exists at all
-		var exists bool
-		err = db.QueryRow(`
-			SELECT EXISTS(
-				SELECT 1 FROM email_subscriptions 
-				WHERE email = ? AND campaign_id = ?
-			)
-		`, email, campaignID).Scan(&exists)
-
-		if err != nil {
-			log.Printf("Error checking if subscription exists: %v", err)
-			return nil, fmt.Errorf("database error: %w", err)
-		}
-
-		if !exists {
-			log.Printf("No subscription found for email=%s, campaign=%s - creating one", email, campaignID)
-
-			// Create a real subscription with a proper secure token
-			// Use the same secure token generation as in real subscriptions
-			unsubscribeToken = generateSecureToken()
-			log.Printf("Generated secure token for new subscription: %s", unsubscribeToken)
-
-			// Insert the subscription with this token
-			_, err = db.Exec(`
-				INSERT INTO email_subscriptions (
-					user_id, email, campaign_id, token, subscribed_at, 
-					status, last_email_sent, created_at, updated_at
-				) VALUES (NULL, ?, ?, ?, CURRENT_TIMESTAMP, 'active', 0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-			`, email, campaignID, unsubscribeToken)
-
-			if err != nil {
-				log.Printf("Error creating subscription: %v", err)
-				return nil, fmt.Errorf("could not create subscription: %w", err)
-			}
-
-			log.Printf("Successfully created subscription with secure token")
-		} else {
-			// Subscription exists but is not active or couldn't get token
-			log.Printf("Subscription exists but couldn't get token - creating a new one")
-
-			// Generate a new secure token and update the subscription
-			unsubscribeToken = generateSecureToken()
-
-			_, err = db.Exec(`
-				UPDATE email_subscriptions
-				SET token = ?,
-				    status = 'active',
-				    updated_at = CURRENT_TIMESTAMP
-				WHERE email = ? AND campaign_id = ?
-			`, unsubscribeToken, email, campaignID)
-
-			if err != nil {
-				log.Printf("Error updating subscription token: %v", err)
-				return nil, fmt.Errorf("could not update subscription token: %w", err)
-			}
-
-			log.Printf("Successfully updated subscription with new secure token: %s", unsubscribeToken)
-		}
-	} else {
-		log.Printf("Successfully retrieved token from database: %s", unsubscribeToken)
-	}
+	// The signed token needs no database, so previews and tests get a working link too
+	unsubscribeToken := UnsubscribeToken(email, CampaignList(campaignID))
 
 	// Create the data for the email template
 	data := map[string]interface{}{
@@ -356,7 +201,7 @@ func CampaignEmailData(firstName, email, campaignID string, emailNumber int) (ma
 		"UnsubscribeLink": GenerateUnsubscribeLink(email, campaignID, unsubscribeToken),
 	}
 
-	log.Printf("📧 Prepared campaign email data for %s, email #%d with token %s", campaignID, emailNumber, unsubscribeToken)
+	log.Printf("📧 Prepared campaign email data for %s, email #%d", campaignID, emailNumber)
 	return data, nil
 }
 
@@ -433,6 +278,158 @@ func (cm *CampaignManager) SubscribeUser(email string, campaignID string, userID
 	return nil
 }
 
+// SubscribePending subscribes an address that hasn't been confirmed yet. The subscription stays pending, and no
+// emails are sent, until ConfirmSubscription is called for it. It reports whether the address needs confirming:
+// false when it's already actively subscribed.
+func (cm *CampaignManager) SubscribePending(email string, campaignID string, userID int) (bool, error) {
+	if _, err := GetCampaign(campaignID); err != nil {
+		return false, err
+	}
+
+	var id int
+	var status string
+	err := cm.db.QueryRow(`
+	SELECT id, status
+	FROM email_subscriptions
+	WHERE email = ? AND campaign_id = ?`, email, campaignID).Scan(&id, &status)
+	if err != nil && err != sql.ErrNoRows {
+		return false, fmt.Errorf("error checking existing subscription: %w", err)
+	}
+
+	switch {
+	case err == sql.ErrNoRows:
+		var userIDValue sql.NullInt64
+		if userID > 0 {
+			userIDValue = sql.NullInt64{Int64: int64(userID), Valid: true}
+		}
+		_, err = cm.db.Exec(`
+		INSERT INTO email_subscriptions (
+			user_id, email, campaign_id, token, subscribed_at,
+			status, last_email_sent, created_at, updated_at
+		) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, 'pending', 0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+			userIDValue, email, campaignID, generateSecureToken())
+		if err != nil {
+			return false, fmt.Errorf("error creating subscription: %w", err)
+		}
+	case status == "unsubscribed":
+		_, err = cm.db.Exec(`
+		UPDATE email_subscriptions
+		SET status = 'pending',
+		    last_email_sent = 0,
+		    unsubscribed_at = NULL,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, id)
+		if err != nil {
+			return false, fmt.Errorf("error resubscribing: %w", err)
+		}
+	case status != "pending":
+		log.Printf("%s already subscribed to campaign %s", email, campaignID)
+		return false, nil
+	}
+
+	log.Printf("Subscription of %s to campaign %s is waiting for confirmation", email, campaignID)
+	return true, nil
+}
+
+// ConfirmSubscription activates a pending subscription once its address is confirmed. The campaign starts
+// from the confirmation, not from when the form was sent.
+func (cm *CampaignManager) ConfirmSubscription(email string, campaignID string) error {
+	return confirmSubscription(cm.db, email, campaignID)
+}
+
+// ConfirmSubscriptionTx is ConfirmSubscription within a transaction, so the confirmation can be rolled back
+// with the changes around it
+func (cm *CampaignManager) ConfirmSubscriptionTx(tx *sql.Tx, email string, campaignID string) error {
+	return confirmSubscription(tx, email, campaignID)
+}
+
+// dbExecutor is a database or a transaction
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func confirmSubscription(db dbExecutor, email string, campaignID string) error {
+	_, err := db.Exec(`
+	UPDATE email_subscriptions
+	SET status = 'active',
+	    subscribed_at = CURRENT_TIMESTAMP,
+	    updated_at = CURRENT_TIMESTAMP
+	WHERE email = ? AND campaign_id = ? AND status = 'pending'`, email, campaignID)
+	if err != nil {
+		return fmt.Errorf("error confirming subscription: %w", err)
+	}
+	log.Printf("Confirmed subscription of %s to campaign %s", email, campaignID)
+	return nil
+}
+
+// EnrollUser starts a campaign for a user from its first email, for triggers and segment targeting. Unlike
+// SubscribeUser it leaves alone anyone who unsubscribed from the campaign or is still part way through it; a
+// finished subscription starts over. It reports whether the user was enrolled.
+func (cm *CampaignManager) EnrollUser(email string, campaignID string, userID int) (bool, error) {
+	campaign, err := GetCampaign(campaignID)
+	if err != nil {
+		return false, err
+	}
+
+	var id, lastEmailSent int
+	var status string
+	err = cm.db.QueryRow(`
+	SELECT id, status, last_email_sent
+	FROM email_subscriptions
+	WHERE email = ? AND campaign_id = ?`, email, campaignID).Scan(&id, &status, &lastEmailSent)
+	if err == sql.ErrNoRows {
+		if err := cm.SubscribeUser(email, campaignID, userID); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error checking existing subscription: %w", err)
+	}
+
+	lastNumber := 0
+	if len(campaign.Emails) > 0 {
+		lastNumber = campaign.Emails[len(campaign.Emails)-1].Number
+	}
+	if status != "active" || lastEmailSent < lastNumber {
+		return false, nil
+	}
+
+	_, err = cm.db.Exec(`
+	UPDATE email_subscriptions 
+	SET last_email_sent = 0,
+	    subscribed_at = CURRENT_TIMESTAMP,
+	    updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?`, id)
+	if err != nil {
+		return false, fmt.Errorf("error restarting subscription: %w", err)
+	}
+	log.Printf("Restarted campaign %s for %s", campaignID, email)
+	return true, nil
+}
+
+// reachedFrequencyCap reports whether an address has had as many campaign emails as the frequency cap allows
+func (cm *CampaignManager) reachedFrequencyCap(email string) (bool, error) {
+	frequencyCap := GetFrequencyCap()
+	if frequencyCap.Emails == 0 {
+		return false, nil
+	}
+
+	var sent int
+	err := cm.db.QueryRow(`
+	SELECT COUNT(*)
+	FROM email_sends es
+	JOIN email_subscriptions s ON s.id = es.subscription_id
+	WHERE s.email = ?
+	AND es.status IN ('success', 'retry')
+	AND es.created_at > datetime('now', ?)`,
+		email, fmt.Sprintf("-%d days", frequencyCap.Days)).Scan(&sent)
+	if err != nil {
+		return false, fmt.Errorf("error counting recent campaign emails: %w", err)
+	}
+	return sent >= frequencyCap.Emails, nil
+}
+
 // UnsubscribeUser unsubscribes a user from a campaign
 func (cm *CampaignManager) UnsubscribeUser(email string, campaignID string) error {
 	query := `
@@ -475,13 +472,16 @@ func (cm *CampaignManager) AutoSubscribeUser(email string, userID int) error {
 	return nil
 }
 
-// GetPendingEmails returns subscriptions that need emails sent
+// GetPendingEmails returns subscriptions that need emails sent. Suppressed addresses and accounts that haven't
+// verified their email wait, and pick up where they left off once that changes.
 func (cm *CampaignManager) GetPendingEmails() ([]EmailSubscription, error) {
 	query := `
 	SELECT id, user_id, email, campaign_id, subscribed_at, status, last_email_sent, 
 	       unsubscribed_at, created_at, updated_at
 	FROM email_subscriptions 
-	WHERE status = 'active'`
+	WHERE status = 'active'
+	  AND LOWER(email) NOT IN (SELECT email FROM email_suppressions)
+	  AND (user_id IS NULL OR user_id IN (SELECT id FROM users WHERE email_verified = true))`
 
 	rows, err := cm.db.Query(query)
 	if err != nil {
@@ -504,42 +504,6 @@ func (cm *CampaignManager) GetPendingEmails() ([]EmailSubscription, error) {
 	return subscriptions, nil
 }
 
-// LogEmailSend records an email send in the database
-func (cm *CampaignManager) LogEmailSend(subscriptionID int, emailNumber int, templateName, subject, status string, errorMsg string) error {
-	var errorValue sql.NullString
-	if errorMsg != "" {
-		errorValue.String = errorMsg
-		errorValue.Valid = true
-	}
-
-	query := `
-	INSERT INTO email_sends (
-		subscription_id, email_number, template_name, subject, status, sent_at, 
-		error_message, retry_count, created_at
-	) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?, 0, CURRENT_TIMESTAMP)`
-
-	_, err := cm.db.Exec(query, subscriptionID, emailNumber, templateName, subject, status, errorValue)
-	if err != nil {
-		return fmt.Errorf("error logging email send: %w", err)
-	}
-
-	// If successful, update last_email_sent in subscription
-	if status == "success" {
-		updateQuery := `
-		UPDATE email_subscriptions 
-		SET last_email_sent = ?,
-		    updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?`
-
-		_, err = cm.db.Exec(updateQuery, emailNumber, subscriptionID)
-		if err != nil {
-			return fmt.Errorf("error updating subscription: %w", err)
-		}
-	}
-
-	return nil
-}
-
 // SendCampaignEmail sends a specific email to a subscriber
 func (cm *CampaignManager) SendCampaignEmail(subscription EmailSubscription, emailNumber int) error {
 	campaign, err := GetCampaign(subscription.CampaignID)
@@ -564,12 +528,15 @@ func (cm *CampaignManager) SendCampaignEmail(subscription EmailSubscription, ema
 
 	// Get first name from users table if this is a registered user
 	firstName := "there" // Default
+	preferencesToken := ""
 	if subscription.UserID.Valid {
-		var fname string
-		err := cm.db.QueryRow("SELECT first_name FROM users WHERE id = ?", subscription.UserID.Int64).Scan(&fname)
+		var fname, token string
+		err := cm.db.QueryRow("SELECT first_name, COALESCE(preferences_token, '') FROM users WHERE id = ?",
+			subscription.UserID.Int64).Scan(&fname, &token)
 		if err == nil && fname != "" {
 			firstName = fname
 		}
+		preferencesToken = token
 	}
 
 	// Prepare email data
@@ -578,34 +545,98 @@ func (cm *CampaignManager) SendCampaignEmail(subscription EmailSubscription, ema
 		return err
 	}
 
+	// Registered users can manage all their notifications from the footer
+	if preferencesToken != "" {
+		emailData["PreferencesLink"] = GeneratePreferencesLink(subscription.Email, preferencesToken)
+	}
+
 	// Create email template
 	template := EmailTemplate{
 		Name:    campaignEmail.TemplateName,
 		Subject: campaignEmail.Subject,
+		track:   campaign.Tracking,
+		list:    CampaignList(campaign.ID),
 	}
 
-	// Try to send the email
-	err = cm.emailSvc.SendTypedEmail(subscription.Email, template, emailData)
-	status := "success"
-	errorMsg := ""
-
+	// The send is recorded first so its links can carry its ID, queued emails as a retry until the outbox
+	// delivers them or gives up. Either way the subscription moves on now so the email isn't queued twice.
+	queued := QueuesEmails(cm.emailSvc)
+	sendID, err := cm.startEmailSend(subscription.ID, emailNumber, campaignEmail.TemplateName, campaignEmail.Subject)
 	if err != nil {
-		status = "failed"
-		errorMsg = err.Error()
+		return err
+	}
+	template.emailSendID = sendID
+	if link, ok := emailData["UnsubscribeLink"].(string); ok {
+		emailData["UnsubscribeLink"] = UnsubscribeSendLink(link, sendID)
+	}
+	if err := cm.emailSvc.SendTypedEmail(subscription.Email, template, emailData); err != nil {
 		log.Printf("❌ Failed to send campaign email: %v", err)
+		return completeEmailSend(cm.db, sendID, "failed", err.Error(), 0)
+	}
+	if queued {
+		log.Printf("📮 Queued campaign email #%d to %s", emailNumber, subscription.Email)
 	} else {
 		log.Printf("✅ Successfully sent campaign email #%d to %s", emailNumber, subscription.Email)
+		if err := completeEmailSend(cm.db, sendID, "success", "", 0); err != nil {
+			return err
+		}
 	}
+	return cm.advanceSubscription(subscription.ID, emailNumber)
+}
 
-	// Log the send attempt
-	return cm.LogEmailSend(
-		subscription.ID,
-		emailNumber,
-		campaignEmail.TemplateName,
-		campaignEmail.Subject,
-		status,
-		errorMsg,
-	)
+// QueuesEmails reports whether the email service delivers through an outbox, so sending only queues the email
+func QueuesEmails(svc EmailService) bool {
+	smtpService, ok := svc.(*SMTPEmailService)
+	return ok && smtpService.outbox != nil
+}
+
+// startEmailSend records a queued email send, to be completed once the outbox is done with it
+func (cm *CampaignManager) startEmailSend(subscriptionID int, emailNumber int, templateName, subject string) (int64, error) {
+	result, err := cm.db.Exec(`
+	INSERT INTO email_sends (
+		subscription_id, email_number, template_name, subject, status, sent_at, retry_count, created_at
+	) VALUES (?, ?, ?, ?, 'retry', NULL, 0, CURRENT_TIMESTAMP)`,
+		subscriptionID, emailNumber, templateName, subject)
+	if err != nil {
+		return 0, fmt.Errorf("error logging email send: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// completeEmailSend records the outcome of a queued email send
+func completeEmailSend(db *sql.DB, sendID int64, status, errorMsg string, retries int) error {
+	var errorValue sql.NullString
+	if errorMsg != "" {
+		errorValue.String = errorMsg
+		errorValue.Valid = true
+	}
+
+	query := `
+	UPDATE email_sends
+	SET status = ?,
+	    error_message = ?,
+	    retry_count = ?,
+	    sent_at = CASE WHEN ? = 'success' THEN CURRENT_TIMESTAMP ELSE sent_at END
+	WHERE id = ?`
+
+	if _, err := db.Exec(query, status, errorValue, retries, status, sendID); err != nil {
+		return fmt.Errorf("error updating email send: %w", err)
+	}
+	return nil
+}
+
+// advanceSubscription records the last email sent to a subscription
+func (cm *CampaignManager) advanceSubscription(subscriptionID int, emailNumber int) error {
+	updateQuery := `
+	UPDATE email_subscriptions 
+	SET last_email_sent = ?,
+	    updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?`
+
+	if _, err := cm.db.Exec(updateQuery, emailNumber, subscriptionID); err != nil {
+		return fmt.Errorf("error updating subscription: %w", err)
+	}
+	return nil
 }
 
 // SendPendingCampaignEmails checks for and sends any pending campaign emails
@@ -655,6 +686,14 @@ func (cm *CampaignManager) SendPendingCampaignEmailsWithLimit(batchSize int) err
 				continue
 			}
 
+			// Hold the email back while the address is at the frequency cap; it goes out on a later run
+			if capped, err := cm.reachedFrequencyCap(sub.Email); err != nil {
+				log.Printf("Error checking frequency cap for %s: %v", sub.Email, err)
+				break
+			} else if capped {
+				break
+			}
+
 			// We found an email that needs to be sent
 			err := cm.SendCampaignEmail(sub, email.Number)
 			if err != nil {
@@ -789,8 +828,13 @@ func (cm *CampaignManager) HandleUserDeletion(userID int64) error {
 	return tx.Commit()
 }
 
-// ValidateUnsubscribeToken checks if the provided token is valid for the given email and campaign
+// ValidateUnsubscribeToken checks if the provided token is valid for the given email and campaign. Links carry
+// a signed token; the token stored with the subscription is still accepted for emails sent before.
 func (cm *CampaignManager) ValidateUnsubscribeToken(email, campaignID, token string) (bool, error) {
+	if ValidUnsubscribeToken(email, CampaignList(campaignID), token) {
+		return true, nil
+	}
+
 	var storedToken string
 	err := cm.db.QueryRow(`
 		SELECT token 
@@ -809,11 +853,9 @@ func (cm *CampaignManager) ValidateUnsubscribeToken(email, campaignID, token str
 		return false, fmt.Errorf("database error: %v", err)
 	}
 
-	valid := token == storedToken
+	valid := token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(storedToken)) == 1
 	if !valid {
-		log.Printf("Token mismatch: provided=%s vs stored=%s", token, storedToken)
-	} else {
-		log.Printf("Token validated successfully")
+		log.Printf("Invalid unsubscribe token for email=%s, campaign=%s", email, campaignID)
 	}
 	return valid, nil
 }
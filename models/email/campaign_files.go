@@ -0,0 +1,323 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"gopkg.in/yaml.v3"
+)
+
+// Campaigns are defined in template directory subdirectories of coursesDir, one per campaign named after its
+// ID. Each has a campaign.yaml with the campaign details and any emails written as HTML and text templates,
+// and markdown emails with the email details in front matter.
+const (
+	coursesDir       = "courses"
+	campaignFileName = "campaign.yaml"
+)
+
+// campaignFile is the contents of a campaign.yaml
+type campaignFile struct {
+	Name          string              `yaml:"name"`
+	Description   string              `yaml:"description"`
+	Emoji         string              `yaml:"emoji"`
+	AutoSubscribe bool                `yaml:"auto_subscribe"`
+	Trigger       *CampaignTrigger    `yaml:"trigger"`
+	Segment       string              `yaml:"segment"`
+	Tracking      bool                `yaml:"tracking"`
+	Emails        []campaignEmailFile `yaml:"emails"`
+}
+
+// campaignEmailFile describes one email, in campaign.yaml or the front matter of a markdown email
+type campaignEmailFile struct {
+	Number   int    `yaml:"number"`
+	Subject  string `yaml:"subject"`
+	Title    string `yaml:"title"` // defaults to the subject
+	Template string `yaml:"template"`
+	SendDay  int    `yaml:"send_day"`
+}
+
+var (
+	campaignsMu sync.RWMutex
+	campaigns   = map[string]EmailCampaign{}
+)
+
+// markdown renders markdown campaign emails
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// LoadCampaigns reads the campaign definitions and lifecycle.yaml under templateDir and makes them the available
+// campaigns and segments. If anything is invalid nothing changes and the error lists every problem found.
+func LoadCampaigns(templateDir string) error {
+	loaded, l, err := readCampaigns(templateDir)
+	if err != nil {
+		return err
+	}
+
+	campaignsMu.Lock()
+	campaigns = loaded
+	lifecycle = l
+	campaignsMu.Unlock()
+	log.Printf("📚 Loaded %d email campaigns and %d segments", len(loaded), len(l.Segments))
+	return nil
+}
+
+// ReadCampaigns reads and validates the campaign definitions under templateDir, checking that every email's
+// templates exist and parse and that the segments they target are defined
+func ReadCampaigns(templateDir string) (map[string]EmailCampaign, error) {
+	loaded, _, err := readCampaigns(templateDir)
+	return loaded, err
+}
+
+// readCampaigns reads and validates the campaign definitions and lifecycle.yaml under templateDir
+func readCampaigns(templateDir string) (map[string]EmailCampaign, Lifecycle, error) {
+	entries, err := os.ReadDir(filepath.Join(templateDir, coursesDir))
+	if err != nil {
+		return nil, Lifecycle{}, fmt.Errorf("failed to read campaigns: %w", err)
+	}
+
+	loaded := map[string]EmailCampaign{}
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		campaign, err := readCampaign(templateDir, entry.Name())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("campaign %s: %w", entry.Name(), err))
+			continue
+		}
+		loaded[campaign.ID] = campaign
+	}
+
+	l, err := readLifecycle(templateDir)
+	if err != nil {
+		errs = append(errs, err)
+	} else if err := checkSegmentReferences(loaded, l); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, Lifecycle{}, errors.Join(errs...)
+	}
+	return loaded, l, nil
+}
+
+// readCampaign reads and validates the campaign in the given courses subdirectory
+func readCampaign(templateDir, id string) (EmailCampaign, error) {
+	dir := filepath.Join(templateDir, coursesDir, id)
+	data, err := os.ReadFile(filepath.Join(dir, campaignFileName))
+	if err != nil {
+		return EmailCampaign{}, err
+	}
+
+	var file campaignFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return EmailCampaign{}, fmt.Errorf("%s: %v", campaignFileName, err)
+	}
+
+	campaign := EmailCampaign{
+		ID:            id,
+		Name:          file.Name,
+		Description:   file.Description,
+		Emoji:         file.Emoji,
+		AutoSubscribe: file.AutoSubscribe,
+		Trigger:       file.Trigger,
+		Segment:       file.Segment,
+		Tracking:      file.Tracking,
+	}
+	var errs []error
+	if campaign.Name == "" {
+		errs = append(errs, errors.New("missing name"))
+	}
+	if campaign.Trigger != nil {
+		if err := checkTrigger(campaign.Trigger); err != nil {
+			errs = append(errs, err)
+		}
+		if campaign.AutoSubscribe {
+			errs = append(errs, errors.New("triggered campaigns can't auto-subscribe"))
+		}
+	}
+
+	for _, e := range file.Emails {
+		if e.Template == "" {
+			errs = append(errs, fmt.Errorf("email %d: missing template", e.Number))
+			continue
+		}
+		templateName := coursesDir + "/" + id + "/" + e.Template
+		if err := checkHTMLTemplates(templateDir, templateName); err != nil {
+			errs = append(errs, fmt.Errorf("email %d: %w", e.Number, err))
+			continue
+		}
+		campaign.Emails = append(campaign.Emails, newCampaignEmail(e, templateName))
+	}
+
+	markdownFiles, _ := filepath.Glob(filepath.Join(dir, "*.md"))
+	for _, path := range markdownFiles {
+		e, _, err := readMarkdownEmail(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(path), err))
+			continue
+		}
+		templateName := coursesDir + "/" + id + "/" + strings.TrimSuffix(filepath.Base(path), ".md")
+		campaign.Emails = append(campaign.Emails, newCampaignEmail(e, templateName))
+	}
+
+	sort.Slice(campaign.Emails, func(i, j int) bool {
+		return campaign.Emails[i].Number < campaign.Emails[j].Number
+	})
+	if len(campaign.Emails) == 0 && len(errs) == 0 {
+		errs = append(errs, errors.New("no emails"))
+	}
+	for i, e := range campaign.Emails {
+		switch {
+		case e.Number < 1:
+			errs = append(errs, fmt.Errorf("email %s: number must be 1 or more", e.TemplateName))
+		case i > 0 && e.Number == campaign.Emails[i-1].Number:
+			errs = append(errs, fmt.Errorf("email %d: number used twice", e.Number))
+		case i > 0 && e.SendDay < campaign.Emails[i-1].SendDay:
+			errs = append(errs, fmt.Errorf("email %d: sent on day %d, before email %d", e.Number, e.SendDay,
+				campaign.Emails[i-1].Number))
+		}
+		if e.Subject == "" {
+			errs = append(errs, fmt.Errorf("email %d: missing subject", e.Number))
+		}
+		if e.SendDay < 0 {
+			errs = append(errs, fmt.Errorf("email %d: send day can't be negative", e.Number))
+		}
+	}
+	if len(errs) > 0 {
+		return EmailCampaign{}, errors.Join(errs...)
+	}
+	return campaign, nil
+}
+
+// newCampaignEmail creates a campaign email from its file description
+func newCampaignEmail(e campaignEmailFile, templateName string) CampaignEmail {
+	title := e.Title
+	if title == "" {
+		title = e.Subject
+	}
+	return CampaignEmail{
+		Number:       e.Number,
+		Subject:      e.Subject,
+		Title:        title,
+		TemplateName: templateName,
+		SendDay:      e.SendDay,
+	}
+}
+
+// checkHTMLTemplates checks that the HTML and text templates of an email exist and parse
+func checkHTMLTemplates(templateDir, templateName string) error {
+	if _, err := htmltemplate.ParseFiles(filepath.Join(templateDir, templateName+".html")); err != nil {
+		return err
+	}
+	if _, err := htmltemplate.ParseFiles(filepath.Join(templateDir, templateName+".txt")); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readMarkdownEmail reads a markdown email's front matter and parses its body as a template
+func readMarkdownEmail(path string) (campaignEmailFile, *template.Template, error) {
+	var e campaignEmailFile
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return e, nil, err
+	}
+
+	// Split front matter and content
+	parts := bytes.SplitN(content, []byte("---\n"), 3)
+	if len(parts) < 3 || len(bytes.TrimSpace(parts[0])) > 0 {
+		return e, nil, errors.New("no front matter found")
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(parts[1]))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&e); err != nil {
+		return e, nil, fmt.Errorf("error parsing front matter: %v", err)
+	}
+	if e.Template != "" {
+		return e, nil, errors.New("markdown emails are their own template")
+	}
+
+	body, err := template.New(filepath.Base(path)).Parse(string(parts[2]))
+	if err != nil {
+		return e, nil, err
+	}
+	return e, body, nil
+}
+
+// renderMarkdownEmail renders a markdown email's body with the data, returning it as HTML and as the markdown
+// itself for the text part
+func renderMarkdownEmail(path string, data interface{}) (htmlContent, textContent string, err error) {
+	_, body, err := readMarkdownEmail(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load markdown email: %w", err)
+	}
+	return renderMarkdownBody(body, data)
+}
+
+// renderMarkdownBody renders a markdown template with the data, returning it as HTML and as markdown
+func renderMarkdownBody(body *template.Template, data interface{}) (htmlContent, textContent string, err error) {
+	textBuf := new(bytes.Buffer)
+	if err := body.Execute(textBuf, data); err != nil {
+		return "", "", fmt.Errorf("failed to render markdown email: %w", err)
+	}
+	htmlBuf := new(bytes.Buffer)
+	if err := markdown.Convert(textBuf.Bytes(), htmlBuf); err != nil {
+		return "", "", fmt.Errorf("failed to convert markdown email: %w", err)
+	}
+	return htmlBuf.String(), textBuf.String(), nil
+}
+
+// WatchCampaigns reloads the campaigns whenever a file under templateDir/courses or lifecycle.yaml changes,
+// checking every interval. Invalid changes are logged and the previous campaigns kept.
+func WatchCampaigns(templateDir string, interval time.Duration) {
+	last := campaignsVersion(templateDir)
+	go func() {
+		for range time.Tick(interval) {
+			version := campaignsVersion(templateDir)
+			if version == last {
+				continue
+			}
+			last = version
+			if err := LoadCampaigns(templateDir); err != nil {
+				log.Printf("❌ Keeping the previous email campaigns, the changed ones are invalid: %v", err)
+			}
+		}
+	}()
+}
+
+// campaignsVersion identifies the state of the campaign files by their count and latest modification time
+func campaignsVersion(templateDir string) string {
+	count := 0
+	var latest time.Time
+	filepath.Walk(filepath.Join(templateDir, coursesDir), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		count++
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	if info, err := os.Stat(filepath.Join(templateDir, lifecycleFileName)); err == nil {
+		count++
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return fmt.Sprintf("%d-%d", count, latest.UnixNano())
+}
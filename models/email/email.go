@@ -6,6 +6,12 @@ import "time"
 type EmailTemplate struct {
 	Name    string
 	Subject string
+
+	emailSendID     int64  // campaign email_sends row to record the delivery on, 0 for none
+	broadcastSendID int64  // broadcast_sends row to record the delivery on, 0 for none
+	track           bool   // add open and click tracking for emailSendID
+	list            string // list bulk email can be unsubscribed from with one click, empty for transactional email
+	markdown        string // body of a broadcast, rendered in place of the named template
 }
 
 // Email Data Structures
@@ -22,25 +28,129 @@ type PasswordResetSuccessEmailData struct {
 	LoginLink string
 }
 
+// VerifyEmailData represents data for the email confirming a new account's address
+type VerifyEmailData struct {
+	FirstName   string
+	VerifyLink  string
+	ExpiryHours int
+	AppName     string
+}
+
+// ConfirmSubscriptionEmailData represents data for the double opt-in email confirming a campaign subscription
+type ConfirmSubscriptionEmailData struct {
+	FirstName     string
+	CampaignName  string
+	CampaignEmoji string
+	ConfirmLink   string
+	ExpiryHours   int
+	AppName       string
+}
+
 // ReminderEmailData represents data for daily habit reminder emails
 type ReminderEmailData struct {
-	FirstName string
-	Habits    []HabitInfo
-	Quote     QuoteInfo
-	AppName   string
+	FirstName       string
+	Habits          []HabitInfo
+	Quote           QuoteInfo
+	Insight         string // top pattern insight, e.g. "Mondays are usually your toughest day"
+	AppName         string
+	PreferencesLink string // public notification preferences page
 }
 
-// FirstHabitEmailData represents data for emails to users without habits
-type FirstHabitEmailData struct {
-	FirstName string
-	Quote     QuoteInfo
-	AppName   string
+// StreakNudgeEmailData represents data for the late evening nudge about streaks not yet logged today
+type StreakNudgeEmailData struct {
+	FirstName       string
+	Habits          []HabitInfo
+	AppName         string
+	PreferencesLink string
+}
+
+// GoalEmailData represents data for goal notification emails
+type GoalEmailData struct {
+	FirstName       string
+	Goal            GoalInfo
+	AppName         string
+	PreferencesLink string
+}
+
+// DigestEmailData represents data for the weekly and monthly progress report emails
+type DigestEmailData struct {
+	FirstName       string
+	Period          string   // "week" or "month"
+	PeriodLabel     string   // e.g. "6 – 12 Jan 2025"
+	Days            []string // heatmap column headings, one per day of the period
+	Habits          []DigestHabitInfo
+	Goals           []DigestGoalInfo
+	BestDay         string // e.g. "Wednesday 8 Jan"; empty when nothing was done
+	BestDayDone     int
+	AppName         string
+	PreferencesLink string
+}
+
+// DigestHabitInfo summarizes one habit over a digest period
+type DigestHabitInfo struct {
+	Name           string
+	Emoji          string
+	CompletionRate float64 // percent of the days the habit existed
+	StreakStart    int     // streak going into the period
+	StreakEnd      int     // streak at the end of the period
+	Total          string  // numeric total or set-reps volume, e.g. "12 sets · 140 reps"; empty for other habits
+	Heatmap        []HeatmapCell
+}
+
+// DigestGoalInfo is a goal whose status changed during a digest period
+type DigestGoalInfo struct {
+	Name       string
+	HabitEmoji string
+	From       string // empty for goals that started during the period
+	To         string
+}
+
+// HeatmapCell is one day of a habit in the digest heatmap
+type HeatmapCell struct {
+	Date   string
+	Status string // done, missed, skipped or none
+	Color  string
+}
+
+// heatmapColors are the cell colors for each log status
+var heatmapColors = map[string]string{
+	"done":    "#2da44e",
+	"skipped": "#9be9a8",
+	"missed":  "#f85149",
+	"none":    "#ebedf0",
+}
+
+// NewHeatmapCell returns the heatmap cell for a day with the given log status
+func NewHeatmapCell(date, status string) HeatmapCell {
+	color, ok := heatmapColors[status]
+	if !ok {
+		status, color = "none", heatmapColors["none"]
+	}
+	return HeatmapCell{Date: date, Status: status, Color: color}
+}
+
+// GoalInfo represents a goal's progress for display in emails
+type GoalInfo struct {
+	Name              string
+	HabitName         string
+	HabitEmoji        string
+	Status            string // e.g. "at risk"
+	CurrentNumber     float64
+	TargetNumber      float64
+	Progress          float64 // percent, capped at 100
+	EndDate           string  // e.g. "31 Jan 2025"
+	DaysLeft          int     // including today
+	RequiredDailyRate float64 // needed per day to finish on time; 0 when it doesn't apply
+	Milestone         string  // the milestone reached, for milestone emails
+	MilestoneTarget   float64
+	Link              string
 }
 
 // HabitInfo represents a habit for display in emails
 type HabitInfo struct {
-	Name  string
-	Emoji string
+	Name   string
+	Emoji  string
+	Streak int // days in the streak at stake if the habit isn't logged today
 }
 
 // QuoteInfo represents a motivational quote
@@ -63,16 +173,72 @@ var (
 		Subject: "Your Password Has Been Reset",
 	}
 
+	// VerifyEmail template asking new users to confirm their email address
+	VerifyEmail = EmailTemplate{
+		Name:    "verify-email",
+		Subject: "Verify Your Email",
+	}
+
+	// ConfirmSubscriptionEmail template asking anonymous subscribers to confirm a campaign subscription
+	ConfirmSubscriptionEmail = EmailTemplate{
+		Name:    "confirm-subscription",
+		Subject: "Confirm Your Subscription",
+	}
+
 	// ReminderEmail template for daily habit reminders
 	ReminderEmail = EmailTemplate{
 		Name:    "reminder",
 		Subject: "Your Daily Habit Reminder",
+		list:    NotificationList("reminder"),
+	}
+
+	// GoalBehindEmail template for goals that fell behind their pace
+	GoalBehindEmail = EmailTemplate{
+		Name:    "goal-behind",
+		Subject: "Your Goal Needs a Push",
+		list:    NotificationList("goal"),
+	}
+
+	// GoalDeadlineEmail template for goals whose end date is coming up
+	GoalDeadlineEmail = EmailTemplate{
+		Name:    "goal-deadline",
+		Subject: "Your Goal Deadline Is Coming Up",
+		list:    NotificationList("goal"),
+	}
+
+	// GoalCompletedEmail template congratulating users on a finished goal
+	GoalCompletedEmail = EmailTemplate{
+		Name:    "goal-completed",
+		Subject: "Goal Complete 🎉",
+		list:    NotificationList("goal"),
+	}
+
+	// GoalMilestoneEmail template for milestones reached on the way to a goal
+	GoalMilestoneEmail = EmailTemplate{
+		Name:    "goal-milestone",
+		Subject: "Milestone Reached 🏁",
+		list:    NotificationList("goal"),
+	}
+
+	// WeeklyDigestEmail template for the weekly progress report
+	WeeklyDigestEmail = EmailTemplate{
+		Name:    "digest",
+		Subject: "Your Week in Habits 📊",
+		list:    NotificationList("digest"),
+	}
+
+	// MonthlyDigestEmail template for the monthly progress report
+	MonthlyDigestEmail = EmailTemplate{
+		Name:    "digest",
+		Subject: "Your Month in Habits 📊",
+		list:    NotificationList("digest"),
 	}
 
-	// FirstHabitEmail template for users without habits
-	FirstHabitEmail = EmailTemplate{
-		Name:    "first-habit",
-		Subject: "Start Your First Habit Today",
+	// StreakNudgeEmail template for the late evening nudge about streaks not yet logged today
+	StreakNudgeEmail = EmailTemplate{
+		Name:    "streak-nudge",
+		Subject: "Don't Break Your Streak 🔥",
+		list:    NotificationList("streak_nudge"),
 	}
 )
 
@@ -81,8 +247,7 @@ type EmailService interface {
 	SendTypedEmail(to string, template EmailTemplate, data interface{}) error
 	SendPasswordResetEmail(to, resetLink string, expiry time.Time) error
 	SendPasswordResetSuccessEmail(to, username string) error
-	SendReminderEmail(to string, firstName string, habits []HabitInfo, quote QuoteInfo) error
-	SendFirstHabitEmail(to string, firstName string, quote QuoteInfo) error
+	SendReminderEmail(to string, firstName string, habits []HabitInfo, quote QuoteInfo, insight, preferencesLink string) error
 	SendSimpleEmail(to, subject, content string) error
 	GetCampaignManager() *CampaignManager
 }
@@ -0,0 +1,187 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// lifecycleFileName is the file in the template directory with the segments and the frequency cap
+const lifecycleFileName = "lifecycle.yaml"
+
+// Events a campaign can be triggered by. Triggered campaigns start when the scheduler sees the event in the
+// user's activity, rather than when the user subscribes.
+const (
+	TriggerInactive       = "inactive"        // no habit logged for Days days
+	TriggerStreak         = "streak"          // a habit's streak reached Days days
+	TriggerGoalCompleted  = "goal_completed"  // a goal was completed
+	TriggerModuleFinished = "module_finished" // every lesson of a masterclass module was completed
+	TriggerNoHabits       = "no_habits"       // signed up Days days ago and has no habits yet
+)
+
+// SegmentAll is the segment of every user, available without being defined
+const SegmentAll = "all"
+
+// CampaignTrigger describes when a triggered campaign starts for a user
+type CampaignTrigger struct {
+	Event           string `yaml:"event"`
+	Days            int    `yaml:"days"`              // the threshold for inactive, streak and no_habits
+	Module          string `yaml:"module"`            // module_finished only: the module slug, or any module when empty
+	RepeatAfterDays int    `yaml:"repeat_after_days"` // how soon the campaign can start again; 0 starts it once
+}
+
+// Lifecycle is the contents of lifecycle.yaml
+type Lifecycle struct {
+	FrequencyCap FrequencyCap `yaml:"frequency_cap"`
+	Segments     []Segment    `yaml:"segments"`
+}
+
+// FrequencyCap limits how many campaign emails one address gets across all campaigns. Emails over the cap
+// wait for a later run. A zero cap doesn't limit anything.
+type FrequencyCap struct {
+	Emails int `yaml:"emails"`
+	Days   int `yaml:"days"`
+}
+
+// Segment is a named group of users defined by rules on their account and activity, all of which must match
+type Segment struct {
+	ID          string       `yaml:"id"`
+	Name        string       `yaml:"name"`
+	Description string       `yaml:"description"`
+	Rules       SegmentRules `yaml:"rules"`
+}
+
+// SegmentRules are the conditions of a segment. Unset rules match everyone.
+type SegmentRules struct {
+	NotificationsEnabled *bool  `yaml:"notifications_enabled"`
+	HasHabits            *bool  `yaml:"has_habits"`
+	ActiveWithinDays     int    `yaml:"active_within_days"`    // logged a habit in the last n days
+	InactiveDays         int    `yaml:"inactive_days"`         // hasn't logged a habit in the last n days
+	SignedUpWithinDays   int    `yaml:"signed_up_within_days"` // signed up in the last n days
+	SignedUpBeforeDays   int    `yaml:"signed_up_before_days"` // signed up more than n days ago
+	SubscribedTo         string `yaml:"subscribed_to"`         // actively subscribed to the campaign
+	HasCourse            string `yaml:"has_course"`            // has access to the course
+}
+
+var lifecycle Lifecycle
+
+// GetSegments returns the segments the admin can target, starting with all users
+func GetSegments() []Segment {
+	campaignsMu.RLock()
+	defer campaignsMu.RUnlock()
+
+	segments := []Segment{{ID: SegmentAll, Name: "All users", Description: "Every registered user"}}
+	return append(segments, lifecycle.Segments...)
+}
+
+// GetSegment returns a segment by ID or an error if not found
+func GetSegment(segmentID string) (Segment, error) {
+	for _, segment := range GetSegments() {
+		if segment.ID == segmentID {
+			return segment, nil
+		}
+	}
+	return Segment{}, fmt.Errorf("segment with ID %s not found", segmentID)
+}
+
+// GetFrequencyCap returns the cap on campaign emails per address
+func GetFrequencyCap() FrequencyCap {
+	campaignsMu.RLock()
+	defer campaignsMu.RUnlock()
+	return lifecycle.FrequencyCap
+}
+
+// readLifecycle reads and validates lifecycle.yaml, which is optional
+func readLifecycle(templateDir string) (Lifecycle, error) {
+	var l Lifecycle
+	data, err := os.ReadFile(filepath.Join(templateDir, lifecycleFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return l, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&l); err != nil {
+		return l, fmt.Errorf("%s: %v", lifecycleFileName, err)
+	}
+
+	var errs []error
+	if l.FrequencyCap.Emails < 0 || l.FrequencyCap.Days < 0 || (l.FrequencyCap.Emails > 0) != (l.FrequencyCap.Days > 0) {
+		errs = append(errs, errors.New("frequency cap needs a positive number of emails and days"))
+	}
+	seen := map[string]bool{SegmentAll: true}
+	for _, segment := range l.Segments {
+		if seen[segment.ID] {
+			errs = append(errs, fmt.Errorf("segment %q: ID missing or used twice", segment.ID))
+		}
+		seen[segment.ID] = true
+		if segment.Name == "" {
+			errs = append(errs, fmt.Errorf("segment %s: missing name", segment.ID))
+		}
+		r := segment.Rules
+		if r.ActiveWithinDays < 0 || r.InactiveDays < 0 || r.SignedUpWithinDays < 0 || r.SignedUpBeforeDays < 0 {
+			errs = append(errs, fmt.Errorf("segment %s: days can't be negative", segment.ID))
+		}
+		if r.ActiveWithinDays > 0 && r.InactiveDays > 0 {
+			errs = append(errs, fmt.Errorf("segment %s: can't be both active and inactive", segment.ID))
+		}
+	}
+	if len(errs) > 0 {
+		return l, fmt.Errorf("%s: %w", lifecycleFileName, errors.Join(errs...))
+	}
+	return l, nil
+}
+
+// checkTrigger validates a campaign trigger
+func checkTrigger(trigger *CampaignTrigger) error {
+	switch trigger.Event {
+	case TriggerInactive, TriggerStreak:
+		if trigger.Days < 1 {
+			return fmt.Errorf("trigger %s needs days", trigger.Event)
+		}
+	case TriggerNoHabits:
+		if trigger.Days < 0 {
+			return errors.New("trigger days can't be negative")
+		}
+	case TriggerGoalCompleted, TriggerModuleFinished:
+		if trigger.Days != 0 {
+			return fmt.Errorf("trigger %s doesn't take days", trigger.Event)
+		}
+	default:
+		return fmt.Errorf("unknown trigger event %q", trigger.Event)
+	}
+	if trigger.Module != "" && trigger.Event != TriggerModuleFinished {
+		return fmt.Errorf("trigger %s doesn't take a module", trigger.Event)
+	}
+	if trigger.RepeatAfterDays < 0 {
+		return errors.New("trigger repeat days can't be negative")
+	}
+	return nil
+}
+
+// checkSegmentReferences checks that the segments campaigns target and the campaigns segments refer to exist
+func checkSegmentReferences(loaded map[string]EmailCampaign, l Lifecycle) error {
+	segmentIDs := map[string]bool{SegmentAll: true}
+	var errs []error
+	for _, segment := range l.Segments {
+		segmentIDs[segment.ID] = true
+		if id := segment.Rules.SubscribedTo; id != "" {
+			if _, ok := loaded[id]; !ok {
+				errs = append(errs, fmt.Errorf("segment %s: unknown campaign %s", segment.ID, id))
+			}
+		}
+	}
+	for _, campaign := range loaded {
+		if campaign.Segment != "" && !segmentIDs[campaign.Segment] {
+			errs = append(errs, fmt.Errorf("campaign %s: unknown segment %s", campaign.ID, campaign.Segment))
+		}
+	}
+	return errors.Join(errs...)
+}
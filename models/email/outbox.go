@@ -0,0 +1,440 @@
+package email
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Outbox message statuses
+const (
+	OutboxPending = "pending" // waiting for its first or next attempt
+	OutboxSending = "sending" // claimed by a worker
+	OutboxSent    = "sent"
+	OutboxDead    = "dead" // failed permanently or ran out of attempts
+)
+
+// How long delivered messages and dead letters are kept. Sent messages are only needed to look into a
+// delivery; dead letters stay longer so they can still be retried by hand.
+const (
+	OutboxSentRetention = 7 * 24 * time.Hour
+	OutboxDeadRetention = 30 * 24 * time.Hour
+)
+
+// Message is a rendered email ready for delivery
+type Message struct {
+	To           string
+	Subject      string
+	TemplateName string
+	HTML         string
+	Text         string
+	Unsubscribe  string // one-click unsubscribe URL for the List-Unsubscribe header, empty for transactional email
+
+	emailSendID     int64 // campaign email_sends row to record the delivery on, 0 for none
+	broadcastSendID int64 // broadcast_sends row to record the delivery on, 0 for none
+}
+
+// PermanentError is a delivery failure that retrying won't fix, such as a rejected address
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks a delivery error as not worth retrying
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// OutboxConfig controls delivery from the outbox
+type OutboxConfig struct {
+	Workers      int           // concurrent deliveries
+	BatchSize    int           // messages claimed per poll
+	MaxAttempts  int           // attempts before a message is dead-lettered
+	BaseDelay    time.Duration // delay before the first retry, doubled for each one after
+	MaxDelay     time.Duration // cap on the retry delay
+	PollInterval time.Duration // how often due messages are picked up
+}
+
+// DefaultOutboxConfig retries for about an hour before giving up
+func DefaultOutboxConfig() OutboxConfig {
+	return OutboxConfig{
+		Workers:      4,
+		BatchSize:    50,
+		MaxAttempts:  8,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     30 * time.Minute,
+		PollInterval: 5 * time.Second,
+	}
+}
+
+// Outbox is a persistent queue of rendered emails. Messages are stored before anything is sent, so a failed
+// or interrupted delivery is retried with exponential backoff instead of being lost.
+type Outbox struct {
+	db      *sql.DB
+	deliver func(*Message) error
+	config  OutboxConfig
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewOutbox creates an outbox that delivers messages with deliver
+func NewOutbox(db *sql.DB, deliver func(*Message) error, config OutboxConfig) *Outbox {
+	return &Outbox{
+		db:      db,
+		deliver: deliver,
+		config:  config,
+	}
+}
+
+// Enqueue stores the message for delivery and returns its ID
+func (o *Outbox) Enqueue(msg *Message) (int64, error) {
+	var emailSendID sql.NullInt64
+	if msg.emailSendID != 0 {
+		emailSendID = sql.NullInt64{Int64: msg.emailSendID, Valid: true}
+	}
+	var broadcastSendID sql.NullInt64
+	if msg.broadcastSendID != 0 {
+		broadcastSendID = sql.NullInt64{Int64: msg.broadcastSendID, Valid: true}
+	}
+	result, err := o.db.Exec(`
+		INSERT INTO email_outbox (to_email, subject, template_name, html_body, text_body, list_unsubscribe,
+			email_send_id, broadcast_send_id, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		msg.To, msg.Subject, msg.TemplateName, msg.HTML, msg.Text, msg.Unsubscribe, emailSendID, broadcastSendID,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error queueing email: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// Start delivers due messages in the background until Stop is called. Messages left claimed by a previous
+// process that stopped mid-delivery are released first, so they may be sent twice but are never lost.
+func (o *Outbox) Start() error {
+	if _, err := o.db.Exec("UPDATE email_outbox SET status = ? WHERE status = ?", OutboxPending, OutboxSending); err != nil {
+		return fmt.Errorf("error releasing claimed emails: %w", err)
+	}
+
+	o.stop = make(chan struct{})
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		ticker := time.NewTicker(o.config.PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-o.stop:
+				return
+			case <-ticker.C:
+				if _, err := o.ProcessDue(time.Now()); err != nil {
+					log.Printf("Error processing email outbox: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop waits for the deliveries in progress and stops the outbox
+func (o *Outbox) Stop() {
+	if o.stop == nil {
+		return
+	}
+	close(o.stop)
+	o.wg.Wait()
+	o.stop = nil
+}
+
+// ProcessDue claims the messages due at now and delivers them on the worker pool. It returns the number
+// delivered.
+func (o *Outbox) ProcessDue(now time.Time) (int, error) {
+	messages, err := o.claimDue(now)
+	if err != nil || len(messages) == 0 {
+		return 0, err
+	}
+
+	workers := o.config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan outboxMessage)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	delivered := 0
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range queue {
+				if o.attempt(m, now) {
+					mu.Lock()
+					delivered++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, m := range messages {
+		queue <- m
+	}
+	close(queue)
+	wg.Wait()
+	return delivered, nil
+}
+
+// outboxMessage is a claimed message with its delivery state
+type outboxMessage struct {
+	id              int64
+	attempts        int // including the one being made
+	emailSendID     sql.NullInt64
+	broadcastSendID sql.NullInt64
+	msg             Message
+}
+
+// claimDue marks up to a batch of due messages as sending and returns them
+func (o *Outbox) claimDue(now time.Time) ([]outboxMessage, error) {
+	batchSize := o.config.BatchSize
+	if batchSize < 1 {
+		batchSize = 50
+	}
+	rows, err := o.db.Query(`
+		SELECT id, attempts, email_send_id, broadcast_send_id, to_email, subject, template_name, html_body, text_body,
+			list_unsubscribe
+		FROM email_outbox
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, id
+		LIMIT ?`,
+		OutboxPending, now.UTC(), batchSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting due emails: %w", err)
+	}
+	var due []outboxMessage
+	for rows.Next() {
+		var m outboxMessage
+		if err := rows.Scan(&m.id, &m.attempts, &m.emailSendID, &m.broadcastSendID, &m.msg.To, &m.msg.Subject, &m.msg.TemplateName,
+			&m.msg.HTML, &m.msg.Text, &m.msg.Unsubscribe); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.attempts++
+		due = append(due, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	claimed := due[:0]
+	for _, m := range due {
+		result, err := o.db.Exec(`
+			UPDATE email_outbox
+			SET status = ?, attempts = ?
+			WHERE id = ? AND status = ?`,
+			OutboxSending, m.attempts, m.id, OutboxPending,
+		)
+		if err != nil {
+			return claimed, fmt.Errorf("error claiming email %d: %w", m.id, err)
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			claimed = append(claimed, m)
+		}
+	}
+	return claimed, nil
+}
+
+// attempt delivers a claimed message and records the outcome, reporting whether it was sent
+func (o *Outbox) attempt(m outboxMessage, now time.Time) bool {
+	err := o.deliver(&m.msg)
+	if err == nil {
+		if _, err := o.db.Exec(`
+			UPDATE email_outbox
+			SET status = ?, sent_at = ?, last_error = NULL
+			WHERE id = ?`,
+			OutboxSent, now.UTC(), m.id,
+		); err != nil {
+			log.Printf("Error marking email %d sent: %v", m.id, err)
+		}
+		o.recordEmailSend(m, "success", "")
+		return true
+	}
+
+	var permanent *PermanentError
+	if errors.As(err, &permanent) || m.attempts >= o.config.MaxAttempts {
+		log.Printf("❌ Giving up on email %d to %s after %d attempts: %v", m.id, m.msg.To, m.attempts, err)
+		if _, dbErr := o.db.Exec(`
+			UPDATE email_outbox
+			SET status = ?, last_error = ?
+			WHERE id = ?`,
+			OutboxDead, err.Error(), m.id,
+		); dbErr != nil {
+			log.Printf("Error dead-lettering email %d: %v", m.id, dbErr)
+		}
+		o.recordEmailSend(m, "failed", err.Error())
+		return false
+	}
+
+	next := now.Add(o.backoff(m.attempts))
+	log.Printf("Email %d to %s failed (attempt %d), retrying at %s: %v", m.id, m.msg.To, m.attempts,
+		next.Format(time.RFC3339), err)
+	if _, dbErr := o.db.Exec(`
+		UPDATE email_outbox
+		SET status = ?, next_attempt_at = ?, last_error = ?
+		WHERE id = ?`,
+		OutboxPending, next.UTC(), err.Error(), m.id,
+	); dbErr != nil {
+		log.Printf("Error rescheduling email %d: %v", m.id, dbErr)
+	}
+	o.recordEmailSend(m, "retry", err.Error())
+	return false
+}
+
+// backoff returns the delay after the given number of failed attempts
+func (o *Outbox) backoff(attempts int) time.Duration {
+	delay := o.config.BaseDelay
+	for i := 1; i < attempts && delay < o.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > o.config.MaxDelay {
+		delay = o.config.MaxDelay
+	}
+	return delay
+}
+
+// recordEmailSend updates the campaign email_sends or broadcast_sends row of the message, if it has one
+func (o *Outbox) recordEmailSend(m outboxMessage, status, errorMsg string) {
+	if m.broadcastSendID.Valid {
+		if err := completeBroadcastSend(o.db, m.broadcastSendID.Int64, status, errorMsg); err != nil {
+			log.Printf("Error recording broadcast send %d: %v", m.broadcastSendID.Int64, err)
+		}
+	}
+	if !m.emailSendID.Valid {
+		return
+	}
+	retries := m.attempts - 1
+	if status == "retry" {
+		retries = m.attempts
+	}
+	if err := completeEmailSend(o.db, m.emailSendID.Int64, status, errorMsg, retries); err != nil {
+		log.Printf("Error recording campaign send %d: %v", m.emailSendID.Int64, err)
+	}
+}
+
+// OutboxStats summarizes the outbox for the admin dashboard
+type OutboxStats struct {
+	Pending       int        // waiting, including retries
+	Retrying      int        // waiting after at least one failed attempt
+	Sending       int        // being delivered
+	Sent24h       int        // delivered in the last 24 hours
+	Dead          int        // dead-lettered
+	OldestPending *time.Time // creation time of the oldest message waiting
+}
+
+// GetOutboxStats returns the queue depth and failure counts
+func GetOutboxStats(db *sql.DB, now time.Time) (OutboxStats, error) {
+	var stats OutboxStats
+	var oldest sql.NullString
+	err := db.QueryRow(`
+		SELECT
+			COUNT(CASE WHEN status = 'pending' THEN 1 END),
+			COUNT(CASE WHEN status = 'pending' AND attempts > 0 THEN 1 END),
+			COUNT(CASE WHEN status = 'sending' THEN 1 END),
+			COUNT(CASE WHEN status = 'sent' AND sent_at >= ? THEN 1 END),
+			COUNT(CASE WHEN status = 'dead' THEN 1 END),
+			MIN(CASE WHEN status = 'pending' THEN created_at END)
+		FROM email_outbox`,
+		now.UTC().Add(-24*time.Hour),
+	).Scan(&stats.Pending, &stats.Retrying, &stats.Sending, &stats.Sent24h, &stats.Dead, &oldest)
+	if err != nil {
+		return stats, fmt.Errorf("error getting outbox stats: %w", err)
+	}
+	if oldest.Valid {
+		if t, err := time.Parse("2006-01-02 15:04:05", oldest.String); err == nil {
+			stats.OldestPending = &t
+		}
+	}
+	return stats, nil
+}
+
+// OutboxFailure is a dead-lettered message or one waiting to be retried
+type OutboxFailure struct {
+	ID            int64
+	To            string
+	Subject       string
+	TemplateName  string
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+}
+
+// GetOutboxFailures returns the most recent messages that failed at least once and haven't been sent
+func GetOutboxFailures(db *sql.DB, limit int) ([]OutboxFailure, error) {
+	rows, err := db.Query(`
+		SELECT id, to_email, subject, template_name, status, attempts, COALESCE(last_error, ''), next_attempt_at,
+			created_at
+		FROM email_outbox
+		WHERE status = 'dead' OR (status = 'pending' AND attempts > 0)
+		ORDER BY id DESC
+		LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting outbox failures: %w", err)
+	}
+	defer rows.Close()
+
+	failures := []OutboxFailure{}
+	for rows.Next() {
+		var f OutboxFailure
+		if err := rows.Scan(&f.ID, &f.To, &f.Subject, &f.TemplateName, &f.Status, &f.Attempts, &f.LastError,
+			&f.NextAttemptAt, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
+
+// RetryOutboxMessage puts a dead-lettered message back in the queue with a fresh set of attempts
+func RetryOutboxMessage(db *sql.DB, id int64) error {
+	result, err := db.Exec(`
+		UPDATE email_outbox
+		SET status = 'pending', attempts = 0, next_attempt_at = ?
+		WHERE id = ? AND status = 'dead'`,
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("error requeueing email: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("no dead-lettered email with ID %d", id)
+	}
+	return nil
+}
+
+// PurgeOutbox deletes sent messages and dead letters older than their retention, so rendered bodies don't
+// pile up. A dead letter's age is taken from its last attempt.
+func PurgeOutbox(db *sql.DB, now time.Time) (int64, error) {
+	result, err := db.Exec(`
+		DELETE FROM email_outbox
+		WHERE (status = 'sent' AND sent_at < ?) OR (status = 'dead' AND next_attempt_at < ?)`,
+		now.UTC().Add(-OutboxSentRetention), now.UTC().Add(-OutboxDeadRetention),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error purging outbox: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,302 @@
+package email
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sample recipient that template previews and test sends are rendered for
+const (
+	sampleFirstName = "Sam"
+	sampleEmail     = "sam@example.com"
+)
+
+// TemplatePreview is an email template rendered with sample data, for checking templates in the admin panel
+type TemplatePreview struct {
+	ID       string // template name, e.g. "reminder" or "courses/onboarding/1-welcome"
+	Group    string // "Notifications" or the campaign's name
+	Subject  string
+	HTML     string
+	Text     string
+	Problems []string // missing text parts and template errors
+}
+
+// sampleTemplate is a template in the template directory with realistic data to preview it with
+type sampleTemplate struct {
+	template EmailTemplate
+	data     func() interface{}
+}
+
+// sampleTemplates are the templates in the top of the template directory with their sample data, by name
+var sampleTemplates = map[string]sampleTemplate{
+	"reset-password": {PasswordResetEmail, func() interface{} {
+		return PasswordResetEmailData{
+			ResetLink:   linkBaseURL() + "/reset?token=sample-token",
+			ExpiryHours: "1",
+			Username:    "there",
+			AppName:     "The Habits Company",
+		}
+	}},
+	"reset-password-success": {PasswordResetSuccessEmail, func() interface{} {
+		return PasswordResetSuccessEmailData{
+			Username:  sampleFirstName,
+			AppName:   "The Habits Company",
+			LoginLink: "https://habits.co/login",
+		}
+	}},
+	"verify-email": {VerifyEmail, func() interface{} {
+		return VerifyEmailData{
+			FirstName:   sampleFirstName,
+			VerifyLink:  GenerateVerificationLink("sample-token"),
+			ExpiryHours: 48,
+			AppName:     "The Habits Company",
+		}
+	}},
+	"confirm-subscription": {ConfirmSubscriptionEmail, func() interface{} {
+		return ConfirmSubscriptionEmailData{
+			FirstName:     sampleFirstName,
+			CampaignName:  "Digital Detox",
+			CampaignEmoji: "📱",
+			ConfirmLink:   GenerateVerificationLink("sample-token"),
+			ExpiryHours:   48,
+			AppName:       "The Habits Company",
+		}
+	}},
+	"reminder": {ReminderEmail, func() interface{} {
+		return ReminderEmailData{
+			FirstName: sampleFirstName,
+			Habits: []HabitInfo{
+				{Name: "Drink Water", Emoji: "💧", Streak: 4},
+				{Name: "Exercise", Emoji: "🏃"},
+				{Name: "Read", Emoji: "📚"},
+			},
+			Quote:           sampleQuote,
+			Insight:         "Mondays are usually your toughest day for 📚 Reading (40%). Today is a good day to beat that.",
+			AppName:         "The Habits Company",
+			PreferencesLink: samplePreferencesLink(),
+		}
+	}},
+	"streak-nudge": {StreakNudgeEmail, func() interface{} {
+		return StreakNudgeEmailData{
+			FirstName: sampleFirstName,
+			Habits: []HabitInfo{
+				{Name: "Reading", Emoji: "📚", Streak: 12},
+				{Name: "Exercise", Emoji: "🏃", Streak: 7},
+			},
+			AppName:         "The Habits Company",
+			PreferencesLink: samplePreferencesLink(),
+		}
+	}},
+	"goal-behind":    {GoalBehindEmail, func() interface{} { return sampleGoalData("at risk", 12) }},
+	"goal-deadline":  {GoalDeadlineEmail, func() interface{} { return sampleGoalData("on track", 17) }},
+	"goal-completed": {GoalCompletedEmail, func() interface{} { return sampleGoalData("done", 20) }},
+	"goal-milestone": {GoalMilestoneEmail, func() interface{} {
+		data := sampleGoalData("on track", 10)
+		data.Goal.Milestone, data.Goal.MilestoneTarget = "Halfway there", 10
+		return data
+	}},
+	"digest": {WeeklyDigestEmail, func() interface{} { return sampleDigestData() }},
+}
+
+// sampleQuote is the quote in previews, which can't pick a random one from the quotes file
+var sampleQuote = QuoteInfo{
+	Text:   "We are what we repeatedly do. Excellence, then, is not an act, but a habit.",
+	Author: "Aristotle",
+}
+
+func samplePreferencesLink() string {
+	return GeneratePreferencesLink(sampleEmail, "sample-token")
+}
+
+// sampleGoalData returns goal email data for a reading goal with the status and books read so far
+func sampleGoalData(status string, current float64) GoalEmailData {
+	return GoalEmailData{
+		FirstName: sampleFirstName,
+		Goal: GoalInfo{
+			Name:              "Read 20 books",
+			HabitName:         "Reading",
+			HabitEmoji:        "📚",
+			Status:            status,
+			CurrentNumber:     current,
+			TargetNumber:      20,
+			Progress:          current / 20 * 100,
+			EndDate:           time.Now().AddDate(0, 0, 3).Format("2 Jan 2006"),
+			DaysLeft:          4,
+			RequiredDailyRate: (20 - current) / 4,
+			Link:              "https://habits.co/goals",
+		},
+		AppName:         "The Habits Company",
+		PreferencesLink: samplePreferencesLink(),
+	}
+}
+
+// sampleDigestData returns a weekly digest with a good habit, a patchy one and a goal back on track
+func sampleDigestData() DigestEmailData {
+	statuses := [][]string{
+		{"done", "done", "skipped", "done", "done", "done", "done"},
+		{"done", "missed", "done", "none", "done", "done", "missed"},
+	}
+	heatmaps := make([][]HeatmapCell, len(statuses))
+	for i, week := range statuses {
+		for d, status := range week {
+			heatmaps[i] = append(heatmaps[i], NewHeatmapCell(fmt.Sprintf("2025-01-%02d", 6+d), status))
+		}
+	}
+	return DigestEmailData{
+		FirstName:   sampleFirstName,
+		Period:      "week",
+		PeriodLabel: "6 – 12 Jan 2025",
+		Days:        []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"},
+		Habits: []DigestHabitInfo{
+			{Name: "Reading", Emoji: "📚", CompletionRate: 85.71, StreakStart: 5, StreakEnd: 12, Heatmap: heatmaps[0]},
+			{Name: "Push-ups", Emoji: "💪", CompletionRate: 57.14, StreakStart: 3, StreakEnd: 0, Total: "12 sets · 240 reps", Heatmap: heatmaps[1]},
+		},
+		Goals: []DigestGoalInfo{
+			{Name: "Read 20 books", HabitEmoji: "📚", From: "at risk", To: "on track"},
+		},
+		BestDay:         "Friday 10 Jan",
+		BestDayDone:     2,
+		AppName:         "The Habits Company",
+		PreferencesLink: samplePreferencesLink(),
+	}
+}
+
+// PreviewTemplates renders every template in the template directory and every campaign email with sample
+// data. Templates that fail to render are included with the error among their problems.
+func (s *SMTPEmailService) PreviewTemplates() ([]TemplatePreview, error) {
+	names, err := s.templateNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var previews []TemplatePreview
+	for _, name := range names {
+		preview, _ := s.PreviewTemplate(name)
+		previews = append(previews, preview)
+	}
+	for _, campaign := range GetAllCampaigns() {
+		for _, e := range campaign.Emails {
+			preview, _ := s.PreviewTemplate(e.TemplateName)
+			previews = append(previews, preview)
+		}
+	}
+	return previews, nil
+}
+
+// PreviewTemplate renders a template in the template directory or a campaign email, by template name, with
+// sample data. It returns an error only for names that are neither; render errors are listed as problems.
+func (s *SMTPEmailService) PreviewTemplate(id string) (TemplatePreview, error) {
+	preview := TemplatePreview{ID: id, Group: "Notifications", Subject: id}
+	template, data, err := s.sampleFor(id, &preview)
+	if err != nil {
+		return preview, err
+	}
+	if template.Subject != "" {
+		preview.Subject = template.Subject
+	}
+
+	preview.Problems = append(preview.Problems, s.missingParts(id)...)
+	if data == nil {
+		preview.Problems = append(preview.Problems, "no sample data to render it with; add it to sampleTemplates")
+		return preview, nil
+	}
+	msg, err := s.Render(sampleEmail, template, data)
+	if err != nil {
+		preview.Problems = append(preview.Problems, err.Error())
+		return preview, nil
+	}
+	preview.HTML, preview.Text = msg.HTML, msg.Text
+	return preview, nil
+}
+
+// SendTemplateTest renders a template with sample data and sends it to the address, with "[Test]" before the
+// subject
+func (s *SMTPEmailService) SendTemplateTest(to, id string) error {
+	preview := TemplatePreview{}
+	template, data, err := s.sampleFor(id, &preview)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return fmt.Errorf("%s has no sample data", id)
+	}
+	template.Subject = "[Test] " + template.Subject
+	return s.SendTypedEmail(to, template, data)
+}
+
+// sampleFor returns the template and sample data for a template name, setting the preview's group for
+// campaign emails. The data is nil for templates without sample data.
+func (s *SMTPEmailService) sampleFor(id string, preview *TemplatePreview) (EmailTemplate, interface{}, error) {
+	if strings.HasPrefix(id, coursesDir+"/") {
+		for _, campaign := range GetAllCampaigns() {
+			for _, e := range campaign.Emails {
+				if e.TemplateName != id {
+					continue
+				}
+				preview.Group = fmt.Sprintf("%s %s", campaign.Emoji, campaign.Name)
+				data, err := CampaignEmailData(sampleFirstName, sampleEmail, campaign.ID, e.Number)
+				if err != nil {
+					return EmailTemplate{}, nil, err
+				}
+				return EmailTemplate{Name: id, Subject: e.Subject, list: CampaignList(campaign.ID)}, data, nil
+			}
+		}
+		return EmailTemplate{}, nil, fmt.Errorf("campaign email %s not found", id)
+	}
+
+	names, err := s.templateNames()
+	if err != nil {
+		return EmailTemplate{}, nil, err
+	}
+	for _, name := range names {
+		if name != id {
+			continue
+		}
+		if sample, ok := sampleTemplates[id]; ok {
+			return sample.template, sample.data(), nil
+		}
+		return EmailTemplate{Name: id}, nil, nil
+	}
+	return EmailTemplate{}, nil, fmt.Errorf("template %s not found", id)
+}
+
+// templateNames returns the names of the templates at the top of the template directory, leaving out the
+// campaign layout
+func (s *SMTPEmailService) templateNames() ([]string, error) {
+	entries, err := os.ReadDir(s.config.TemplateDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template directory: %w", err)
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		name := strings.TrimSuffix(entry.Name(), ext)
+		if entry.IsDir() || (ext != ".html" && ext != ".txt") || name == "base" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// missingParts lists the HTML or text files a template is missing. Markdown campaign emails are both.
+func (s *SMTPEmailService) missingParts(id string) []string {
+	path := filepath.Join(s.config.TemplateDir, id)
+	if _, err := os.Stat(path + ".md"); err == nil {
+		return nil
+	}
+	var missing []string
+	for _, part := range []struct{ ext, name string }{{".html", "HTML"}, {".txt", "text"}} {
+		if _, err := os.Stat(path + part.ext); err != nil {
+			missing = append(missing, fmt.Sprintf("no %s%s for the %s part", filepath.Base(id), part.ext, part.name))
+		}
+	}
+	return missing
+}
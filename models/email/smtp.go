@@ -1,82 +1,163 @@
 package email
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"time"
-
-	"github.com/wneessen/go-mail"
 )
 
-// SMTPConfig holds configuration for SMTP connections
+// SMTPConfig holds configuration for sending email
 type SMTPConfig struct {
-	Host        string
-	Port        int
-	Username    string
-	Password    string
-	FromName    string
-	FromEmail   string
-	TemplateDir string
-	Secure      bool
-	RequireTLS  bool
-	MaxConns    int
-}
-
-// SMTPEmailService implements EmailService
+	Transport    string // smtp, sendmail, file, memory or log; see NewTransport for the default
+	Host         string
+	Port         int    // defaults to 587
+	TLS          string // TLS policy for SMTP, mandatory by default
+	Username     string // SMTP authentication is skipped without one
+	Password     string
+	FromName     string
+	FromEmail    string
+	TemplateDir  string
+	SendmailPath string // defaults to /usr/sbin/sendmail
+	MailDir      string // directory for the file transport
+	MaxConns     int
+}
+
+// SMTPConfigFromEnv reads the mail configuration from the MAIL_* and SMTP_* environment variables
+func SMTPConfigFromEnv(templateDir string) SMTPConfig {
+	port, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	return SMTPConfig{
+		Transport:    os.Getenv("MAIL_TRANSPORT"),
+		Host:         os.Getenv("SMTP_HOST"),
+		Port:         port,
+		TLS:          os.Getenv("SMTP_TLS"),
+		Username:     os.Getenv("SMTP_USERNAME"),
+		Password:     os.Getenv("SMTP_PASSWORD"),
+		FromName:     os.Getenv("SMTP_FROM_NAME"),
+		FromEmail:    os.Getenv("SMTP_FROM_EMAIL"),
+		TemplateDir:  templateDir,
+		SendmailPath: os.Getenv("SENDMAIL_PATH"),
+		MailDir:      os.Getenv("MAIL_DIR"),
+	}
+}
+
+// SMTPEmailService implements EmailService, rendering emails from templates and handing them to a transport
 type SMTPEmailService struct {
 	config          SMTPConfig
-	client          *mail.Client
+	transport       Transport
 	campaignManager *CampaignManager
+	outbox          *Outbox
+	suppressions    *sql.DB // database with the suppression list, nil to send to any address
 }
 
-// NewSMTPEmailService creates a new SMTP email service
+// NewSMTPEmailService creates an email service sending through the transport selected in the config
 func NewSMTPEmailService(config SMTPConfig) (EmailService, error) {
-	client, err := mail.NewClient(config.Host,
-		mail.WithPort(config.Port),
-		mail.WithSMTPAuth(mail.SMTPAuthPlain),
-		mail.WithUsername(config.Username),
-		mail.WithPassword(config.Password),
-		mail.WithTLSPolicy(mail.TLSMandatory),
-	)
-
+	transport, err := NewTransport(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create mail client: %w", err)
+		return nil, err
 	}
 
 	return &SMTPEmailService{
-		config: config,
-		client: client,
+		config:    config,
+		transport: transport,
 	}, nil
 }
 
-// SendTypedEmail sends an email using the specified template and data
+// SendTypedEmail renders the template with the data and sends it, through the outbox when there is one
 func (s *SMTPEmailService) SendTypedEmail(to string, template EmailTemplate, data interface{}) error {
-	// Check if we're in development/staging mode
-	if env := os.Getenv("APP_ENV"); env != "production" {
-		log.Printf("[%s MODE] Would send email to %s with subject: %s", env, to, template.Subject)
-		return nil
+	if err := s.checkSuppressed(to); err != nil {
+		return err
 	}
+	msg, err := s.Render(to, template, data)
+	if err != nil {
+		return err
+	}
+	if s.outbox != nil {
+		_, err := s.outbox.Enqueue(msg)
+		return err
+	}
+	return s.Deliver(msg)
+}
 
-	// Load and render templates
-	htmlContent, textContent, err := s.renderTemplates(template.Name, data)
+// Render renders the template with the data into a message ready for delivery
+func (s *SMTPEmailService) Render(to string, template EmailTemplate, data interface{}) (*Message, error) {
+	var htmlContent, textContent string
+	var err error
+	if template.markdown != "" {
+		htmlContent, textContent, err = s.renderBroadcast(template.markdown, data)
+	} else {
+		htmlContent, textContent, err = s.renderTemplates(template.Name, data)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to render templates: %w", err)
+		return nil, fmt.Errorf("failed to render templates: %w", err)
+	}
+	if template.track && template.emailSendID != 0 {
+		htmlContent = addTracking(htmlContent, template.emailSendID)
+	}
+	msg := &Message{
+		To:              to,
+		Subject:         template.Subject,
+		TemplateName:    template.Name,
+		HTML:            htmlContent,
+		Text:            textContent,
+		emailSendID:     template.emailSendID,
+		broadcastSendID: template.broadcastSendID,
+	}
+	if template.list != "" {
+		msg.Unsubscribe = OneClickUnsubscribeURL(to, template.list)
+		if template.emailSendID != 0 {
+			msg.Unsubscribe = UnsubscribeSendLink(msg.Unsubscribe, template.emailSendID)
+		}
 	}
+	return msg, nil
+}
 
-	msg := mail.NewMsg()
-	if err := msg.From(fmt.Sprintf("%s <%s>", s.config.FromName, s.config.FromEmail)); err != nil {
-		return fmt.Errorf("failed to set from address: %w", err)
+// Deliver hands a rendered message to the transport. Messages queued before their address was suppressed
+// fail permanently.
+func (s *SMTPEmailService) Deliver(msg *Message) error {
+	if err := s.checkSuppressed(msg.To); err != nil {
+		return Permanent(err)
+	}
+	return s.transport.Send(msg)
+}
+
+// checkSuppressed returns ErrSuppressed if the address is on the suppression list
+func (s *SMTPEmailService) checkSuppressed(to string) error {
+	if s.suppressions == nil {
+		return nil
 	}
-	if err := msg.To(to); err != nil {
-		return fmt.Errorf("failed to set to address: %w", err)
+	suppressed, err := IsSuppressed(s.suppressions, to)
+	if err != nil {
+		return err
+	}
+	if suppressed {
+		log.Printf("🚫 Not sending to suppressed address %s", to)
+		return fmt.Errorf("%w: %s", ErrSuppressed, to)
 	}
-	msg.Subject(template.Subject)
+	return nil
+}
 
-	msg.SetBodyString(mail.TypeTextPlain, textContent)
-	msg.AddAlternativeString(mail.TypeTextHTML, htmlContent)
+// TransportName returns the name of the transport in use, e.g. "smtp"
+func (s *SMTPEmailService) TransportName() string {
+	return transportName(s.config)
+}
+
+// Mailbox returns the messages kept by the transport, or nil when it delivers them
+func (s *SMTPEmailService) Mailbox() Mailbox {
+	mailbox, _ := s.transport.(Mailbox)
+	return mailbox
+}
+
+// SetOutbox makes the service queue messages in the outbox instead of sending them straight away
+func (s *SMTPEmailService) SetOutbox(outbox *Outbox) {
+	s.outbox = outbox
+}
 
-	return s.client.DialAndSend(msg)
+// SetSuppressionList makes the service check the suppression list in the database before every send
+func (s *SMTPEmailService) SetSuppressionList(db *sql.DB) {
+	s.suppressions = db
 }
 
 // SendPasswordResetEmail sends a password reset email
@@ -112,26 +193,18 @@ func (s *SMTPEmailService) SendPasswordResetSuccessEmail(to, username string) er
 }
 
 // SendReminderEmail sends a daily habit reminder email
-func (s *SMTPEmailService) SendReminderEmail(to string, firstName string, habits []HabitInfo, quote QuoteInfo) error {
+func (s *SMTPEmailService) SendReminderEmail(to string, firstName string, habits []HabitInfo, quote QuoteInfo, insight, preferencesLink string) error {
 	data := ReminderEmailData{
-		FirstName: firstName,
-		Habits:    habits,
-		Quote:     quote,
-		AppName:   "The Habits Company",
+		FirstName:       firstName,
+		Habits:          habits,
+		Quote:           quote,
+		Insight:         insight,
+		AppName:         "The Habits Company",
+		PreferencesLink: preferencesLink,
 	}
 	return s.SendTypedEmail(to, ReminderEmail, data)
 }
 
-// SendFirstHabitEmail sends an email encouraging users to create their first habit
-func (s *SMTPEmailService) SendFirstHabitEmail(to string, firstName string, quote QuoteInfo) error {
-	data := FirstHabitEmailData{
-		FirstName: firstName,
-		Quote:     quote,
-		AppName:   s.config.FromName,
-	}
-	return s.SendTypedEmail(to, FirstHabitEmail, data)
-}
-
 // SendSimpleEmail sends a simple email with custom subject and content
 func (s *SMTPEmailService) SendSimpleEmail(to, subject, content string) error {
 	// Create a custom template for the simple email
@@ -0,0 +1,124 @@
+package email
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Reasons an address is on the suppression list
+const (
+	SuppressBounce    = "bounce"    // the address hard-bounced
+	SuppressComplaint = "complaint" // the recipient reported our email as spam
+	SuppressManual    = "manual"    // added by an admin
+)
+
+// ErrSuppressed is returned when sending to an address on the suppression list
+var ErrSuppressed = errors.New("address is on the suppression list")
+
+// Suppression is an address no email is sent to
+type Suppression struct {
+	Email     string
+	Reason    string
+	Detail    string // the bounce diagnostic or complaint type
+	Source    string // where it came from, e.g. mailbox, webhook or admin
+	CreatedAt time.Time
+}
+
+// Suppress adds the address to the suppression list, updating the reason if it's already there
+func Suppress(db *sql.DB, address, reason, detail, source string) error {
+	address = normalizeAddress(address)
+	if address == "" {
+		return errors.New("missing address to suppress")
+	}
+	_, err := db.Exec(`
+		INSERT INTO email_suppressions (email, reason, detail, source)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (email) DO UPDATE SET reason = excluded.reason, detail = excluded.detail,
+			source = excluded.source, created_at = CURRENT_TIMESTAMP`,
+		address, reason, detail, source,
+	)
+	if err != nil {
+		return fmt.Errorf("error suppressing %s: %w", address, err)
+	}
+	return nil
+}
+
+// IsSuppressed reports whether the address is on the suppression list
+func IsSuppressed(db *sql.DB, address string) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM email_suppressions WHERE email = ?)",
+		normalizeAddress(address)).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error checking suppression list: %w", err)
+	}
+	return exists, nil
+}
+
+// LiftSuppression takes the address off the suppression list so it's sent email again
+func LiftSuppression(db *sql.DB, address string) error {
+	result, err := db.Exec("DELETE FROM email_suppressions WHERE email = ?", normalizeAddress(address))
+	if err != nil {
+		return fmt.Errorf("error lifting suppression: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%s isn't suppressed", address)
+	}
+	return nil
+}
+
+// GetSuppressions returns the most recently suppressed addresses
+func GetSuppressions(db *sql.DB, limit int) ([]Suppression, error) {
+	rows, err := db.Query(`
+		SELECT email, reason, COALESCE(detail, ''), COALESCE(source, ''), created_at
+		FROM email_suppressions
+		ORDER BY created_at DESC, email
+		LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting suppressions: %w", err)
+	}
+	defer rows.Close()
+
+	suppressions := []Suppression{}
+	for rows.Next() {
+		var s Suppression
+		if err := rows.Scan(&s.Email, &s.Reason, &s.Detail, &s.Source, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		suppressions = append(suppressions, s)
+	}
+	return suppressions, rows.Err()
+}
+
+// CountSuppressions returns how many addresses are suppressed for each reason
+func CountSuppressions(db *sql.DB) (map[string]int, error) {
+	rows, err := db.Query("SELECT reason, COUNT(*) FROM email_suppressions GROUP BY reason")
+	if err != nil {
+		return nil, fmt.Errorf("error counting suppressions: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var reason string
+		var count int
+		if err := rows.Scan(&reason, &count); err != nil {
+			return nil, err
+		}
+		counts[reason] = count
+	}
+	return counts, rows.Err()
+}
+
+// normalizeAddress lowercases the address and strips any display name or angle brackets
+func normalizeAddress(address string) string {
+	address = strings.TrimSpace(address)
+	if i := strings.LastIndex(address, "<"); i >= 0 {
+		address = strings.TrimSuffix(address[i+1:], ">")
+	}
+	return strings.ToLower(strings.TrimSpace(address))
+}
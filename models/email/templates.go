@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"os"
 	"path/filepath"
 	"strings"
 )
@@ -70,12 +71,62 @@ func (s *SMTPEmailService) renderCampaignTemplates(templateName string, data int
 	// Campaign template paths
 	htmlPath := filepath.Join(s.config.TemplateDir, templateName+".html")
 	textPath := filepath.Join(s.config.TemplateDir, templateName+".txt")
+
+	// Render the content, written in markdown or as HTML and text templates
+	contentHTMLBuf := new(bytes.Buffer)
+	contentTextBuf := new(bytes.Buffer)
+
+	markdownPath := filepath.Join(s.config.TemplateDir, templateName+".md")
+	if _, err := os.Stat(markdownPath); err == nil {
+		htmlContent, textContent, err := renderMarkdownEmail(markdownPath, data)
+		if err != nil {
+			log.Printf("❌ Failed to render markdown email: %v", err)
+			return "", "", err
+		}
+		contentHTMLBuf.WriteString(htmlContent)
+		contentTextBuf.WriteString(textContent)
+	} else {
+		contentHTML, err := template.ParseFiles(htmlPath)
+		if err != nil {
+			log.Printf("❌ Failed to load content HTML template: %v", err)
+			return "", "", fmt.Errorf("failed to load content HTML template: %w", err)
+		}
+
+		contentText, err := template.ParseFiles(textPath)
+		if err != nil {
+			log.Printf("❌ Failed to load content text template: %v", err)
+			return "", "", fmt.Errorf("failed to load content text template: %w", err)
+		}
+
+		if err := contentHTML.Execute(contentHTMLBuf, data); err != nil {
+			log.Printf("❌ Failed to render content HTML template: %v", err)
+			return "", "", fmt.Errorf("failed to render content HTML template: %w", err)
+		}
+
+		if err := contentText.Execute(contentTextBuf, data); err != nil {
+			log.Printf("❌ Failed to render content text template: %v", err)
+			return "", "", fmt.Errorf("failed to render content text template: %w", err)
+		}
+	}
+
+	htmlOutput, textOutput, err := s.renderBaseTemplates(contentHTMLBuf.String(), contentTextBuf.String(), data.(map[string]interface{}))
+	if err != nil {
+		return "", "", err
+	}
+
+	log.Printf("✅ Successfully rendered campaign templates for: %s", templateName)
+	return htmlOutput, textOutput, nil
+}
+
+// renderBaseTemplates puts rendered campaign content into the base layout, with the title, campaign and
+// unsubscribe footer from the data
+func (s *SMTPEmailService) renderBaseTemplates(contentHTML, contentText string, data map[string]interface{}) (htmlContent, textContent string, err error) {
 	baseHTMLPath := filepath.Join(s.config.TemplateDir, "base.html")
 	baseTextPath := filepath.Join(s.config.TemplateDir, "base.txt")
 
 	// Extract the unsubscribe link before rendering for post-processing
 	unsubscribeLink := ""
-	if linkData, ok := data.(map[string]interface{})["UnsubscribeLink"]; ok {
+	if linkData, ok := data["UnsubscribeLink"]; ok {
 		if linkStr, ok := linkData.(string); ok {
 			unsubscribeLink = linkStr
 			log.Printf("📝 Extracted unsubscribe link for post-processing: %s", unsubscribeLink)
@@ -95,44 +146,18 @@ func (s *SMTPEmailService) renderCampaignTemplates(templateName string, data int
 		return "", "", fmt.Errorf("failed to load base text template: %w", err)
 	}
 
-	// Load and render content templates
-	contentHTML, err := template.ParseFiles(htmlPath)
-	if err != nil {
-		log.Printf("❌ Failed to load content HTML template: %v", err)
-		return "", "", fmt.Errorf("failed to load content HTML template: %w", err)
-	}
-
-	contentText, err := template.ParseFiles(textPath)
-	if err != nil {
-		log.Printf("❌ Failed to load content text template: %v", err)
-		return "", "", fmt.Errorf("failed to load content text template: %w", err)
-	}
-
-	// Render content templates first
-	contentHTMLBuf := new(bytes.Buffer)
-	contentTextBuf := new(bytes.Buffer)
-
-	if err := contentHTML.Execute(contentHTMLBuf, data); err != nil {
-		log.Printf("❌ Failed to render content HTML template: %v", err)
-		return "", "", fmt.Errorf("failed to render content HTML template: %w", err)
-	}
-
-	if err := contentText.Execute(contentTextBuf, data); err != nil {
-		log.Printf("❌ Failed to render content text template: %v", err)
-		return "", "", fmt.Errorf("failed to render content text template: %w", err)
-	}
-
 	// Create base template data with rendered content
 	campaignData := map[string]interface{}{
-		"Content":       template.HTML(contentHTMLBuf.String()),
-		"Title":         data.(map[string]interface{})["Title"],
-		"Subject":       data.(map[string]interface{})["Subject"],
-		"AppName":       data.(map[string]interface{})["AppName"],
-		"CampaignName":  data.(map[string]interface{})["CampaignName"],
-		"CampaignEmoji": data.(map[string]interface{})["CampaignEmoji"],
+		"Content":       template.HTML(contentHTML),
+		"Title":         data["Title"],
+		"Subject":       data["Subject"],
+		"AppName":       data["AppName"],
+		"CampaignName":  data["CampaignName"],
+		"CampaignEmoji": data["CampaignEmoji"],
 		// Add a placeholder for the unsubscribe link that we'll replace later
 		"UnsubscribeLink": "UNSUBSCRIBE_LINK_PLACEHOLDER",
-		"FirstName":       data.(map[string]interface{})["FirstName"],
+		"FirstName":       data["FirstName"],
+		"PreferencesLink": data["PreferencesLink"],
 	}
 
 	// Render base templates with content
@@ -146,11 +171,11 @@ func (s *SMTPEmailService) renderCampaignTemplates(templateName string, data int
 
 	// For text template, use plain text content
 	textCampaignData := map[string]interface{}{
-		"Content":         contentTextBuf.String(),
-		"Title":           data.(map[string]interface{})["Title"],
-		"AppName":         data.(map[string]interface{})["AppName"],
-		"CampaignName":    data.(map[string]interface{})["CampaignName"],
-		"CampaignEmoji":   data.(map[string]interface{})["CampaignEmoji"],
+		"Content":         contentText,
+		"Title":           data["Title"],
+		"AppName":         data["AppName"],
+		"CampaignName":    data["CampaignName"],
+		"CampaignEmoji":   data["CampaignEmoji"],
 		"UnsubscribeLink": unsubscribeLink, // Use the original link for text emails
 	}
 
@@ -166,7 +191,5 @@ func (s *SMTPEmailService) renderCampaignTemplates(templateName string, data int
 		htmlOutput = strings.Replace(htmlOutput, "UNSUBSCRIBE_LINK_PLACEHOLDER", unsubscribeLink, 1)
 		log.Printf("📝 Replaced unsubscribe link placeholder in HTML output")
 	}
-
-	log.Printf("✅ Successfully rendered campaign templates for: %s", templateName)
 	return htmlOutput, finalTextBuf.String(), nil
 }
@@ -0,0 +1,142 @@
+package email
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Email events recorded for campaign emails: opens and clicks on tracked ones, and unsubscribes from any. Only
+// the send and the time are kept, never the reader's IP address or user agent.
+const (
+	EventOpen        = "open"
+	EventClick       = "click"
+	EventUnsubscribe = "unsubscribe"
+)
+
+var (
+	signingKeyOnce sync.Once
+	signingKeyData []byte
+)
+
+// signingKey returns the key signing tracking and unsubscribe links in emails, from EMAIL_SIGNING_KEY.
+// Without it a random key is used, so links stop working when the server restarts.
+func signingKey() []byte {
+	signingKeyOnce.Do(func() {
+		if key := os.Getenv("EMAIL_SIGNING_KEY"); key != "" {
+			signingKeyData = []byte(key)
+			return
+		}
+		log.Printf("Warning: EMAIL_SIGNING_KEY not set, signed email links won't survive a restart")
+		signingKeyData = make([]byte, 32)
+		rand.Read(signingKeyData)
+	})
+	return signingKeyData
+}
+
+// CheckSigningKey refuses a production server without EMAIL_SIGNING_KEY, where a restart would break the
+// tracking and unsubscribe links in every email already sent
+func CheckSigningKey() error {
+	if os.Getenv("APP_ENV") == "production" && os.Getenv("EMAIL_SIGNING_KEY") == "" {
+		return fmt.Errorf("EMAIL_SIGNING_KEY must be set in production, it signs the tracking and unsubscribe links in emails")
+	}
+	return nil
+}
+
+// sign returns the signature of the parts for a link
+func sign(parts ...string) string {
+	mac := hmac.New(sha256.New, signingKey())
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validSignature reports whether the signature matches the parts
+func validSignature(signature string, parts ...string) bool {
+	return hmac.Equal([]byte(signature), []byte(sign(parts...)))
+}
+
+// TrackingPixelURL returns the URL of the open pixel for a send
+func TrackingPixelURL(sendID int64) string {
+	id := strconv.FormatInt(sendID, 10)
+	return fmt.Sprintf("%s/email/open?s=%s&sig=%s", linkBaseURL(), id, sign(EventOpen, id))
+}
+
+// TrackingClickURL returns a URL that records a click on the send and redirects to target
+func TrackingClickURL(sendID int64, target string) string {
+	id := strconv.FormatInt(sendID, 10)
+	return fmt.Sprintf("%s/email/click?s=%s&u=%s&sig=%s",
+		linkBaseURL(), id, url.QueryEscape(target), sign(EventClick, id, target))
+}
+
+// ValidTrackingPixel reports whether an open pixel's signature is valid
+func ValidTrackingPixel(sendID int64, signature string) bool {
+	return validSignature(signature, EventOpen, strconv.FormatInt(sendID, 10))
+}
+
+// ValidTrackingClick reports whether a click link's signature is valid, so it can't redirect anywhere else
+func ValidTrackingClick(sendID int64, target, signature string) bool {
+	return validSignature(signature, EventClick, strconv.FormatInt(sendID, 10), target)
+}
+
+// UnsubscribeSendLink adds the send to an unsubscribe link, signed, so leaving is counted against the email
+// the link came in
+func UnsubscribeSendLink(link string, sendID int64) string {
+	id := strconv.FormatInt(sendID, 10)
+	return link + "&s=" + id + "&ssig=" + sign(EventUnsubscribe, id)
+}
+
+// UnsubscribeSend returns the send an unsubscribe link came in, or 0 if it has none or the signature is invalid
+func UnsubscribeSend(query url.Values) int64 {
+	sendID, err := strconv.ParseInt(query.Get("s"), 10, 64)
+	if err != nil || !validSignature(query.Get("ssig"), EventUnsubscribe, query.Get("s")) {
+		return 0
+	}
+	return sendID
+}
+
+// trackedLink matches the web links in rendered HTML
+var trackedLink = regexp.MustCompile(`href="(https?://[^"]+)"`)
+
+// addTracking rewrites the web links in an email's HTML to go through the click tracker and adds the open
+// pixel. Unsubscribe and preference links are left alone so leaving isn't counted as engagement.
+func addTracking(htmlContent string, sendID int64) string {
+	htmlContent = trackedLink.ReplaceAllStringFunc(htmlContent, func(attr string) string {
+		target := html.UnescapeString(trackedLink.FindStringSubmatch(attr)[1])
+		if strings.Contains(target, "/unsubscribe") {
+			return attr
+		}
+		return `href="` + html.EscapeString(TrackingClickURL(sendID, target)) + `"`
+	})
+
+	pixel := `<img src="` + html.EscapeString(TrackingPixelURL(sendID)) + `" width="1" height="1" alt="" style="display:block;border:0">`
+	if i := strings.LastIndex(strings.ToLower(htmlContent), "</body>"); i >= 0 {
+		return htmlContent[:i] + pixel + htmlContent[i:]
+	}
+	return htmlContent + pixel
+}
+
+// RecordEmailEvent records an open, click or unsubscribe on a campaign send
+func RecordEmailEvent(db *sql.DB, sendID int64, kind, target string) error {
+	var targetValue sql.NullString
+	if target != "" {
+		targetValue = sql.NullString{String: target, Valid: true}
+	}
+	_, err := db.Exec(`
+	INSERT INTO email_events (email_send_id, kind, url)
+	SELECT id, ?, ? FROM email_sends WHERE id = ?`, kind, targetValue, sendID)
+	if err != nil {
+		return fmt.Errorf("error recording email %s: %w", kind, err)
+	}
+	return nil
+}
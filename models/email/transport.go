@@ -0,0 +1,369 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wneessen/go-mail"
+)
+
+// Mail transports selectable with SMTPConfig.Transport
+const (
+	TransportSMTP     = "smtp"
+	TransportSendmail = "sendmail"
+	TransportFile     = "file"   // writes .eml files to a directory
+	TransportMemory   = "memory" // keeps recent messages for the dev inbox
+	TransportLog      = "log"    // only logs what would be sent
+)
+
+// TLS policies for the SMTP transport
+const (
+	TLSMandatory     = "mandatory"     // STARTTLS required
+	TLSOpportunistic = "opportunistic" // STARTTLS when the server offers it
+	TLSNone          = "none"          // plain text, e.g. for a local mail catcher
+	TLSImplicit      = "implicit"      // TLS from the start of the connection, usually port 465
+)
+
+// Transport delivers rendered messages
+type Transport interface {
+	Send(msg *Message) error
+}
+
+// CapturedMessage is a message kept by a transport that doesn't deliver it
+type CapturedMessage struct {
+	ID      string
+	From    string
+	To      string
+	Subject string
+	Date    time.Time
+	HTML    string
+	Text    string
+
+	Unsubscribe string // the List-Unsubscribe URL, empty for transactional email
+}
+
+// Mailbox is implemented by transports that keep the messages they are given, for the dev inbox
+type Mailbox interface {
+	Messages() ([]CapturedMessage, error)
+	Message(id string) (CapturedMessage, error)
+}
+
+// NewTransport creates the transport selected in the config, defaulting to SMTP in production and to logging
+// elsewhere
+func NewTransport(config SMTPConfig) (Transport, error) {
+	switch name := transportName(config); name {
+	case TransportSMTP:
+		return NewSMTPTransport(config)
+	case TransportSendmail:
+		return &SendmailTransport{config: config}, nil
+	case TransportFile:
+		return NewFileTransport(config)
+	case TransportMemory:
+		return NewMemoryTransport(config, 200), nil
+	case TransportLog:
+		return LogTransport{}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", name)
+	}
+}
+
+// transportName returns the transport selected in the config or the default for the environment
+func transportName(config SMTPConfig) string {
+	if config.Transport != "" {
+		return config.Transport
+	}
+	if os.Getenv("APP_ENV") == "production" {
+		return TransportSMTP
+	}
+	return TransportLog
+}
+
+// buildMsg turns a rendered message into a MIME message with text and HTML alternatives
+func buildMsg(config SMTPConfig, msg *Message) (*mail.Msg, error) {
+	m := mail.NewMsg()
+	if err := m.From(fmt.Sprintf("%s <%s>", config.FromName, config.FromEmail)); err != nil {
+		return nil, Permanent(fmt.Errorf("failed to set from address: %w", err))
+	}
+	if err := m.To(msg.To); err != nil {
+		return nil, Permanent(fmt.Errorf("failed to set to address: %w", err))
+	}
+	m.Subject(msg.Subject)
+	m.SetDate()
+	if msg.Unsubscribe != "" {
+		// RFC 8058 one-click unsubscribe, which bulk senders need for Gmail and Yahoo
+		m.SetGenHeader(mail.HeaderListUnsubscribe, "<"+msg.Unsubscribe+">")
+		m.SetGenHeader(mail.HeaderListUnsubscribePost, "List-Unsubscribe=One-Click")
+	}
+
+	m.SetBodyString(mail.TypeTextPlain, msg.Text)
+	m.AddAlternativeString(mail.TypeTextHTML, msg.HTML)
+	return m, nil
+}
+
+// SMTPTransport delivers messages to an SMTP server
+type SMTPTransport struct {
+	config SMTPConfig
+	client *mail.Client
+}
+
+// NewSMTPTransport creates an SMTP transport. Authentication is skipped without a username, so local mail
+// catchers work with just a host, port and the "none" TLS policy.
+func NewSMTPTransport(config SMTPConfig) (*SMTPTransport, error) {
+	port := config.Port
+	if port == 0 {
+		port = 587
+	}
+	opts := []mail.Option{mail.WithPort(port)}
+
+	switch config.TLS {
+	case "", TLSMandatory:
+		opts = append(opts, mail.WithTLSPolicy(mail.TLSMandatory))
+	case TLSOpportunistic:
+		opts = append(opts, mail.WithTLSPolicy(mail.TLSOpportunistic))
+	case TLSNone:
+		opts = append(opts, mail.WithTLSPolicy(mail.NoTLS))
+	case TLSImplicit:
+		opts = append(opts, mail.WithSSL())
+	default:
+		return nil, fmt.Errorf("unknown TLS policy %q", config.TLS)
+	}
+
+	if config.Username != "" {
+		opts = append(opts,
+			mail.WithSMTPAuth(mail.SMTPAuthPlain),
+			mail.WithUsername(config.Username),
+			mail.WithPassword(config.Password),
+		)
+	}
+
+	client, err := mail.NewClient(config.Host, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mail client: %w", err)
+	}
+	return &SMTPTransport{config: config, client: client}, nil
+}
+
+// Send delivers the message. Failures the server reports as permanent are wrapped in a PermanentError so the
+// outbox doesn't retry them.
+func (t *SMTPTransport) Send(msg *Message) error {
+	m, err := buildMsg(t.config, msg)
+	if err != nil {
+		return err
+	}
+	if err := t.client.DialAndSend(m); err != nil {
+		var sendErr *mail.SendError
+		if errors.As(err, &sendErr) && !sendErr.IsTemp() {
+			return Permanent(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// SendmailTransport pipes messages to a local sendmail binary
+type SendmailTransport struct {
+	config SMTPConfig
+}
+
+// Send delivers the message with sendmail
+func (t *SendmailTransport) Send(msg *Message) error {
+	m, err := buildMsg(t.config, msg)
+	if err != nil {
+		return err
+	}
+	path := t.config.SendmailPath
+	if path == "" {
+		path = mail.SendmailPath
+	}
+	if err := m.WriteToSendmailWithCommand(path); err != nil {
+		return fmt.Errorf("sendmail failed: %w", err)
+	}
+	return nil
+}
+
+// FileTransport writes each message as an .eml file to a directory
+type FileTransport struct {
+	config SMTPConfig
+	mu     sync.Mutex
+	seq    int
+}
+
+// NewFileTransport creates a file transport writing to config.MailDir, creating it if needed
+func NewFileTransport(config SMTPConfig) (*FileTransport, error) {
+	if config.MailDir == "" {
+		return nil, errors.New("the file mail transport needs a mail directory")
+	}
+	if err := os.MkdirAll(config.MailDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &FileTransport{config: config}, nil
+}
+
+// Send writes the message to a new file named after the time it was sent
+func (t *FileTransport) Send(msg *Message) error {
+	m, err := buildMsg(t.config, msg)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.seq++
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().UTC().Format("20060102-150405.000000"), t.seq%10000)
+	t.mu.Unlock()
+
+	if err := m.WriteToFile(filepath.Join(t.config.MailDir, name)); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	log.Printf("📁 Wrote email to %s with subject %q to %s", msg.To, msg.Subject, name)
+	return nil
+}
+
+// Messages returns the most recent 100 messages in the directory, newest first
+func (t *FileTransport) Messages() ([]CapturedMessage, error) {
+	entries, err := os.ReadDir(t.config.MailDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mail directory: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".eml") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	if len(names) > 100 {
+		names = names[:100]
+	}
+
+	messages := []CapturedMessage{}
+	for _, name := range names {
+		msg, err := t.Message(name)
+		if err != nil {
+			log.Printf("Skipping unreadable email %s: %v", name, err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// Message reads the message with the given file name
+func (t *FileTransport) Message(id string) (CapturedMessage, error) {
+	if id != filepath.Base(id) || !strings.HasSuffix(id, ".eml") {
+		return CapturedMessage{}, fmt.Errorf("invalid email file name %q", id)
+	}
+	m, err := mail.EMLToMsgFromFile(filepath.Join(t.config.MailDir, id))
+	if err != nil {
+		return CapturedMessage{}, fmt.Errorf("failed to parse email: %w", err)
+	}
+
+	captured := CapturedMessage{
+		ID:   id,
+		From: strings.Join(m.GetFromString(), ", "),
+
+		Subject: strings.Join(m.GetGenHeader(mail.HeaderSubject), " "),
+	}
+	var to []string
+	for _, addr := range m.GetTo() {
+		to = append(to, addr.Address)
+	}
+	captured.To = strings.Join(to, ", ")
+	if unsubscribe := m.GetGenHeader(mail.HeaderListUnsubscribe); len(unsubscribe) > 0 {
+		captured.Unsubscribe = strings.Trim(unsubscribe[0], "<>")
+	}
+	if dates := m.GetGenHeader(mail.HeaderDate); len(dates) > 0 {
+		captured.Date, _ = time.Parse(time.RFC1123Z, dates[0])
+	}
+	for _, part := range m.GetParts() {
+		content, err := part.GetContent()
+		if err != nil {
+			continue
+		}
+		switch part.GetContentType() {
+		case mail.TypeTextHTML:
+			captured.HTML = string(content)
+		case mail.TypeTextPlain:
+			captured.Text = string(content)
+		}
+	}
+	return captured, nil
+}
+
+// MemoryTransport keeps the most recent messages in memory
+type MemoryTransport struct {
+	config   SMTPConfig
+	limit    int
+	mu       sync.Mutex
+	nextID   int
+	messages []CapturedMessage // oldest first
+}
+
+// NewMemoryTransport creates a memory transport keeping up to limit messages
+func NewMemoryTransport(config SMTPConfig, limit int) *MemoryTransport {
+	return &MemoryTransport{config: config, limit: limit}
+}
+
+// Send stores the message, dropping the oldest one when full
+func (t *MemoryTransport) Send(msg *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.nextID++
+	t.messages = append(t.messages, CapturedMessage{
+		ID:      strconv.Itoa(t.nextID),
+		From:    fmt.Sprintf("%s <%s>", t.config.FromName, t.config.FromEmail),
+		To:      msg.To,
+		Subject: msg.Subject,
+		Date:    time.Now(),
+		HTML:    msg.HTML,
+		Text:    msg.Text,
+
+		Unsubscribe: msg.Unsubscribe,
+	})
+	if len(t.messages) > t.limit {
+		t.messages = t.messages[len(t.messages)-t.limit:]
+	}
+	return nil
+}
+
+// Messages returns the stored messages, newest first
+func (t *MemoryTransport) Messages() ([]CapturedMessage, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	messages := make([]CapturedMessage, 0, len(t.messages))
+	for i := len(t.messages) - 1; i >= 0; i-- {
+		messages = append(messages, t.messages[i])
+	}
+	return messages, nil
+}
+
+// Message returns the stored message with the given ID
+func (t *MemoryTransport) Message(id string) (CapturedMessage, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, m := range t.messages {
+		if m.ID == id {
+			return m, nil
+		}
+	}
+	return CapturedMessage{}, fmt.Errorf("no email with ID %s", id)
+}
+
+// LogTransport only logs the messages it is given
+type LogTransport struct{}
+
+// Send logs the recipient and subject
+func (LogTransport) Send(msg *Message) error {
+	log.Printf("[%s MODE] Would send email to %s with subject: %s", os.Getenv("APP_ENV"), msg.To, msg.Subject)
+	return nil
+}
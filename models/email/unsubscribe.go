@@ -0,0 +1,51 @@
+package email
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Kinds of list a recipient can leave with one click. A list is written "campaign:<campaign ID>" or
+// "notification:<notification type>", with the notification types of the preferences plus digest.
+const (
+	ListCampaign     = "campaign"
+	ListNotification = "notification"
+)
+
+// CampaignList returns the list of a campaign's emails
+func CampaignList(campaignID string) string {
+	return ListCampaign + ":" + campaignID
+}
+
+// NotificationList returns the list of a type of notification email
+func NotificationList(kind string) string {
+	return ListNotification + ":" + kind
+}
+
+// ParseList splits a list into its kind and the campaign ID or notification type
+func ParseList(list string) (kind, id string, err error) {
+	kind, id, ok := strings.Cut(list, ":")
+	if !ok || id == "" || (kind != ListCampaign && kind != ListNotification) {
+		return "", "", fmt.Errorf("invalid list %q", list)
+	}
+	return kind, id, nil
+}
+
+// UnsubscribeToken returns the signed token that lets the address leave the list. It's derived from the
+// address and list rather than stored, so every email can carry one and it works however old the email is.
+func UnsubscribeToken(address, list string) string {
+	return sign("unsubscribe", normalizeAddress(address), list)
+}
+
+// ValidUnsubscribeToken reports whether the token lets the address leave the list
+func ValidUnsubscribeToken(address, list, token string) bool {
+	return validSignature(token, "unsubscribe", normalizeAddress(address), list)
+}
+
+// OneClickUnsubscribeURL returns the RFC 8058 one-click unsubscribe URL for the List-Unsubscribe header.
+// Mail clients POST to it; opening it in a browser shows the unsubscribe or preferences page instead.
+func OneClickUnsubscribeURL(address, list string) string {
+	return fmt.Sprintf("%s/unsubscribe/one-click?email=%s&list=%s&token=%s",
+		linkBaseURL(), url.QueryEscape(address), url.QueryEscape(list), UnsubscribeToken(address, list))
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mad/models/email"
+)
+
+func TestEmailPreviews(t *testing.T) {
+	if err := email.LoadCampaigns("../ui/email"); err != nil {
+		t.Fatalf("LoadCampaigns failed: %v", err)
+	}
+	svc, err := email.NewSMTPEmailService(email.SMTPConfig{
+		Transport:   email.TransportMemory,
+		FromName:    "The Habits Company",
+		FromEmail:   "hello@example.com",
+		TemplateDir: "../ui/email",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create email service: %v", err)
+	}
+	smtp := svc.(*email.SMTPEmailService)
+
+	// Every template in the repo and every campaign email renders with its sample data
+	previews, err := smtp.PreviewTemplates()
+	if err != nil {
+		t.Fatalf("PreviewTemplates failed: %v", err)
+	}
+	ids := map[string]email.TemplatePreview{}
+	for _, preview := range previews {
+		ids[preview.ID] = preview
+		if len(preview.Problems) > 0 {
+			t.Errorf("Expected %s to render, got %v", preview.ID, preview.Problems)
+		}
+	}
+	reminder, ok := ids["reminder"]
+	if !ok || !strings.Contains(reminder.HTML, "Sam") || !strings.Contains(reminder.Text, "Drink Water") {
+		t.Errorf("Expected the reminder with sample habits, got %+v", reminder)
+	}
+	if _, ok := ids["base"]; ok {
+		t.Error("Expected the layout not to be listed as a template")
+	}
+	welcome, ok := ids["courses/onboarding/1-welcome"]
+	if !ok || welcome.Group != "🚀 Getting Started with Habits" || welcome.HTML == "" {
+		t.Errorf("Expected the onboarding emails grouped by campaign, got %+v", welcome)
+	}
+
+	// A test goes to the admin with the sample data
+	if err := smtp.SendTemplateTest("ada@example.com", "reset-password"); err != nil {
+		t.Fatalf("SendTemplateTest failed: %v", err)
+	}
+	if err := smtp.SendTemplateTest("ada@example.com", "nope"); err == nil {
+		t.Error("Expected an unknown template to be rejected")
+	}
+	messages, _ := smtp.Mailbox().Messages()
+	if len(messages) != 1 || messages[0].To != "ada@example.com" || !strings.HasPrefix(messages[0].Subject, "[Test] ") {
+		t.Fatalf("Expected a test to Ada, got %+v", messages)
+	}
+	if !strings.Contains(messages[0].HTML, "/reset?token=sample-token") {
+		t.Error("Expected the sample reset link in the test")
+	}
+
+	// Missing text parts, broken templates and templates without sample data are flagged
+	dir := t.TempDir()
+	for _, name := range []string{"base.html", "base.txt"} {
+		content, err := os.ReadFile(filepath.Join("../ui/email", name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	for name, content := range map[string]string{
+		"reminder.html":     "Hi {{ .Nickname }}",
+		"reminder.txt":      "Hi {{ .FirstName }}",
+		"streak-nudge.html": "Hi {{ .FirstName }}",
+		"mystery.txt":       "Hi",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	broken, err := email.NewSMTPEmailService(email.SMTPConfig{Transport: email.TransportMemory, TemplateDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create email service: %v", err)
+	}
+	preview, err := broken.(*email.SMTPEmailService).PreviewTemplate("reminder")
+	if err != nil {
+		t.Fatalf("PreviewTemplate failed: %v", err)
+	}
+	if len(preview.Problems) != 1 || !strings.Contains(preview.Problems[0], "Nickname") {
+		t.Errorf("Expected the unknown field flagged, got %v", preview.Problems)
+	}
+	preview, _ = broken.(*email.SMTPEmailService).PreviewTemplate("streak-nudge")
+	if len(preview.Problems) == 0 || preview.Problems[0] != "no streak-nudge.txt for the text part" {
+		t.Errorf("Expected the missing text part flagged, got %v", preview.Problems)
+	}
+	preview, _ = broken.(*email.SMTPEmailService).PreviewTemplate("mystery")
+	if problems := strings.Join(preview.Problems, "\n"); !strings.Contains(problems, "no mystery.html") || !strings.Contains(problems, "no sample data") {
+		t.Errorf("Expected the missing HTML part and sample data flagged, got %v", preview.Problems)
+	}
+}
@@ -7,55 +7,99 @@ import (
 )
 
 type Goal struct {
-	ID            int     `json:"id"`
-	UserID        int     `json:"user_id"`
-	HabitID       int     `json:"habit_id"`
-	Name          string  `json:"name"`
-	StartDate     string  `json:"start_date"`
-	EndDate       string  `json:"end_date"`
-	TargetNumber  float64 `json:"target_number"`
-	CurrentNumber float64 `json:"current_number"`
-	Status        string  `json:"status"`
-	Position      int     `json:"position"`
-	CreatedAt     string  `json:"created_at"`
-	UpdatedAt     string  `json:"updated_at"`
-	HabitName     string  `json:"habit_name"`
-	HabitEmoji    string  `json:"habit_emoji"`
+	ID            int             `json:"id"`
+	UserID        int             `json:"user_id"`
+	HabitID       int             `json:"habit_id"`
+	Name          string          `json:"name"`
+	StartDate     string          `json:"start_date"`
+	EndDate       string          `json:"end_date"`
+	TargetNumber  float64         `json:"target_number"`
+	CurrentNumber float64         `json:"current_number"`
+	Status        string          `json:"status"`
+	Position      int             `json:"position"`
+	CreatedAt     string          `json:"created_at"`
+	UpdatedAt     string          `json:"updated_at"`
+	HabitName     string          `json:"habit_name"`
+	HabitEmoji    string          `json:"habit_emoji"`
+	Kind          string          `json:"kind"`
+	TargetOption  *HabitOption    `json:"target_option,omitempty"` // option counted by option_count goals
+	Comparison    string          `json:"comparison,omitempty"`    // at_least or at_most, for average goals
+	Progress      float64         `json:"progress"`                // percent of the way to the target, capped at 100
+	Recurrence    string          `json:"recurrence"`              // weekly, monthly, quarterly or yearly; empty for one-off goals
+	Habits        []GoalHabit     `json:"habits"`                  // habits counted towards the goal, HabitID first
+	Milestones    []GoalMilestone `json:"milestones"`
 }
 
 // CRUD Methods
 
 func (g *Goal) Create(db *sql.DB) error {
+	g.Kind = g.kindOrDefault()
+	if g.HabitID == 0 && len(g.Habits) > 0 {
+		g.HabitID = g.Habits[0].HabitID
+	}
+	targetOption, err := g.targetOptionValue()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		INSERT INTO goals (
 			user_id, habit_id, name, start_date, end_date, 
-			target_number, position
-		) VALUES (?, ?, ?, ?, ?, ?, (
+			target_number, kind, target_option, comparison, recurrence, position
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, (
 			SELECT COALESCE(MAX(position), 0) + 1 
 			FROM goals 
 			WHERE user_id = ?
 		))
 		RETURNING id, created_at, updated_at`
 
-	return db.QueryRow(
+	err = tx.QueryRow(
 		query,
 		g.UserID, g.HabitID, g.Name, g.StartDate, g.EndDate,
-		g.TargetNumber, g.UserID,
+		g.TargetNumber, g.Kind, targetOption, g.Comparison, g.Recurrence, g.UserID,
 	).Scan(&g.ID, &g.CreatedAt, &g.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := g.insertHabitsAndMilestones(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func GetGoal(db *sql.DB, id int) (*Goal, error) {
 	goal := &Goal{}
-	query := `SELECT * FROM goals WHERE id = ?`
+	var targetOption sql.NullString
+	query := `
+		SELECT id, user_id, habit_id, name, start_date, end_date, target_number,
+			   COALESCE(current_number, 0), COALESCE(status, 'on_track'), progress,
+			   position, created_at, updated_at,
+			   kind, target_option, comparison, recurrence
+		FROM goals
+		WHERE id = ?`
 	err := db.QueryRow(query, id).Scan(
 		&goal.ID, &goal.UserID, &goal.HabitID, &goal.Name,
 		&goal.StartDate, &goal.EndDate, &goal.TargetNumber,
-		&goal.CurrentNumber, &goal.Status, &goal.Position,
+		&goal.CurrentNumber, &goal.Status, &goal.Progress, &goal.Position,
 		&goal.CreatedAt, &goal.UpdatedAt,
+		&goal.Kind, &targetOption, &goal.Comparison, &goal.Recurrence,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if err := goal.setTargetOption(targetOption); err != nil {
+		return nil, err
+	}
+	if err := prepareGoals(db, []*Goal{goal}); err != nil {
+		return nil, err
+	}
 	return goal, nil
 }
 
@@ -69,9 +113,16 @@ func GetGoalsByUser(db *sql.DB, userID int) ([]Goal, error) {
 			g.start_date,
 			g.end_date,
 			g.target_number,
+			COALESCE(g.current_number, 0),
+			COALESCE(g.status, 'on_track'),
+			g.progress,
 			g.position,
 			g.created_at,
 			g.updated_at,
+			g.kind,
+			g.target_option,
+			g.comparison,
+			g.recurrence,
 			h.emoji as habit_emoji,
 			h.name as habit_name
 		FROM goals g
@@ -86,9 +137,10 @@ func GetGoalsByUser(db *sql.DB, userID int) ([]Goal, error) {
 	}
 	defer rows.Close()
 
-	var goals []Goal
+	var goals []*Goal
 	for rows.Next() {
-		var g Goal
+		g := &Goal{}
+		var targetOption sql.NullString
 		err := rows.Scan(
 			&g.ID,
 			&g.UserID,
@@ -97,25 +149,37 @@ func GetGoalsByUser(db *sql.DB, userID int) ([]Goal, error) {
 			&g.StartDate,
 			&g.EndDate,
 			&g.TargetNumber,
+			&g.CurrentNumber,
+			&g.Status,
+			&g.Progress,
 			&g.Position,
 			&g.CreatedAt,
 			&g.UpdatedAt,
+			&g.Kind,
+			&targetOption,
+			&g.Comparison,
+			&g.Recurrence,
 			&g.HabitEmoji,
 			&g.HabitName,
 		)
 		if err != nil {
 			return nil, err
 		}
-
-		// Calculate progress in memory
-		if err := g.CalculateProgressInMemory(db); err != nil {
-			return nil, fmt.Errorf("error calculating progress for goal %d: %v", g.ID, err)
+		if err := g.setTargetOption(targetOption); err != nil {
+			return nil, err
 		}
-
 		goals = append(goals, g)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
 
-	return goals, nil
+	if err := prepareGoals(db, goals); err != nil {
+		return nil, err
+	}
+
+	return dereferenceGoals(goals), nil
 }
 
 func (g *Goal) Update(db *sql.DB) error {
@@ -224,20 +288,23 @@ func (g *Goal) UpdateStatus(db *sql.DB) error {
 	return nil
 }
 
-// ValidateHabitType checks if the habit type is valid for goal creation
+// ValidateHabitType checks that every habit of the goal belongs to the user and suits the goal kind
 func (g *Goal) ValidateHabitType(db *sql.DB) error {
-	var habitType string
-	err := db.QueryRow("SELECT habit_type FROM habits WHERE id = ? AND user_id = ?",
-		g.HabitID, g.UserID).Scan(&habitType)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("habit not found or unauthorized")
+	for _, h := range g.goalHabits() {
+		var habitType HabitType
+		var habitOptions sql.NullString
+		err := db.QueryRow("SELECT habit_type, habit_options FROM habits WHERE id = ? AND user_id = ?",
+			h.HabitID, g.UserID).Scan(&habitType, &habitOptions)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("habit not found or unauthorized")
+			}
+			return fmt.Errorf("error checking habit type: %v", err)
 		}
-		return fmt.Errorf("error checking habit type: %v", err)
-	}
 
-	if habitType == "option-select" {
-		return fmt.Errorf("goals cannot be created for option-select habits")
+		if err := g.validateKindForHabit(habitType, habitOptions); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -265,113 +332,20 @@ func (g *Goal) Validate() error {
 		return fmt.Errorf("target number must be positive")
 	}
 
-	return nil
-}
-
-// CalculateProgress updates the current progress and status of the goal
-func (g *Goal) CalculateProgress(db *sql.DB) error {
-	// Get habit type
-	var habitType string
-	err := db.QueryRow("SELECT habit_type FROM habits WHERE id = ?", g.HabitID).Scan(&habitType)
-	if err != nil {
-		return fmt.Errorf("error getting habit type: %v", err)
-	}
-
-	fmt.Printf("Calculating progress for goal %d (habit %d, type %s)\n", g.ID, g.HabitID, habitType)
-
-	// Calculate current progress based on habit type
-	var query string
-	switch habitType {
-	case "binary":
-		query = `
-			SELECT COUNT(DISTINCT date) 
-			FROM habit_logs 
-			WHERE habit_id = ? 
-			AND date BETWEEN ? AND ? 
-			AND status = 'done'`
-	case "numeric":
-		query = `
-			SELECT COALESCE(SUM(CAST(json_extract(value, '$.value') AS FLOAT)), 0)
-			FROM habit_logs 
-			WHERE habit_id = ? 
-			AND date BETWEEN ? AND ? 
-			AND status = 'done'`
-	case "set-reps":
-		query = `
-			SELECT COALESCE(
-				(
-					SELECT SUM(json_extract(s.value, '$.reps'))
-					FROM habit_logs hl,
-						 json_each(json_extract(hl.value, '$.sets')) AS s
-					WHERE hl.habit_id = ?
-					AND date(hl.date) BETWEEN date(?) AND date(?)
-					AND hl.status = 'done'
-				),
-				0
-			)`
-	default:
-		return fmt.Errorf("unsupported habit type: %s", habitType)
-	}
-
-	err = db.QueryRow(query, g.HabitID, g.StartDate, g.EndDate).Scan(&g.CurrentNumber)
-	if err != nil {
-		return fmt.Errorf("error calculating progress: %v", err)
-	}
-
-	// Calculate expected progress
-	startDate, err := time.Parse("2006-01-02", g.StartDate)
-	if err != nil {
-		return fmt.Errorf("error parsing start date: %v", err)
-	}
-
-	endDate, err := time.Parse("2006-01-02", g.EndDate)
-	if err != nil {
-		return fmt.Errorf("error parsing end date: %v", err)
-	}
-
-	// Get today's date in UTC to ensure consistent comparison
-	todayTime := time.Now().UTC().Truncate(24 * time.Hour)
-	endDate = endDate.UTC().Truncate(24 * time.Hour)
-
-	// Store whether today is past the end date
-	isPastEndDate := todayTime.After(endDate)
-
-	// For progress calculation, cap today at end date
-	if isPastEndDate {
-		todayTime = endDate
+	if err := g.validateRecurrence(startDate, endDate); err != nil {
+		return err
 	}
 
-	totalDays := endDate.Sub(startDate).Hours() / 24
-	daysPassed := todayTime.Sub(startDate).Hours() / 24
-	expectedProgress := (daysPassed / totalDays) * g.TargetNumber
-
-	// Determine status
-	switch {
-	case g.CurrentNumber >= g.TargetNumber:
-		g.Status = "done"
-	case isPastEndDate:
-		g.Status = "failed"
-	case g.CurrentNumber >= expectedProgress:
-		g.Status = "on_track"
-	case g.CurrentNumber >= expectedProgress*0.9:
-		g.Status = "at_risk"
-	default:
-		g.Status = "off_track"
+	if err := g.validateHabitsAndMilestones(startDate, endDate); err != nil {
+		return err
 	}
 
-	fmt.Printf("Current progress: %f/%f\n", g.CurrentNumber, g.TargetNumber)
-
-	// After calculating current_number, update it in the database along with the status
-	_, err = db.Exec(`
-		UPDATE goals 
-		SET current_number = ?, status = ?
-		WHERE id = ?`,
-		g.CurrentNumber, g.Status, g.ID)
-	if err != nil {
-		return fmt.Errorf("error updating goal progress: %v", err)
-	}
+	return g.validateKind(startDate, endDate)
+}
 
-	return nil
+// CalculateProgress recalculates the current progress and status of the goal and stores them
+func (g *Goal) CalculateProgress(db *sql.DB) error {
+	return g.recalculate(db, time.Now())
 }
 
 // GetGoalsByHabit returns all active goals for a given habit
@@ -379,12 +353,13 @@ func GetGoalsByHabit(db *sql.DB, habitID int) ([]*Goal, error) {
 	goals := []*Goal{}
 	rows, err := db.Query(`
 		SELECT id, user_id, habit_id, name, start_date, end_date, 
-			   target_number, position,
-			   created_at, updated_at
+			   target_number, COALESCE(current_number, 0), COALESCE(status, 'on_track'), progress,
+			   position, created_at, updated_at,
+			   kind, target_option, comparison, recurrence
 		FROM goals 
-		WHERE habit_id = ? 
+		WHERE (habit_id = ? OR id IN (SELECT goal_id FROM goal_habits WHERE habit_id = ?))
 		AND end_date >= DATE('now')
-		ORDER BY position ASC`, habitID)
+		ORDER BY position ASC`, habitID, habitID)
 	if err != nil {
 		return nil, err
 	}
@@ -392,22 +367,30 @@ func GetGoalsByHabit(db *sql.DB, habitID int) ([]*Goal, error) {
 
 	for rows.Next() {
 		goal := &Goal{}
+		var targetOption sql.NullString
 		err := rows.Scan(
 			&goal.ID, &goal.UserID, &goal.HabitID, &goal.Name,
 			&goal.StartDate, &goal.EndDate, &goal.TargetNumber,
+			&goal.CurrentNumber, &goal.Status, &goal.Progress,
 			&goal.Position, &goal.CreatedAt, &goal.UpdatedAt,
+			&goal.Kind, &targetOption, &goal.Comparison, &goal.Recurrence,
 		)
 		if err != nil {
 			return nil, err
 		}
-
-		// Calculate progress in memory
-		if err := goal.CalculateProgressInMemory(db); err != nil {
-			return nil, fmt.Errorf("error calculating progress for goal %d: %v", goal.ID, err)
+		if err := goal.setTargetOption(targetOption); err != nil {
+			return nil, err
 		}
-
 		goals = append(goals, goal)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := prepareGoals(db, goals); err != nil {
+		return nil, err
+	}
 	return goals, nil
 }
 
@@ -448,9 +431,16 @@ func GetGoalsByUserWithHabitInfo(db *sql.DB, userID int) ([]Goal, error) {
 			g.start_date,
 			g.end_date,
 			g.target_number,
+			COALESCE(g.current_number, 0),
+			COALESCE(g.status, 'on_track'),
+			g.progress,
 			g.position,
 			g.created_at,
 			g.updated_at,
+			g.kind,
+			g.target_option,
+			g.comparison,
+			g.recurrence,
 			h.name as habit_name,
 			h.emoji as habit_emoji
 		FROM goals g
@@ -464,9 +454,10 @@ func GetGoalsByUserWithHabitInfo(db *sql.DB, userID int) ([]Goal, error) {
 	}
 	defer rows.Close()
 
-	var goals []Goal
+	var goals []*Goal
 	for rows.Next() {
-		var g Goal
+		g := &Goal{}
+		var targetOption sql.NullString
 		err := rows.Scan(
 			&g.ID,
 			&g.UserID,
@@ -475,114 +466,59 @@ func GetGoalsByUserWithHabitInfo(db *sql.DB, userID int) ([]Goal, error) {
 			&g.StartDate,
 			&g.EndDate,
 			&g.TargetNumber,
+			&g.CurrentNumber,
+			&g.Status,
+			&g.Progress,
 			&g.Position,
 			&g.CreatedAt,
 			&g.UpdatedAt,
+			&g.Kind,
+			&targetOption,
+			&g.Comparison,
+			&g.Recurrence,
 			&g.HabitName,
 			&g.HabitEmoji,
 		)
 		if err != nil {
 			return nil, err
 		}
-
-		// Calculate progress in memory
-		if err := g.CalculateProgressInMemory(db); err != nil {
-			return nil, fmt.Errorf("error calculating progress for goal %d: %v", g.ID, err)
+		if err := g.setTargetOption(targetOption); err != nil {
+			return nil, err
 		}
-
 		goals = append(goals, g)
 	}
-	return goals, nil
-}
-
-// CalculateProgressInMemory calculates the current progress and status of the goal without writing to the database
-func (g *Goal) CalculateProgressInMemory(db *sql.DB) error {
-	// Get habit type
-	var habitType string
-	err := db.QueryRow("SELECT habit_type FROM habits WHERE id = ?", g.HabitID).Scan(&habitType)
-	if err != nil {
-		return fmt.Errorf("error getting habit type: %v", err)
-	}
-
-	// Calculate current progress based on habit type
-	var query string
-	switch habitType {
-	case "binary":
-		query = `
-			SELECT COUNT(DISTINCT date) 
-			FROM habit_logs 
-			WHERE habit_id = ? 
-			AND date BETWEEN ? AND ? 
-			AND status = 'done'`
-	case "numeric":
-		query = `
-			SELECT COALESCE(SUM(CAST(json_extract(value, '$.value') AS FLOAT)), 0)
-			FROM habit_logs 
-			WHERE habit_id = ? 
-			AND date BETWEEN ? AND ? 
-			AND status = 'done'`
-	case "set-reps":
-		query = `
-			SELECT COALESCE(
-				(
-					SELECT SUM(json_extract(s.value, '$.reps'))
-					FROM habit_logs hl,
-						 json_each(json_extract(hl.value, '$.sets')) AS s
-					WHERE hl.habit_id = ?
-					AND date(hl.date) BETWEEN date(?) AND date(?)
-					AND hl.status = 'done'
-				),
-				0
-			)`
-	default:
-		return fmt.Errorf("unsupported habit type: %s", habitType)
-	}
-
-	err = db.QueryRow(query, g.HabitID, g.StartDate, g.EndDate).Scan(&g.CurrentNumber)
-	if err != nil {
-		return fmt.Errorf("error calculating progress: %v", err)
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
+	rows.Close()
 
-	// Calculate expected progress
-	startDate, err := time.Parse("2006-01-02", g.StartDate)
-	if err != nil {
-		return fmt.Errorf("error parsing start date: %v", err)
+	if err := prepareGoals(db, goals); err != nil {
+		return nil, err
 	}
+	return dereferenceGoals(goals), nil
+}
 
-	endDate, err := time.Parse("2006-01-02", g.EndDate)
-	if err != nil {
-		return fmt.Errorf("error parsing end date: %v", err)
+// prepareGoals loads the habits and milestones of goals read from the database. Progress is read as stored;
+// only milestone statuses, which depend on the date, are set here.
+func prepareGoals(db *sql.DB, goals []*Goal) error {
+	if err := loadGoalDetails(db, goals); err != nil {
+		return err
 	}
-
-	// Get today's date in UTC to ensure consistent comparison
-	todayTime := time.Now().UTC().Truncate(24 * time.Hour)
-	endDate = endDate.UTC().Truncate(24 * time.Hour)
-
-	// Store whether today is past the end date
-	isPastEndDate := todayTime.After(endDate)
-
-	// For progress calculation, cap today at end date
-	if isPastEndDate {
-		todayTime = endDate
+	today := truncateToDay(time.Now().UTC())
+	for _, g := range goals {
+		g.setMilestoneStatuses(today)
 	}
+	return nil
+}
 
-	totalDays := endDate.Sub(startDate).Hours() / 24
-	daysPassed := todayTime.Sub(startDate).Hours() / 24
-	expectedProgress := (daysPassed / totalDays) * g.TargetNumber
-
-	// Determine status
-	switch {
-	case g.CurrentNumber >= g.TargetNumber:
-		g.Status = "done"
-	case isPastEndDate:
-		g.Status = "failed"
-	case g.CurrentNumber >= expectedProgress:
-		g.Status = "on_track"
-	case g.CurrentNumber >= expectedProgress*0.9:
-		g.Status = "at_risk"
-	default:
-		g.Status = "off_track"
+// dereferenceGoals copies goals into a slice of values, keeping nil for an empty result
+func dereferenceGoals(goals []*Goal) []Goal {
+	if goals == nil {
+		return nil
 	}
-
-	return nil
+	result := make([]Goal, len(goals))
+	for i, g := range goals {
+		result[i] = *g
+	}
+	return result
 }
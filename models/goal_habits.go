@@ -0,0 +1,313 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GoalHabit is a habit counted towards a goal, with the weight its values are multiplied by
+type GoalHabit struct {
+	HabitID int     `json:"habit_id"`
+	Weight  float64 `json:"weight"`
+	Name    string  `json:"name,omitempty"`
+	Emoji   string  `json:"emoji,omitempty"`
+}
+
+// GoalMilestone is an intermediate target of a cumulative goal
+type GoalMilestone struct {
+	ID           int     `json:"id"`
+	GoalID       int     `json:"goal_id"`
+	Name         string  `json:"name"`
+	TargetNumber float64 `json:"target_number"`
+	DueDate      string  `json:"due_date,omitempty"`
+	ReachedAt    *string `json:"reached_at,omitempty"`
+	Status       string  `json:"status"` // pending, reached or missed
+}
+
+// MilestoneReached is returned when a habit log completes a milestone, so the client can celebrate it
+type MilestoneReached struct {
+	GoalID     int     `json:"goal_id"`
+	GoalName   string  `json:"goal_name"`
+	Milestone  string  `json:"milestone"`
+	Target     float64 `json:"target_number"`
+	HabitEmoji string  `json:"habit_emoji"`
+}
+
+// goalHabits returns the habits counted by the goal; goals saved before multi-habit support count their own habit
+func (g *Goal) goalHabits() []GoalHabit {
+	if len(g.Habits) > 0 {
+		return g.Habits
+	}
+	return []GoalHabit{{HabitID: g.HabitID, Weight: 1}}
+}
+
+// validateHabitsAndMilestones checks the habit weights and milestones that don't need the database
+func (g *Goal) validateHabitsAndMilestones(startDate, endDate time.Time) error {
+	seen := make(map[int]bool)
+	for _, h := range g.Habits {
+		if h.Weight <= 0 {
+			return fmt.Errorf("habit weights must be positive")
+		}
+		if seen[h.HabitID] {
+			return fmt.Errorf("a habit can only be added to a goal once")
+		}
+		seen[h.HabitID] = true
+	}
+	if len(g.Habits) > 1 && g.kindOrDefault() != GoalKindTotal {
+		return fmt.Errorf("only total goals can combine several habits")
+	}
+
+	if len(g.Milestones) == 0 {
+		return nil
+	}
+	if !g.isCumulative() {
+		return fmt.Errorf("milestones are only available for total and option count goals")
+	}
+	if g.Recurrence != "" {
+		return fmt.Errorf("milestones are not available for recurring goals")
+	}
+	for _, m := range g.Milestones {
+		if strings.TrimSpace(m.Name) == "" {
+			return fmt.Errorf("milestone name is required")
+		}
+		if m.TargetNumber <= 0 || m.TargetNumber > g.TargetNumber {
+			return fmt.Errorf("milestone %q must have a target between 0 and the goal target", m.Name)
+		}
+		if m.DueDate == "" {
+			continue
+		}
+		due, err := time.Parse("2006-01-02", m.DueDate)
+		if err != nil {
+			return fmt.Errorf("invalid due date for milestone %q: %v", m.Name, err)
+		}
+		if due.Before(startDate) || due.After(endDate) {
+			return fmt.Errorf("milestone %q must be due within the goal period", m.Name)
+		}
+	}
+	return nil
+}
+
+// insertHabitsAndMilestones stores the goal's habits and milestones after the goal is created
+func (g *Goal) insertHabitsAndMilestones(tx *sql.Tx) error {
+	for _, h := range g.goalHabits() {
+		_, err := tx.Exec("INSERT INTO goal_habits (goal_id, habit_id, weight) VALUES (?, ?, ?)",
+			g.ID, h.HabitID, h.Weight)
+		if err != nil {
+			return fmt.Errorf("error adding habit to goal: %v", err)
+		}
+	}
+	for i := range g.Milestones {
+		if err := g.Milestones[i].insert(tx, g.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *GoalMilestone) insert(tx *sql.Tx, goalID int) error {
+	var dueDate sql.NullString
+	if m.DueDate != "" {
+		dueDate = sql.NullString{String: m.DueDate, Valid: true}
+	}
+	err := tx.QueryRow(`
+		INSERT INTO goal_milestones (goal_id, name, target_number, due_date)
+		VALUES (?, ?, ?, ?)
+		RETURNING id`,
+		goalID, m.Name, m.TargetNumber, dueDate,
+	).Scan(&m.ID)
+	if err != nil {
+		return fmt.Errorf("error adding milestone: %v", err)
+	}
+	m.GoalID = goalID
+	return nil
+}
+
+// SaveMilestones replaces the goal's milestones with g.Milestones. Existing milestones are matched by ID
+// and keep the date they were reached.
+func (g *Goal) SaveMilestones(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	keep := []interface{}{g.ID}
+	placeholders := []string{}
+	for _, m := range g.Milestones {
+		if m.ID != 0 {
+			keep = append(keep, m.ID)
+			placeholders = append(placeholders, "?")
+		}
+	}
+	query := "DELETE FROM goal_milestones WHERE goal_id = ?"
+	if len(placeholders) > 0 {
+		query += " AND id NOT IN (" + strings.Join(placeholders, ",") + ")"
+	}
+	if _, err := tx.Exec(query, keep...); err != nil {
+		return fmt.Errorf("error removing milestones: %v", err)
+	}
+
+	for i := range g.Milestones {
+		m := &g.Milestones[i]
+		if m.ID == 0 {
+			if err := m.insert(tx, g.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		var dueDate sql.NullString
+		if m.DueDate != "" {
+			dueDate = sql.NullString{String: m.DueDate, Valid: true}
+		}
+		_, err := tx.Exec(`
+			UPDATE goal_milestones
+			SET name = ?, target_number = ?, due_date = ?
+			WHERE id = ? AND goal_id = ?`,
+			m.Name, m.TargetNumber, dueDate, m.ID, g.ID,
+		)
+		if err != nil {
+			return fmt.Errorf("error updating milestone: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// loadGoalDetails fills in the habits and milestones of each goal, and its habit names and emojis,
+// joined for goals combining several habits
+func loadGoalDetails(db *sql.DB, goals []*Goal) error {
+	if len(goals) == 0 {
+		return nil
+	}
+	byID := make(map[int]*Goal, len(goals))
+	args := make([]interface{}, 0, len(goals))
+	placeholders := make([]string, 0, len(goals))
+	for _, g := range goals {
+		byID[g.ID] = g
+		g.Habits = []GoalHabit{}
+		g.Milestones = []GoalMilestone{}
+		args = append(args, g.ID)
+		placeholders = append(placeholders, "?")
+	}
+	in := "(" + strings.Join(placeholders, ",") + ")"
+
+	rows, err := db.Query(`
+		SELECT gh.goal_id, gh.habit_id, gh.weight, h.name, h.emoji
+		FROM goal_habits gh
+		JOIN habits h ON h.id = gh.habit_id
+		WHERE gh.goal_id IN `+in+`
+		ORDER BY gh.goal_id, gh.rowid`, args...)
+	if err != nil {
+		return fmt.Errorf("error getting goal habits: %v", err)
+	}
+	for rows.Next() {
+		var goalID int
+		var h GoalHabit
+		if err := rows.Scan(&goalID, &h.HabitID, &h.Weight, &h.Name, &h.Emoji); err != nil {
+			rows.Close()
+			return err
+		}
+		byID[goalID].Habits = append(byID[goalID].Habits, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query(`
+		SELECT id, goal_id, name, target_number, COALESCE(due_date, ''), reached_at
+		FROM goal_milestones
+		WHERE goal_id IN `+in+`
+		ORDER BY goal_id, target_number`, args...)
+	if err != nil {
+		return fmt.Errorf("error getting goal milestones: %v", err)
+	}
+	for rows.Next() {
+		var m GoalMilestone
+		var reachedAt sql.NullString
+		if err := rows.Scan(&m.ID, &m.GoalID, &m.Name, &m.TargetNumber, &m.DueDate, &reachedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		if reachedAt.Valid {
+			m.ReachedAt = &reachedAt.String
+		}
+		byID[m.GoalID].Milestones = append(byID[m.GoalID].Milestones, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, g := range goals {
+		if len(g.Habits) == 0 {
+			continue
+		}
+		names := make([]string, len(g.Habits))
+		emojis := ""
+		for i, h := range g.Habits {
+			names[i] = h.Name
+			emojis += h.Emoji
+		}
+		g.HabitName = strings.Join(names, " + ")
+		g.HabitEmoji = emojis
+	}
+	return nil
+}
+
+// setMilestoneStatuses sets the status of each milestone as of today
+func (g *Goal) setMilestoneStatuses(today time.Time) {
+	for i := range g.Milestones {
+		m := &g.Milestones[i]
+		switch {
+		case m.ReachedAt != nil || g.CurrentNumber >= m.TargetNumber:
+			m.Status = "reached"
+		case m.DueDate != "" && today.Format("2006-01-02") > m.DueDate:
+			m.Status = "missed"
+		default:
+			m.Status = "pending"
+		}
+	}
+}
+
+// CheckGoalMilestones marks milestones reached by the current progress of the habit's active goals and
+// returns the ones reached for the first time, queueing a notification for each
+func CheckGoalMilestones(db *sql.DB, habitID int, now time.Time) ([]MilestoneReached, error) {
+	goals, err := GetGoalsByHabit(db, habitID)
+	if err != nil {
+		return nil, err
+	}
+
+	reached := []MilestoneReached{}
+	for _, g := range goals {
+		for i := range g.Milestones {
+			m := &g.Milestones[i]
+			if m.ReachedAt != nil || g.CurrentNumber < m.TargetNumber {
+				continue
+			}
+			reachedAt := now.UTC().Format("2006-01-02 15:04:05")
+			result, err := db.Exec("UPDATE goal_milestones SET reached_at = ? WHERE id = ? AND reached_at IS NULL",
+				reachedAt, m.ID)
+			if err != nil {
+				return nil, fmt.Errorf("error marking milestone reached: %v", err)
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				continue
+			}
+			m.ReachedAt = &reachedAt
+			if err := g.queueMilestoneNotification(db, m.ID); err != nil {
+				return nil, err
+			}
+			reached = append(reached, MilestoneReached{
+				GoalID:     g.ID,
+				GoalName:   g.Name,
+				Milestone:  m.Name,
+				Target:     m.TargetNumber,
+				HabitEmoji: g.HabitEmoji,
+			})
+		}
+	}
+	return reached, nil
+}
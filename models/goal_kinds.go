@@ -0,0 +1,471 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Goal kinds
+const (
+	GoalKindTotal          = "total"           // sum of the habit's values between the dates
+	GoalKindOptionCount    = "option_count"    // number of days a specific option was logged
+	GoalKindStreak         = "streak"          // reach a streak of at least N days within the period
+	GoalKindCompletionRate = "completion_rate" // percentage of days done over the period
+	GoalKindAverage        = "average"         // average numeric value at least or at most a threshold
+)
+
+// Comparisons for average goals
+const (
+	GoalAtLeast = "at_least"
+	GoalAtMost  = "at_most"
+)
+
+// kindOrDefault returns the goal kind, treating goals created before kinds existed as totals
+func (g *Goal) kindOrDefault() string {
+	if g.Kind == "" {
+		return GoalKindTotal
+	}
+	return g.Kind
+}
+
+// targetOptionValue returns the target option as a JSON string for storage
+func (g *Goal) targetOptionValue() (sql.NullString, error) {
+	if g.TargetOption == nil {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(g.TargetOption)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+// setTargetOption decodes a stored target option
+func (g *Goal) setTargetOption(value sql.NullString) error {
+	g.TargetOption = nil
+	if !value.Valid || value.String == "" {
+		return nil
+	}
+	var option HabitOption
+	if err := json.Unmarshal([]byte(value.String), &option); err != nil {
+		return fmt.Errorf("invalid target option: %v", err)
+	}
+	g.TargetOption = &option
+	return nil
+}
+
+// validateKind checks the kind-specific fields of a goal
+func (g *Goal) validateKind(startDate, endDate time.Time) error {
+	switch g.kindOrDefault() {
+	case GoalKindTotal:
+	case GoalKindOptionCount:
+		if g.TargetOption == nil || (g.TargetOption.Emoji == "" && g.TargetOption.Label == "") {
+			return fmt.Errorf("option count goals need a target option")
+		}
+	case GoalKindStreak:
+		if g.TargetNumber != math.Trunc(g.TargetNumber) {
+			return fmt.Errorf("streak length must be a whole number of days")
+		}
+		periodDays := endDate.Sub(startDate).Hours()/24 + 1
+		if g.TargetNumber > periodDays {
+			return fmt.Errorf("streak length cannot be longer than the goal period (%d days)", int(periodDays))
+		}
+	case GoalKindCompletionRate:
+		if g.TargetNumber > 100 {
+			return fmt.Errorf("completion rate target must be at most 100%%")
+		}
+	case GoalKindAverage:
+		if g.Comparison != GoalAtLeast && g.Comparison != GoalAtMost {
+			return fmt.Errorf("average goals need a comparison of at_least or at_most")
+		}
+	default:
+		return fmt.Errorf("invalid goal kind: %s", g.Kind)
+	}
+
+	if g.kindOrDefault() != GoalKindAverage && g.Comparison != "" {
+		return fmt.Errorf("comparison is only used by average goals")
+	}
+	if g.kindOrDefault() != GoalKindOptionCount && g.TargetOption != nil {
+		return fmt.Errorf("target option is only used by option count goals")
+	}
+	return nil
+}
+
+// validateKindForHabit checks that the goal kind can be tracked for the habit type
+func (g *Goal) validateKindForHabit(habitType HabitType, habitOptions sql.NullString) error {
+	switch g.kindOrDefault() {
+	case GoalKindTotal:
+		if habitType == OptionSelectHabit {
+			return fmt.Errorf("option-select habits cannot have total goals, use an option count goal")
+		}
+	case GoalKindOptionCount:
+		if habitType != OptionSelectHabit {
+			return fmt.Errorf("option count goals are only available for option-select habits")
+		}
+		var options []HabitOption
+		if habitOptions.Valid && habitOptions.String != "" {
+			if err := json.Unmarshal([]byte(habitOptions.String), &options); err != nil {
+				return fmt.Errorf("invalid habit options format: %v", err)
+			}
+		}
+		for _, option := range options {
+			if option.Emoji == g.TargetOption.Emoji && option.Label == g.TargetOption.Label {
+				return nil
+			}
+		}
+		return fmt.Errorf("target option is not one of the habit's options")
+	case GoalKindAverage:
+		if habitType != NumericHabit {
+			return fmt.Errorf("average goals are only available for numeric habits")
+		}
+	}
+	return nil
+}
+
+// computeProgress sets CurrentNumber, Progress and Status for the goal as of now, on the day it is in the
+// owner's timezone
+func (g *Goal) computeProgress(db *sql.DB, now time.Time) error {
+	startDate, err := time.Parse("2006-01-02", g.StartDate)
+	if err != nil {
+		return fmt.Errorf("error parsing start date: %v", err)
+	}
+	endDate, err := time.Parse("2006-01-02", g.EndDate)
+	if err != nil {
+		return fmt.Errorf("error parsing end date: %v", err)
+	}
+	// Goals without an owner go by UTC
+	var tz string
+	err = db.QueryRow("SELECT timezone FROM users WHERE id = ?", g.UserID).Scan(&tz)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error getting user timezone: %v", err)
+	}
+	today := localDay(tz, now)
+
+	switch g.kindOrDefault() {
+	case GoalKindTotal:
+		err = g.computeTotalProgress(db, startDate, endDate, today)
+	case GoalKindOptionCount:
+		err = g.computeOptionCountProgress(db, startDate, endDate, today)
+	case GoalKindStreak:
+		err = g.computeStreakProgress(db, startDate, endDate, today)
+	case GoalKindCompletionRate:
+		err = g.computeCompletionRateProgress(db, startDate, endDate, today)
+	case GoalKindAverage:
+		err = g.computeAverageProgress(db, endDate, today)
+	default:
+		err = fmt.Errorf("unsupported goal kind: %s", g.Kind)
+	}
+	if err != nil {
+		return err
+	}
+	g.setMilestoneStatuses(today)
+	return nil
+}
+
+// computeTotalProgress sums the weighted values of the goal's habits and compares them to a linear expected pace
+func (g *Goal) computeTotalProgress(db *sql.DB, startDate, endDate, today time.Time) error {
+	total := 0.0
+	for _, h := range g.goalHabits() {
+		value, err := g.habitTotal(db, h.HabitID)
+		if err != nil {
+			return err
+		}
+		total += value * h.Weight
+	}
+	g.CurrentNumber = roundTo2(total)
+	g.setLinearStatus(startDate, endDate, today)
+	return nil
+}
+
+// habitTotal sums one habit's values over the goal period: done days, numeric values or set-reps reps
+func (g *Goal) habitTotal(db *sql.DB, habitID int) (float64, error) {
+	var habitType HabitType
+	err := db.QueryRow("SELECT habit_type FROM habits WHERE id = ?", habitID).Scan(&habitType)
+	if err != nil {
+		return 0, fmt.Errorf("error getting habit type: %v", err)
+	}
+
+	var query string
+	switch habitType {
+	case BinaryHabit:
+		query = `
+			SELECT COUNT(DISTINCT date(date))
+			FROM habit_logs
+			WHERE habit_id = ?
+			AND date(date) BETWEEN date(?) AND date(?)
+			AND status = 'done'`
+	case NumericHabit:
+		query = `
+			SELECT COALESCE(SUM(CAST(json_extract(value, '$.value') AS FLOAT)), 0)
+			FROM habit_logs
+			WHERE habit_id = ?
+			AND date(date) BETWEEN date(?) AND date(?)
+			AND status = 'done'`
+	case SetRepsHabit:
+		query = `
+			SELECT COALESCE(
+				(
+					SELECT SUM(json_extract(s.value, '$.reps'))
+					FROM habit_logs hl,
+						 json_each(json_extract(hl.value, '$.sets')) AS s
+					WHERE hl.habit_id = ?
+					AND date(hl.date) BETWEEN date(?) AND date(?)
+					AND hl.status = 'done'
+				),
+				0
+			)`
+	default:
+		return 0, fmt.Errorf("unsupported habit type: %s", habitType)
+	}
+
+	var total float64
+	if err := db.QueryRow(query, habitID, g.StartDate, g.EndDate).Scan(&total); err != nil {
+		return 0, fmt.Errorf("error calculating progress: %v", err)
+	}
+	return total, nil
+}
+
+// computeOptionCountProgress counts the days the target option was logged
+func (g *Goal) computeOptionCountProgress(db *sql.DB, startDate, endDate, today time.Time) error {
+	if g.TargetOption == nil {
+		return fmt.Errorf("option count goal has no target option")
+	}
+	err := db.QueryRow(`
+		SELECT COUNT(DISTINCT date(date))
+		FROM habit_logs
+		WHERE habit_id = ?
+		AND date(date) BETWEEN date(?) AND date(?)
+		AND status = 'done'
+		AND json_extract(value, '$.emoji') = ?
+		AND json_extract(value, '$.label') = ?`,
+		g.HabitID, g.StartDate, g.EndDate, g.TargetOption.Emoji, g.TargetOption.Label,
+	).Scan(&g.CurrentNumber)
+	if err != nil {
+		return fmt.Errorf("error calculating progress: %v", err)
+	}
+	g.setLinearStatus(startDate, endDate, today)
+	return nil
+}
+
+// setLinearStatus sets the status of a cumulative goal against a linear expected pace
+func (g *Goal) setLinearStatus(startDate, endDate, today time.Time) {
+	isPastEndDate := today.After(endDate)
+	expectedProgress := linearExpected(g.TargetNumber, startDate, endDate, today)
+
+	switch {
+	case g.CurrentNumber >= g.TargetNumber:
+		g.Status = "done"
+	case isPastEndDate:
+		g.Status = "failed"
+	case g.CurrentNumber >= expectedProgress:
+		g.Status = "on_track"
+	case g.CurrentNumber >= expectedProgress*0.9:
+		g.Status = "at_risk"
+	default:
+		g.Status = "off_track"
+	}
+	g.Progress = progressPercent(g.CurrentNumber, g.TargetNumber)
+}
+
+// goalDoneDays returns the distinct days the habit was done within the goal period, in order
+func (g *Goal) goalDoneDays(db *sql.DB) ([]time.Time, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT date(date)
+		FROM habit_logs
+		WHERE habit_id = ?
+		AND date(date) BETWEEN date(?) AND date(?)
+		AND status = 'done'
+		ORDER BY 1`,
+		g.HabitID, g.StartDate, g.EndDate,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting done days: %v", err)
+	}
+	defer rows.Close()
+
+	days := []time.Time{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		day, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing log date: %v", err)
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
+
+// computeStreakProgress tracks the longest streak within the period and whether the target is still reachable
+func (g *Goal) computeStreakProgress(db *sql.DB, startDate, endDate, today time.Time) error {
+	days, err := g.goalDoneDays(db)
+	if err != nil {
+		return err
+	}
+
+	longest, run := 0, 0
+	var prev time.Time
+	for _, day := range days {
+		if !prev.IsZero() && day.Sub(prev) == 24*time.Hour {
+			run++
+		} else {
+			run = 1
+		}
+		if run > longest {
+			longest = run
+		}
+		prev = day
+	}
+
+	// The current run is still alive if the last done day is today or yesterday
+	currentRun := 0
+	if len(days) > 0 && !prev.Before(today.AddDate(0, 0, -1)) {
+		currentRun = run
+	}
+
+	g.CurrentNumber = float64(longest)
+	g.Progress = progressPercent(g.CurrentNumber, g.TargetNumber)
+
+	// Days still available to extend the current run, counting today unless it is already done
+	remaining := 0.0
+	if !today.After(endDate) {
+		from := maxTime(today, startDate)
+		if currentRun > 0 && prev.Equal(today) {
+			from = from.AddDate(0, 0, 1)
+		}
+		remaining = endDate.Sub(from).Hours()/24 + 1
+	}
+	slack := float64(currentRun) + remaining - g.TargetNumber
+
+	switch {
+	case g.CurrentNumber >= g.TargetNumber:
+		g.Status = "done"
+	case today.After(endDate):
+		g.Status = "failed"
+	case slack < 0:
+		g.Status = "off_track"
+	case slack <= g.TargetNumber*0.1:
+		g.Status = "at_risk"
+	default:
+		g.Status = "on_track"
+	}
+	return nil
+}
+
+// computeCompletionRateProgress tracks the share of elapsed days the habit was done
+func (g *Goal) computeCompletionRateProgress(db *sql.DB, startDate, endDate, today time.Time) error {
+	days, err := g.goalDoneDays(db)
+	if err != nil {
+		return err
+	}
+
+	// Elapsed days run through yesterday; today only counts once it is done
+	done, doneToday := 0, false
+	for _, day := range days {
+		if day.After(today) {
+			continue
+		}
+		done++
+		if day.Equal(today) {
+			doneToday = true
+		}
+	}
+	lastElapsed := minTime(today.AddDate(0, 0, -1), endDate)
+	if doneToday {
+		lastElapsed = minTime(today, endDate)
+	}
+	elapsed := 0
+	if !lastElapsed.Before(startDate) {
+		elapsed = int(lastElapsed.Sub(startDate).Hours()/24) + 1
+	}
+	totalDays := int(endDate.Sub(startDate).Hours()/24) + 1
+
+	g.CurrentNumber = 0
+	if elapsed > 0 {
+		g.CurrentNumber = roundTo2(float64(done) / float64(elapsed) * 100)
+	}
+	g.Progress = progressPercent(g.CurrentNumber, g.TargetNumber)
+
+	guaranteed := float64(done)/float64(totalDays)*100 >= g.TargetNumber
+	bestPossible := float64(done+totalDays-elapsed) / float64(totalDays) * 100
+
+	switch {
+	case guaranteed:
+		g.Status = "done"
+	case today.After(endDate):
+		g.Status = "failed"
+	case bestPossible < g.TargetNumber:
+		g.Status = "off_track"
+	case elapsed == 0 || g.CurrentNumber >= g.TargetNumber:
+		g.Status = "on_track"
+	case g.CurrentNumber >= g.TargetNumber*0.9:
+		g.Status = "at_risk"
+	default:
+		g.Status = "off_track"
+	}
+	return nil
+}
+
+// computeAverageProgress compares the average logged value with the threshold
+func (g *Goal) computeAverageProgress(db *sql.DB, endDate, today time.Time) error {
+	var average sql.NullFloat64
+	var count int
+	err := db.QueryRow(`
+		SELECT AVG(CAST(json_extract(value, '$.value') AS FLOAT)), COUNT(*)
+		FROM habit_logs
+		WHERE habit_id = ?
+		AND date(date) BETWEEN date(?) AND date(?)
+		AND status = 'done'`,
+		g.HabitID, g.StartDate, g.EndDate,
+	).Scan(&average, &count)
+	if err != nil {
+		return fmt.Errorf("error calculating progress: %v", err)
+	}
+
+	g.CurrentNumber = 0
+	if average.Valid {
+		g.CurrentNumber = roundTo2(average.Float64)
+	}
+
+	var met, near bool
+	if g.Comparison == GoalAtMost {
+		met = g.CurrentNumber <= g.TargetNumber
+		near = g.CurrentNumber <= g.TargetNumber*1.1
+		g.Progress = 100
+		if g.CurrentNumber > g.TargetNumber {
+			g.Progress = roundTo2(g.TargetNumber / g.CurrentNumber * 100)
+		}
+	} else {
+		met = g.CurrentNumber >= g.TargetNumber
+		near = g.CurrentNumber >= g.TargetNumber*0.9
+		g.Progress = progressPercent(g.CurrentNumber, g.TargetNumber)
+	}
+
+	// An average can still change until the period ends, so it is only done afterwards
+	switch {
+	case today.After(endDate) && count > 0 && met:
+		g.Status = "done"
+	case today.After(endDate):
+		g.Status = "failed"
+	case count == 0 || met:
+		g.Status = "on_track"
+	case near:
+		g.Status = "at_risk"
+	default:
+		g.Status = "off_track"
+	}
+	return nil
+}
+
+// progressPercent returns current as a percentage of target, capped at 100
+func progressPercent(current, target float64) float64 {
+	if target <= 0 {
+		return 0
+	}
+	return roundTo2(math.Min(current/target*100, 100))
+}
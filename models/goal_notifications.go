@@ -0,0 +1,348 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"mad/models/email"
+)
+
+// Goal notification kinds; each is sent at most once per goal period
+const (
+	GoalNotificationBehind    = "behind"    // status became at_risk or off_track
+	GoalNotificationDeadline  = "deadline"  // the end date is near and the goal isn't finished
+	GoalNotificationDone      = "done"      // status became done
+	GoalNotificationMilestone = "milestone" // a milestone was reached; sent once per milestone
+)
+
+var goalNotificationTemplates = map[string]email.EmailTemplate{
+	GoalNotificationBehind:    email.GoalBehindEmail,
+	GoalNotificationDeadline:  email.GoalDeadlineEmail,
+	GoalNotificationDone:      email.GoalCompletedEmail,
+	GoalNotificationMilestone: email.GoalMilestoneEmail,
+}
+
+// queueStatusNotification queues an email when the goal falls behind or is completed. Moving between
+// at_risk and off_track doesn't count as falling behind again.
+func (g *Goal) queueStatusNotification(db *sql.DB, previous string) error {
+	if previous == "" || previous == g.Status {
+		return nil
+	}
+
+	var kind string
+	switch g.Status {
+	case "at_risk", "off_track":
+		if previous == "at_risk" || previous == "off_track" {
+			return nil
+		}
+		kind = GoalNotificationBehind
+	case "done":
+		kind = GoalNotificationDone
+	default:
+		return nil
+	}
+
+	_, err := db.Exec(`
+		INSERT OR IGNORE INTO goal_notifications (goal_id, kind, period_end)
+		VALUES (?, ?, ?)`,
+		g.ID, kind, g.EndDate,
+	)
+	if err != nil {
+		return fmt.Errorf("error queueing goal notification: %v", err)
+	}
+	return nil
+}
+
+// queueMilestoneNotification queues an email for a milestone the goal just reached
+func (g *Goal) queueMilestoneNotification(db *sql.DB, milestoneID int) error {
+	_, err := db.Exec(`
+		INSERT OR IGNORE INTO goal_notifications (goal_id, kind, period_end, milestone_id)
+		VALUES (?, ?, ?, ?)`,
+		g.ID, GoalNotificationMilestone, g.EndDate, milestoneID,
+	)
+	if err != nil {
+		return fmt.Errorf("error queueing milestone notification: %v", err)
+	}
+	return nil
+}
+
+// QueueGoalDeadlineNotifications queues a deadline email for every unfinished goal ending within its owner's
+// chosen number of days, counted from the day it is in their timezone. It returns the number of emails queued.
+func QueueGoalDeadlineNotifications(db *sql.DB, now time.Time) (int, error) {
+	rows, err := db.Query(`
+		SELECT g.id, g.end_date, u.timezone, u.goal_deadline_days
+		FROM goals g
+		JOIN users u ON u.id = g.user_id
+		WHERE COALESCE(g.status, '') NOT IN ('done', 'failed')
+		AND date(g.end_date) >= date(?, '-1 day')`,
+		truncateToDay(now.UTC()).Format("2006-01-02"),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error getting goals near their deadline: %v", err)
+	}
+	type deadline struct {
+		goalID  int
+		endDate string
+	}
+	var due []deadline
+	for rows.Next() {
+		var d deadline
+		var tz string
+		var days int
+		if err := rows.Scan(&d.goalID, &d.endDate, &tz, &days); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		endDate, err := time.Parse("2006-01-02", d.endDate)
+		if err != nil {
+			continue
+		}
+		today := localDay(tz, now)
+		if !endDate.Before(today) && !endDate.After(today.AddDate(0, 0, days)) {
+			due = append(due, d)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, d := range due {
+		result, err := db.Exec(`
+			INSERT OR IGNORE INTO goal_notifications (goal_id, kind, period_end)
+			VALUES (?, ?, ?)`,
+			d.goalID, GoalNotificationDeadline, d.endDate,
+		)
+		if err != nil {
+			return queued, fmt.Errorf("error queueing goal deadline notifications: %v", err)
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+// pendingGoalNotification is a queued goal email with its recipient
+type pendingGoalNotification struct {
+	id            int
+	goalID        int
+	kind          string
+	periodEnd     string
+	milestoneID   int
+	userID        int
+	email         string
+	firstName     string
+	timezone      string
+	emailVerified bool // goal updates aren't emailed to unverified addresses
+}
+
+// SendGoalNotifications sends the queued goal notifications by email and webhook, as each user prefers.
+// Notifications for users who turned goal updates off or can't be reached, or that no longer match the goal, are
+// skipped; those in the user's quiet hours and failed sends stay queued for the next run. It returns the number
+// sent.
+func SendGoalNotifications(db *sql.DB, emailSvc email.EmailService, now time.Time) (int, error) {
+	rows, err := db.Query(`
+		SELECT n.id, n.goal_id, n.kind, n.period_end, n.milestone_id, u.id, u.email, u.first_name, u.timezone,
+			u.email_verified
+		FROM goal_notifications n
+		JOIN goals g ON g.id = n.goal_id
+		JOIN users u ON u.id = g.user_id
+		WHERE n.sent_at IS NULL
+		ORDER BY n.id`)
+	if err != nil {
+		return 0, fmt.Errorf("error getting queued goal notifications: %v", err)
+	}
+
+	var pending []pendingGoalNotification
+	for rows.Next() {
+		var n pendingGoalNotification
+		if err := rows.Scan(&n.id, &n.goalID, &n.kind, &n.periodEnd, &n.milestoneID, &n.userID, &n.email, &n.firstName, &n.timezone,
+			&n.emailVerified); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, n := range pending {
+		goal, err := GetGoal(db, n.goalID)
+		if err != nil {
+			return sent, fmt.Errorf("error getting goal %d: %v", n.goalID, err)
+		}
+
+		prefs, err := GetNotificationPreferences(db, n.userID)
+		if err != nil {
+			return sent, err
+		}
+		emailOn := prefs.Allows(NotificationGoal, ChannelEmail) && n.emailVerified
+		webhookOn := prefs.Allows(NotificationGoal, ChannelWebhook)
+
+		if (!emailOn && !webhookOn) || !goal.stillWarrants(n.kind, n.periodEnd, n.milestoneID) {
+			if err := markGoalNotification(db, n.id, true); err != nil {
+				return sent, err
+			}
+			continue
+		}
+		if prefs.InQuietHours(now) {
+			continue
+		}
+
+		info := goal.emailInfo(localDay(n.timezone, now))
+		if m := goal.milestone(n.milestoneID); m != nil {
+			info.Milestone, info.MilestoneTarget = m.Name, m.TargetNumber
+		}
+		if emailOn {
+			data := email.GoalEmailData{
+				FirstName:       n.firstName,
+				Goal:            info,
+				AppName:         "The Habits Company",
+				PreferencesLink: PreferencesLink(db, n.userID, n.email),
+			}
+			if err := emailSvc.SendTypedEmail(n.email, goalNotificationTemplates[n.kind], data); err != nil {
+				log.Printf("Error sending %s goal email to %s: %v", n.kind, n.email, err)
+				continue
+			}
+		}
+		if webhookOn {
+			if err := postWebhook(prefs.WebhookURL, goalWebhook(n.kind, info, now)); err != nil {
+				log.Printf("Error posting %s goal webhook for user %d: %v", n.kind, n.userID, err)
+				if !emailOn {
+					continue
+				}
+			}
+		}
+		if err := markGoalNotification(db, n.id, false); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// markGoalNotification records that a queued goal email was sent or skipped
+func markGoalNotification(db *sql.DB, id int, skipped bool) error {
+	_, err := db.Exec(`
+		UPDATE goal_notifications
+		SET sent_at = CURRENT_TIMESTAMP, skipped = ?
+		WHERE id = ?`,
+		skipped, id,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating goal notification: %v", err)
+	}
+	return nil
+}
+
+// goalWebhook builds the webhook notification for a goal notification
+func goalWebhook(kind string, info email.GoalInfo, now time.Time) WebhookNotification {
+	name := strings.TrimSpace(info.HabitEmoji + " " + info.Name)
+	message := fmt.Sprintf("%s is %s", name, info.Status)
+	switch kind {
+	case GoalNotificationDeadline:
+		message = fmt.Sprintf("%s ends on %s with %d days left", name, info.EndDate, info.DaysLeft)
+	case GoalNotificationDone:
+		message = fmt.Sprintf("%s is complete 🎉", name)
+	case GoalNotificationMilestone:
+		message = fmt.Sprintf("%s reached the %s milestone 🏁", name, info.Milestone)
+	}
+	return WebhookNotification{
+		Type:    NotificationGoal,
+		Message: message,
+		Link:    info.Link,
+		Data: map[string]interface{}{
+			"kind":           kind,
+			"goal":           info.Name,
+			"status":         info.Status,
+			"current_number": info.CurrentNumber,
+			"target_number":  info.TargetNumber,
+			"progress":       info.Progress,
+			"milestone":      info.Milestone,
+		},
+		SentAt: now.UTC(),
+	}
+}
+
+// stillWarrants reports whether a notification queued for the period ending periodEnd still matches the goal,
+// which may have recovered, been finished or rolled over since, or lost the milestone it was queued for
+func (g *Goal) stillWarrants(kind, periodEnd string, milestoneID int) bool {
+	if g.EndDate != periodEnd {
+		return false
+	}
+	switch kind {
+	case GoalNotificationBehind:
+		return g.Status == "at_risk" || g.Status == "off_track"
+	case GoalNotificationDeadline:
+		return g.Status != "done" && g.Status != "failed"
+	case GoalNotificationDone:
+		return g.Status == "done"
+	case GoalNotificationMilestone:
+		m := g.milestone(milestoneID)
+		return m != nil && m.ReachedAt != nil
+	}
+	return false
+}
+
+// milestone returns the goal's milestone with the ID, or nil
+func (g *Goal) milestone(id int) *GoalMilestone {
+	for i := range g.Milestones {
+		if g.Milestones[i].ID == id {
+			return &g.Milestones[i]
+		}
+	}
+	return nil
+}
+
+// RequiredDailyRate returns how much a cumulative goal needs per remaining day, today included, to reach its
+// target on time. It is 0 for other kinds and for goals already reached or past their end date.
+func (g *Goal) RequiredDailyRate(today time.Time) float64 {
+	if !g.isCumulative() {
+		return 0
+	}
+	daysLeft := g.daysLeft(today)
+	remaining := g.TargetNumber - g.CurrentNumber
+	if daysLeft <= 0 || remaining <= 0 {
+		return 0
+	}
+	return roundTo2(remaining / float64(daysLeft))
+}
+
+// daysLeft returns the number of days left in the goal period, today included
+func (g *Goal) daysLeft(today time.Time) int {
+	endDate, err := time.Parse("2006-01-02", g.EndDate)
+	if err != nil || today.After(endDate) {
+		return 0
+	}
+	return int(math.Round(endDate.Sub(today).Hours()/24)) + 1
+}
+
+// emailInfo returns the goal's progress for display in emails
+func (g *Goal) emailInfo(today time.Time) email.GoalInfo {
+	endDate := g.EndDate
+	if t, err := time.Parse("2006-01-02", g.EndDate); err == nil {
+		endDate = t.Format("2 Jan 2006")
+	}
+	return email.GoalInfo{
+		Name:              g.Name,
+		HabitName:         g.HabitName,
+		HabitEmoji:        g.HabitEmoji,
+		Status:            strings.ReplaceAll(g.Status, "_", " "),
+		CurrentNumber:     g.CurrentNumber,
+		TargetNumber:      g.TargetNumber,
+		Progress:          g.Progress,
+		EndDate:           endDate,
+		DaysLeft:          g.daysLeft(today),
+		RequiredDailyRate: g.RequiredDailyRate(today),
+		Link:              "https://habits.co/goals",
+	}
+}
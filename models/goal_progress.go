@@ -0,0 +1,130 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Goal progress is stored on the goal rather than computed on every read. It is recalculated when a habit
+// log of one of the goal's habits is written, when the goal itself changes, and nightly for status changes
+// that only depend on the date, such as a goal failing once its end date has passed.
+
+// recalculate computes the goal's progress as of now, stores it and queues an email if the status change
+// calls for one
+func (g *Goal) recalculate(db *sql.DB, now time.Time) error {
+	previous := g.Status
+	if err := g.storeProgress(db, now); err != nil {
+		return err
+	}
+	return g.queueStatusNotification(db, previous)
+}
+
+// storeProgress computes the goal's progress as of now and stores it
+func (g *Goal) storeProgress(db *sql.DB, now time.Time) error {
+	if err := g.computeProgress(db, now); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`
+		UPDATE goals
+		SET current_number = ?, status = ?, progress = ?
+		WHERE id = ?`,
+		g.CurrentNumber, g.Status, g.Progress, g.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating goal progress: %v", err)
+	}
+	return nil
+}
+
+// RecalculateGoalsForHabit recalculates every goal counting the habit, past periods included since a log
+// can be written for any date
+func RecalculateGoalsForHabit(db *sql.DB, habitID int, now time.Time) error {
+	ids, err := goalIDs(db, `
+		SELECT id
+		FROM goals
+		WHERE habit_id = ?
+		OR id IN (SELECT goal_id FROM goal_habits WHERE habit_id = ?)`,
+		habitID, habitID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = recalculateGoals(db, ids, now, true)
+	return err
+}
+
+// RecalculateGoals recalculates every goal whose status can still change with the date: goals in progress,
+// goals that ended yesterday and goals not yet settled as done or failed. It returns the number of goals
+// whose status changed.
+func RecalculateGoals(db *sql.DB, now time.Time) (int, error) {
+	return recalculateDueGoals(db, now, true)
+}
+
+// backfillGoalProgress stores the progress of goals saved before progress was stored. Their stored status is
+// only the column default, so the changes aren't notified: the goals didn't just fall behind or finish.
+func backfillGoalProgress(db *sql.DB, now time.Time) (int, error) {
+	return recalculateDueGoals(db, now, false)
+}
+
+// recalculateDueGoals recalculates the goals whose status can still change with the date, queueing status
+// emails when notify is set
+func recalculateDueGoals(db *sql.DB, now time.Time, notify bool) (int, error) {
+	ids, err := goalIDs(db, `
+		SELECT id
+		FROM goals
+		WHERE date(end_date) >= date(?, '-1 day')
+		OR COALESCE(status, '') NOT IN ('done', 'failed')`,
+		truncateToDay(now.UTC()).Format("2006-01-02"),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return recalculateGoals(db, ids, now, notify)
+}
+
+// recalculateGoals recalculates the given goals and returns the number whose status changed. Status emails are
+// only queued when notify is set. A goal that fails doesn't stop the others; the first error is returned once
+// all have been tried.
+func recalculateGoals(db *sql.DB, ids []int, now time.Time, notify bool) (int, error) {
+	changed := 0
+	var firstErr error
+	for _, id := range ids {
+		goal, err := GetGoal(db, id)
+		if err == nil {
+			previous := goal.Status
+			if notify {
+				err = goal.recalculate(db, now)
+			} else {
+				err = goal.storeProgress(db, now)
+			}
+			if err == nil && goal.Status != previous {
+				changed++
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error recalculating goal %d: %v", id, err)
+		}
+	}
+	return changed, firstErr
+}
+
+// goalIDs returns the goal ids selected by query
+func goalIDs(db *sql.DB, query string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting goals: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
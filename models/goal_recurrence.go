@@ -0,0 +1,259 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Goal recurrences; an empty recurrence is a one-off goal
+const (
+	GoalRecurrenceWeekly    = "weekly"
+	GoalRecurrenceMonthly   = "monthly"
+	GoalRecurrenceQuarterly = "quarterly"
+	GoalRecurrenceYearly    = "yearly"
+)
+
+// GoalPeriod is one period of a goal, either archived or the one in progress
+type GoalPeriod struct {
+	ID            int     `json:"id,omitempty"`
+	GoalID        int     `json:"goal_id"`
+	StartDate     string  `json:"start_date"`
+	EndDate       string  `json:"end_date"`
+	TargetNumber  float64 `json:"target_number"`
+	CurrentNumber float64 `json:"current_number"`
+	Status        string  `json:"status"`
+	ArchivedAt    string  `json:"archived_at,omitempty"`
+}
+
+// GoalHistory lists every finished period of a recurring goal along with the current one
+type GoalHistory struct {
+	GoalID     int          `json:"goal_id"`
+	Recurrence string       `json:"recurrence"`
+	Periods    []GoalPeriod `json:"periods"` // oldest first
+	Current    GoalPeriod   `json:"current"`
+	Completed  int          `json:"completed"`
+	Hits       int          `json:"hits"`
+	HitRate    float64      `json:"hit_rate"` // percent of finished periods that were done
+}
+
+// IsValidGoalRecurrence reports whether recurrence is supported; empty means the goal does not repeat
+func IsValidGoalRecurrence(recurrence string) bool {
+	switch recurrence {
+	case "", GoalRecurrenceWeekly, GoalRecurrenceMonthly, GoalRecurrenceQuarterly, GoalRecurrenceYearly:
+		return true
+	}
+	return false
+}
+
+// RecurrenceEndDate returns the last day of the period of the given recurrence starting on start
+func RecurrenceEndDate(recurrence string, start time.Time) time.Time {
+	var next time.Time
+	switch recurrence {
+	case GoalRecurrenceWeekly:
+		next = start.AddDate(0, 0, 7)
+	case GoalRecurrenceMonthly:
+		next = start.AddDate(0, 1, 0)
+	case GoalRecurrenceQuarterly:
+		next = start.AddDate(0, 3, 0)
+	case GoalRecurrenceYearly:
+		next = start.AddDate(1, 0, 0)
+	default:
+		return start
+	}
+	return next.AddDate(0, 0, -1)
+}
+
+// validateRecurrence checks that a recurring goal's dates span exactly one period
+func (g *Goal) validateRecurrence(startDate, endDate time.Time) error {
+	if !IsValidGoalRecurrence(g.Recurrence) {
+		return fmt.Errorf("invalid recurrence: %s", g.Recurrence)
+	}
+	if g.Recurrence == "" {
+		return nil
+	}
+	if expected := RecurrenceEndDate(g.Recurrence, startDate); !endDate.Equal(expected) {
+		return fmt.Errorf("a %s goal starting %s must end on %s",
+			g.Recurrence, startDate.Format("2006-01-02"), expected.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// Rollover archives every finished period of a recurring goal and moves it to the period containing now.
+// It returns the number of periods archived.
+func (g *Goal) Rollover(db *sql.DB, now time.Time) (int, error) {
+	if g.Recurrence == "" {
+		return 0, nil
+	}
+
+	today := truncateToDay(now.UTC())
+
+	// Evaluate every finished period before writing anything
+	var finished []GoalPeriod
+	for {
+		endDate, err := time.Parse("2006-01-02", g.EndDate)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing end date: %v", err)
+		}
+		if !today.After(endDate) {
+			break
+		}
+
+		// Final progress is evaluated the day after the period ends
+		if err := g.computeProgress(db, endDate.AddDate(0, 0, 1)); err != nil {
+			return 0, err
+		}
+		finished = append(finished, GoalPeriod{
+			GoalID:        g.ID,
+			StartDate:     g.StartDate,
+			EndDate:       g.EndDate,
+			TargetNumber:  g.TargetNumber,
+			CurrentNumber: g.CurrentNumber,
+			Status:        g.Status,
+		})
+
+		nextStart := endDate.AddDate(0, 0, 1)
+		g.StartDate = nextStart.Format("2006-01-02")
+		g.EndDate = RecurrenceEndDate(g.Recurrence, nextStart).Format("2006-01-02")
+	}
+
+	if len(finished) == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, p := range finished {
+		_, err = tx.Exec(`
+			INSERT INTO goal_periods (goal_id, start_date, end_date, target_number, current_number, status)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			p.GoalID, p.StartDate, p.EndDate, p.TargetNumber, p.CurrentNumber, p.Status,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("error archiving goal period: %v", err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE goals
+		SET start_date = ?, end_date = ?, current_number = 0, status = 'on_track', updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		g.StartDate, g.EndDate, g.ID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error opening next goal period: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// Logs may already have been written for the new period
+	g.CurrentNumber = 0
+	g.Status = "on_track"
+	if err := g.recalculate(db, now); err != nil {
+		return 0, err
+	}
+	return len(finished), nil
+}
+
+// RolloverRecurringGoals rolls over every recurring goal whose period has ended and returns the number of periods archived
+func RolloverRecurringGoals(db *sql.DB, now time.Time) (int, error) {
+	rows, err := db.Query(`
+		SELECT id
+		FROM goals
+		WHERE recurrence != ''
+		AND date(end_date) < date(?)`,
+		truncateToDay(now.UTC()).Format("2006-01-02"),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error getting recurring goals: %v", err)
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, id := range ids {
+		goal, err := GetGoal(db, id)
+		if err != nil {
+			return total, fmt.Errorf("error getting goal %d: %v", id, err)
+		}
+		archived, err := goal.Rollover(db, now)
+		if err != nil {
+			return total, fmt.Errorf("error rolling over goal %d: %v", id, err)
+		}
+		total += archived
+	}
+	return total, nil
+}
+
+// GetGoalHistory returns the archived periods of a goal, the current period and the hit rate across finished periods
+func GetGoalHistory(db *sql.DB, goal *Goal, now time.Time) (GoalHistory, error) {
+	history := GoalHistory{
+		GoalID:     goal.ID,
+		Recurrence: goal.Recurrence,
+		Periods:    []GoalPeriod{},
+	}
+
+	rows, err := db.Query(`
+		SELECT id, goal_id, start_date, end_date, target_number, current_number, status, archived_at
+		FROM goal_periods
+		WHERE goal_id = ?
+		ORDER BY start_date ASC`,
+		goal.ID,
+	)
+	if err != nil {
+		return history, fmt.Errorf("error getting goal periods: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p GoalPeriod
+		err := rows.Scan(&p.ID, &p.GoalID, &p.StartDate, &p.EndDate, &p.TargetNumber,
+			&p.CurrentNumber, &p.Status, &p.ArchivedAt)
+		if err != nil {
+			return history, err
+		}
+		history.Periods = append(history.Periods, p)
+		history.Completed++
+		if p.Status == "done" {
+			history.Hits++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return history, err
+	}
+
+	if err := goal.computeProgress(db, now); err != nil {
+		return history, err
+	}
+	history.Current = GoalPeriod{
+		GoalID:        goal.ID,
+		StartDate:     goal.StartDate,
+		EndDate:       goal.EndDate,
+		TargetNumber:  goal.TargetNumber,
+		CurrentNumber: goal.CurrentNumber,
+		Status:        goal.Status,
+	}
+
+	if history.Completed > 0 {
+		history.HitRate = roundTo2(float64(history.Hits) / float64(history.Completed) * 100)
+	}
+	return history, nil
+}
@@ -0,0 +1,261 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// projectionWindowDays is how far back the recent rate for projected completion looks
+const projectionWindowDays = 14
+
+// GoalSnapshot is a goal's progress at the end of one day
+type GoalSnapshot struct {
+	Date           string  `json:"date"`
+	CurrentNumber  float64 `json:"current_number"`
+	ExpectedNumber float64 `json:"expected_number"`
+	Status         string  `json:"status"`
+	Live           bool    `json:"live,omitempty"` // computed now rather than recorded by the snapshot job
+}
+
+// GoalStatusEvent is a change of status between two consecutive snapshots
+type GoalStatusEvent struct {
+	Date string `json:"date"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// GoalProjection estimates when a cumulative goal will reach its target at the recent rate
+type GoalProjection struct {
+	Date       string  `json:"date"`
+	RatePerDay float64 `json:"rate_per_day"`
+	OnTime     bool    `json:"on_time"`
+}
+
+// GoalProgressSeries is the day by day progress of a goal's current period
+type GoalProgressSeries struct {
+	GoalID       int               `json:"goal_id"`
+	Kind         string            `json:"kind"`
+	StartDate    string            `json:"start_date"`
+	EndDate      string            `json:"end_date"`
+	TargetNumber float64           `json:"target_number"`
+	Snapshots    []GoalSnapshot    `json:"snapshots"`
+	Events       []GoalStatusEvent `json:"events"`
+	Projection   *GoalProjection   `json:"projection,omitempty"`
+}
+
+// isCumulative reports whether the goal counts up towards its target over the period
+func (g *Goal) isCumulative() bool {
+	kind := g.kindOrDefault()
+	return kind == GoalKindTotal || kind == GoalKindOptionCount
+}
+
+// linearExpected returns how far along a target should be on today if progress were spread evenly over the period
+func linearExpected(target float64, startDate, endDate, today time.Time) float64 {
+	if today.After(endDate) {
+		today = endDate
+	}
+	totalDays := endDate.Sub(startDate).Hours() / 24
+	daysPassed := today.Sub(startDate).Hours() / 24
+	if totalDays <= 0 || daysPassed < 0 {
+		return 0
+	}
+	return (daysPassed / totalDays) * target
+}
+
+// expectedNumber returns the expected progress as of today: a linear pace for cumulative goals, the target otherwise
+func (g *Goal) expectedNumber(today time.Time) (float64, error) {
+	if !g.isCumulative() {
+		return g.TargetNumber, nil
+	}
+	startDate, err := time.Parse("2006-01-02", g.StartDate)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing start date: %v", err)
+	}
+	endDate, err := time.Parse("2006-01-02", g.EndDate)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing end date: %v", err)
+	}
+	return roundTo2(linearExpected(g.TargetNumber, startDate, endDate, today)), nil
+}
+
+// RecordGoalSnapshots stores today's progress for every goal whose period includes today.
+// It returns the number of snapshots recorded; running it again the same day overwrites them.
+func RecordGoalSnapshots(db *sql.DB, now time.Time) (int, error) {
+	today := truncateToDay(now.UTC())
+	day := today.Format("2006-01-02")
+
+	rows, err := db.Query(`
+		SELECT id
+		FROM goals
+		WHERE date(start_date) <= date(?)
+		AND date(end_date) >= date(?)`,
+		day, day,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error getting active goals: %v", err)
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	recorded := 0
+	for _, id := range ids {
+		goal, err := GetGoal(db, id)
+		if err != nil {
+			return recorded, fmt.Errorf("error getting goal %d: %v", id, err)
+		}
+		if err := goal.computeProgress(db, now); err != nil {
+			return recorded, fmt.Errorf("error calculating progress for goal %d: %v", id, err)
+		}
+		expected, err := goal.expectedNumber(today)
+		if err != nil {
+			return recorded, err
+		}
+
+		_, err = db.Exec(`
+			INSERT INTO goal_snapshots (goal_id, date, current_number, expected_number, status)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(goal_id, date) DO UPDATE SET
+				current_number = excluded.current_number,
+				expected_number = excluded.expected_number,
+				status = excluded.status`,
+			goal.ID, day, goal.CurrentNumber, expected, goal.Status,
+		)
+		if err != nil {
+			return recorded, fmt.Errorf("error recording snapshot for goal %d: %v", id, err)
+		}
+		recorded++
+	}
+	return recorded, nil
+}
+
+// GetGoalProgressSeries returns the recorded snapshots of the goal's current period, with today's live progress
+// appended when it has not been recorded yet, the status changes between them and a projected completion date
+func GetGoalProgressSeries(db *sql.DB, goal *Goal, now time.Time) (GoalProgressSeries, error) {
+	series := GoalProgressSeries{
+		GoalID:       goal.ID,
+		Kind:         goal.kindOrDefault(),
+		StartDate:    goal.StartDate,
+		EndDate:      goal.EndDate,
+		TargetNumber: goal.TargetNumber,
+		Snapshots:    []GoalSnapshot{},
+		Events:       []GoalStatusEvent{},
+	}
+
+	rows, err := db.Query(`
+		SELECT date, current_number, expected_number, status
+		FROM goal_snapshots
+		WHERE goal_id = ?
+		AND date(date) BETWEEN date(?) AND date(?)
+		ORDER BY date ASC`,
+		goal.ID, goal.StartDate, goal.EndDate,
+	)
+	if err != nil {
+		return series, fmt.Errorf("error getting goal snapshots: %v", err)
+	}
+	for rows.Next() {
+		var s GoalSnapshot
+		if err := rows.Scan(&s.Date, &s.CurrentNumber, &s.ExpectedNumber, &s.Status); err != nil {
+			rows.Close()
+			return series, err
+		}
+		series.Snapshots = append(series.Snapshots, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return series, err
+	}
+
+	today := truncateToDay(now.UTC())
+	if err := goal.computeProgress(db, now); err != nil {
+		return series, err
+	}
+	endDate, err := time.Parse("2006-01-02", goal.EndDate)
+	if err != nil {
+		return series, fmt.Errorf("error parsing end date: %v", err)
+	}
+	todayStr := today.Format("2006-01-02")
+	last := len(series.Snapshots) - 1
+	if !today.After(endDate) && todayStr >= goal.StartDate && (last < 0 || series.Snapshots[last].Date != todayStr) {
+		expected, err := goal.expectedNumber(today)
+		if err != nil {
+			return series, err
+		}
+		series.Snapshots = append(series.Snapshots, GoalSnapshot{
+			Date:           todayStr,
+			CurrentNumber:  goal.CurrentNumber,
+			ExpectedNumber: expected,
+			Status:         goal.Status,
+			Live:           true,
+		})
+	}
+
+	for i := 1; i < len(series.Snapshots); i++ {
+		prev, cur := series.Snapshots[i-1], series.Snapshots[i]
+		if prev.Status != cur.Status {
+			series.Events = append(series.Events, GoalStatusEvent{Date: cur.Date, From: prev.Status, To: cur.Status})
+		}
+	}
+
+	if goal.isCumulative() && goal.Status != "done" && !today.After(endDate) {
+		series.Projection = projectGoalCompletion(series.Snapshots, goal, today, endDate)
+	}
+	return series, nil
+}
+
+// projectGoalCompletion extrapolates the rate over the last projectionWindowDays of snapshots, falling back to the
+// average rate since the start of the period when there are too few snapshots
+func projectGoalCompletion(snapshots []GoalSnapshot, goal *Goal, today, endDate time.Time) *GoalProjection {
+	remaining := goal.TargetNumber - goal.CurrentNumber
+	if remaining <= 0 {
+		return nil
+	}
+
+	rate, recent := 0.0, false
+	if len(snapshots) >= 2 {
+		latest := snapshots[len(snapshots)-1]
+		latestDate, _ := time.Parse("2006-01-02", latest.Date)
+		windowStart := latestDate.AddDate(0, 0, -projectionWindowDays)
+		for _, s := range snapshots {
+			date, err := time.Parse("2006-01-02", s.Date)
+			if err != nil || date.Before(windowStart) {
+				continue
+			}
+			if days := latestDate.Sub(date).Hours() / 24; days > 0 {
+				rate = (latest.CurrentNumber - s.CurrentNumber) / days
+				recent = true
+			}
+			break
+		}
+	}
+	if !recent {
+		startDate, err := time.Parse("2006-01-02", goal.StartDate)
+		if err != nil {
+			return nil
+		}
+		rate = goal.CurrentNumber / (today.Sub(startDate).Hours()/24 + 1)
+	}
+	if rate <= 0 {
+		return nil
+	}
+
+	projected := today.AddDate(0, 0, int(math.Ceil(remaining/rate)))
+	return &GoalProjection{
+		Date:       projected.Format("2006-01-02"),
+		RatePerDay: roundTo2(rate),
+		OnTime:     !projected.After(endDate),
+	}
+}
@@ -0,0 +1,856 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// TestGoalKindValidation tests the kind-specific checks in Validate and ValidateHabitType
+func TestGoalKindValidation(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	mood := createTestHabitForTests(t, db, userID, OptionSelectHabit, "Mood")
+	water := createTestHabitForTests(t, db, userID, NumericHabit, "Water")
+
+	base := Goal{UserID: int(userID), Name: "Goal", StartDate: "2024-01-01", EndDate: "2024-01-31", TargetNumber: 10}
+
+	validateTests := []struct {
+		name    string
+		modify  func(g *Goal)
+		wantErr bool
+	}{
+		{"total without kind", func(g *Goal) {}, false},
+		{"unknown kind", func(g *Goal) { g.Kind = "median" }, true},
+		{"option count without option", func(g *Goal) { g.Kind = GoalKindOptionCount }, true},
+		{"streak longer than period", func(g *Goal) { g.Kind = GoalKindStreak; g.TargetNumber = 32 }, true},
+		{"fractional streak", func(g *Goal) { g.Kind = GoalKindStreak; g.TargetNumber = 2.5 }, true},
+		{"completion rate over 100", func(g *Goal) { g.Kind = GoalKindCompletionRate; g.TargetNumber = 120 }, true},
+		{"average without comparison", func(g *Goal) { g.Kind = GoalKindAverage }, true},
+		{"average at most", func(g *Goal) { g.Kind = GoalKindAverage; g.Comparison = GoalAtMost }, false},
+		{"comparison on total", func(g *Goal) { g.Comparison = GoalAtLeast }, true},
+	}
+	for _, tt := range validateTests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := base
+			tt.modify(&g)
+			if err := g.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	habitTests := []struct {
+		name    string
+		goal    Goal
+		wantErr bool
+	}{
+		{"total on option-select", Goal{HabitID: mood.ID, Kind: GoalKindTotal}, true},
+		{"option count on option-select", Goal{HabitID: mood.ID, Kind: GoalKindOptionCount, TargetOption: &HabitOption{Emoji: "🙂", Label: "Good"}}, false},
+		{"unknown option", Goal{HabitID: mood.ID, Kind: GoalKindOptionCount, TargetOption: &HabitOption{Emoji: "🤩", Label: "Great"}}, true},
+		{"option count on numeric", Goal{HabitID: water.ID, Kind: GoalKindOptionCount, TargetOption: &HabitOption{Emoji: "🙂", Label: "Good"}}, true},
+		{"streak on option-select", Goal{HabitID: mood.ID, Kind: GoalKindStreak}, false},
+		{"average on numeric", Goal{HabitID: water.ID, Kind: GoalKindAverage}, false},
+		{"average on option-select", Goal{HabitID: mood.ID, Kind: GoalKindAverage}, true},
+	}
+	for _, tt := range habitTests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := tt.goal
+			g.UserID = int(userID)
+			if err := g.ValidateHabitType(db); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateHabitType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestGoalKindProgress tests progress and status for each goal kind
+func TestGoalKindProgress(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	t.Run("option count", func(t *testing.T) {
+		mood := createTestHabitForTests(t, db, userID, OptionSelectHabit, "Mood")
+		for d := 1; d <= 5; d++ {
+			createHabitLog(t, db, mood.ID, day(d), "done", HabitOption{Emoji: "🙂", Label: "Good"})
+		}
+		createHabitLog(t, db, mood.ID, day(6), "done", HabitOption{Emoji: "😐", Label: "Neutral"})
+
+		goal := &Goal{
+			UserID: int(userID), HabitID: mood.ID, Name: "20 good days",
+			StartDate: "2024-01-01", EndDate: "2024-01-31", TargetNumber: 20,
+			Kind: GoalKindOptionCount, TargetOption: &HabitOption{Emoji: "🙂", Label: "Good"},
+		}
+		if err := goal.Create(db); err != nil {
+			t.Fatalf("Failed to create goal: %v", err)
+		}
+		stored, err := GetGoal(db, goal.ID)
+		if err != nil {
+			t.Fatalf("Failed to get goal: %v", err)
+		}
+		if stored.Kind != GoalKindOptionCount || stored.TargetOption == nil || stored.TargetOption.Label != "Good" {
+			t.Fatalf("Goal kind not stored: %+v", stored)
+		}
+
+		if err := stored.computeProgress(db, day(8)); err != nil {
+			t.Fatalf("computeProgress failed: %v", err)
+		}
+		if stored.CurrentNumber != 5 || stored.Progress != 25 || stored.Status != "on_track" {
+			t.Errorf("Expected 5 days (25%%) on track, got %v (%v%%) %s", stored.CurrentNumber, stored.Progress, stored.Status)
+		}
+
+		if err := stored.computeProgress(db, day(20)); err != nil {
+			t.Fatalf("computeProgress failed: %v", err)
+		}
+		if stored.Status != "off_track" {
+			t.Errorf("Expected off_track, got %s", stored.Status)
+		}
+	})
+
+	t.Run("total includes the end day", func(t *testing.T) {
+		habit := createTestHabitForTests(t, db, userID, BinaryHabit, "Stretch")
+		createHabitLog(t, db, habit.ID, day(31), "done", nil)
+
+		goal := &Goal{HabitID: habit.ID, StartDate: "2024-01-01", EndDate: "2024-01-31", TargetNumber: 1}
+		if err := goal.computeProgress(db, day(31).AddDate(0, 0, 1)); err != nil {
+			t.Fatalf("computeProgress failed: %v", err)
+		}
+		if goal.CurrentNumber != 1 || goal.Status != "done" {
+			t.Errorf("Expected the end day to count, got %v %s", goal.CurrentNumber, goal.Status)
+		}
+	})
+
+	t.Run("streak", func(t *testing.T) {
+		habit := createTestHabitForTests(t, db, userID, BinaryHabit, "Run")
+		for _, d := range []int{1, 2, 3, 5, 6, 7, 8, 9} {
+			createHabitLog(t, db, habit.ID, day(d), "done", nil)
+		}
+
+		goal := &Goal{HabitID: habit.ID, StartDate: "2024-01-01", EndDate: "2024-01-31", TargetNumber: 7, Kind: GoalKindStreak}
+		if err := goal.computeProgress(db, day(10)); err != nil {
+			t.Fatalf("computeProgress failed: %v", err)
+		}
+		if goal.CurrentNumber != 5 || goal.Status != "on_track" {
+			t.Errorf("Expected longest streak 5 on track, got %v %s", goal.CurrentNumber, goal.Status)
+		}
+
+		// Too few days left to build a new streak after the run broke
+		short := &Goal{HabitID: habit.ID, StartDate: "2024-01-01", EndDate: "2024-01-12", TargetNumber: 7, Kind: GoalKindStreak}
+		if err := short.computeProgress(db, day(11)); err != nil {
+			t.Fatalf("computeProgress failed: %v", err)
+		}
+		if short.Status != "off_track" {
+			t.Errorf("Expected off_track, got %s", short.Status)
+		}
+
+		createHabitLog(t, db, habit.ID, day(10), "done", nil)
+		createHabitLog(t, db, habit.ID, day(11), "done", nil)
+		if err := goal.computeProgress(db, day(11)); err != nil {
+			t.Fatalf("computeProgress failed: %v", err)
+		}
+		if goal.CurrentNumber != 7 || goal.Status != "done" || goal.Progress != 100 {
+			t.Errorf("Expected streak of 7 done, got %v %s", goal.CurrentNumber, goal.Status)
+		}
+	})
+
+	t.Run("completion rate", func(t *testing.T) {
+		habit := createTestHabitForTests(t, db, userID, BinaryHabit, "Read")
+		for _, d := range []int{1, 2, 3, 5, 6, 7, 8} {
+			createHabitLog(t, db, habit.ID, day(d), "done", nil)
+		}
+
+		tests := []struct {
+			target float64
+			now    time.Time
+			want   string
+		}{
+			{50, day(10), "on_track"},
+			{80, day(10), "at_risk"},
+			{95, day(10), "off_track"},
+			{20, day(10), "done"},
+			{50, day(31).AddDate(0, 0, 1), "failed"},
+		}
+		for _, tt := range tests {
+			goal := &Goal{HabitID: habit.ID, StartDate: "2024-01-01", EndDate: "2024-01-31", TargetNumber: tt.target, Kind: GoalKindCompletionRate}
+			if err := goal.computeProgress(db, tt.now); err != nil {
+				t.Fatalf("computeProgress failed: %v", err)
+			}
+			if goal.Status != tt.want {
+				t.Errorf("Target %v%% at %s: expected %s, got %s (rate %v)", tt.target, tt.now.Format("2006-01-02"), tt.want, goal.Status, goal.CurrentNumber)
+			}
+		}
+
+		// Today is not counted against the rate until it is logged
+		goal := &Goal{HabitID: habit.ID, StartDate: "2024-01-01", EndDate: "2024-01-31", TargetNumber: 50, Kind: GoalKindCompletionRate}
+		if err := goal.computeProgress(db, day(10)); err != nil {
+			t.Fatalf("computeProgress failed: %v", err)
+		}
+		if goal.CurrentNumber != 77.78 {
+			t.Errorf("Expected 7 of 9 days (77.78%%), got %v", goal.CurrentNumber)
+		}
+	})
+
+	t.Run("average", func(t *testing.T) {
+		habit := createTestHabitForTests(t, db, userID, NumericHabit, "Coffee")
+		for d, value := range map[int]float64{1: 4, 2: 6, 3: 5} {
+			createHabitLog(t, db, habit.ID, day(d), "done", map[string]float64{"value": value})
+		}
+
+		tests := []struct {
+			comparison string
+			target     float64
+			now        time.Time
+			want       string
+		}{
+			{GoalAtMost, 5, day(10), "on_track"},
+			{GoalAtMost, 5, day(31).AddDate(0, 0, 1), "done"},
+			{GoalAtMost, 4.6, day(10), "at_risk"},
+			{GoalAtMost, 3, day(10), "off_track"},
+			{GoalAtLeast, 5.5, day(10), "at_risk"},
+			{GoalAtLeast, 6, day(31).AddDate(0, 0, 1), "failed"},
+		}
+		for _, tt := range tests {
+			goal := &Goal{HabitID: habit.ID, StartDate: "2024-01-01", EndDate: "2024-01-31", TargetNumber: tt.target, Kind: GoalKindAverage, Comparison: tt.comparison}
+			if err := goal.computeProgress(db, tt.now); err != nil {
+				t.Fatalf("computeProgress failed: %v", err)
+			}
+			if goal.CurrentNumber != 5 || goal.Status != tt.want {
+				t.Errorf("%s %v: expected average 5 %s, got %v %s", tt.comparison, tt.target, tt.want, goal.CurrentNumber, goal.Status)
+			}
+		}
+	})
+}
+
+// TestGoalRecurrence tests period validation, rollover and history for recurring goals
+func TestGoalRecurrence(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	habit := createTestHabitForTests(t, db, userID, BinaryHabit, "Gym")
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	if got := RecurrenceEndDate(GoalRecurrenceMonthly, day(31)).Format("2006-01-02"); got != "2024-03-01" {
+		t.Errorf("Expected monthly period from Jan 31 to end 2024-03-01, got %s", got)
+	}
+
+	goal := &Goal{
+		UserID: int(userID), HabitID: habit.ID, Name: "Gym 3x a week",
+		StartDate: "2024-01-01", EndDate: "2024-01-10", TargetNumber: 3,
+		Recurrence: GoalRecurrenceWeekly,
+	}
+	if err := goal.Validate(); err == nil {
+		t.Error("Expected error for a weekly goal spanning 10 days")
+	}
+	goal.EndDate = "2024-01-07"
+	if err := goal.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	goal.Recurrence = "daily"
+	if err := goal.Validate(); err == nil {
+		t.Error("Expected error for unknown recurrence")
+	}
+	goal.Recurrence = GoalRecurrenceWeekly
+	if err := goal.Create(db); err != nil {
+		t.Fatalf("Failed to create goal: %v", err)
+	}
+
+	// Week 1 is hit, week 2 is missed, week 3 is in progress
+	for _, d := range []int{1, 3, 5, 9, 15} {
+		createHabitLog(t, db, habit.ID, day(d), "done", nil)
+	}
+
+	archived, err := RolloverRecurringGoals(db, day(7))
+	if err != nil || archived != 0 {
+		t.Fatalf("Expected nothing to roll over on the last day of the period, got %d (%v)", archived, err)
+	}
+
+	archived, err = RolloverRecurringGoals(db, day(16))
+	if err != nil {
+		t.Fatalf("RolloverRecurringGoals failed: %v", err)
+	}
+	if archived != 2 {
+		t.Errorf("Expected 2 periods archived, got %d", archived)
+	}
+
+	stored, err := GetGoal(db, goal.ID)
+	if err != nil {
+		t.Fatalf("Failed to get goal: %v", err)
+	}
+	if stored.StartDate != "2024-01-15" || stored.EndDate != "2024-01-21" || stored.Status != "on_track" {
+		t.Errorf("Expected the goal to move to 2024-01-15..2024-01-21, got %s..%s %s", stored.StartDate, stored.EndDate, stored.Status)
+	}
+
+	history, err := GetGoalHistory(db, stored, day(16))
+	if err != nil {
+		t.Fatalf("GetGoalHistory failed: %v", err)
+	}
+	if len(history.Periods) != 2 || history.Periods[0].Status != "done" || history.Periods[0].CurrentNumber != 3 ||
+		history.Periods[1].Status != "failed" || history.Periods[1].CurrentNumber != 1 {
+		t.Errorf("Unexpected periods: %+v", history.Periods)
+	}
+	if history.Hits != 1 || history.Completed != 2 || history.HitRate != 50 {
+		t.Errorf("Expected hit rate 1/2 (50%%), got %d/%d (%v%%)", history.Hits, history.Completed, history.HitRate)
+	}
+	if history.Current.StartDate != "2024-01-15" || history.Current.CurrentNumber != 1 {
+		t.Errorf("Unexpected current period: %+v", history.Current)
+	}
+
+	// Rolling over again the same day is a no-op
+	if archived, err := RolloverRecurringGoals(db, day(16)); err != nil || archived != 0 {
+		t.Errorf("Expected no further rollover, got %d (%v)", archived, err)
+	}
+}
+
+// TestGoalSnapshots tests daily snapshots, status change events and the projected completion date
+func TestGoalSnapshots(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	habit := createTestHabitForTests(t, db, userID, BinaryHabit, "Walk")
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	goal := &Goal{
+		UserID: int(userID), HabitID: habit.ID, Name: "Walk 20 days",
+		StartDate: "2024-01-01", EndDate: "2024-01-31", TargetNumber: 20,
+	}
+	if err := goal.Create(db); err != nil {
+		t.Fatalf("Failed to create goal: %v", err)
+	}
+	past := &Goal{
+		UserID: int(userID), HabitID: habit.ID, Name: "Last year",
+		StartDate: "2023-12-01", EndDate: "2023-12-31", TargetNumber: 5,
+	}
+	if err := past.Create(db); err != nil {
+		t.Fatalf("Failed to create goal: %v", err)
+	}
+
+	// Walking every other day falls behind the pace of 20 in 31 days
+	for d := 1; d <= 11; d++ {
+		if d%2 == 1 {
+			createHabitLog(t, db, habit.ID, day(d), "done", nil)
+		}
+		recorded, err := RecordGoalSnapshots(db, day(d).Add(23*time.Hour))
+		if err != nil {
+			t.Fatalf("RecordGoalSnapshots failed: %v", err)
+		}
+		if recorded != 1 {
+			t.Errorf("Expected only the active goal to be recorded, got %d", recorded)
+		}
+	}
+	if _, err := RecordGoalSnapshots(db, day(11)); err != nil {
+		t.Fatalf("Recording twice on the same day failed: %v", err)
+	}
+
+	series, err := GetGoalProgressSeries(db, goal, day(12))
+	if err != nil {
+		t.Fatalf("GetGoalProgressSeries failed: %v", err)
+	}
+	if len(series.Snapshots) != 12 || !series.Snapshots[11].Live || series.Snapshots[10].Live {
+		t.Fatalf("Expected 11 recorded snapshots and a live one, got %+v", series.Snapshots)
+	}
+	if s := series.Snapshots[7]; s.Date != "2024-01-08" || s.CurrentNumber != 4 || s.ExpectedNumber != 4.67 || s.Status != "off_track" {
+		t.Errorf("Unexpected snapshot for Jan 8: %+v", s)
+	}
+
+	// Each walk catches up with the pace a little, each rest day falls further behind
+	wantEvents := []GoalStatusEvent{
+		{Date: "2024-01-06", From: "on_track", To: "at_risk"},
+		{Date: "2024-01-07", From: "at_risk", To: "on_track"},
+		{Date: "2024-01-08", From: "on_track", To: "off_track"},
+		{Date: "2024-01-09", From: "off_track", To: "at_risk"},
+		{Date: "2024-01-10", From: "at_risk", To: "off_track"},
+		{Date: "2024-01-11", From: "off_track", To: "at_risk"},
+		{Date: "2024-01-12", From: "at_risk", To: "off_track"},
+	}
+	if len(series.Events) != len(wantEvents) {
+		t.Fatalf("Expected %d events, got %+v", len(wantEvents), series.Events)
+	}
+	for i, want := range wantEvents {
+		if series.Events[i] != want {
+			t.Errorf("Event %d: expected %+v, got %+v", i, want, series.Events[i])
+		}
+	}
+
+	// 5 more walks over the last 11 days leaves 14 to go at 0.45 a day
+	if series.Projection == nil || series.Projection.Date != "2024-02-12" || series.Projection.RatePerDay != 0.45 || series.Projection.OnTime {
+		t.Errorf("Unexpected projection: %+v", series.Projection)
+	}
+}
+
+// TestGoalHabitsAndMilestones tests weighted multi-habit goals and milestone tracking
+func TestGoalHabitsAndMilestones(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	run := createTestHabitForTests(t, db, userID, NumericHabit, "Run")
+	cycle := createTestHabitForTests(t, db, userID, NumericHabit, "Cycle")
+	mood := createTestHabitForTests(t, db, userID, OptionSelectHabit, "Mood")
+
+	today := truncateToDay(time.Now().UTC())
+	start := today.AddDate(0, 0, -10)
+	end := today.AddDate(0, 0, 20)
+
+	base := Goal{
+		UserID: int(userID), HabitID: run.ID, Name: "100 km",
+		StartDate: start.Format("2006-01-02"), EndDate: end.Format("2006-01-02"), TargetNumber: 100,
+	}
+
+	validateTests := []struct {
+		name    string
+		modify  func(g *Goal)
+		wantErr bool
+	}{
+		{"weighted habits", func(g *Goal) { g.Habits = []GoalHabit{{HabitID: run.ID, Weight: 1}, {HabitID: cycle.ID, Weight: 0.25}} }, false},
+		{"duplicate habit", func(g *Goal) { g.Habits = []GoalHabit{{HabitID: run.ID, Weight: 1}, {HabitID: run.ID, Weight: 2}} }, true},
+		{"zero weight", func(g *Goal) { g.Habits = []GoalHabit{{HabitID: run.ID, Weight: 0}} }, true},
+		{"several habits on a streak", func(g *Goal) {
+			g.Kind = GoalKindStreak
+			g.TargetNumber = 5
+			g.Habits = []GoalHabit{{HabitID: run.ID, Weight: 1}, {HabitID: cycle.ID, Weight: 1}}
+		}, true},
+		{"milestone above target", func(g *Goal) { g.Milestones = []GoalMilestone{{Name: "Too far", TargetNumber: 150}} }, true},
+		{"milestone without name", func(g *Goal) { g.Milestones = []GoalMilestone{{TargetNumber: 50}} }, true},
+		{"milestone due after end", func(g *Goal) {
+			g.Milestones = []GoalMilestone{{Name: "Late", TargetNumber: 50, DueDate: end.AddDate(0, 0, 1).Format("2006-01-02")}}
+		}, true},
+		{"milestone on completion rate", func(g *Goal) {
+			g.Kind = GoalKindCompletionRate
+			g.TargetNumber = 50
+			g.Milestones = []GoalMilestone{{Name: "Half", TargetNumber: 25}}
+		}, true},
+	}
+	for _, tt := range validateTests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := base
+			tt.modify(&g)
+			if err := g.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	mixed := base
+	mixed.Habits = []GoalHabit{{HabitID: run.ID, Weight: 1}, {HabitID: mood.ID, Weight: 1}}
+	if err := mixed.ValidateHabitType(db); err == nil {
+		t.Error("Expected error for a total goal counting an option-select habit")
+	}
+
+	goal := base
+	goal.HabitID = 0
+	goal.Habits = []GoalHabit{{HabitID: run.ID, Weight: 1}, {HabitID: cycle.ID, Weight: 0.25}}
+	goal.Milestones = []GoalMilestone{
+		{Name: "Halfway", TargetNumber: 50},
+		{Name: "First 10", TargetNumber: 10, DueDate: start.AddDate(0, 0, 2).Format("2006-01-02")},
+	}
+	if err := goal.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if err := goal.Create(db); err != nil {
+		t.Fatalf("Failed to create goal: %v", err)
+	}
+	if goal.HabitID != run.ID {
+		t.Errorf("Expected the first habit to be the goal's habit, got %d", goal.HabitID)
+	}
+
+	// 20 km run and 80 km cycled count for 20 + 80 * 0.25 = 40
+	createHabitLog(t, db, run.ID, start.AddDate(0, 0, 4), "done", map[string]interface{}{"value": 20.0})
+	createHabitLog(t, db, cycle.ID, start.AddDate(0, 0, 5), "done", map[string]interface{}{"value": 80.0})
+	if err := RecalculateGoalsForHabit(db, cycle.ID, time.Now()); err != nil {
+		t.Fatalf("RecalculateGoalsForHabit failed: %v", err)
+	}
+
+	goals, err := GetGoalsByHabit(db, cycle.ID)
+	if err != nil {
+		t.Fatalf("GetGoalsByHabit failed: %v", err)
+	}
+	if len(goals) != 1 || goals[0].ID != goal.ID {
+		t.Fatalf("Expected the goal to be found through its second habit, got %+v", goals)
+	}
+	if goals[0].CurrentNumber != 40 {
+		t.Errorf("Expected weighted total 40, got %v", goals[0].CurrentNumber)
+	}
+	if goals[0].HabitName != "Run + Cycle" {
+		t.Errorf("Expected joined habit name, got %q", goals[0].HabitName)
+	}
+
+	// Milestones count as reached even when reached after their due date
+	reached, err := CheckGoalMilestones(db, cycle.ID, time.Now())
+	if err != nil {
+		t.Fatalf("CheckGoalMilestones failed: %v", err)
+	}
+	if len(reached) != 1 || reached[0].Milestone != "First 10" {
+		t.Errorf("Expected the first milestone to be reached, got %+v", reached)
+	}
+	if reached, _ := CheckGoalMilestones(db, run.ID, time.Now()); len(reached) != 0 {
+		t.Errorf("Expected a milestone to be reported only once, got %+v", reached)
+	}
+
+	createHabitLog(t, db, run.ID, start.AddDate(0, 0, 6), "done", map[string]interface{}{"value": 15.0})
+	if err := RecalculateGoalsForHabit(db, run.ID, time.Now()); err != nil {
+		t.Fatalf("RecalculateGoalsForHabit failed: %v", err)
+	}
+	reached, err = CheckGoalMilestones(db, run.ID, time.Now())
+	if err != nil {
+		t.Fatalf("CheckGoalMilestones failed: %v", err)
+	}
+	if len(reached) != 1 || reached[0].Milestone != "Halfway" {
+		t.Errorf("Expected the halfway milestone to be reached at 55, got %+v", reached)
+	}
+
+	// Each milestone reached is emailed once, through the goal notifications
+	mock := NewMockEmailService()
+	sent, err := SendGoalNotifications(db, mock, time.Now())
+	if err != nil {
+		t.Fatalf("SendGoalNotifications failed: %v", err)
+	}
+	if sent != 2 || !mock.sentEmails["testhabit@example.com-goal-milestone"] {
+		t.Errorf("Expected an email for each milestone, got %d sent: %v", sent, mock.sentEmails)
+	}
+	if sent, _ := SendGoalNotifications(db, mock, time.Now()); sent != 0 {
+		t.Errorf("Expected milestone emails to be sent once, got %d", sent)
+	}
+
+	stored, err := GetGoal(db, goal.ID)
+	if err != nil {
+		t.Fatalf("Failed to get goal: %v", err)
+	}
+	if len(stored.Habits) != 2 || stored.Habits[1].Weight != 0.25 {
+		t.Errorf("Unexpected goal habits: %+v", stored.Habits)
+	}
+
+	// Milestones kept by ID keep the date they were reached
+	var halfway GoalMilestone
+	for _, m := range stored.Milestones {
+		if m.Name == "Halfway" {
+			halfway = m
+		}
+	}
+	halfway.Name = "Half way"
+	stored.Milestones = []GoalMilestone{halfway, {Name: "Almost", TargetNumber: 90, DueDate: start.AddDate(0, 0, 8).Format("2006-01-02")}}
+	if err := stored.SaveMilestones(db); err != nil {
+		t.Fatalf("SaveMilestones failed: %v", err)
+	}
+
+	stored, err = GetGoal(db, goal.ID)
+	if err != nil {
+		t.Fatalf("Failed to get goal: %v", err)
+	}
+	if err := stored.computeProgress(db, today); err != nil {
+		t.Fatalf("computeProgress failed: %v", err)
+	}
+	if len(stored.Milestones) != 2 {
+		t.Fatalf("Expected 2 milestones, got %+v", stored.Milestones)
+	}
+	if m := stored.Milestones[0]; m.Name != "Half way" || m.ReachedAt == nil || m.Status != "reached" {
+		t.Errorf("Expected the kept milestone to stay reached, got %+v", m)
+	}
+	if m := stored.Milestones[1]; m.Name != "Almost" || m.Status != "missed" {
+		t.Errorf("Expected the overdue milestone to be missed, got %+v", m)
+	}
+}
+
+// TestGoalRecalculation tests that stored progress changes on log writes and with the date, and that reads
+// return it as stored
+func TestGoalRecalculation(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	habit := createTestHabitForTests(t, db, userID, BinaryHabit, "Read")
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	goal := &Goal{
+		UserID: int(userID), HabitID: habit.ID, Name: "Read 5 days",
+		StartDate: "2024-01-01", EndDate: "2024-01-10", TargetNumber: 5,
+	}
+	if err := goal.Create(db); err != nil {
+		t.Fatalf("Failed to create goal: %v", err)
+	}
+	past := &Goal{
+		UserID: int(userID), HabitID: habit.ID, Name: "Last year",
+		StartDate: "2023-12-01", EndDate: "2023-12-31", TargetNumber: 5,
+	}
+	if err := past.Create(db); err != nil {
+		t.Fatalf("Failed to create goal: %v", err)
+	}
+
+	stored := func(id int) *Goal {
+		t.Helper()
+		g, err := GetGoal(db, id)
+		if err != nil {
+			t.Fatalf("Failed to get goal: %v", err)
+		}
+		return g
+	}
+
+	createHabitLog(t, db, habit.ID, day(1), "done", nil)
+	createHabitLog(t, db, habit.ID, day(2), "done", nil)
+	if err := RecalculateGoalsForHabit(db, habit.ID, day(2)); err != nil {
+		t.Fatalf("RecalculateGoalsForHabit failed: %v", err)
+	}
+	goals, err := GetGoalsByUser(db, int(userID))
+	if err != nil {
+		t.Fatalf("GetGoalsByUser failed: %v", err)
+	}
+	if len(goals) != 2 || goals[0].Status != "on_track" || goals[0].CurrentNumber != 2 || goals[0].Progress != 40 {
+		t.Errorf("Expected the goal on track at 2/5 (40%%), got %+v", goals)
+	}
+	if goals[1].Status != "failed" {
+		t.Errorf("Expected last year's goal to have failed, got %s", goals[1].Status)
+	}
+
+	// Falling behind the pace is picked up by the nightly recalculation; settled goals are left alone
+	changed, err := RecalculateGoals(db, day(8))
+	if err != nil {
+		t.Fatalf("RecalculateGoals failed: %v", err)
+	}
+	if changed != 1 || stored(goal.ID).Status != "off_track" {
+		t.Errorf("Expected only the goal to change, to off_track, got %d changes and %s", changed, stored(goal.ID).Status)
+	}
+
+	// Reads return the stored progress until it is recalculated
+	createHabitLog(t, db, habit.ID, day(8), "done", nil)
+	createHabitLog(t, db, habit.ID, day(9), "done", nil)
+	if g := stored(goal.ID); g.CurrentNumber != 2 || g.Status != "off_track" {
+		t.Errorf("Expected reads not to recalculate, got %v %s", g.CurrentNumber, g.Status)
+	}
+
+	changed, err = RecalculateGoals(db, day(11))
+	if err != nil {
+		t.Fatalf("RecalculateGoals failed: %v", err)
+	}
+	if g := stored(goal.ID); changed != 1 || g.Status != "failed" || g.CurrentNumber != 4 {
+		t.Errorf("Expected the goal to fail at 4/5 after its end date, got %d changes, %v %s", changed, g.CurrentNumber, g.Status)
+	}
+
+	// A log written late for a day inside an ended period still counts
+	createHabitLog(t, db, habit.ID, day(10), "done", nil)
+	if err := RecalculateGoalsForHabit(db, habit.ID, day(12)); err != nil {
+		t.Fatalf("RecalculateGoalsForHabit failed: %v", err)
+	}
+	if g := stored(goal.ID); g.Status != "done" || g.Progress != 100 {
+		t.Errorf("Expected the goal to be done, got %s (%v%%)", g.Status, g.Progress)
+	}
+
+	if changed, err := RecalculateGoals(db, day(13)); err != nil || changed != 0 {
+		t.Errorf("Expected no changes once every goal is settled, got %d (%v)", changed, err)
+	}
+}
+
+// TestGoalProgressMigration tests that storing progress for existing goals doesn't notify their owners
+func TestGoalProgressMigration(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	habit := createTestHabitForTests(t, db, userID, BinaryHabit, "Read")
+	today := truncateToDay(time.Now().UTC())
+	createHabitLog(t, db, habit.ID, today.AddDate(0, 0, -5), "done", nil)
+
+	behind := &Goal{
+		UserID: int(userID), HabitID: habit.ID, Name: "Read 20 days",
+		StartDate: today.AddDate(0, 0, -10).Format("2006-01-02"), EndDate: today.AddDate(0, 0, 20).Format("2006-01-02"),
+		TargetNumber: 20,
+	}
+	finished := &Goal{
+		UserID: int(userID), HabitID: habit.ID, Name: "Read once",
+		StartDate: today.AddDate(0, 0, -30).Format("2006-01-02"), EndDate: today.AddDate(0, 0, -1).Format("2006-01-02"),
+		TargetNumber: 1,
+	}
+	for _, g := range []*Goal{behind, finished} {
+		if err := g.Create(db); err != nil {
+			t.Fatalf("Failed to create goal: %v", err)
+		}
+	}
+
+	// Before progress was stored every goal had the default status
+	for _, statement := range []string{
+		"DELETE FROM goal_notifications",
+		"UPDATE goals SET status = 'on_track', current_number = 0",
+		"ALTER TABLE goals DROP COLUMN progress",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Failed to set up the old schema: %v", err)
+		}
+	}
+	if err := MigrateDB(db); err != nil {
+		t.Fatalf("MigrateDB failed: %v", err)
+	}
+
+	for _, want := range []struct {
+		goal   *Goal
+		status string
+	}{{behind, "off_track"}, {finished, "done"}} {
+		g, err := GetGoal(db, want.goal.ID)
+		if err != nil {
+			t.Fatalf("GetGoal failed: %v", err)
+		}
+		if g.Status != want.status {
+			t.Errorf("Expected %s to be %s after the migration, got %s", g.Name, want.status, g.Status)
+		}
+	}
+	var queued int
+	if err := db.QueryRow("SELECT COUNT(*) FROM goal_notifications").Scan(&queued); err != nil {
+		t.Fatalf("Failed to count notifications: %v", err)
+	}
+	if queued != 0 {
+		t.Errorf("Expected the migration not to queue goal emails, got %d", queued)
+	}
+}
+
+// TestGoalDaysInUserTimezone tests that goals go by the day it is where their owner lives
+func TestGoalDaysInUserTimezone(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	if _, err := db.Exec("UPDATE users SET timezone = 'Pacific/Auckland' WHERE id = ?", userID); err != nil {
+		t.Fatalf("Failed to set timezone: %v", err)
+	}
+	habit := createTestHabitForTests(t, db, userID, BinaryHabit, "Read")
+	goal := &Goal{
+		UserID: int(userID), HabitID: habit.ID, Name: "Read 5 days",
+		StartDate: "2024-01-01", EndDate: "2024-01-10", TargetNumber: 5,
+	}
+	if err := goal.Create(db); err != nil {
+		t.Fatalf("Failed to create goal: %v", err)
+	}
+
+	// Midday on 6 Jan UTC is already 7 Jan in Auckland, 3 days before the end
+	if queued, err := QueueGoalDeadlineNotifications(db, time.Date(2024, 1, 6, 12, 0, 0, 0, time.UTC)); err != nil || queued != 1 {
+		t.Errorf("Expected a deadline email 3 days before the end in Auckland, got %d (%v)", queued, err)
+	}
+
+	// Midday on 10 Jan UTC is 11 Jan in Auckland, after the goal ended
+	if err := goal.computeProgress(db, time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("computeProgress failed: %v", err)
+	}
+	if goal.Status != "failed" {
+		t.Errorf("Expected the goal to have failed once it ended in Auckland, got %q", goal.Status)
+	}
+}
+
+// TestGoalNotifications tests queueing goal emails on status changes and deadlines, and sending them
+func TestGoalNotifications(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	optedOutID := createTestUserForHabits(t, db, "2")
+	if err := SetNotificationPreference(db, int(optedOutID), NotificationGoal, ChannelEmail, false); err != nil {
+		t.Fatalf("Failed to update preferences: %v", err)
+	}
+	unverifiedID := createTestUserForHabits(t, db, "3")
+	if _, err := db.Exec("UPDATE users SET email_verified = false WHERE id = ?", unverifiedID); err != nil {
+		t.Fatalf("Failed to unverify user: %v", err)
+	}
+	habit := createTestHabitForTests(t, db, userID, BinaryHabit, "Read")
+	otherHabit := createTestHabitForTests(t, db, optedOutID, BinaryHabit, "Read")
+	unverifiedHabit := createTestHabitForTests(t, db, unverifiedID, BinaryHabit, "Read")
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	goal := &Goal{
+		UserID: int(userID), HabitID: habit.ID, Name: "Read 5 days",
+		StartDate: "2024-01-01", EndDate: "2024-01-10", TargetNumber: 5,
+	}
+	other := &Goal{
+		UserID: int(optedOutID), HabitID: otherHabit.ID, Name: "Read 5 days",
+		StartDate: "2024-01-01", EndDate: "2024-01-10", TargetNumber: 5,
+	}
+	unverified := &Goal{
+		UserID: int(unverifiedID), HabitID: unverifiedHabit.ID, Name: "Read 5 days",
+		StartDate: "2024-01-01", EndDate: "2024-01-10", TargetNumber: 5,
+	}
+	for _, g := range []*Goal{goal, other, unverified} {
+		if err := g.Create(db); err != nil {
+			t.Fatalf("Failed to create goal: %v", err)
+		}
+	}
+
+	pending := func() []string {
+		t.Helper()
+		rows, err := db.Query("SELECT kind FROM goal_notifications WHERE goal_id = ? AND sent_at IS NULL ORDER BY id", goal.ID)
+		if err != nil {
+			t.Fatalf("Failed to get notifications: %v", err)
+		}
+		defer rows.Close()
+		kinds := []string{}
+		for rows.Next() {
+			var kind string
+			rows.Scan(&kind)
+			kinds = append(kinds, kind)
+		}
+		return kinds
+	}
+
+	// Falling behind queues one email, however long the goal stays behind
+	for _, d := range []int{2, 3} {
+		if _, err := RecalculateGoals(db, day(d)); err != nil {
+			t.Fatalf("RecalculateGoals failed: %v", err)
+		}
+	}
+	if kinds := pending(); len(kinds) != 1 || kinds[0] != GoalNotificationBehind {
+		t.Errorf("Expected one behind notification, got %v", kinds)
+	}
+
+	mock := NewMockEmailService()
+	sent, err := SendGoalNotifications(db, mock, day(3))
+	if err != nil {
+		t.Fatalf("SendGoalNotifications failed: %v", err)
+	}
+	if sent != 1 || !mock.sentEmails["testhabit@example.com-goal-behind"] || mock.sentEmails["testhabit2@example.com-goal-behind"] ||
+		mock.sentEmails["testhabit3@example.com-goal-behind"] {
+		t.Errorf("Expected only the opted in, verified user to be emailed, got %d sent: %v", sent, mock.sentEmails)
+	}
+
+	// Deadline emails follow the user's chosen number of days
+	if queued, err := QueueGoalDeadlineNotifications(db, day(6)); err != nil || queued != 0 {
+		t.Errorf("Expected no deadline emails 4 days before the end, got %d (%v)", queued, err)
+	}
+	if queued, err := QueueGoalDeadlineNotifications(db, day(7)); err != nil || queued != 3 {
+		t.Errorf("Expected deadline emails 3 days before the end, got %d (%v)", queued, err)
+	}
+
+	for _, d := range []int{1, 2} {
+		createHabitLog(t, db, habit.ID, day(d), "done", nil)
+	}
+	if err := RecalculateGoalsForHabit(db, habit.ID, day(8)); err != nil {
+		t.Fatalf("RecalculateGoalsForHabit failed: %v", err)
+	}
+	stored, err := GetGoal(db, goal.ID)
+	if err != nil {
+		t.Fatalf("Failed to get goal: %v", err)
+	}
+	if rate := stored.RequiredDailyRate(day(8)); rate != 1 {
+		t.Errorf("Expected 3 more days over 3 days left to need 1 a day, got %v", rate)
+	}
+
+	// Completing the goal congratulates the user and drops the deadline email that no longer applies
+	for _, d := range []int{3, 4, 5} {
+		createHabitLog(t, db, habit.ID, day(d), "done", nil)
+	}
+	if err := RecalculateGoalsForHabit(db, habit.ID, day(8)); err != nil {
+		t.Fatalf("RecalculateGoalsForHabit failed: %v", err)
+	}
+	if kinds := pending(); len(kinds) != 2 || kinds[0] != GoalNotificationDeadline || kinds[1] != GoalNotificationDone {
+		t.Errorf("Expected deadline and done notifications, got %v", kinds)
+	}
+
+	mock = NewMockEmailService()
+	if _, err := SendGoalNotifications(db, mock, day(8)); err != nil {
+		t.Fatalf("SendGoalNotifications failed: %v", err)
+	}
+	if !mock.sentEmails["testhabit@example.com-goal-completed"] || mock.sentEmails["testhabit@example.com-goal-deadline"] {
+		t.Errorf("Expected only the completion email, got %v", mock.sentEmails)
+	}
+	if kinds := pending(); len(kinds) != 0 {
+		t.Errorf("Expected nothing left to send, got %v", kinds)
+	}
+}
@@ -18,16 +18,17 @@ const (
 )
 
 type Habit struct {
-	ID            int            `json:"id"`
-	UserID        int            `json:"user_id"`
-	Name          string         `json:"name"`
-	Emoji         string         `json:"emoji"`
-	CreatedAt     time.Time      `json:"created_at"`
-	HabitType     HabitType      `json:"habit_type"`
-	IsDefault     bool           `json:"is_default"`
-	DisplayOrder  int            `json:"display_order"`
-	HabitOptions  sql.NullString `json:"habit_options"`
-	CurrentStreak int            `json:"current_streak"`
+	ID              int            `json:"id"`
+	UserID          int            `json:"user_id"`
+	Name            string         `json:"name"`
+	Emoji           string         `json:"emoji"`
+	CreatedAt       time.Time      `json:"created_at"`
+	HabitType       HabitType      `json:"habit_type"`
+	IsDefault       bool           `json:"is_default"`
+	DisplayOrder    int            `json:"display_order"`
+	HabitOptions    sql.NullString `json:"habit_options"`
+	CurrentStreak   int            `json:"current_streak"`
+	ReminderEnabled bool           `json:"reminder_enabled"` // listed in the user's daily reminders
 }
 
 type HabitOption struct {
@@ -91,8 +92,18 @@ func InitializeHabitsDB(db *sql.DB) error {
 	return err
 }
 
+// dbExecutor is a database or a transaction, so log writes can share a transaction with other changes
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 // CreateOrUpdate creates or updates a habit log based on habit type
 func (hl *HabitLog) CreateOrUpdate(db *sql.DB) error {
+	return hl.createOrUpdate(db)
+}
+
+func (hl *HabitLog) createOrUpdate(db dbExecutor) error {
 	// Get the habit type
 	var habitType HabitType
 	err := db.QueryRow("SELECT habit_type FROM habits WHERE id = ?", hl.HabitID).Scan(&habitType)
@@ -218,6 +229,10 @@ func (hl *HabitLog) CreateOrUpdate(db *sql.DB) error {
 			return fmt.Errorf("At least one set is required")
 		}
 
+		if !IsValidWeightUnit(setRepsValue.Unit) {
+			return fmt.Errorf("Unit must be kg or lbs")
+		}
+
 		// Insert new log with the set-reps value
 		result, err := db.Exec(`
 			INSERT INTO habit_logs (habit_id, date, status, value, created_at) 
@@ -306,10 +321,11 @@ func (h *Habit) Create(db *sql.DB) error {
 func GetHabitByID(db *sql.DB, id int) (*Habit, error) {
 	habit := &Habit{}
 	err := db.QueryRow(`
-		SELECT id, user_id, name, emoji, habit_type, is_default, created_at 
+		SELECT id, user_id, name, emoji, habit_type, is_default, created_at, reminder_enabled
 		FROM habits 
 		WHERE id = ?
-	`, id).Scan(&habit.ID, &habit.UserID, &habit.Name, &habit.Emoji, &habit.HabitType, &habit.IsDefault, &habit.CreatedAt)
+	`, id).Scan(&habit.ID, &habit.UserID, &habit.Name, &habit.Emoji, &habit.HabitType, &habit.IsDefault, &habit.CreatedAt,
+		&habit.ReminderEnabled)
 
 	if err != nil {
 		return nil, err
@@ -370,7 +386,8 @@ func HabitExists(db *sql.DB, name string, userID int) (bool, error) {
 func GetHabitsByUserID(db *sql.DB, userID int) ([]Habit, error) {
 	habits := []Habit{}
 	rows, err := db.Query(`
-		SELECT id, user_id, name, emoji, habit_type, is_default, created_at, display_order, habit_options
+		SELECT id, user_id, name, emoji, habit_type, is_default, created_at, display_order, habit_options,
+			reminder_enabled
 		FROM habits 
 		WHERE user_id = ?
 		ORDER BY display_order ASC
@@ -392,6 +409,7 @@ func GetHabitsByUserID(db *sql.DB, userID int) ([]Habit, error) {
 			&habit.CreatedAt,
 			&habit.DisplayOrder,
 			&habit.HabitOptions,
+			&habit.ReminderEnabled,
 		)
 		if err != nil {
 			return nil, err
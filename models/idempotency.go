@@ -0,0 +1,90 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// IdempotencyKeyTTL is how long a stored habit log response can be replayed
+const IdempotencyKeyTTL = 7 * 24 * time.Hour
+
+// GetHabitLogIdempotentResponse returns the stored response for a previously seen idempotency key. The response
+// is empty while the write that claimed the key is still in progress.
+func GetHabitLogIdempotentResponse(db *sql.DB, userID int, key string) (string, bool, error) {
+	var response string
+	err := db.QueryRow(`
+		SELECT response
+		FROM habit_log_idempotency_keys
+		WHERE user_id = ? AND idempotency_key = ?
+	`, userID, key).Scan(&response)
+
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return response, true, nil
+}
+
+// SaveHabitLogIdempotentResponse stores the response of a habit log write so replays return it unchanged. A
+// response already stored under the key is kept.
+func SaveHabitLogIdempotentResponse(db *sql.DB, userID int, key, response string) error {
+	_, err := db.Exec(`
+		INSERT INTO habit_log_idempotency_keys (user_id, idempotency_key, response, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id, idempotency_key) DO UPDATE SET response = excluded.response
+		WHERE habit_log_idempotency_keys.response = ''
+	`, userID, key, response)
+	return err
+}
+
+// CreateOrUpdateOnce saves the log unless the idempotency key has been used before, claiming the key in the
+// same transaction so concurrent replays of one request can't both apply it. When the key was already claimed
+// it returns false with the response stored for it, which is empty while that write is still in progress.
+func (hl *HabitLog) CreateOrUpdateOnce(db *sql.DB, userID int, key string) (bool, string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, "", fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT OR IGNORE INTO habit_log_idempotency_keys (user_id, idempotency_key, response, created_at)
+		VALUES (?, ?, '', CURRENT_TIMESTAMP)
+	`, userID, key)
+	if err != nil {
+		return false, "", fmt.Errorf("error claiming idempotency key: %v", err)
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return false, "", fmt.Errorf("error claiming idempotency key: %v", err)
+	}
+	if claimed == 0 {
+		tx.Rollback()
+		response, _, err := GetHabitLogIdempotentResponse(db, userID, key)
+		return false, response, err
+	}
+
+	if err := hl.createOrUpdate(tx); err != nil {
+		return false, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, "", fmt.Errorf("error committing habit log: %v", err)
+	}
+	return true, "", nil
+}
+
+// PurgeExpiredIdempotencyKeys removes idempotency keys older than IdempotencyKeyTTL
+func PurgeExpiredIdempotencyKeys(db *sql.DB) (int64, error) {
+	cutoff := time.Now().UTC().Add(-IdempotencyKeyTTL).Format("2006-01-02 15:04:05")
+	result, err := db.Exec(`
+		DELETE FROM habit_log_idempotency_keys
+		WHERE created_at < ?
+	`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
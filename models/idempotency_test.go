@@ -0,0 +1,99 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// TestHabitLogIdempotencyKeys tests storing, replaying and purging idempotency keys
+func TestHabitLogIdempotencyKeys(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db)
+	userID := int(user.ID)
+
+	// Unknown key
+	_, found, err := GetHabitLogIdempotentResponse(db, userID, "key-1")
+	if err != nil {
+		t.Fatalf("Failed to look up key: %v", err)
+	}
+	if found {
+		t.Error("Expected unknown key to not be found")
+	}
+
+	// Store and replay
+	if err := SaveHabitLogIdempotentResponse(db, userID, "key-1", `{"success":true}`); err != nil {
+		t.Fatalf("Failed to save key: %v", err)
+	}
+	response, found, err := GetHabitLogIdempotentResponse(db, userID, "key-1")
+	if err != nil {
+		t.Fatalf("Failed to look up key: %v", err)
+	}
+	if !found || response != `{"success":true}` {
+		t.Errorf("Expected stored response, got found=%v response=%q", found, response)
+	}
+
+	// A second save with the same key keeps the original response
+	if err := SaveHabitLogIdempotentResponse(db, userID, "key-1", `{"success":false}`); err != nil {
+		t.Fatalf("Failed to save duplicate key: %v", err)
+	}
+	response, _, _ = GetHabitLogIdempotentResponse(db, userID, "key-1")
+	if response != `{"success":true}` {
+		t.Errorf("Expected original response to be kept, got %q", response)
+	}
+
+	// Keys are scoped per user
+	_, found, _ = GetHabitLogIdempotentResponse(db, userID+1, "key-1")
+	if found {
+		t.Error("Expected key to be scoped to its user")
+	}
+
+	// Expired keys are purged
+	if _, err := db.Exec(`UPDATE habit_log_idempotency_keys SET created_at = '2000-01-01 00:00:00'`); err != nil {
+		t.Fatalf("Failed to age key: %v", err)
+	}
+	purged, err := PurgeExpiredIdempotencyKeys(db)
+	if err != nil {
+		t.Fatalf("Failed to purge keys: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("Expected 1 purged key, got %d", purged)
+	}
+}
+
+// TestHabitLogCreateOrUpdateOnce tests that a replayed write is applied once
+func TestHabitLogCreateOrUpdateOnce(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	user := createTestUser(t, db)
+	userID := int(user.ID)
+	habitID := int(createTestHabit(t, db, user.ID))
+	date := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+
+	claimed, _, err := (&HabitLog{HabitID: habitID, Date: date, Status: "done"}).CreateOrUpdateOnce(db, userID, "key-1")
+	if err != nil || !claimed {
+		t.Fatalf("Expected the first write to claim the key, got claimed=%v err=%v", claimed, err)
+	}
+
+	// A replay before the response is stored is told the write is in progress, and changes nothing
+	claimed, stored, err := (&HabitLog{HabitID: habitID, Date: date, Status: "missed"}).CreateOrUpdateOnce(db, userID, "key-1")
+	if err != nil || claimed || stored != "" {
+		t.Fatalf("Expected the replay not to claim the key, got claimed=%v stored=%q err=%v", claimed, stored, err)
+	}
+	var status string
+	if err := db.QueryRow("SELECT status FROM habit_logs WHERE habit_id = ?", habitID).Scan(&status); err != nil || status != "done" {
+		t.Errorf("Expected the first write to stand, got %q (%v)", status, err)
+	}
+
+	// Once the response is stored, replays get it back
+	if err := SaveHabitLogIdempotentResponse(db, userID, "key-1", `{"success":true}`); err != nil {
+		t.Fatalf("Failed to save response: %v", err)
+	}
+	_, stored, _ = (&HabitLog{HabitID: habitID, Date: date, Status: "missed"}).CreateOrUpdateOnce(db, userID, "key-1")
+	if stored != `{"success":true}` {
+		t.Errorf("Expected the stored response, got %q", stored)
+	}
+}
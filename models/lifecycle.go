@@ -0,0 +1,474 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"mad/models/email"
+)
+
+// lifecycleTimeLayout is how lifecycle times are stored and how SQLite's datetime() formats them
+const lifecycleTimeLayout = "2006-01-02 15:04:05"
+
+// lifecycleLookback is how recent a trigger event has to be. Older events, such as goals completed before a
+// campaign was added, don't start campaigns.
+const lifecycleLookback = 30 * 24 * time.Hour
+
+var (
+	courseModulesMu sync.RWMutex
+	courseModules   = map[string]int{}
+)
+
+// SetCourseModules tells the lifecycle triggers how many lessons each masterclass module has, by module slug,
+// so they can tell when a module is finished
+func SetCourseModules(lessons map[string]int) {
+	courseModulesMu.Lock()
+	defer courseModulesMu.Unlock()
+	courseModules = lessons
+}
+
+// lifecycleMatch is a user whose activity matches a campaign trigger, and when it started matching
+type lifecycleMatch struct {
+	UserID  int64
+	Email   string
+	EventAt time.Time
+}
+
+// SegmentMember is a user in a segment
+type SegmentMember struct {
+	UserID        int64
+	Email         string
+	FirstName     string
+	EmailVerified bool
+}
+
+// RunLifecycleTriggers enrolls users in the triggered campaigns whose trigger matches their activity and who are
+// in the campaign's segment. Each user is enrolled once per event, and again only after the campaign's repeat
+// interval. It returns the number of users enrolled.
+func RunLifecycleTriggers(db *sql.DB, now time.Time) (int, error) {
+	now = now.UTC()
+	cm := email.NewCampaignManager(db, nil)
+	enrolled := 0
+	for _, campaign := range email.GetTriggeredCampaigns() {
+		matches, err := triggerMatches(db, *campaign.Trigger, now)
+		if err != nil {
+			return enrolled, fmt.Errorf("error evaluating trigger for campaign %s: %v", campaign.ID, err)
+		}
+		if len(matches) == 0 {
+			continue
+		}
+
+		var members map[int64]bool
+		if campaign.Segment != "" && campaign.Segment != email.SegmentAll {
+			segment, err := email.GetSegment(campaign.Segment)
+			if err != nil {
+				return enrolled, err
+			}
+			inSegment, err := GetSegmentMembers(db, segment, now)
+			if err != nil {
+				return enrolled, err
+			}
+			members = map[int64]bool{}
+			for _, member := range inSegment {
+				members[member.UserID] = true
+			}
+		}
+
+		for _, match := range matches {
+			if members != nil && !members[match.UserID] {
+				continue
+			}
+			due, err := triggerDue(db, match, campaign, now)
+			if err != nil {
+				return enrolled, err
+			}
+			if !due {
+				continue
+			}
+
+			ok, err := cm.EnrollUser(match.Email, campaign.ID, int(match.UserID))
+			if err != nil {
+				log.Printf("Error enrolling %s in campaign %s: %v", match.Email, campaign.ID, err)
+				continue
+			}
+			_, err = db.Exec(`
+				INSERT INTO lifecycle_triggers (user_id, campaign_id, event_at, triggered_at, enrolled)
+				VALUES (?, ?, ?, ?, ?)`,
+				match.UserID, campaign.ID, match.EventAt.Format(lifecycleTimeLayout), now.Format(lifecycleTimeLayout), ok)
+			if err != nil {
+				return enrolled, fmt.Errorf("error recording lifecycle trigger: %v", err)
+			}
+			if ok {
+				enrolled++
+			}
+		}
+	}
+	return enrolled, nil
+}
+
+// triggerDue reports whether a match should start the campaign: the user hasn't been triggered for it yet,
+// or the event happened since the last time and the repeat interval has passed
+func triggerDue(db *sql.DB, match lifecycleMatch, campaign email.EmailCampaign, now time.Time) (bool, error) {
+	var last sql.NullString
+	err := db.QueryRow(`
+		SELECT MAX(triggered_at) FROM lifecycle_triggers WHERE user_id = ? AND campaign_id = ?`,
+		match.UserID, campaign.ID).Scan(&last)
+	if err != nil {
+		return false, fmt.Errorf("error getting last lifecycle trigger: %v", err)
+	}
+	if !last.Valid {
+		return true, nil
+	}
+	if campaign.Trigger.RepeatAfterDays == 0 {
+		return false, nil
+	}
+
+	lastAt, err := time.Parse(lifecycleTimeLayout, last.String)
+	if err != nil {
+		return false, fmt.Errorf("error parsing lifecycle trigger time: %v", err)
+	}
+	repeatAfter := time.Duration(campaign.Trigger.RepeatAfterDays) * 24 * time.Hour
+	return match.EventAt.After(lastAt) && now.Sub(lastAt) >= repeatAfter, nil
+}
+
+// triggerMatches returns the users whose activity matches the trigger, with events in the lookback window
+func triggerMatches(db *sql.DB, trigger email.CampaignTrigger, now time.Time) ([]lifecycleMatch, error) {
+	days := fmt.Sprintf("+%d days", trigger.Days)
+
+	var matches []lifecycleMatch
+	var err error
+	switch trigger.Event {
+	case email.TriggerInactive:
+		// Inactive since the day after the last log; users who never logged anything are left to no_habits
+		matches, err = queryLifecycleMatches(db, `
+			SELECT u.id, u.email, datetime(MAX(date(l.date)), ?) AS event_at
+			FROM users u
+			JOIN habits h ON h.user_id = u.id
+			JOIN habit_logs l ON l.habit_id = h.id
+			WHERE l.status IN ('done', 'skipped')
+			GROUP BY u.id`, days)
+	case email.TriggerNoHabits:
+		matches, err = queryLifecycleMatches(db, `
+			SELECT u.id, u.email, datetime(u.created_at, ?) AS event_at
+			FROM users u
+			WHERE NOT EXISTS (SELECT 1 FROM habits h WHERE h.user_id = u.id)`, days)
+	case email.TriggerGoalCompleted:
+		matches, err = queryLifecycleMatches(db, `
+			SELECT u.id, u.email, MAX(datetime(n.created_at)) AS event_at
+			FROM goal_notifications n
+			JOIN goals g ON g.id = n.goal_id
+			JOIN users u ON u.id = g.user_id
+			WHERE n.kind = 'done'
+			GROUP BY u.id`)
+	case email.TriggerModuleFinished:
+		matches, err = moduleFinishedMatches(db, trigger.Module)
+	case email.TriggerStreak:
+		matches, err = streakMatches(db, trigger.Days, now)
+	default:
+		return nil, fmt.Errorf("unknown trigger event %q", trigger.Event)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	since := now.Add(-lifecycleLookback)
+	recent := matches[:0]
+	for _, match := range matches {
+		if !match.EventAt.After(now) && match.EventAt.After(since) {
+			recent = append(recent, match)
+		}
+	}
+	return recent, nil
+}
+
+// queryLifecycleMatches runs a query selecting user ID, email and event time
+func queryLifecycleMatches(db *sql.DB, query string, args ...interface{}) ([]lifecycleMatch, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []lifecycleMatch
+	for rows.Next() {
+		var match lifecycleMatch
+		var eventAt sql.NullString
+		if err := rows.Scan(&match.UserID, &match.Email, &eventAt); err != nil {
+			return nil, err
+		}
+		if !eventAt.Valid {
+			continue
+		}
+		if match.EventAt, err = time.Parse(lifecycleTimeLayout, eventAt.String); err != nil {
+			return nil, err
+		}
+		matches = append(matches, match)
+	}
+	return matches, rows.Err()
+}
+
+// moduleFinishedMatches returns users who completed every lesson of a module, or of the given module, with
+// the time of the last lesson
+func moduleFinishedMatches(db *sql.DB, module string) ([]lifecycleMatch, error) {
+	rows, err := db.Query(`
+		SELECT u.id, u.email, c.module_id, COUNT(*), MAX(datetime(c.completed_at))
+		FROM user_lesson_completion c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.completed = true AND c.completed_at IS NOT NULL
+		GROUP BY u.id, c.module_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	courseModulesMu.RLock()
+	defer courseModulesMu.RUnlock()
+
+	latest := map[int64]lifecycleMatch{}
+	var order []int64
+	for rows.Next() {
+		var match lifecycleMatch
+		var moduleID, completedAt string
+		var completed int
+		if err := rows.Scan(&match.UserID, &match.Email, &moduleID, &completed, &completedAt); err != nil {
+			return nil, err
+		}
+		lessons, known := courseModules[moduleID]
+		if !known || completed < lessons || (module != "" && moduleID != module) {
+			continue
+		}
+		if match.EventAt, err = time.Parse(lifecycleTimeLayout, completedAt); err != nil {
+			return nil, err
+		}
+		previous, seen := latest[match.UserID]
+		if !seen {
+			order = append(order, match.UserID)
+		}
+		if !seen || match.EventAt.After(previous.EventAt) {
+			latest[match.UserID] = match
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	matches := make([]lifecycleMatch, 0, len(order))
+	for _, userID := range order {
+		matches = append(matches, latest[userID])
+	}
+	return matches, nil
+}
+
+// streakMatches returns users with a habit whose current streak is at least the given days, with the day the
+// streak reached it
+func streakMatches(db *sql.DB, days int, now time.Time) ([]lifecycleMatch, error) {
+	// Only habits logged on enough of the recent days can have a streak that long
+	rows, err := db.Query(`
+		SELECT h.id, u.id, u.email
+		FROM habits h
+		JOIN users u ON u.id = h.user_id
+		WHERE (
+			SELECT COUNT(DISTINCT date(l.date))
+			FROM habit_logs l
+			WHERE l.habit_id = h.id
+			AND l.status IN ('done', 'skipped')
+			AND date(l.date) >= date(?, ?)
+		) >= ?`,
+		now.Format(lifecycleTimeLayout), fmt.Sprintf("-%d days", days), days)
+	if err != nil {
+		return nil, err
+	}
+	type candidate struct {
+		habit Habit
+		match lifecycleMatch
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.habit.ID, &c.match.UserID, &c.match.Email); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	today := now.Truncate(24 * time.Hour)
+	latest := map[int64]int{}
+	var matches []lifecycleMatch
+	for _, c := range candidates {
+		if err := c.habit.CalculateCurrentStreak(db); err != nil {
+			return nil, err
+		}
+		if c.habit.CurrentStreak < days {
+			continue
+		}
+		c.match.EventAt = today.AddDate(0, 0, days-c.habit.CurrentStreak)
+		if i, seen := latest[c.match.UserID]; seen {
+			if c.match.EventAt.After(matches[i].EventAt) {
+				matches[i] = c.match
+			}
+			continue
+		}
+		latest[c.match.UserID] = len(matches)
+		matches = append(matches, c.match)
+	}
+	return matches, nil
+}
+
+// GetSegmentMembers returns the users matching every rule of the segment
+func GetSegmentMembers(db *sql.DB, segment email.Segment, now time.Time) ([]SegmentMember, error) {
+	nowValue := now.UTC().Format(lifecycleTimeLayout)
+	daysAgo := func(days int) string { return fmt.Sprintf("-%d days", days) }
+	loggedSince := `EXISTS (
+		SELECT 1 FROM habits h
+		JOIN habit_logs l ON l.habit_id = h.id
+		WHERE h.user_id = u.id
+		AND l.status IN ('done', 'skipped')
+		AND date(l.date) > date(?, ?)
+	)`
+
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+	r := segment.Rules
+	if r.NotificationsEnabled != nil {
+		conditions = append(conditions, "u.notification_enabled = ?")
+		args = append(args, *r.NotificationsEnabled)
+	}
+	if r.HasHabits != nil {
+		condition := "EXISTS (SELECT 1 FROM habits h WHERE h.user_id = u.id)"
+		if !*r.HasHabits {
+			condition = "NOT " + condition
+		}
+		conditions = append(conditions, condition)
+	}
+	if r.ActiveWithinDays > 0 {
+		conditions = append(conditions, loggedSince)
+		args = append(args, nowValue, daysAgo(r.ActiveWithinDays))
+	}
+	if r.InactiveDays > 0 {
+		conditions = append(conditions, "NOT "+loggedSince)
+		args = append(args, nowValue, daysAgo(r.InactiveDays))
+	}
+	if r.SignedUpWithinDays > 0 {
+		conditions = append(conditions, "datetime(u.created_at) > datetime(?, ?)")
+		args = append(args, nowValue, daysAgo(r.SignedUpWithinDays))
+	}
+	if r.SignedUpBeforeDays > 0 {
+		conditions = append(conditions, "datetime(u.created_at) <= datetime(?, ?)")
+		args = append(args, nowValue, daysAgo(r.SignedUpBeforeDays))
+	}
+	if r.SubscribedTo != "" {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM email_subscriptions s
+			WHERE s.user_id = u.id AND s.campaign_id = ? AND s.status = 'active'
+		)`)
+		args = append(args, r.SubscribedTo)
+	}
+	if r.HasCourse != "" {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM user_course_access a
+			WHERE a.user_id = u.id AND a.course_id = ? AND a.status = 'active'
+		)`)
+		args = append(args, r.HasCourse)
+	}
+
+	rows, err := db.Query(`
+		SELECT u.id, u.email, u.first_name, u.email_verified
+		FROM users u
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY u.id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting members of segment %s: %v", segment.ID, err)
+	}
+	defer rows.Close()
+
+	members := []SegmentMember{}
+	for rows.Next() {
+		var member SegmentMember
+		if err := rows.Scan(&member.UserID, &member.Email, &member.FirstName, &member.EmailVerified); err != nil {
+			return nil, fmt.Errorf("error scanning segment member: %v", err)
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// SubscribeSegment enrolls every member of a segment in a campaign, leaving out anyone who unsubscribed from it
+// or is part way through it. It returns the number of users enrolled.
+func SubscribeSegment(db *sql.DB, segmentID, campaignID string, now time.Time) (int, error) {
+	segment, err := email.GetSegment(segmentID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := email.GetCampaign(campaignID); err != nil {
+		return 0, err
+	}
+	members, err := GetSegmentMembers(db, segment, now)
+	if err != nil {
+		return 0, err
+	}
+
+	cm := email.NewCampaignManager(db, nil)
+	enrolled := 0
+	for _, member := range members {
+		ok, err := cm.EnrollUser(member.Email, campaignID, int(member.UserID))
+		if err != nil {
+			return enrolled, fmt.Errorf("error enrolling %s: %v", member.Email, err)
+		}
+		if ok {
+			enrolled++
+		}
+	}
+	return enrolled, nil
+}
+
+// SegmentSummary is a segment with its current size, for the admin dashboard
+type SegmentSummary struct {
+	email.Segment
+	Members int
+}
+
+// GetSegmentSummaries returns every segment with its current number of members
+func GetSegmentSummaries(db *sql.DB, now time.Time) ([]SegmentSummary, error) {
+	var summaries []SegmentSummary
+	for _, segment := range email.GetSegments() {
+		members, err := GetSegmentMembers(db, segment, now)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, SegmentSummary{Segment: segment, Members: len(members)})
+	}
+	return summaries, nil
+}
+
+// TriggeredCampaignSummary is a triggered campaign with how many users it started for recently
+type TriggeredCampaignSummary struct {
+	email.EmailCampaign
+	Enrolled30d int
+}
+
+// GetTriggeredCampaignSummaries returns the triggered campaigns with the users enrolled in the last 30 days
+func GetTriggeredCampaignSummaries(db *sql.DB, now time.Time) ([]TriggeredCampaignSummary, error) {
+	since := now.UTC().AddDate(0, 0, -30).Format(lifecycleTimeLayout)
+	var summaries []TriggeredCampaignSummary
+	for _, campaign := range email.GetTriggeredCampaigns() {
+		summary := TriggeredCampaignSummary{EmailCampaign: campaign}
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM lifecycle_triggers
+			WHERE campaign_id = ? AND enrolled = true AND triggered_at > ?`,
+			campaign.ID, since).Scan(&summary.Enrolled30d)
+		if err != nil {
+			return nil, fmt.Errorf("error counting lifecycle triggers: %v", err)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
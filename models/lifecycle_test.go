@@ -0,0 +1,223 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mad/models/email"
+)
+
+func TestLifecycleTriggers(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("lifecycle.yaml", "frequency_cap:\n  emails: 1\n  days: 7\nsegments:\n  - id: notified\n    name: Notified\n    rules:\n      notifications_enabled: true\n")
+	campaigns := map[string]string{
+		"win-back":    "trigger:\n  event: inactive\n  days: 7\n  repeat_after_days: 30\nsegment: notified\n",
+		"first-habit": "trigger:\n  event: no_habits\n  days: 2\n",
+		"streak":      "trigger:\n  event: streak\n  days: 3\n",
+		"module":      "trigger:\n  event: module_finished\n",
+		"goal":        "trigger:\n  event: goal_completed\n",
+	}
+	for id, trigger := range campaigns {
+		write("courses/"+id+"/campaign.yaml", "name: "+id+"\n"+trigger)
+		write("courses/"+id+"/1-hello.md", "---\nnumber: 1\nsubject: Hello\n---\nHi {{.FirstName}}\n")
+	}
+	if err := email.LoadCampaigns(dir); err != nil {
+		t.Fatalf("LoadCampaigns failed: %v", err)
+	}
+	t.Cleanup(func() { email.LoadCampaigns("../ui/email") })
+	SetCourseModules(map[string]int{"welcome": 2})
+
+	now := time.Now()
+	user := func(name string, notifications bool, signedUp time.Time) int64 {
+		t.Helper()
+		userID := createTestUserForHabits(t, db, name)
+		_, err := db.Exec("UPDATE users SET notification_enabled = ?, created_at = ? WHERE id = ?",
+			notifications, signedUp.UTC().Format(lifecycleTimeLayout), userID)
+		if err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		return userID
+	}
+	lapsed := user("lapsed", true, now.AddDate(0, -2, 0))
+	quiet := user("quiet", false, now.AddDate(0, -2, 0))
+	user("new", true, now.AddDate(0, 0, -3))
+	user("newest", true, now)
+	streaker := user("streaker", true, now.AddDate(0, -1, 0))
+	student := user("student", true, now)
+	achiever := user("achiever", true, now.AddDate(0, -1, 0))
+
+	lapsedHabit := createTestHabitForTests(t, db, lapsed, BinaryHabit, "Read")
+	createHabitLog(t, db, lapsedHabit.ID, now.AddDate(0, 0, -10), "done", nil)
+	quietHabit := createTestHabitForTests(t, db, quiet, BinaryHabit, "Read")
+	createHabitLog(t, db, quietHabit.ID, now.AddDate(0, 0, -10), "done", nil)
+	streakHabit := createTestHabitForTests(t, db, streaker, BinaryHabit, "Run")
+	for day := 0; day < 3; day++ {
+		createHabitLog(t, db, streakHabit.ID, now.AddDate(0, 0, -day), "done", nil)
+	}
+	for _, lesson := range []string{"getting-started", "course-overview"} {
+		_, err := db.Exec(`
+			INSERT INTO user_lesson_completion (user_id, lesson_id, module_id, completed, completed_at)
+			VALUES (?, ?, 'welcome', true, CURRENT_TIMESTAMP)`, student, lesson)
+		if err != nil {
+			t.Fatalf("Failed to complete lesson: %v", err)
+		}
+	}
+	goalHabit := createTestHabitForTests(t, db, achiever, BinaryHabit, "Walk")
+	result, err := db.Exec(`
+		INSERT INTO goals (user_id, habit_id, name, start_date, end_date, target_number, position, status)
+		VALUES (?, ?, 'Walk 10 times', '2026-01-01', '2026-12-31', 10, 0, 'done')`, achiever, goalHabit.ID)
+	if err != nil {
+		t.Fatalf("Failed to create goal: %v", err)
+	}
+	goalID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO goal_notifications (goal_id, kind, period_end) VALUES (?, 'done', '2026-12-31')", goalID); err != nil {
+		t.Fatalf("Failed to record goal completion: %v", err)
+	}
+
+	subscriptions := func(t *testing.T) map[string]string {
+		t.Helper()
+		rows, err := db.Query("SELECT email, campaign_id FROM email_subscriptions WHERE status = 'active' ORDER BY id")
+		if err != nil {
+			t.Fatalf("Failed to get subscriptions: %v", err)
+		}
+		defer rows.Close()
+		subscribed := map[string]string{}
+		for rows.Next() {
+			var address, campaignID string
+			rows.Scan(&address, &campaignID)
+			subscribed[address] += campaignID + " "
+		}
+		return subscribed
+	}
+
+	// Each trigger enrolls the users whose activity matches it, within the campaign's segment
+	t.Run("triggers enroll matching users", func(t *testing.T) {
+		enrolled, err := RunLifecycleTriggers(db, now.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("RunLifecycleTriggers failed: %v", err)
+		}
+		want := map[string]string{
+			"testhabitlapsed@example.com":   "win-back ",
+			"testhabitnew@example.com":      "first-habit ",
+			"testhabitstreaker@example.com": "streak ",
+			"testhabitstudent@example.com":  "module ",
+			"testhabitachiever@example.com": "goal ",
+		}
+		if got := subscriptions(t); enrolled != 5 || len(got) != len(want) {
+			t.Fatalf("Expected 5 enrollments %v, got %d: %v", want, enrolled, got)
+		} else {
+			for address, campaignIDs := range want {
+				if got[address] != campaignIDs {
+					t.Errorf("Expected %s in %q, got %q", address, campaignIDs, got[address])
+				}
+			}
+		}
+
+		// Running again doesn't enroll anyone twice
+		if enrolled, err := RunLifecycleTriggers(db, now.Add(time.Hour)); err != nil || enrolled != 0 {
+			t.Errorf("Expected no enrollments on the second run, got %d (%v)", enrolled, err)
+		}
+
+	})
+
+	// Segments select users by their rules, and enrolling one leaves out anyone who unsubscribed or is part way
+	t.Run("segments leave out unsubscribed users", func(t *testing.T) {
+		segment, err := email.GetSegment("notified")
+		if err != nil {
+			t.Fatalf("GetSegment failed: %v", err)
+		}
+		members, err := GetSegmentMembers(db, segment, now)
+		if err != nil || len(members) != 6 {
+			t.Fatalf("Expected 6 members of the segment, got %d (%v)", len(members), err)
+		}
+		for _, member := range members {
+			if member.UserID == quiet {
+				t.Error("Expected users with notifications off to be left out")
+			}
+		}
+		cm := email.NewCampaignManager(db, nil)
+		cm.SubscribeUser("testhabitstreaker@example.com", "goal", int(streaker))
+		cm.UnsubscribeUser("testhabitstreaker@example.com", "goal")
+		if enrolled, err := SubscribeSegment(db, "notified", "goal", now); err != nil || enrolled != 4 {
+			t.Errorf("Expected 4 users enrolled from the segment, got %d (%v)", enrolled, err)
+		}
+		if got := subscriptions(t)["testhabitstreaker@example.com"]; got != "streak " {
+			t.Errorf("Expected the unsubscribed user to stay unsubscribed, got %q", got)
+		}
+
+	})
+
+	// Repeatable triggers start a finished campaign over once the user lapses again after the repeat interval
+	t.Run("repeatable triggers start over", func(t *testing.T) {
+		later := now.AddDate(0, 0, 31)
+		createHabitLog(t, db, lapsedHabit.ID, now.AddDate(0, 0, 20), "done", nil)
+		db.Exec("UPDATE email_subscriptions SET last_email_sent = 1 WHERE campaign_id = 'win-back'")
+		enrolled, err := RunLifecycleTriggers(db, later)
+		if err != nil {
+			t.Fatalf("RunLifecycleTriggers failed: %v", err)
+		}
+		// lapsed restarts win-back, streaker has lapsed since, and newest and student have now had no habits for 2 days
+		if enrolled != 4 {
+			t.Errorf("Expected 4 enrollments after the repeat interval, got %d: %v", enrolled, subscriptions(t))
+		}
+		var lastEmailSent int
+		db.QueryRow("SELECT last_email_sent FROM email_subscriptions WHERE user_id = ? AND campaign_id = 'win-back'", lapsed).Scan(&lastEmailSent)
+		if lastEmailSent != 0 {
+			t.Errorf("Expected the win-back campaign to start over, got last email %d", lastEmailSent)
+		}
+		var triggers int
+		db.QueryRow("SELECT COUNT(*) FROM lifecycle_triggers WHERE user_id = ?", achiever).Scan(&triggers)
+		if triggers != 1 {
+			t.Errorf("Expected the goal trigger once, got %d", triggers)
+		}
+
+	})
+
+	// The frequency cap holds back campaign emails over the limit across all campaigns
+	t.Run("the frequency cap holds back emails", func(t *testing.T) {
+		mockEmail := NewMockEmailService()
+		if err := email.NewCampaignManager(db, mockEmail).SendPendingCampaignEmails(); err != nil {
+			t.Fatalf("SendPendingCampaignEmails failed: %v", err)
+		}
+		rows, err := db.Query(`
+		SELECT s.email, COUNT(*)
+		FROM email_sends es
+		JOIN email_subscriptions s ON s.id = es.subscription_id
+		GROUP BY s.email`)
+		if err != nil {
+			t.Fatalf("Failed to count sends: %v", err)
+		}
+		sent := map[string]int{}
+		for rows.Next() {
+			var address string
+			var count int
+			rows.Scan(&address, &count)
+			sent[address] = count
+		}
+		rows.Close()
+		if sent["testhabitlapsed@example.com"] != 1 || len(sent) != 6 {
+			t.Errorf("Expected one email to each of the 6 subscribed addresses, got %v", sent)
+		}
+		for address, count := range sent {
+			if count > 1 {
+				t.Errorf("Expected at most 1 email to %s, got %d", address, count)
+			}
+		}
+	})
+}
@@ -0,0 +1,455 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"mad/models/email"
+)
+
+// Notification types a user can turn on or off per channel
+const (
+	NotificationReminder     = "reminder"     // the daily reminders the user picked
+	NotificationStreakNudge  = "streak_nudge" // the late evening nudge for long streaks
+	NotificationGoal         = "goal"         // goals falling behind, nearing their deadline or completed
+	NotificationAnnouncement = "announcement" // one-off broadcasts from the admins
+)
+
+// Notification channels
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// Digest frequencies
+const (
+	DigestOff     = "off"
+	DigestWeekly  = "weekly"
+	DigestMonthly = "monthly"
+)
+
+// NotificationType describes a notification type for the preference pages
+type NotificationType struct {
+	Key         string `json:"key"`
+	Label       string `json:"label"`
+	Description string `json:"description"`
+}
+
+// NotificationTypes lists the notification types in display order
+var NotificationTypes = []NotificationType{
+	{NotificationReminder, "📬 Daily Reminders", "The habits you haven't logged yet, at the times you picked"},
+	{NotificationStreakNudge, "🔥 Streak Nudges", "A late evening nudge when a long streak is about to end"},
+	{NotificationGoal, "🎯 Goal Updates", "When a goal falls behind, is about to end or is complete"},
+	{NotificationAnnouncement, "📣 Announcements", "Occasional news about the app from the team"},
+}
+
+// NotificationChannels lists the channels in display order
+var NotificationChannels = []string{ChannelEmail, ChannelWebhook}
+
+// NotificationPreferences are what a user wants to hear about, how and when. Enabled is the master switch
+// (users.notification_enabled); when it is off nothing is sent on any channel.
+type NotificationPreferences struct {
+	UserID          int                        `json:"-"`
+	Enabled         bool                       `json:"enabled"`
+	Channels        map[string]map[string]bool `json:"channels"`          // type -> channel -> enabled
+	QuietHoursStart string                     `json:"quiet_hours_start"` // HH:MM in the user's timezone, empty for none
+	QuietHoursEnd   string                     `json:"quiet_hours_end"`
+	DigestFrequency string                     `json:"digest_frequency"`
+	WebhookURL      string                     `json:"webhook_url"`
+	Timezone        string                     `json:"timezone"`
+}
+
+// defaultChannelEnabled is the preference when the user hasn't chosen: email on, everything else off
+func defaultChannelEnabled(channel string) bool {
+	return channel == ChannelEmail
+}
+
+func validNotificationType(kind string) bool {
+	for _, t := range NotificationTypes {
+		if t.Key == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func validNotificationChannel(channel string) bool {
+	for _, c := range NotificationChannels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// GetNotificationPreferences returns the user's preferences, with defaults for anything not chosen yet
+func GetNotificationPreferences(db *sql.DB, userID int) (*NotificationPreferences, error) {
+	p := &NotificationPreferences{UserID: userID, Channels: map[string]map[string]bool{}}
+	err := db.QueryRow(`
+		SELECT notification_enabled, quiet_hours_start, quiet_hours_end, digest_frequency, webhook_url, timezone
+		FROM users
+		WHERE id = ?`,
+		userID,
+	).Scan(&p.Enabled, &p.QuietHoursStart, &p.QuietHoursEnd, &p.DigestFrequency, &p.WebhookURL, &p.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("error getting notification preferences: %v", err)
+	}
+
+	for _, t := range NotificationTypes {
+		p.Channels[t.Key] = map[string]bool{}
+		for _, c := range NotificationChannels {
+			p.Channels[t.Key][c] = defaultChannelEnabled(c)
+		}
+	}
+
+	rows, err := db.Query("SELECT type, channel, enabled FROM notification_preferences WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting notification preferences: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind, channel string
+		var enabled bool
+		if err := rows.Scan(&kind, &channel, &enabled); err != nil {
+			return nil, err
+		}
+		if validNotificationType(kind) && validNotificationChannel(channel) {
+			p.Channels[kind][channel] = enabled
+		}
+	}
+	return p, rows.Err()
+}
+
+// Validate checks the quiet hours, digest frequency and webhook URL
+func (p *NotificationPreferences) Validate() error {
+	if (p.QuietHoursStart == "") != (p.QuietHoursEnd == "") {
+		return fmt.Errorf("quiet hours need both a start and an end")
+	}
+	for _, t := range []string{p.QuietHoursStart, p.QuietHoursEnd} {
+		if t == "" {
+			continue
+		}
+		if _, err := time.Parse("15:04", t); err != nil {
+			return fmt.Errorf("invalid quiet hours time %q, expected HH:MM", t)
+		}
+	}
+
+	switch p.DigestFrequency {
+	case DigestOff, DigestWeekly, DigestMonthly:
+	default:
+		return fmt.Errorf("invalid digest frequency: %s", p.DigestFrequency)
+	}
+
+	if p.WebhookURL != "" {
+		u, err := url.Parse(p.WebhookURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("the webhook URL must be an https:// address")
+		}
+		if ip := net.ParseIP(u.Hostname()); u.Hostname() == "localhost" || (ip != nil && !publicIP(ip)) {
+			return fmt.Errorf("the webhook URL must be a public address")
+		}
+	}
+
+	for kind, channels := range p.Channels {
+		if !validNotificationType(kind) {
+			return fmt.Errorf("unknown notification type: %s", kind)
+		}
+		for channel := range channels {
+			if !validNotificationChannel(channel) {
+				return fmt.Errorf("unknown notification channel: %s", channel)
+			}
+		}
+	}
+	return nil
+}
+
+// Save validates and stores the preferences
+func (p *NotificationPreferences) Save(db *sql.DB) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET notification_enabled = ?, quiet_hours_start = ?, quiet_hours_end = ?, digest_frequency = ?,
+			webhook_url = ?
+		WHERE id = ?`,
+		p.Enabled, p.QuietHoursStart, p.QuietHoursEnd, p.DigestFrequency, p.WebhookURL, p.UserID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating notification preferences: %v", err)
+	}
+
+	for kind, channels := range p.Channels {
+		for channel, enabled := range channels {
+			_, err := tx.Exec(`
+				INSERT INTO notification_preferences (user_id, type, channel, enabled)
+				VALUES (?, ?, ?, ?)
+				ON CONFLICT (user_id, type, channel) DO UPDATE SET enabled = excluded.enabled`,
+				p.UserID, kind, channel, enabled,
+			)
+			if err != nil {
+				return fmt.Errorf("error updating notification preferences: %v", err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// SetNotificationPreference turns one notification type on or off for one channel
+func SetNotificationPreference(db *sql.DB, userID int, kind, channel string, enabled bool) error {
+	if !validNotificationType(kind) || !validNotificationChannel(channel) {
+		return fmt.Errorf("unknown notification preference: %s by %s", kind, channel)
+	}
+	_, err := db.Exec(`
+		INSERT INTO notification_preferences (user_id, type, channel, enabled)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, type, channel) DO UPDATE SET enabled = excluded.enabled`,
+		userID, kind, channel, enabled,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating notification preference: %v", err)
+	}
+	return nil
+}
+
+// Allows reports whether the user wants the notification type on the channel. The webhook channel also needs
+// a webhook URL.
+func (p *NotificationPreferences) Allows(kind, channel string) bool {
+	if !p.Enabled || !p.Channels[kind][channel] {
+		return false
+	}
+	return channel != ChannelWebhook || p.WebhookURL != ""
+}
+
+// InQuietHours reports whether now falls in the user's quiet hours, which may run past midnight
+func (p *NotificationPreferences) InQuietHours(now time.Time) bool {
+	if p.QuietHoursStart == "" || p.QuietHoursEnd == "" {
+		return false
+	}
+	start, err1 := time.Parse("15:04", p.QuietHoursStart)
+	end, err2 := time.Parse("15:04", p.QuietHoursEnd)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	if startMinute < endMinute {
+		return minute >= startMinute && minute < endMinute
+	}
+	if startMinute > endMinute {
+		return minute >= startMinute || minute < endMinute
+	}
+	return false
+}
+
+// PreferencesToken returns the token that lets the user manage their notifications from an email link
+// without logging in, creating it the first time
+func PreferencesToken(db *sql.DB, userID int) (string, error) {
+	var token string
+	err := db.QueryRow("SELECT COALESCE(preferences_token, '') FROM users WHERE id = ?", userID).Scan(&token)
+	if err != nil {
+		return "", fmt.Errorf("error getting preferences token: %v", err)
+	}
+	if token != "" {
+		return token, nil
+	}
+
+	token, err = generatePreferencesToken()
+	if err != nil {
+		return "", err
+	}
+	if _, err := db.Exec("UPDATE users SET preferences_token = ? WHERE id = ?", token, userID); err != nil {
+		return "", fmt.Errorf("error storing preferences token: %v", err)
+	}
+	return token, nil
+}
+
+func generatePreferencesToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating preferences token: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GetUserIDByPreferencesToken returns the user with the email address if the token is theirs
+func GetUserIDByPreferencesToken(db *sql.DB, emailAddr, token string) (int, error) {
+	var userID int
+	var stored string
+	err := db.QueryRow(`
+		SELECT id, COALESCE(preferences_token, '')
+		FROM users
+		WHERE email = ?`,
+		strings.ToLower(emailAddr),
+	).Scan(&userID, &stored)
+	if err != nil {
+		return 0, fmt.Errorf("invalid or expired link")
+	}
+	if stored == "" || subtle.ConstantTimeCompare([]byte(stored), []byte(token)) != 1 {
+		return 0, fmt.Errorf("invalid or expired link")
+	}
+	return userID, nil
+}
+
+// PreferencesLink returns the link to the user's public preference page for email footers, falling back to
+// the settings page
+func PreferencesLink(db *sql.DB, userID int, emailAddr string) string {
+	token, err := PreferencesToken(db, userID)
+	if err != nil {
+		log.Printf("Error getting preferences link for user %d: %v", userID, err)
+		return "https://habits.co/settings"
+	}
+	return email.GeneratePreferencesLink(emailAddr, token)
+}
+
+// backfillPreferencesTokens gives every user without a preferences token one
+func backfillPreferencesTokens(db *sql.DB) error {
+	rows, err := db.Query("SELECT id FROM users WHERE preferences_token IS NULL")
+	if err != nil {
+		return fmt.Errorf("error getting users without a preferences token: %v", err)
+	}
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range userIDs {
+		if _, err := PreferencesToken(db, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CampaignPreference is an email course the address is or was subscribed to
+type CampaignPreference struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Emoji      string `json:"emoji"`
+	Subscribed bool   `json:"subscribed"`
+}
+
+// GetCampaignPreferences returns the email courses the address has subscriptions for
+func GetCampaignPreferences(db *sql.DB, emailAddr string) ([]CampaignPreference, error) {
+	rows, err := db.Query(`
+		SELECT campaign_id, status = 'active'
+		FROM email_subscriptions
+		WHERE email = ?
+		ORDER BY campaign_id`,
+		strings.ToLower(emailAddr),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting campaign subscriptions: %v", err)
+	}
+	defer rows.Close()
+
+	campaigns := []CampaignPreference{}
+	for rows.Next() {
+		var c CampaignPreference
+		if err := rows.Scan(&c.ID, &c.Subscribed); err != nil {
+			return nil, err
+		}
+		campaign, err := email.GetCampaign(c.ID)
+		if err != nil {
+			continue // campaign no longer exists
+		}
+		c.Name = campaign.Name
+		c.Emoji = campaign.Emoji
+		campaigns = append(campaigns, c)
+	}
+	return campaigns, rows.Err()
+}
+
+// SetCampaignPreference subscribes or unsubscribes the address from an email course it has a subscription for
+func SetCampaignPreference(db *sql.DB, emailAddr, campaignID string, userID int, subscribed bool) error {
+	campaigns, err := GetCampaignPreferences(db, emailAddr)
+	if err != nil {
+		return err
+	}
+	for _, c := range campaigns {
+		if c.ID != campaignID {
+			continue
+		}
+		if c.Subscribed == subscribed {
+			return nil
+		}
+		cm := email.NewCampaignManager(db, nil)
+		if subscribed {
+			return cm.SubscribeUser(strings.ToLower(emailAddr), campaignID, userID)
+		}
+		return cm.UnsubscribeUser(strings.ToLower(emailAddr), campaignID)
+	}
+	return fmt.Errorf("no subscription to campaign %s", campaignID)
+}
+
+// UnsubscribeFromList takes the address off a list after a one-click unsubscribe: a campaign, or one type
+// of notification email. The digest list turns the digest off. Leaving a list the address isn't on is not an
+// error, since mail clients may post more than once.
+func UnsubscribeFromList(db *sql.DB, emailAddr, list string) error {
+	kind, id, err := email.ParseList(list)
+	if err != nil {
+		return err
+	}
+
+	if kind == email.ListCampaign {
+		campaigns, err := GetCampaignPreferences(db, emailAddr)
+		if err != nil {
+			return err
+		}
+		for _, c := range campaigns {
+			if c.ID == id && c.Subscribed {
+				return email.NewCampaignManager(db, nil).UnsubscribeUser(strings.ToLower(emailAddr), id)
+			}
+		}
+		return nil
+	}
+
+	user, err := GetUserByEmail(db, emailAddr)
+	if err == sql.ErrNoRows {
+		return nil // the account was deleted since
+	}
+	if err != nil {
+		return fmt.Errorf("error getting user: %v", err)
+	}
+	if id == "digest" {
+		prefs, err := GetNotificationPreferences(db, int(user.ID))
+		if err != nil {
+			return err
+		}
+		prefs.DigestFrequency = DigestOff
+		return prefs.Save(db)
+	}
+	return SetNotificationPreference(db, int(user.ID), id, ChannelEmail, false)
+}
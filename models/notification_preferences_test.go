@@ -0,0 +1,189 @@
+package models
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationPreferenceCenter(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := int(createTestUserForHabits(t, db))
+
+	prefs, err := GetNotificationPreferences(db, userID)
+	if err != nil {
+		t.Fatalf("GetNotificationPreferences failed: %v", err)
+	}
+	if !prefs.Allows(NotificationGoal, ChannelEmail) || prefs.Allows(NotificationGoal, ChannelWebhook) {
+		t.Errorf("Expected email on and other channels off by default, got %v", prefs.Channels)
+	}
+	if prefs.DigestFrequency != DigestOff {
+		t.Errorf("Expected the digest off by default, got %q", prefs.DigestFrequency)
+	}
+
+	invalid := []NotificationPreferences{
+		{QuietHoursStart: "22:00", DigestFrequency: DigestOff},
+		{QuietHoursStart: "10pm", QuietHoursEnd: "07:00", DigestFrequency: DigestOff},
+		{DigestFrequency: "daily"},
+		{DigestFrequency: DigestOff, WebhookURL: "http://example.com/hook"},
+		{DigestFrequency: DigestOff, WebhookURL: "https://localhost/hook"},
+		{DigestFrequency: DigestOff, WebhookURL: "https://169.254.169.254/latest/meta-data"},
+		{DigestFrequency: DigestOff, WebhookURL: "https://10.0.0.5/hook"},
+		{DigestFrequency: DigestOff, Channels: map[string]map[string]bool{"newsletter": {ChannelEmail: true}}},
+		{DigestFrequency: DigestOff, Channels: map[string]map[string]bool{NotificationGoal: {"sms": true}}},
+	}
+	for _, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Expected an error for %+v", p)
+		}
+	}
+
+	// The webhook channel needs a URL, and the master switch overrides everything
+	prefs.Channels[NotificationReminder][ChannelWebhook] = true
+	prefs.Channels[NotificationGoal][ChannelEmail] = false
+	prefs.QuietHoursStart, prefs.QuietHoursEnd = "22:00", "07:00"
+	prefs.DigestFrequency = DigestWeekly
+	if err := prefs.Save(db); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	prefs, _ = GetNotificationPreferences(db, userID)
+	if prefs.Allows(NotificationReminder, ChannelWebhook) {
+		t.Error("Expected no webhook notifications without a webhook URL")
+	}
+	if prefs.Allows(NotificationGoal, ChannelEmail) || prefs.DigestFrequency != DigestWeekly {
+		t.Errorf("Expected saved preferences to be loaded, got %+v", prefs)
+	}
+	prefs.WebhookURL = "https://example.com/hook"
+	if !prefs.Allows(NotificationReminder, ChannelWebhook) {
+		t.Error("Expected webhook notifications with a webhook URL")
+	}
+	prefs.Enabled = false
+	if prefs.Allows(NotificationReminder, ChannelEmail) {
+		t.Error("Expected nothing allowed with notifications off")
+	}
+
+	// Quiet hours run past midnight in the user's timezone
+	prefs.Timezone = "America/New_York"
+	if !prefs.InQuietHours(time.Date(2024, 1, 11, 4, 0, 0, 0, time.UTC)) { // 23:00 in New York
+		t.Error("Expected 23:00 to be in quiet hours")
+	}
+	if prefs.InQuietHours(time.Date(2024, 1, 11, 13, 0, 0, 0, time.UTC)) { // 08:00 in New York
+		t.Error("Expected 08:00 not to be in quiet hours")
+	}
+
+	// Preference links work only with the user's own token
+	token, err := PreferencesToken(db, userID)
+	if err != nil || token == "" {
+		t.Fatalf("PreferencesToken failed: %v", err)
+	}
+	if again, _ := PreferencesToken(db, userID); again != token {
+		t.Error("Expected the preferences token to be stable")
+	}
+	if id, err := GetUserIDByPreferencesToken(db, "TestHabit@example.com", token); err != nil || id != userID {
+		t.Errorf("Expected the token to identify user %d, got %d (%v)", userID, id, err)
+	}
+	if _, err := GetUserIDByPreferencesToken(db, "testhabit@example.com", "wrong"); err == nil {
+		t.Error("Expected an error for a wrong token")
+	}
+}
+
+func TestRemindersRespectPreferences(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	createTestHabitForTests(t, db, userID, BinaryHabit, "Read")
+	reminder := &Reminder{UserID: int(userID), Time: "19:00"}
+	if err := reminder.Create(db, time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Failed to create reminder: %v", err)
+	}
+	now := time.Date(2024, 1, 10, 19, 0, 0, 0, time.UTC)
+
+	// A reminder falling in quiet hours is skipped for the day
+	prefs, _ := GetNotificationPreferences(db, int(userID))
+	prefs.QuietHoursStart, prefs.QuietHoursEnd = "18:00", "20:00"
+	if err := prefs.Save(db); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	mock := NewMockEmailService()
+	NewScheduler(db, mock).dispatchReminders(now)
+	if len(mock.sentEmails) != 0 {
+		t.Errorf("Expected no emails in quiet hours, got %v", mock.sentEmails)
+	}
+	if due, _ := GetDueReminders(db, now); len(due) != 0 {
+		t.Errorf("Expected the reminder to be rescheduled, got %d due", len(due))
+	}
+
+	// With reminder emails off nothing is sent either
+	prefs.QuietHoursStart, prefs.QuietHoursEnd = "", ""
+	prefs.Channels[NotificationReminder][ChannelEmail] = false
+	if err := prefs.Save(db); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	next := time.Date(2024, 1, 11, 19, 0, 0, 0, time.UTC)
+	NewScheduler(db, mock).dispatchReminders(next)
+	if len(mock.sentEmails) != 0 {
+		t.Errorf("Expected no emails with reminders off, got %v", mock.sentEmails)
+	}
+
+	if err := SetNotificationPreference(db, int(userID), NotificationReminder, ChannelEmail, true); err != nil {
+		t.Fatalf("SetNotificationPreference failed: %v", err)
+	}
+	next = time.Date(2024, 1, 12, 19, 0, 0, 0, time.UTC)
+
+	// A run that overlaps one still dispatching leaves the reminders to it
+	scheduler := NewScheduler(db, mock)
+	scheduler.reminders.Lock()
+	scheduler.dispatchReminders(next)
+	scheduler.reminders.Unlock()
+	if len(mock.sentEmails) != 0 {
+		t.Errorf("Expected an overlapping run to send nothing, got %v", mock.sentEmails)
+	}
+
+	scheduler.dispatchReminders(next)
+	if _, ok := mock.sentEmails["testhabit@example.com-reminder"]; !ok {
+		t.Errorf("Expected a reminder email, got %v", mock.sentEmails)
+	}
+}
+
+func TestWebhooksOnlyReachPublicAddresses(t *testing.T) {
+	for _, tc := range []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"192.168.1.1", false},
+		{"172.16.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::ffff:127.0.0.1", false},
+	} {
+		if got := publicIP(net.ParseIP(tc.ip)); got != tc.public {
+			t.Errorf("publicIP(%s) = %v, expected %v", tc.ip, got, tc.public)
+		}
+	}
+
+	// A server on loopback is never posted to, even when the URL passed validation some other way
+	var hit bool
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hit = true }))
+	defer server.Close()
+	err := postWebhook(server.URL, WebhookNotification{Type: NotificationReminder, Message: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "not a public address") {
+		t.Errorf("Expected the loopback webhook to be refused, got %v", err)
+	}
+	if hit {
+		t.Error("Expected the loopback server not to be reached")
+	}
+}
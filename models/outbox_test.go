@@ -0,0 +1,218 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mad/models/email"
+)
+
+func TestOutbox(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	svc, err := email.NewSMTPEmailService(email.SMTPConfig{Host: "localhost", Port: 587, TemplateDir: "../ui/email"})
+	if err != nil {
+		t.Fatalf("Failed to create email service: %v", err)
+	}
+	if err := email.LoadCampaigns("../ui/email"); err != nil {
+		t.Fatalf("Failed to load campaigns: %v", err)
+	}
+	smtpService := svc.(*email.SMTPEmailService)
+	cm := email.NewCampaignManager(db, svc)
+	smtpService.SetCampaignManager(cm)
+
+	// flaky@ fails twice before going through, down@ never does and bad@ is rejected outright
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	deliver := func(msg *email.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[msg.To]++
+		switch {
+		case msg.To == "bad@example.com":
+			return email.Permanent(errors.New("550 no such user"))
+		case msg.To == "down@example.com", msg.To == "flaky@example.com" && attempts[msg.To] <= 2:
+			return errors.New("421 try again later")
+		}
+		return nil
+	}
+	outbox := email.NewOutbox(db, deliver, email.OutboxConfig{
+		Workers:     2,
+		BatchSize:   10,
+		MaxAttempts: 3,
+		BaseDelay:   time.Minute,
+		MaxDelay:    90 * time.Second,
+	})
+	smtpService.SetOutbox(outbox)
+
+	t.Run("emails are queued rather than sent", func(t *testing.T) {
+		if err := cm.SubscribeUser("flaky@example.com", "digital-detox", 0); err != nil {
+			t.Fatalf("SubscribeUser failed: %v", err)
+		}
+		if err := cm.SendPendingCampaignEmails(); err != nil {
+			t.Fatalf("SendPendingCampaignEmails failed: %v", err)
+		}
+		for _, to := range []string{"down@example.com", "bad@example.com"} {
+			if err := svc.SendReminderEmail(to, "Sam", nil, email.QuoteInfo{}, "", ""); err != nil {
+				t.Fatalf("SendReminderEmail failed: %v", err)
+			}
+		}
+		if len(attempts) != 0 {
+			t.Fatalf("Expected nothing delivered before the outbox runs, got %v", attempts)
+		}
+	})
+
+	campaignSend := func(t *testing.T) (status string, retries int) {
+		t.Helper()
+		if err := db.QueryRow("SELECT status, retry_count FROM email_sends").Scan(&status, &retries); err != nil {
+			t.Fatalf("Failed to get the campaign send: %v", err)
+		}
+		return status, retries
+	}
+	now := time.Now().Add(time.Second)
+
+	// Queued campaign emails move the subscription on and are recorded as retries until delivered
+	t.Run("campaign sends wait for the outbox", func(t *testing.T) {
+		if status, _ := campaignSend(t); status != "retry" {
+			t.Errorf("Expected the queued campaign send to be a retry, got %q", status)
+		}
+		var lastSent int
+		db.QueryRow("SELECT last_email_sent FROM email_subscriptions").Scan(&lastSent)
+		if lastSent != 1 {
+			t.Errorf("Expected the subscription to move on to email 1, got %d", lastSent)
+		}
+		if err := cm.SendPendingCampaignEmails(); err != nil {
+			t.Fatalf("SendPendingCampaignEmails failed: %v", err)
+		}
+	})
+
+	t.Run("failures back off until delivered", func(t *testing.T) {
+		if delivered, err := outbox.ProcessDue(now); err != nil || delivered != 0 {
+			t.Fatalf("Expected every first attempt to fail, got %d delivered (%v)", delivered, err)
+		}
+		if attempts["bad@example.com"] != 1 || attempts["flaky@example.com"] != 1 {
+			t.Errorf("Expected one attempt each, got %v", attempts)
+		}
+
+		// Nothing is retried before its backoff, which doubles each time up to the cap
+		outbox.ProcessDue(now.Add(59 * time.Second))
+		if attempts["flaky@example.com"] != 1 {
+			t.Errorf("Expected no retry within a minute, got %d attempts", attempts["flaky@example.com"])
+		}
+		outbox.ProcessDue(now.Add(time.Minute))
+		if status, retries := campaignSend(t); status != "retry" || retries != 2 {
+			t.Errorf("Expected the campaign send to be retrying after 2 attempts, got %q with %d", status, retries)
+		}
+		outbox.ProcessDue(now.Add(2 * time.Minute))
+		if attempts["flaky@example.com"] != 2 {
+			t.Errorf("Expected the second retry after 90 seconds, got %d attempts", attempts["flaky@example.com"])
+		}
+		delivered, err := outbox.ProcessDue(now.Add(150 * time.Second))
+		if err != nil || delivered != 1 {
+			t.Fatalf("Expected the flaky email to be delivered, got %d (%v)", delivered, err)
+		}
+		if status, retries := campaignSend(t); status != "success" || retries != 2 {
+			t.Errorf("Expected the campaign send to succeed after 2 retries, got %q with %d", status, retries)
+		}
+		if attempts["down@example.com"] != 3 || attempts["bad@example.com"] != 1 {
+			t.Errorf("Expected down@ to be tried 3 times and bad@ once, got %v", attempts)
+		}
+	})
+
+	var failures []email.OutboxFailure
+	t.Run("failures are dead-lettered", func(t *testing.T) {
+		stats, err := email.GetOutboxStats(db, now.Add(3*time.Minute))
+		if err != nil {
+			t.Fatalf("GetOutboxStats failed: %v", err)
+		}
+		if stats.Pending != 0 || stats.Dead != 2 || stats.Sent24h != 1 {
+			t.Errorf("Expected 2 dead and 1 sent, got %+v", stats)
+		}
+		failures, err = email.GetOutboxFailures(db, 10)
+		if err != nil || len(failures) != 2 {
+			t.Fatalf("Expected 2 failures, got %d (%v)", len(failures), err)
+		}
+		for _, f := range failures {
+			if f.To == "bad@example.com" && !strings.Contains(f.LastError, "550") {
+				t.Errorf("Expected the rejection to be kept, got %q", f.LastError)
+			}
+		}
+	})
+
+	t.Run("dead letters can be retried by hand", func(t *testing.T) {
+		if len(failures) == 0 {
+			t.Skip("no dead letters to retry")
+		}
+		if err := email.RetryOutboxMessage(db, failures[0].ID); err != nil {
+			t.Fatalf("RetryOutboxMessage failed: %v", err)
+		}
+		if err := email.RetryOutboxMessage(db, failures[0].ID); err == nil {
+			t.Error("Expected an error retrying an email that isn't dead")
+		}
+		if stats, _ := email.GetOutboxStats(db, now); stats.Pending != 1 || stats.Dead != 1 {
+			t.Errorf("Expected the email back in the queue, got %+v", stats)
+		}
+	})
+
+	// Sent emails and dead letters are purged once past their retention, and nothing waiting is
+	t.Run("old emails are purged", func(t *testing.T) {
+		if purged, err := email.PurgeOutbox(db, now.Add(email.OutboxSentRetention)); err != nil || purged != 0 {
+			t.Errorf("Expected nothing purged within the retention, got %d (%v)", purged, err)
+		}
+		if purged, err := email.PurgeOutbox(db, now.Add(email.OutboxSentRetention+time.Hour)); err != nil || purged != 1 {
+			t.Errorf("Expected the sent email purged, got %d (%v)", purged, err)
+		}
+		if purged, err := email.PurgeOutbox(db, now.Add(email.OutboxDeadRetention+time.Hour)); err != nil || purged != 1 {
+			t.Errorf("Expected the dead letter purged, got %d (%v)", purged, err)
+		}
+		var left int
+		db.QueryRow("SELECT COUNT(*) FROM email_outbox").Scan(&left)
+		if left != 1 {
+			t.Errorf("Expected only the requeued email left, got %d", left)
+		}
+	})
+
+	// Queued broadcast sends are only marked sent or failed once the outbox is done with them
+	t.Run("broadcast sends wait for the outbox", func(t *testing.T) {
+		result, err := db.Exec(`
+			INSERT INTO broadcasts (subject, body, segment_id, status, scheduled_at, created_at)
+			VALUES ('News', 'Hi {{ .FirstName }}', ?, ?, ?, ?)`, email.SegmentAll, BroadcastSending, now, now)
+		if err != nil {
+			t.Fatalf("Failed to create the broadcast: %v", err)
+		}
+		broadcastID, _ := result.LastInsertId()
+		for _, to := range []string{"news@example.com", "bad@example.com"} {
+			if _, err := db.Exec("INSERT INTO broadcast_sends (broadcast_id, email) VALUES (?, ?)", broadcastID, to); err != nil {
+				t.Fatalf("Failed to add a recipient: %v", err)
+			}
+		}
+		if sent, err := SendBroadcastBatch(db, svc, 10, now); err != nil || sent != 2 {
+			t.Fatalf("Expected 2 recipients processed, got %d (%v)", sent, err)
+		}
+		broadcastSend := func(to string) string {
+			var status string
+			db.QueryRow("SELECT status FROM broadcast_sends WHERE email = ?", to).Scan(&status)
+			return status
+		}
+		if status := broadcastSend("news@example.com"); status != "queued" {
+			t.Errorf("Expected the send to stay queued until delivered, got %q", status)
+		}
+		broadcasts, _ := GetBroadcasts(db, 10)
+		if b := broadcasts[0]; b.Queued != 2 || b.Sent != 0 || b.Progress() != 0 {
+			t.Errorf("Expected both sends queued, got %+v", b)
+		}
+
+		outbox.ProcessDue(now.Add(time.Hour))
+		if status := broadcastSend("news@example.com"); status != "sent" {
+			t.Errorf("Expected the delivered send to be sent, got %q", status)
+		}
+		if status := broadcastSend("bad@example.com"); status != "failed" {
+			t.Errorf("Expected the rejected send to be failed, got %q", status)
+		}
+	})
+}
@@ -0,0 +1,292 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// PatternWindowDays is how far back pattern analysis looks
+const PatternWindowDays = 90
+
+const (
+	// minPatternDays is the minimum number of analysed days before insights are reported
+	minPatternDays = 14
+	// minWeekdayGap is the completion rate gap (percentage points) between best and worst day worth mentioning
+	minWeekdayGap = 20.0
+	// minBreakRate is the streak break rate (percent) for a weekday to count as a failure sequence
+	minBreakRate = 50.0
+)
+
+// WeekdayPattern holds the completion rate for one day of the week
+type WeekdayPattern struct {
+	Weekday        string  `json:"weekday"`
+	Done           int     `json:"done"`
+	Days           int     `json:"days"`
+	CompletionRate float64 `json:"completion_rate"`
+}
+
+// FailureSequence describes a day that is often missed right after a completed day
+type FailureSequence struct {
+	Weekday     string  `json:"weekday"`
+	After       string  `json:"after"`       // the preceding day, e.g. "the weekend"
+	Breaks      int     `json:"breaks"`      // times the day was missed after the previous day was done
+	Occurrences int     `json:"occurrences"` // times the previous day was done
+	Rate        float64 `json:"rate"`
+	Description string  `json:"description"`
+}
+
+// LogTimePattern describes when during the day a habit is usually logged
+type LogTimePattern struct {
+	TypicalTime string         `json:"typical_time"` // median logging time, HH:MM in the user's timezone
+	Timezone    string         `json:"timezone"`
+	Samples     int            `json:"samples"`
+	Periods     map[string]int `json:"periods"` // morning, afternoon, evening, night
+}
+
+// HabitPatterns is the result of analysing a habit's logs by weekday and time of day
+type HabitPatterns struct {
+	HabitID          int               `json:"habit_id"`
+	From             time.Time         `json:"from"`
+	To               time.Time         `json:"to"`
+	Days             int               `json:"days"`
+	Weekdays         []WeekdayPattern  `json:"weekdays"`
+	BestDay          *WeekdayPattern   `json:"best_day,omitempty"`
+	WorstDay         *WeekdayPattern   `json:"worst_day,omitempty"`
+	FailureSequences []FailureSequence `json:"failure_sequences"`
+	LogTime          *LogTimePattern   `json:"log_time,omitempty"`
+	Insights         []string          `json:"insights"`
+}
+
+// TopInsight returns the most relevant insight, or an empty string if there is none
+func (p *HabitPatterns) TopInsight() string {
+	if len(p.Insights) == 0 {
+		return ""
+	}
+	return p.Insights[0]
+}
+
+// mondayFirst lists weekdays in display order
+var mondayFirst = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+// GetHabitPatterns analyses the last PatternWindowDays of a habit's logs, up to and including yesterday
+func GetHabitPatterns(db *sql.DB, habitID int, now time.Time) (HabitPatterns, error) {
+	var name string
+	var userID int
+	var createdAt time.Time
+	err := db.QueryRow("SELECT name, user_id, created_at FROM habits WHERE id = ?", habitID).Scan(&name, &userID, &createdAt)
+	if err != nil {
+		return HabitPatterns{}, fmt.Errorf("habit not found: %v", err)
+	}
+	loc, err := userLocation(db, userID)
+	if err != nil {
+		return HabitPatterns{}, err
+	}
+
+	// Today is excluded because it may simply not be logged yet
+	to := truncateToDay(now.UTC()).AddDate(0, 0, -1)
+	from := maxTime(to.AddDate(0, 0, -(PatternWindowDays-1)), truncateToDay(createdAt.UTC()))
+
+	logs := []HabitLog{}
+	if !to.Before(from) {
+		logs, err = GetHabitLogsByDateRange(db, habitID, from, to)
+		if err != nil {
+			return HabitPatterns{}, fmt.Errorf("error getting habit logs: %v", err)
+		}
+	}
+
+	patterns := analyseHabitPatterns(logs, from, to, loc)
+	patterns.HabitID = habitID
+	patterns.Insights = buildPatternInsights(name, patterns)
+	return patterns, nil
+}
+
+// analyseHabitPatterns computes weekday rates, failure sequences and logging times for logs between from and to.
+// Logging times are read in loc, the user's timezone.
+func analyseHabitPatterns(logs []HabitLog, from, to time.Time, loc *time.Location) HabitPatterns {
+	patterns := HabitPatterns{
+		From:             from,
+		To:               to,
+		Weekdays:         []WeekdayPattern{},
+		FailureSequences: []FailureSequence{},
+	}
+
+	done := make(map[string]bool)
+	logMinutes := []int{}
+	periods := map[string]int{"morning": 0, "afternoon": 0, "evening": 0, "night": 0}
+
+	for _, log := range logs {
+		if log.Status != "done" {
+			continue
+		}
+		date := truncateToDay(log.Date.UTC())
+		done[date.Format("2006-01-02")] = true
+
+		// Only logs recorded on the day itself say anything about logging time; backfills are ignored
+		created := log.CreatedAt.In(loc)
+		if !truncateToDay(created).Equal(date) {
+			continue
+		}
+		logMinutes = append(logMinutes, created.Hour()*60+created.Minute())
+		switch hour := created.Hour(); {
+		case hour >= 5 && hour < 12:
+			periods["morning"]++
+		case hour >= 12 && hour < 17:
+			periods["afternoon"]++
+		case hour >= 17 && hour < 22:
+			periods["evening"]++
+		default:
+			periods["night"]++
+		}
+	}
+
+	byWeekday := make(map[time.Weekday]*WeekdayPattern)
+	breaks := make(map[time.Weekday]int)
+	afterDone := make(map[time.Weekday]int)
+	for _, weekday := range mondayFirst {
+		byWeekday[weekday] = &WeekdayPattern{Weekday: weekday.String()}
+	}
+
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		patterns.Days++
+		isDone := done[day.Format("2006-01-02")]
+		stats := byWeekday[day.Weekday()]
+		stats.Days++
+		if isDone {
+			stats.Done++
+		}
+
+		// A break is a miss on a day that follows a completed day
+		prev := day.AddDate(0, 0, -1)
+		if !prev.Before(from) && done[prev.Format("2006-01-02")] {
+			afterDone[day.Weekday()]++
+			if !isDone {
+				breaks[day.Weekday()]++
+			}
+		}
+	}
+
+	for _, weekday := range mondayFirst {
+		stats := byWeekday[weekday]
+		if stats.Days > 0 {
+			stats.CompletionRate = roundTo2(float64(stats.Done) / float64(stats.Days) * 100)
+		}
+		patterns.Weekdays = append(patterns.Weekdays, *stats)
+	}
+
+	// Best and worst days need every weekday to have been seen at least twice
+	if patterns.Days >= minPatternDays {
+		best, worst := patterns.Weekdays[0], patterns.Weekdays[0]
+		for _, stats := range patterns.Weekdays[1:] {
+			if stats.CompletionRate > best.CompletionRate {
+				best = stats
+			}
+			if stats.CompletionRate < worst.CompletionRate {
+				worst = stats
+			}
+		}
+		if best.CompletionRate > worst.CompletionRate {
+			patterns.BestDay = &best
+			patterns.WorstDay = &worst
+		}
+	}
+
+	for _, weekday := range mondayFirst {
+		occurrences := afterDone[weekday]
+		if occurrences < 2 {
+			continue
+		}
+		rate := roundTo2(float64(breaks[weekday]) / float64(occurrences) * 100)
+		if rate < minBreakRate {
+			continue
+		}
+		after := "a completed " + ((weekday + 6) % 7).String()
+		if weekday == time.Monday {
+			after = "the weekend"
+		}
+		patterns.FailureSequences = append(patterns.FailureSequences, FailureSequence{
+			Weekday:     weekday.String(),
+			After:       after,
+			Breaks:      breaks[weekday],
+			Occurrences: occurrences,
+			Rate:        rate,
+			Description: fmt.Sprintf("Missed %d of %d %ss after %s", breaks[weekday], occurrences, weekday, after),
+		})
+	}
+	sort.SliceStable(patterns.FailureSequences, func(i, j int) bool {
+		return patterns.FailureSequences[i].Rate > patterns.FailureSequences[j].Rate
+	})
+
+	if len(logMinutes) > 0 {
+		sort.Ints(logMinutes)
+		median := logMinutes[len(logMinutes)/2]
+		patterns.LogTime = &LogTimePattern{
+			TypicalTime: fmt.Sprintf("%02d:%02d", median/60, median%60),
+			Timezone:    loc.String(),
+			Samples:     len(logMinutes),
+			Periods:     periods,
+		}
+	}
+
+	return patterns
+}
+
+// buildPatternInsights turns patterns into short sentences, most useful first
+func buildPatternInsights(habitName string, patterns HabitPatterns) []string {
+	insights := []string{}
+	if patterns.Days < minPatternDays {
+		return insights
+	}
+
+	if len(patterns.FailureSequences) > 0 {
+		seq := patterns.FailureSequences[0]
+		insights = append(insights, fmt.Sprintf("You often miss %s on %ss after %s (%d of %d times).",
+			habitName, seq.Weekday, seq.After, seq.Breaks, seq.Occurrences))
+	}
+
+	if patterns.BestDay != nil && patterns.BestDay.CompletionRate-patterns.WorstDay.CompletionRate >= minWeekdayGap {
+		insights = append(insights, fmt.Sprintf("%ss are your strongest day for %s (%.0f%%), %ss your weakest (%.0f%%).",
+			patterns.BestDay.Weekday, habitName, patterns.BestDay.CompletionRate,
+			patterns.WorstDay.Weekday, patterns.WorstDay.CompletionRate))
+	}
+
+	if patterns.LogTime != nil && patterns.LogTime.Samples >= minPatternDays/2 {
+		insights = append(insights, fmt.Sprintf("You usually log %s around %s.", habitName, patterns.LogTime.TypicalTime))
+	}
+
+	return insights
+}
+
+// GetReminderInsight picks the most relevant pattern insight across a user's habits for today's reminder.
+// A habit whose weakest day is today takes priority over general insights.
+func GetReminderInsight(db *sql.DB, userID int, now time.Time) (string, error) {
+	habits, err := GetHabitsByUserID(db, userID)
+	if err != nil {
+		return "", err
+	}
+
+	loc, err := userLocation(db, userID)
+	if err != nil {
+		return "", err
+	}
+	today := now.In(loc).Weekday().String()
+	fallback := ""
+	for _, habit := range habits {
+		patterns, err := GetHabitPatterns(db, habit.ID, now)
+		if err != nil {
+			return "", err
+		}
+		if patterns.WorstDay != nil && patterns.WorstDay.Weekday == today &&
+			patterns.BestDay.CompletionRate-patterns.WorstDay.CompletionRate >= minWeekdayGap {
+			return fmt.Sprintf("%ss are usually your toughest day for %s %s (%.0f%%). Today is a good day to beat that.",
+				today, habit.Emoji, habit.Name, patterns.WorstDay.CompletionRate), nil
+		}
+		if fallback == "" {
+			fallback = patterns.TopInsight()
+		}
+	}
+	return fallback, nil
+}
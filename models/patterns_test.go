@@ -0,0 +1,115 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHabitPatterns tests weekday rates, failure sequences, logging time and reminder insights
+func TestHabitPatterns(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	habit := createTestHabitForTests(t, db, userID, BinaryHabit, "Meditate")
+
+	// Four weeks starting Monday 2024-01-01, done every day except Mondays
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := db.Exec("UPDATE habits SET created_at = ? WHERE id = ?", from, habit.ID); err != nil {
+		t.Fatalf("Failed to backdate habit: %v", err)
+	}
+	for day := from; day.Before(from.AddDate(0, 0, 28)); day = day.AddDate(0, 0, 1) {
+		status := "done"
+		if day.Weekday() == time.Monday {
+			status = "missed"
+		}
+		log := createHabitLog(t, db, habit.ID, day, status, nil)
+		// Logged on the day itself at 07:30
+		if _, err := db.Exec("UPDATE habit_logs SET created_at = ? WHERE id = ?", day.Add(7*time.Hour+30*time.Minute), log.ID); err != nil {
+			t.Fatalf("Failed to set log time: %v", err)
+		}
+	}
+
+	// Monday 2024-01-29: the window covers the four weeks up to yesterday
+	now := time.Date(2024, 1, 29, 18, 0, 0, 0, time.UTC)
+	patterns, err := GetHabitPatterns(db, habit.ID, now)
+	if err != nil {
+		t.Fatalf("GetHabitPatterns failed: %v", err)
+	}
+
+	if patterns.Days != 28 {
+		t.Errorf("Expected 28 analysed days, got %d", patterns.Days)
+	}
+	if len(patterns.Weekdays) != 7 || patterns.Weekdays[0].Weekday != "Monday" {
+		t.Fatalf("Expected weekdays starting on Monday, got %+v", patterns.Weekdays)
+	}
+	if patterns.Weekdays[0].CompletionRate != 0 || patterns.Weekdays[1].CompletionRate != 100 {
+		t.Errorf("Unexpected weekday rates: %+v", patterns.Weekdays)
+	}
+	if patterns.WorstDay == nil || patterns.WorstDay.Weekday != "Monday" {
+		t.Errorf("Expected Monday as worst day, got %+v", patterns.WorstDay)
+	}
+	if patterns.BestDay == nil || patterns.BestDay.CompletionRate != 100 {
+		t.Errorf("Expected a best day at 100%%, got %+v", patterns.BestDay)
+	}
+
+	if len(patterns.FailureSequences) != 1 {
+		t.Fatalf("Expected 1 failure sequence, got %+v", patterns.FailureSequences)
+	}
+	seq := patterns.FailureSequences[0]
+	if seq.Weekday != "Monday" || seq.After != "the weekend" || seq.Breaks != 3 || seq.Occurrences != 3 {
+		t.Errorf("Unexpected failure sequence: %+v", seq)
+	}
+
+	if patterns.LogTime == nil || patterns.LogTime.TypicalTime != "07:30" || patterns.LogTime.Periods["morning"] != 24 {
+		t.Errorf("Unexpected log time pattern: %+v", patterns.LogTime)
+	}
+
+	if !strings.Contains(patterns.TopInsight(), "Mondays after the weekend") {
+		t.Errorf("Expected top insight about Mondays, got %q", patterns.TopInsight())
+	}
+
+	// On a Monday the reminder highlights the weakest day
+	insight, err := GetReminderInsight(db, int(userID), now)
+	if err != nil {
+		t.Fatalf("GetReminderInsight failed: %v", err)
+	}
+	if !strings.HasPrefix(insight, "Mondays are usually your toughest day") {
+		t.Errorf("Unexpected reminder insight: %q", insight)
+	}
+
+	// On other days it falls back to the top insight
+	insight, err = GetReminderInsight(db, int(userID), now.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("GetReminderInsight failed: %v", err)
+	}
+	if insight == "" || strings.HasPrefix(insight, "Mondays are usually") {
+		t.Errorf("Expected general insight, got %q", insight)
+	}
+
+	// Logging times are reported in the user's timezone
+	if _, err := db.Exec("UPDATE users SET timezone = 'Europe/Rome' WHERE id = ?", userID); err != nil {
+		t.Fatalf("Failed to set timezone: %v", err)
+	}
+	patterns, err = GetHabitPatterns(db, habit.ID, now)
+	if err != nil {
+		t.Fatalf("GetHabitPatterns failed: %v", err)
+	}
+	if patterns.LogTime == nil || patterns.LogTime.TypicalTime != "08:30" || patterns.LogTime.Timezone != "Europe/Rome" {
+		t.Errorf("Expected 08:30 in Rome, got %+v", patterns.LogTime)
+	}
+	if !strings.Contains(strings.Join(patterns.Insights, " "), "around 08:30.") {
+		t.Errorf("Expected the local logging time in the insights, got %v", patterns.Insights)
+	}
+
+	// New habits have no insights yet
+	fresh := createTestHabitForTests(t, db, userID, BinaryHabit, "Fresh")
+	patterns, err = GetHabitPatterns(db, fresh.ID, time.Now())
+	if err != nil {
+		t.Fatalf("GetHabitPatterns failed: %v", err)
+	}
+	if len(patterns.Insights) != 0 || patterns.BestDay != nil {
+		t.Errorf("Expected no insights for a new habit, got %+v", patterns)
+	}
+}
@@ -0,0 +1,448 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DefaultReminderTime is the daily reminder every new user starts with, in their timezone
+const DefaultReminderTime = "19:00"
+
+// Reminder kinds
+const (
+	ReminderKindDaily       = "daily"        // a time the user picked
+	ReminderKindStreakNudge = "streak_nudge" // the late evening nudge for long streaks not yet logged today
+)
+
+// StreakNudgeTime is when the streak nudge goes out, in the user's timezone
+const StreakNudgeTime = "21:30"
+
+// StreakNudgeThreshold is the shortest streak, in days, worth a second nudge
+const StreakNudgeThreshold = 7
+
+// reminderGracePeriod is how late a reminder can still be sent, e.g. after downtime; older ones are skipped
+const reminderGracePeriod = time.Hour
+
+// Reminder is a daily email at a time of day in the user's timezone. Without a habit it lists every habit the
+// user included in reminders; with one it is about that habit only.
+type Reminder struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	HabitID    *int       `json:"habit_id,omitempty"`
+	Kind       string     `json:"kind"`
+	Time       string     `json:"time"` // HH:MM
+	NextDueAt  time.Time  `json:"next_due_at"`
+	LastSentAt *time.Time `json:"last_sent_at,omitempty"`
+}
+
+// DueReminder is a reminder whose time has come, with what is needed to send it
+type DueReminder struct {
+	Reminder
+	Email         string
+	FirstName     string
+	Timezone      string
+	EmailVerified bool // reminders aren't emailed to unverified addresses
+}
+
+// ValidateTimezone checks that tz is an IANA timezone name such as "Europe/Rome"
+func ValidateTimezone(tz string) error {
+	if tz == "" {
+		return fmt.Errorf("timezone is required")
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return fmt.Errorf("unknown timezone: %s", tz)
+	}
+	return nil
+}
+
+// nextReminderDue returns the first time after after at which the time of day occurs in loc
+func nextReminderDue(timeOfDay string, loc *time.Location, after time.Time) (time.Time, error) {
+	t, err := time.Parse("15:04", timeOfDay)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid reminder time %q, expected HH:MM", timeOfDay)
+	}
+	local := after.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	for !next.After(after) {
+		local = local.AddDate(0, 0, 1)
+		next = time.Date(local.Year(), local.Month(), local.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	}
+	return next.UTC(), nil
+}
+
+// userLocation returns the timezone of the user
+func userLocation(db *sql.DB, userID int) (*time.Location, error) {
+	var tz string
+	if err := db.QueryRow("SELECT timezone FROM users WHERE id = ?", userID).Scan(&tz); err != nil {
+		return nil, fmt.Errorf("error getting user timezone: %v", err)
+	}
+	return time.LoadLocation(tz)
+}
+
+// Create validates and stores the reminder, scheduling its first occurrence after now
+func (r *Reminder) Create(db *sql.DB, now time.Time) error {
+	if r.Kind == "" {
+		r.Kind = ReminderKindDaily
+	}
+	if r.HabitID != nil {
+		var owner int
+		err := db.QueryRow("SELECT user_id FROM habits WHERE id = ?", *r.HabitID).Scan(&owner)
+		if err != nil || owner != r.UserID {
+			return fmt.Errorf("habit not found or unauthorized")
+		}
+	}
+
+	loc, err := userLocation(db, r.UserID)
+	if err != nil {
+		return err
+	}
+	r.NextDueAt, err = nextReminderDue(r.Time, loc, now)
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM reminders
+		WHERE user_id = ? AND habit_id IS ? AND kind = ? AND time = ?`,
+		r.UserID, r.HabitID, r.Kind, r.Time,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("there is already a reminder at %s", r.Time)
+	}
+
+	err = db.QueryRow(`
+		INSERT INTO reminders (user_id, habit_id, kind, time, next_due_at)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id`,
+		r.UserID, r.HabitID, r.Kind, r.Time, r.NextDueAt,
+	).Scan(&r.ID)
+	if err != nil {
+		return fmt.Errorf("error creating reminder: %v", err)
+	}
+	return nil
+}
+
+// Delete removes the reminder if it belongs to the user; the streak nudge isn't one of the user's reminders
+func (r *Reminder) Delete(db *sql.DB) error {
+	result, err := db.Exec("DELETE FROM reminders WHERE id = ? AND user_id = ? AND kind = 'daily'", r.ID, r.UserID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetRemindersByUser returns the reminders the user picked, the ones for every habit first, in time order
+func GetRemindersByUser(db *sql.DB, userID int) ([]Reminder, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, habit_id, kind, time, next_due_at, last_sent_at
+		FROM reminders
+		WHERE user_id = ? AND kind = 'daily'
+		ORDER BY habit_id IS NOT NULL, habit_id, time`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting reminders: %v", err)
+	}
+	defer rows.Close()
+
+	reminders := []Reminder{}
+	for rows.Next() {
+		r, err := scanReminder(rows)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, r)
+	}
+	return reminders, rows.Err()
+}
+
+// scanReminder reads the reminder columns selected by GetRemindersByUser
+func scanReminder(rows *sql.Rows, extra ...interface{}) (Reminder, error) {
+	var r Reminder
+	var habitID sql.NullInt64
+	var lastSentAt sql.NullTime
+	dest := append([]interface{}{&r.ID, &r.UserID, &habitID, &r.Kind, &r.Time, &r.NextDueAt, &lastSentAt}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return r, err
+	}
+	if habitID.Valid {
+		id := int(habitID.Int64)
+		r.HabitID = &id
+	}
+	if lastSentAt.Valid {
+		r.LastSentAt = &lastSentAt.Time
+	}
+	return r, nil
+}
+
+// CreateDefaultReminder gives a new user the daily reminder at DefaultReminderTime and the streak nudge
+func CreateDefaultReminder(db *sql.DB, userID int, now time.Time) error {
+	r := &Reminder{UserID: userID, Kind: ReminderKindDaily, Time: DefaultReminderTime}
+	if err := r.Create(db, now); err != nil {
+		return err
+	}
+	nudge := &Reminder{UserID: userID, Kind: ReminderKindStreakNudge, Time: StreakNudgeTime}
+	return nudge.Create(db, now)
+}
+
+// backfillStreakNudges gives every user without a streak nudge one, scheduled in their timezone
+func backfillStreakNudges(db *sql.DB) error {
+	rows, err := db.Query(`
+		SELECT id
+		FROM users
+		WHERE id NOT IN (SELECT user_id FROM reminders WHERE kind = 'streak_nudge')`)
+	if err != nil {
+		return fmt.Errorf("error getting users without a streak nudge: %v", err)
+	}
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, userID := range userIDs {
+		nudge := &Reminder{UserID: userID, Kind: ReminderKindStreakNudge, Time: StreakNudgeTime}
+		if err := nudge.Create(db, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetUserTimezone changes the user's timezone and reschedules their reminders to match
+func SetUserTimezone(db *sql.DB, userID int, tz string, now time.Time) error {
+	if err := ValidateTimezone(tz); err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE users SET timezone = ? WHERE id = ?", tz, userID); err != nil {
+		return fmt.Errorf("error updating timezone: %v", err)
+	}
+
+	rows, err := db.Query("SELECT id, time FROM reminders WHERE user_id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("error getting reminders: %v", err)
+	}
+	var reminders []Reminder
+	for rows.Next() {
+		var r Reminder
+		if err := rows.Scan(&r.ID, &r.Time); err != nil {
+			rows.Close()
+			return err
+		}
+		reminders = append(reminders, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	loc, _ := time.LoadLocation(tz)
+	for _, r := range reminders {
+		next, err := nextReminderDue(r.Time, loc, now)
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec("UPDATE reminders SET next_due_at = ? WHERE id = ?", next, r.ID); err != nil {
+			return fmt.Errorf("error rescheduling reminder: %v", err)
+		}
+	}
+	return nil
+}
+
+// SetHabitReminderEnabled sets whether the habit is listed in the user's daily reminders
+func SetHabitReminderEnabled(db *sql.DB, userID, habitID int, enabled bool) error {
+	result, err := db.Exec("UPDATE habits SET reminder_enabled = ? WHERE id = ? AND user_id = ?", enabled, habitID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetDueReminders returns the reminders due at now for users with notifications enabled
+func GetDueReminders(db *sql.DB, now time.Time) ([]DueReminder, error) {
+	rows, err := db.Query(`
+		SELECT r.id, r.user_id, r.habit_id, r.kind, r.time, r.next_due_at, r.last_sent_at,
+			   u.email, u.first_name, u.timezone, u.email_verified
+		FROM reminders r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.next_due_at <= ?
+		AND u.notification_enabled = true
+		ORDER BY r.next_due_at`,
+		now.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting due reminders: %v", err)
+	}
+	defer rows.Close()
+
+	due := []DueReminder{}
+	for rows.Next() {
+		var d DueReminder
+		d.Reminder, err = scanReminder(rows, &d.Email, &d.FirstName, &d.Timezone, &d.EmailVerified)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+// Stale reports whether the reminder is too far past its time to still be worth sending
+func (d *DueReminder) Stale(now time.Time) bool {
+	return now.Sub(d.NextDueAt) > reminderGracePeriod
+}
+
+// ReminderHabits returns the habits the reminder is about that are not yet logged on the user's today, with
+// CurrentStreak set to the streak at stake. That is the reminder's own habit, or every habit the user included;
+// the streak nudge keeps only streaks of at least StreakNudgeThreshold days.
+func (d *DueReminder) ReminderHabits(db *sql.DB, now time.Time) ([]Habit, error) {
+	habits, err := GetHabitsByUserID(db, d.UserID)
+	if err != nil {
+		return nil, err
+	}
+	included := []Habit{}
+	for _, h := range habits {
+		if (d.HabitID != nil && h.ID == *d.HabitID) || (d.HabitID == nil && h.ReminderEnabled) {
+			included = append(included, h)
+		}
+	}
+
+	unlogged, err := unloggedHabits(db, included, localDay(d.Timezone, now))
+	if err != nil {
+		return nil, err
+	}
+	if d.Kind != ReminderKindStreakNudge {
+		return unlogged, nil
+	}
+	atStake := []Habit{}
+	for _, h := range unlogged {
+		if h.CurrentStreak >= StreakNudgeThreshold {
+			atStake = append(atStake, h)
+		}
+	}
+	return atStake, nil
+}
+
+// localDay returns the date it is at now in the timezone, as midnight UTC like the habit log dates
+func localDay(tz string, now time.Time) time.Time {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// unloggedHabits returns the habits with no log on day, with CurrentStreak set to the streak that ends if they
+// stay unlogged: the run of done or skipped days up to the day before
+func unloggedHabits(db *sql.DB, habits []Habit, day time.Time) ([]Habit, error) {
+	unlogged := []Habit{}
+	for _, h := range habits {
+		var logged bool
+		err := db.QueryRow(`
+			SELECT COUNT(*) > 0
+			FROM habit_logs
+			WHERE habit_id = ? AND date(date) = date(?)`,
+			h.ID, day.Format("2006-01-02"),
+		).Scan(&logged)
+		if err != nil {
+			return nil, fmt.Errorf("error checking habit log: %v", err)
+		}
+		if logged {
+			continue
+		}
+		h.CurrentStreak, err = streakBefore(db, h.ID, day)
+		if err != nil {
+			return nil, err
+		}
+		unlogged = append(unlogged, h)
+	}
+	return unlogged, nil
+}
+
+// streakBefore counts the consecutive done or skipped days ending the day before day
+func streakBefore(db *sql.DB, habitID int, day time.Time) (int, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT date(date) AS log_date
+		FROM habit_logs
+		WHERE habit_id = ?
+		AND date(date) < date(?)
+		AND status IN ('done', 'skipped')
+		ORDER BY log_date DESC
+		LIMIT 366`,
+		habitID, day.Format("2006-01-02"),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error calculating streak: %v", err)
+	}
+	defer rows.Close()
+
+	streak := 0
+	expected := day.AddDate(0, 0, -1).Format("2006-01-02")
+	for rows.Next() {
+		var logDate string
+		if err := rows.Scan(&logDate); err != nil {
+			return 0, err
+		}
+		if logDate != expected {
+			break
+		}
+		streak++
+		t, _ := time.Parse("2006-01-02", logDate)
+		expected = t.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	return streak, rows.Err()
+}
+
+// Advance schedules the reminder's next occurrence after now, recording now as sent when sent is true
+func (d *DueReminder) Advance(db *sql.DB, now time.Time, sent bool) error {
+	loc, err := time.LoadLocation(d.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	next, err := nextReminderDue(d.Time, loc, now)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE reminders SET next_due_at = ? WHERE id = ?"
+	args := []interface{}{next, d.ID}
+	if sent {
+		query = "UPDATE reminders SET next_due_at = ?, last_sent_at = ? WHERE id = ?"
+		args = []interface{}{next, now.UTC(), d.ID}
+	}
+	if _, err := db.Exec(query, args...); err != nil {
+		return fmt.Errorf("error advancing reminder: %v", err)
+	}
+	d.NextDueAt = next
+	return nil
+}
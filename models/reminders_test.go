@@ -0,0 +1,273 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextReminderDue(t *testing.T) {
+	rome, _ := time.LoadLocation("Europe/Rome")
+	newYork, _ := time.LoadLocation("America/New_York")
+
+	tests := []struct {
+		name     string
+		time     string
+		loc      *time.Location
+		after    time.Time
+		expected time.Time
+	}{
+		{
+			name:     "later today",
+			time:     "19:00",
+			loc:      time.UTC,
+			after:    time.Date(2024, 1, 10, 8, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 10, 19, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "exactly due moves to tomorrow",
+			time:     "19:00",
+			loc:      time.UTC,
+			after:    time.Date(2024, 1, 10, 19, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 11, 19, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "in the user's timezone",
+			time:     "08:30",
+			loc:      rome,
+			after:    time.Date(2024, 1, 10, 6, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 10, 7, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "local date differs from UTC",
+			time:     "21:00",
+			loc:      newYork,
+			after:    time.Date(2024, 1, 11, 1, 0, 0, 0, time.UTC), // 20:00 on the 10th in New York
+			expected: time.Date(2024, 1, 11, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "across daylight saving",
+			time:     "19:00",
+			loc:      rome,
+			after:    time.Date(2024, 3, 30, 19, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 3, 31, 17, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nextReminderDue(tt.time, tt.loc, tt.after)
+			if err != nil {
+				t.Fatalf("nextReminderDue failed: %v", err)
+			}
+			if !got.Equal(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	if _, err := nextReminderDue("7pm", time.UTC, time.Now()); err == nil {
+		t.Error("Expected an error for an invalid time")
+	}
+}
+
+func TestReminders(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	otherID := createTestUserForHabits(t, db, "2")
+	read := createTestHabitForTests(t, db, userID, BinaryHabit, "Read")
+	run := createTestHabitForTests(t, db, userID, BinaryHabit, "Run")
+	otherHabit := createTestHabitForTests(t, db, otherID, BinaryHabit, "Read")
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	if err := SetUserTimezone(db, int(userID), "Mars/Olympus", now); err == nil {
+		t.Error("Expected an error for an unknown timezone")
+	}
+	if err := SetUserTimezone(db, int(userID), "Europe/Rome", now); err != nil {
+		t.Fatalf("SetUserTimezone failed: %v", err)
+	}
+
+	daily := &Reminder{UserID: int(userID), Time: "19:00"}
+	if err := daily.Create(db, now); err != nil {
+		t.Fatalf("Failed to create reminder: %v", err)
+	}
+	if expected := time.Date(2024, 1, 10, 18, 0, 0, 0, time.UTC); !daily.NextDueAt.Equal(expected) {
+		t.Errorf("Expected the reminder at 19:00 Rome time (%v), got %v", expected, daily.NextDueAt)
+	}
+	if err := (&Reminder{UserID: int(userID), Time: "19:00"}).Create(db, now); err == nil {
+		t.Error("Expected an error for a duplicate reminder")
+	}
+	if err := (&Reminder{UserID: int(userID), HabitID: &otherHabit.ID, Time: "07:00"}).Create(db, now); err == nil {
+		t.Error("Expected an error for another user's habit")
+	}
+
+	perHabit := &Reminder{UserID: int(userID), HabitID: &run.ID, Time: "19:00"}
+	if err := perHabit.Create(db, now); err != nil {
+		t.Fatalf("Failed to create habit reminder: %v", err)
+	}
+
+	// The daily reminder lists only the included habits, the habit reminder only its habit
+	if err := SetHabitReminderEnabled(db, int(userID), run.ID, false); err != nil {
+		t.Fatalf("SetHabitReminderEnabled failed: %v", err)
+	}
+	if err := SetHabitReminderEnabled(db, int(userID), otherHabit.ID, false); err == nil {
+		t.Error("Expected an error for another user's habit")
+	}
+
+	dueAt := time.Date(2024, 1, 10, 18, 0, 0, 0, time.UTC)
+	due, err := GetDueReminders(db, dueAt)
+	if err != nil {
+		t.Fatalf("GetDueReminders failed: %v", err)
+	}
+	if len(due) != 2 {
+		t.Fatalf("Expected 2 due reminders, got %d", len(due))
+	}
+	for _, d := range due {
+		habits, err := d.ReminderHabits(db, dueAt)
+		if err != nil {
+			t.Fatalf("ReminderHabits failed: %v", err)
+		}
+		expected := read.ID
+		if d.HabitID != nil {
+			expected = run.ID
+		}
+		if len(habits) != 1 || habits[0].ID != expected {
+			t.Errorf("Expected reminder %d to be about habit %d, got %v", d.ID, expected, habits)
+		}
+	}
+
+	// Sending schedules the next day; nothing is due until then
+	sentAt := time.Date(2024, 1, 10, 18, 0, 30, 0, time.UTC)
+	if err := due[0].Advance(db, sentAt, true); err != nil {
+		t.Fatalf("Advance failed: %v", err)
+	}
+	if expected := time.Date(2024, 1, 11, 18, 0, 0, 0, time.UTC); !due[0].NextDueAt.Equal(expected) {
+		t.Errorf("Expected the next reminder at %v, got %v", expected, due[0].NextDueAt)
+	}
+	if due, _ := GetDueReminders(db, sentAt); len(due) != 1 {
+		t.Errorf("Expected 1 reminder still due, got %d", len(due))
+	}
+
+	// Reminders far past their time are stale
+	if due[1].Stale(time.Date(2024, 1, 10, 18, 30, 0, 0, time.UTC)) {
+		t.Error("Expected a reminder 30 minutes late not to be stale")
+	}
+	if !due[1].Stale(time.Date(2024, 1, 10, 20, 0, 0, 0, time.UTC)) {
+		t.Error("Expected a reminder 2 hours late to be stale")
+	}
+
+	// Users with notifications off get no reminders
+	if _, err := db.Exec("UPDATE users SET notification_enabled = false WHERE id = ?", userID); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	if due, _ := GetDueReminders(db, time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)); len(due) != 0 {
+		t.Errorf("Expected no due reminders, got %d", len(due))
+	}
+
+	// Changing timezone reschedules the reminders
+	if err := SetUserTimezone(db, int(userID), "America/New_York", now); err != nil {
+		t.Fatalf("SetUserTimezone failed: %v", err)
+	}
+	reminders, err := GetRemindersByUser(db, int(userID))
+	if err != nil {
+		t.Fatalf("GetRemindersByUser failed: %v", err)
+	}
+	if len(reminders) != 2 || reminders[0].HabitID != nil {
+		t.Fatalf("Expected the daily reminder first, got %v", reminders)
+	}
+	if expected := time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC); !reminders[0].NextDueAt.Equal(expected) {
+		t.Errorf("Expected the reminder at 19:00 New York time (%v), got %v", expected, reminders[0].NextDueAt)
+	}
+
+	if err := (&Reminder{ID: daily.ID, UserID: int(otherID)}).Delete(db); err == nil {
+		t.Error("Expected an error deleting another user's reminder")
+	}
+	if err := daily.Delete(db); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+}
+
+func TestDefaultReminder(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db)
+
+	reminders, err := GetRemindersByUser(db, int(user.ID))
+	if err != nil {
+		t.Fatalf("GetRemindersByUser failed: %v", err)
+	}
+	if len(reminders) != 1 || reminders[0].HabitID != nil || reminders[0].Time != DefaultReminderTime {
+		t.Errorf("Expected a daily reminder at %s for a new user, got %v", DefaultReminderTime, reminders)
+	}
+
+	var nudges int
+	if err := db.QueryRow("SELECT COUNT(*) FROM reminders WHERE user_id = ? AND kind = ?", user.ID, ReminderKindStreakNudge).Scan(&nudges); err != nil {
+		t.Fatalf("Failed to count streak nudges: %v", err)
+	}
+	if nudges != 1 {
+		t.Errorf("Expected a streak nudge for a new user, got %d", nudges)
+	}
+}
+
+func TestSmartReminders(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	if err := SetUserTimezone(db, int(userID), "America/New_York", time.Now()); err != nil {
+		t.Fatalf("SetUserTimezone failed: %v", err)
+	}
+	long := createTestHabitForTests(t, db, userID, BinaryHabit, "Read")
+	short := createTestHabitForTests(t, db, userID, BinaryHabit, "Run")
+	done := createTestHabitForTests(t, db, userID, BinaryHabit, "Meditate")
+
+	// 02:00 UTC on the 11th is still the evening of the 10th in New York
+	now := time.Date(2024, 1, 11, 2, 0, 0, 0, time.UTC)
+	for d := 2; d <= 9; d++ {
+		createHabitLog(t, db, long.ID, time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC), "done", nil)
+	}
+	createHabitLog(t, db, short.ID, time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC), "done", nil)
+	createHabitLog(t, db, short.ID, time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC), "skipped", nil)
+	createHabitLog(t, db, done.ID, time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), "done", nil)
+
+	daily := &DueReminder{Reminder: Reminder{UserID: int(userID), Kind: ReminderKindDaily}, Timezone: "America/New_York"}
+	habits, err := daily.ReminderHabits(db, now)
+	if err != nil {
+		t.Fatalf("ReminderHabits failed: %v", err)
+	}
+	streaks := map[int]int{}
+	for _, h := range habits {
+		streaks[h.ID] = h.CurrentStreak
+	}
+	if len(habits) != 2 || streaks[long.ID] != 8 || streaks[short.ID] != 2 {
+		t.Errorf("Expected the two unlogged habits with streaks 8 and 2, got %v", streaks)
+	}
+
+	nudge := &DueReminder{Reminder: Reminder{UserID: int(userID), Kind: ReminderKindStreakNudge}, Timezone: "America/New_York"}
+	habits, err = nudge.ReminderHabits(db, now)
+	if err != nil {
+		t.Fatalf("ReminderHabits failed: %v", err)
+	}
+	if len(habits) != 1 || habits[0].ID != long.ID {
+		t.Errorf("Expected the nudge to cover only the long streak, got %v", habits)
+	}
+
+	// With everything logged the reminder is skipped but still moves on to the next day
+	createHabitLog(t, db, long.ID, time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), "done", nil)
+	createHabitLog(t, db, short.ID, time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), "missed", nil)
+	reminder := &Reminder{UserID: int(userID), Time: "20:30"}
+	if err := reminder.Create(db, time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Failed to create reminder: %v", err)
+	}
+
+	mock := NewMockEmailService()
+	NewScheduler(db, mock).dispatchReminders(now)
+	if len(mock.sentEmails) != 0 {
+		t.Errorf("Expected no emails with every habit logged, got %v", mock.sentEmails)
+	}
+	if due, _ := GetDueReminders(db, now); len(due) != 0 {
+		t.Errorf("Expected the skipped reminder to be rescheduled, got %d due", len(due))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"mad/models/email"
@@ -18,9 +19,9 @@ type Scheduler struct {
 	cron       *cron.Cron
 	batchSize  int
 	batchDelay time.Duration
-	dailyTime  string
-	weeklyTime string
-	weeklyDay  time.Weekday
+	bounceMbox string     // maildir or mbox receiving bounces and complaints, from BOUNCE_MAILBOX
+	reminders  sync.Mutex // held while reminders are dispatched, so a slow run doesn't send them twice
+	broadcasts sync.Mutex // held while broadcasts are sent, so a slow run isn't overlapped by the next
 	isRunning  bool
 	stopChan   chan struct{}
 }
@@ -33,9 +34,7 @@ func NewScheduler(db *sql.DB, emailSvc email.EmailService) *Scheduler {
 		cron:       cron.New(),
 		batchSize:  25,                     // Default batch size of 25 emails
 		batchDelay: 200 * time.Millisecond, // Default delay of 200ms between batches
-		dailyTime:  "0 19 * * *",           // Default to 7 PM daily
-		weeklyTime: "0 18 * * 0",           // Default to 6 PM on Sundays
-		weeklyDay:  time.Sunday,
+		bounceMbox: os.Getenv("BOUNCE_MAILBOX"),
 		isRunning:  false,
 		stopChan:   make(chan struct{}),
 	}
@@ -47,25 +46,109 @@ func (s *Scheduler) Start() error {
 		return nil // Already running
 	}
 
-	// Schedule daily reminders for users with habits
-	_, err := s.cron.AddFunc(s.dailyTime, func() {
-		s.sendDailyReminders()
+	// Dispatch reminders as they fall due; each user picks their own times in their timezone
+	_, err := s.cron.AddFunc("* * * * *", func() {
+		s.dispatchReminders(time.Now())
 	})
 	if err != nil {
 		return err
 	}
 
-	// Schedule weekly nudges for users without habits
-	_, err = s.cron.AddFunc(s.weeklyTime, func() {
-		s.sendWeeklyFirstHabitReminders()
+	// Schedule campaign email sending (every minute with rate limiting)
+	_, err = s.cron.AddFunc("* * * * *", func() {
+		s.SendCampaignEmailsBatch(20) // Send 20 emails per minute (1200/hour max)
 	})
 	if err != nil {
 		return err
 	}
 
-	// Schedule campaign email sending (every minute with rate limiting)
+	// Schedule daily goal progress snapshots (just before midnight, so the day is complete)
+	_, err = s.cron.AddFunc("55 23 * * *", func() {
+		s.recordGoalSnapshots()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Schedule rollover of recurring goals whose period has ended (daily just after midnight)
+	_, err = s.cron.AddFunc("5 0 * * *", func() {
+		s.rolloverRecurringGoals()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Schedule goal recalculation for status changes driven by the date, such as goals failing after their end
+	// date (daily after the rollover, so new periods are included)
+	_, err = s.cron.AddFunc("10 0 * * *", func() {
+		s.recalculateGoals()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Schedule sending of queued goal emails (every 5 minutes, so completions are congratulated promptly)
+	_, err = s.cron.AddFunc("*/5 * * * *", func() {
+		s.sendGoalNotifications()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Schedule lifecycle campaign triggers (hourly, so win-back and milestone emails start within the hour)
+	_, err = s.cron.AddFunc("20 * * * *", func() {
+		s.runLifecycleTriggers()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Schedule broadcasts (every minute, so they start on time; each run sends every recipient due in batches)
 	_, err = s.cron.AddFunc("* * * * *", func() {
-		s.SendCampaignEmailsBatch(20) // Send 20 emails per minute (1200/hour max)
+		s.sendBroadcasts()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Schedule weekly and monthly digests (hourly, so each user gets theirs in the morning in their timezone)
+	_, err = s.cron.AddFunc("0 * * * *", func() {
+		s.sendDigests()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Schedule bounce and complaint processing (every 5 minutes, so bad addresses are suppressed before the
+	// next reminders go out)
+	if s.bounceMbox != "" {
+		_, err = s.cron.AddFunc("*/5 * * * *", func() {
+			s.processBounces()
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Schedule cleanup of expired habit log idempotency keys (daily at 3 AM)
+	_, err = s.cron.AddFunc("0 3 * * *", func() {
+		s.purgeIdempotencyKeys()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Schedule cleanup of expired email verification tokens (daily at 3 AM)
+	_, err = s.cron.AddFunc("0 3 * * *", func() {
+		s.purgeVerificationTokens()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Schedule cleanup of delivered and dead-lettered emails past their retention (daily at 3 AM)
+	_, err = s.cron.AddFunc("0 3 * * *", func() {
+		s.purgeOutbox()
 	})
 	if err != nil {
 		return err
@@ -103,190 +186,283 @@ func (s *Scheduler) SetBatchDelay(delay time.Duration) {
 	s.batchDelay = delay
 }
 
-// SetDailyReminderTime sets the time for daily reminders (cron format)
-func (s *Scheduler) SetDailyReminderTime(cronExpr string) error {
-	// Validate cron expression
-	_, err := cron.ParseStandard(cronExpr)
+// sendReminder sends a reminder email listing the habits, with a quote and the user's top pattern insight
+func (s *Scheduler) sendReminder(to, firstName string, userID int, habits []Habit, preferencesLink string) error {
+	// Convert habits to email format
+	habitInfos := make([]email.HabitInfo, 0, len(habits))
+	for _, habit := range habits {
+		habitInfos = append(habitInfos, email.HabitInfo{
+			Name:   habit.Name,
+			Emoji:  habit.Emoji,
+			Streak: habit.CurrentStreak,
+		})
+	}
+
+	// Get a random quote
+	quote, err := GetRandomQuoteForEmail()
 	if err != nil {
-		return err
+		log.Printf("Error getting quote: %v", err)
+		// Continue with empty quote rather than failing
+		quote = email.QuoteInfo{
+			Text:   "Success is the sum of small efforts, repeated day in and day out.",
+			Author: "Robert Collier",
+		}
 	}
-	s.dailyTime = cronExpr
-	return nil
-}
 
-// SetWeeklyReminderTime sets the time for weekly reminders (cron format)
-func (s *Scheduler) SetWeeklyReminderTime(cronExpr string) error {
-	// Validate cron expression
-	_, err := cron.ParseStandard(cronExpr)
+	// A pattern insight is a nice-to-have; the reminder still goes out without one
+	insight, err := GetReminderInsight(s.db, userID, time.Now())
 	if err != nil {
-		return err
+		log.Printf("Error getting reminder insight for user %d: %v", userID, err)
+		insight = ""
 	}
-	s.weeklyTime = cronExpr
-	return nil
+
+	return s.emailSvc.SendReminderEmail(to, firstName, habitInfos, quote, insight, preferencesLink)
 }
 
-// sendDailyReminders sends reminder emails to users with habits
-func (s *Scheduler) sendDailyReminders() {
-	env := os.Getenv("APP_ENV")
-	if env == "" {
-		env = "development" // Default to development if not set
+// sendStreakNudge sends the late evening nudge listing the streaks that end tonight unless logged
+func (s *Scheduler) sendStreakNudge(to, firstName string, habits []Habit, preferencesLink string) error {
+	habitInfos := make([]email.HabitInfo, 0, len(habits))
+	for _, habit := range habits {
+		habitInfos = append(habitInfos, email.HabitInfo{
+			Name:   habit.Name,
+			Emoji:  habit.Emoji,
+			Streak: habit.CurrentStreak,
+		})
 	}
 
-	users, err := GetUsersWithHabitsAndNotificationsEnabled(s.db)
-	if err != nil {
-		log.Printf("Error getting users with habits: %v", err)
-		return
+	data := email.StreakNudgeEmailData{
+		FirstName:       firstName,
+		Habits:          habitInfos,
+		AppName:         "The Habits Company",
+		PreferencesLink: preferencesLink,
 	}
+	return s.emailSvc.SendTypedEmail(to, email.StreakNudgeEmail, data)
+}
 
-	log.Printf("[%s] Sending daily reminders to %d users", env, len(users))
+// dispatchReminders sends every reminder due at now and schedules its next occurrence. Reminders missed by
+// more than the grace period, e.g. during downtime, are skipped rather than sent late, and so are reminders
+// with every habit already logged, turned off in the user's preferences or due in their quiet hours.
+func (s *Scheduler) dispatchReminders(now time.Time) {
+	if !s.reminders.TryLock() {
+		return // the previous run is still sending; what it leaves due is picked up next minute
+	}
+	defer s.reminders.Unlock()
 
-	// If not in production, log more details but don't actually send emails
-	// (The email service will handle skipping sends, this is just for better logging)
-	if env != "production" {
-		log.Printf("[%s] Email sending is disabled in non-production environments", env)
-		for _, user := range users {
-			log.Printf("[%s] Would send reminder to: %s (%s)", env, user.Email, user.FirstName)
-		}
+	due, err := GetDueReminders(s.db, now)
+	if err != nil {
+		log.Printf("Error getting due reminders: %v", err)
 		return
 	}
 
-	// Process users in batches
-	for i := 0; i < len(users); i += s.batchSize {
-		end := i + s.batchSize
-		if end > len(users) {
-			end = len(users)
+	for i := range due {
+		reminder := &due[i]
+		sent, err := s.deliverReminder(reminder, now)
+		if err != nil {
+			// Left due, so the next run retries it within the grace period
+			log.Printf("Error sending reminder %d to %s: %v", reminder.ID, reminder.Email, err)
+			continue
 		}
-
-		batch := users[i:end]
-		s.processDailyReminderBatch(batch)
-
-		// Sleep between batches to avoid overwhelming the SMTP server
-		if end < len(users) {
-			time.Sleep(s.batchDelay)
+		if err := reminder.Advance(s.db, now, sent); err != nil {
+			log.Printf("Error scheduling reminder %d: %v", reminder.ID, err)
 		}
 	}
 }
 
-// processDailyReminderBatch processes a batch of users for daily reminders
-func (s *Scheduler) processDailyReminderBatch(users []*User) {
-	for _, user := range users {
-		// Get the user's habits
-		habits, err := GetHabitsByUserID(s.db, int(user.ID))
-		if err != nil {
-			log.Printf("Error getting habits for user %d: %v", user.ID, err)
-			continue
-		}
+// deliverReminder sends the reminder on each channel the user wants it on, reporting whether anything was sent
+func (s *Scheduler) deliverReminder(reminder *DueReminder, now time.Time) (bool, error) {
+	if reminder.Stale(now) {
+		return false, nil
+	}
 
-		// Skip if no habits (shouldn't happen due to our query, but just in case)
-		if len(habits) == 0 {
-			continue
-		}
+	prefs, err := GetNotificationPreferences(s.db, reminder.UserID)
+	if err != nil {
+		return false, err
+	}
+	kind := NotificationReminder
+	if reminder.Kind == ReminderKindStreakNudge {
+		kind = NotificationStreakNudge
+	}
+	emailOn := prefs.Allows(kind, ChannelEmail) && reminder.EmailVerified
+	webhookOn := prefs.Allows(kind, ChannelWebhook)
+	if (!emailOn && !webhookOn) || prefs.InQuietHours(now) {
+		return false, nil
+	}
 
-		// Convert habits to email format
-		habitInfos := make([]email.HabitInfo, 0, len(habits))
-		for _, habit := range habits {
-			habitInfos = append(habitInfos, email.HabitInfo{
-				Name:  habit.Name,
-				Emoji: habit.Emoji,
-			})
-		}
+	habits, err := reminder.ReminderHabits(s.db, now)
+	if err != nil {
+		return false, err
+	}
+	if len(habits) == 0 {
+		return false, nil
+	}
 
-		// Get a random quote
-		quote, err := GetRandomQuoteForEmail()
+	if emailOn {
+		link := PreferencesLink(s.db, reminder.UserID, reminder.Email)
+		if kind == NotificationStreakNudge {
+			err = s.sendStreakNudge(reminder.Email, reminder.FirstName, habits, link)
+		} else {
+			err = s.sendReminder(reminder.Email, reminder.FirstName, reminder.UserID, habits, link)
+		}
 		if err != nil {
-			log.Printf("Error getting quote: %v", err)
-			// Continue with empty quote rather than failing
-			quote = email.QuoteInfo{
-				Text:   "Success is the sum of small efforts, repeated day in and day out.",
-				Author: "Robert Collier",
+			return false, err
+		}
+	}
+	if webhookOn {
+		if err := postWebhook(prefs.WebhookURL, reminderWebhook(kind, habits, now)); err != nil {
+			if !emailOn {
+				return false, err
 			}
+			// The email went out, so a retry would send it twice
+			log.Printf("Error posting reminder webhook for user %d: %v", reminder.UserID, err)
 		}
+	}
+	return true, nil
+}
 
-		// Send the email
-		err = s.emailSvc.SendReminderEmail(user.Email, user.FirstName, habitInfos, quote)
-		if err != nil {
-			log.Printf("Error sending reminder email to %s: %v", user.Email, err)
-			// Continue with next user rather than failing the whole batch
-		} else {
-			log.Printf("Sent reminder email to %s", user.Email)
-		}
+// purgeIdempotencyKeys removes habit log idempotency keys that can no longer be replayed
+func (s *Scheduler) purgeIdempotencyKeys() {
+	purged, err := PurgeExpiredIdempotencyKeys(s.db)
+	if err != nil {
+		log.Printf("Error purging idempotency keys: %v", err)
+		return
 	}
+	log.Printf("Purged %d expired idempotency keys", purged)
 }
 
-// sendWeeklyFirstHabitReminders sends emails to users without habits
-func (s *Scheduler) sendWeeklyFirstHabitReminders() {
-	env := os.Getenv("APP_ENV")
-	if env == "" {
-		env = "development" // Default to development if not set
+// purgeVerificationTokens removes email verification tokens that expired and no longer count towards the limit
+func (s *Scheduler) purgeVerificationTokens() {
+	purged, err := PurgeExpiredVerificationTokens(s.db, time.Now())
+	if err != nil {
+		log.Printf("Error purging verification tokens: %v", err)
+		return
 	}
+	log.Printf("Purged %d expired verification tokens", purged)
+}
 
-	users, err := GetUsersWithNoHabitsAndNotificationsEnabled(s.db)
+// purgeOutbox removes sent and dead-lettered emails past their retention
+func (s *Scheduler) purgeOutbox() {
+	purged, err := email.PurgeOutbox(s.db, time.Now())
 	if err != nil {
-		log.Printf("Error getting users without habits: %v", err)
+		log.Printf("Error purging the email outbox: %v", err)
 		return
 	}
+	log.Printf("Purged %d old outbox emails", purged)
+}
 
-	log.Printf("[%s] Sending first habit reminders to %d users", env, len(users))
+// recordGoalSnapshots stores today's progress for every active goal
+func (s *Scheduler) recordGoalSnapshots() {
+	recorded, err := RecordGoalSnapshots(s.db, time.Now())
+	if err != nil {
+		log.Printf("Error recording goal snapshots: %v", err)
+		return
+	}
+	log.Printf("Recorded %d goal snapshots", recorded)
+}
 
-	// If not in production, log more details but don't actually send emails
-	if env != "production" {
-		log.Printf("[%s] Email sending is disabled in non-production environments", env)
-		for _, user := range users {
-			log.Printf("[%s] Would send first habit reminder to: %s (%s)", env, user.Email, user.FirstName)
-		}
+// rolloverRecurringGoals archives finished periods of recurring goals and opens the next ones
+func (s *Scheduler) rolloverRecurringGoals() {
+	archived, err := RolloverRecurringGoals(s.db, time.Now())
+	if err != nil {
+		log.Printf("Error rolling over recurring goals: %v", err)
 		return
 	}
+	log.Printf("Rolled over %d recurring goal periods", archived)
+}
 
-	// Process users in batches
-	for i := 0; i < len(users); i += s.batchSize {
-		end := i + s.batchSize
-		if end > len(users) {
-			end = len(users)
-		}
+// recalculateGoals updates the stored progress and status of goals that can change with the date, and queues
+// deadline emails for goals ending soon
+func (s *Scheduler) recalculateGoals() {
+	now := time.Now()
+	changed, err := RecalculateGoals(s.db, now)
+	if err != nil {
+		log.Printf("Error recalculating goals: %v", err)
+	} else {
+		log.Printf("Recalculated goals, %d changed status", changed)
+	}
 
-		batch := users[i:end]
-		s.processFirstHabitReminderBatch(batch)
+	queued, err := QueueGoalDeadlineNotifications(s.db, now)
+	if err != nil {
+		log.Printf("Error queueing goal deadline emails: %v", err)
+		return
+	}
+	log.Printf("Queued %d goal deadline emails", queued)
+}
 
-		// Sleep between batches to avoid overwhelming the SMTP server
-		if end < len(users) {
-			time.Sleep(s.batchDelay)
-		}
+// sendGoalNotifications sends queued goal emails
+func (s *Scheduler) sendGoalNotifications() {
+	sent, err := SendGoalNotifications(s.db, s.emailSvc, time.Now())
+	if err != nil {
+		log.Printf("Error sending goal emails: %v", err)
+		return
+	}
+	if sent > 0 {
+		log.Printf("Sent %d goal emails", sent)
 	}
 }
 
-// processFirstHabitReminderBatch processes a batch of users for first habit reminders
-func (s *Scheduler) processFirstHabitReminderBatch(users []*User) {
-	for _, user := range users {
-		// Get a random quote
-		quote, err := GetRandomQuoteForEmail()
-		if err != nil {
-			log.Printf("Error getting quote: %v", err)
-			// Continue with empty quote rather than failing
-			quote = email.QuoteInfo{
-				Text:   "The secret of getting ahead is getting started.",
-				Author: "Mark Twain",
-			}
-		}
+// runLifecycleTriggers enrolls users in the triggered campaigns matching their activity
+func (s *Scheduler) runLifecycleTriggers() {
+	enrolled, err := RunLifecycleTriggers(s.db, time.Now())
+	if err != nil {
+		log.Printf("Error running lifecycle triggers: %v", err)
+		return
+	}
+	if enrolled > 0 {
+		log.Printf("Enrolled %d users in triggered campaigns", enrolled)
+	}
+}
 
-		// Send the email
-		err = s.emailSvc.SendFirstHabitEmail(user.Email, user.FirstName, quote)
+// processBounces suppresses the addresses in the bounces and complaints delivered to the bounce mailbox
+func (s *Scheduler) processBounces() {
+	suppressed, err := email.ProcessBounceMailbox(s.db, s.bounceMbox)
+	if err != nil {
+		log.Printf("Error processing bounce mailbox: %v", err)
+		return
+	}
+	if suppressed > 0 {
+		log.Printf("Suppressed %d addresses from the bounce mailbox", suppressed)
+	}
+}
+
+// sendDigests sends the weekly and monthly progress digests that are due
+func (s *Scheduler) sendDigests() {
+	sent, err := SendDigests(s.db, s.emailSvc, time.Now())
+	if err != nil {
+		log.Printf("Error sending digests: %v", err)
+		return
+	}
+	if sent > 0 {
+		log.Printf("Sent %d digests", sent)
+	}
+}
+
+// sendBroadcasts starts the broadcasts that are due and emails their recipients in batches
+func (s *Scheduler) sendBroadcasts() {
+	if !s.broadcasts.TryLock() {
+		return // the previous run is still sending
+	}
+	defer s.broadcasts.Unlock()
+
+	if _, err := StartDueBroadcasts(s.db, time.Now()); err != nil {
+		log.Printf("Error starting broadcasts: %v", err)
+	}
+	for {
+		sent, err := SendBroadcastBatch(s.db, s.emailSvc, s.batchSize, time.Now())
 		if err != nil {
-			log.Printf("Error sending first habit email to %s: %v", user.Email, err)
-			// Continue with next user rather than failing the whole batch
-		} else {
-			log.Printf("Sent first habit email to %s", user.Email)
+			log.Printf("Error sending broadcasts: %v", err)
+			return
 		}
+		if sent < s.batchSize {
+			return // nobody left
+		}
+		// Sleep between batches to avoid overwhelming the SMTP server
+		time.Sleep(s.batchDelay)
 	}
 }
 
-// RunDailyRemindersNow triggers the daily reminder job immediately
+// RunDailyRemindersNow dispatches the reminders due now immediately, the same way the minute job does
 func (s *Scheduler) RunDailyRemindersNow() {
-	go s.sendDailyReminders()
-}
-
-// RunWeeklyFirstHabitRemindersNow triggers the weekly first habit reminder job immediately
-func (s *Scheduler) RunWeeklyFirstHabitRemindersNow() {
-	go s.sendWeeklyFirstHabitReminders()
+	go s.dispatchReminders(time.Now())
 }
 
 // SendCampaignEmails sends pending campaign emails (legacy method, uses default batch size)
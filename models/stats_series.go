@@ -0,0 +1,378 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// StatsGranularity is the size of each bucket in a stats time series
+type StatsGranularity string
+
+const (
+	GranularityDay   StatsGranularity = "day"
+	GranularityWeek  StatsGranularity = "week"
+	GranularityMonth StatsGranularity = "month"
+	GranularityYear  StatsGranularity = "year"
+)
+
+// maxStatsBuckets caps the number of buckets a single query may produce
+const maxStatsBuckets = 1000
+
+// StatsQuery describes a stats request for one habit over a date range
+type StatsQuery struct {
+	HabitID     int
+	From        time.Time
+	To          time.Time
+	Granularity StatsGranularity
+	CompareFrom *time.Time
+	CompareTo   *time.Time
+}
+
+// StatsBucket holds aggregated values for one period of a stats series
+type StatsBucket struct {
+	Start          time.Time      `json:"start"`
+	End            time.Time      `json:"end"`
+	Days           int            `json:"days"` // elapsed days in the bucket, future days are not counted
+	Done           int            `json:"done"`
+	Missed         int            `json:"missed"`
+	Skipped        int            `json:"skipped"`
+	CompletionRate float64        `json:"completion_rate"` // percentage of elapsed days marked done
+	ValueTotal     float64        `json:"value_total,omitempty"`
+	ValueAverage   float64        `json:"value_average,omitempty"`
+	Options        []ChoiceOption `json:"options,omitempty"`
+	Sets           int            `json:"sets,omitempty"`
+	Reps           int            `json:"reps,omitempty"`
+	Volume         float64        `json:"volume,omitempty"` // sum of reps x weight, in kg
+}
+
+// StatsSeries is a bucketed time series with a summary over the whole range
+type StatsSeries struct {
+	From        time.Time        `json:"from"`
+	To          time.Time        `json:"to"`
+	Granularity StatsGranularity `json:"granularity"`
+	Buckets     []StatsBucket    `json:"buckets"`
+	Summary     StatsBucket      `json:"summary"`
+}
+
+// StatsComparison describes how the current period changed relative to the comparison period
+type StatsComparison struct {
+	CompletionRateDelta float64  `json:"completion_rate_delta"` // percentage points
+	DoneChange          *float64 `json:"done_change"`           // percent, nil when the comparison had none
+	ValueTotalChange    *float64 `json:"value_total_change,omitempty"`
+	VolumeChange        *float64 `json:"volume_change,omitempty"`
+}
+
+// StatsQueryResult is the response for a stats query
+type StatsQueryResult struct {
+	HabitID    int              `json:"habit_id"`
+	HabitType  HabitType        `json:"habit_type"`
+	Current    StatsSeries      `json:"current"`
+	Comparison *StatsSeries     `json:"comparison,omitempty"`
+	Change     *StatsComparison `json:"change,omitempty"`
+}
+
+// ParseStatsGranularity validates a granularity string, defaulting to day
+func ParseStatsGranularity(value string) (StatsGranularity, error) {
+	switch StatsGranularity(value) {
+	case "":
+		return GranularityDay, nil
+	case GranularityDay, GranularityWeek, GranularityMonth, GranularityYear:
+		return StatsGranularity(value), nil
+	default:
+		return "", fmt.Errorf("invalid granularity: %s", value)
+	}
+}
+
+// PreviousPeriod returns the period of equal length that ends the day before from
+func PreviousPeriod(from, to time.Time) (time.Time, time.Time) {
+	days := int(to.Sub(from).Hours()/24) + 1
+	prevTo := from.AddDate(0, 0, -1)
+	return prevTo.AddDate(0, 0, -(days - 1)), prevTo
+}
+
+// Validate checks that the query describes a usable range
+func (q *StatsQuery) Validate() error {
+	if q.To.Before(q.From) {
+		return fmt.Errorf("to date must not be before from date")
+	}
+	if _, err := ParseStatsGranularity(string(q.Granularity)); err != nil {
+		return err
+	}
+	if countBuckets(q.From, q.To, q.Granularity) > maxStatsBuckets {
+		return fmt.Errorf("range produces more than %d buckets, use a coarser granularity", maxStatsBuckets)
+	}
+	if (q.CompareFrom == nil) != (q.CompareTo == nil) {
+		return fmt.Errorf("comparison period needs both a start and an end date")
+	}
+	if q.CompareFrom != nil {
+		if q.CompareTo.Before(*q.CompareFrom) {
+			return fmt.Errorf("comparison end date must not be before comparison start date")
+		}
+		if countBuckets(*q.CompareFrom, *q.CompareTo, q.Granularity) > maxStatsBuckets {
+			return fmt.Errorf("comparison range produces more than %d buckets, use a coarser granularity", maxStatsBuckets)
+		}
+	}
+	return nil
+}
+
+// QueryHabitStats builds time series stats for a habit over the query range and optional comparison period
+func QueryHabitStats(db *sql.DB, q StatsQuery) (StatsQueryResult, error) {
+	if err := q.Validate(); err != nil {
+		return StatsQueryResult{}, err
+	}
+
+	var habitType HabitType
+	var habitOptionsStr sql.NullString
+	err := db.QueryRow("SELECT habit_type, habit_options FROM habits WHERE id = ?", q.HabitID).Scan(&habitType, &habitOptionsStr)
+	if err != nil {
+		return StatsQueryResult{}, fmt.Errorf("habit not found: %v", err)
+	}
+
+	var options []HabitOption
+	if habitType == OptionSelectHabit && habitOptionsStr.Valid {
+		if err := json.Unmarshal([]byte(habitOptionsStr.String), &options); err != nil {
+			return StatsQueryResult{}, fmt.Errorf("invalid habit options format: %v", err)
+		}
+	}
+
+	result := StatsQueryResult{
+		HabitID:   q.HabitID,
+		HabitType: habitType,
+	}
+
+	today := truncateToDay(time.Now().UTC())
+
+	logs, err := GetHabitLogsByDateRange(db, q.HabitID, q.From, q.To)
+	if err != nil {
+		return StatsQueryResult{}, fmt.Errorf("error getting habit logs: %v", err)
+	}
+	result.Current = buildStatsSeries(habitType, options, logs, q.From, q.To, q.Granularity, today)
+
+	if q.CompareFrom != nil {
+		compareLogs, err := GetHabitLogsByDateRange(db, q.HabitID, *q.CompareFrom, *q.CompareTo)
+		if err != nil {
+			return StatsQueryResult{}, fmt.Errorf("error getting comparison logs: %v", err)
+		}
+		comparison := buildStatsSeries(habitType, options, compareLogs, *q.CompareFrom, *q.CompareTo, q.Granularity, today)
+		result.Comparison = &comparison
+		result.Change = compareStatsBuckets(habitType, result.Current.Summary, comparison.Summary)
+	}
+
+	return result, nil
+}
+
+// buildStatsSeries buckets logs between from and to
+func buildStatsSeries(habitType HabitType, options []HabitOption, logs []HabitLog, from, to time.Time, granularity StatsGranularity, today time.Time) StatsSeries {
+	from = truncateToDay(from)
+	to = truncateToDay(to)
+
+	series := StatsSeries{
+		From:        from,
+		To:          to,
+		Granularity: granularity,
+		Buckets:     []StatsBucket{},
+	}
+
+	for start := bucketStart(from, granularity); !start.After(to); start = nextBucketStart(start, granularity) {
+		bucket := StatsBucket{
+			Start: maxTime(start, from),
+			End:   minTime(nextBucketStart(start, granularity).AddDate(0, 0, -1), to),
+		}
+		bucket.Days = elapsedDays(bucket.Start, bucket.End, today)
+		series.Buckets = append(series.Buckets, bucket)
+	}
+
+	series.Summary = StatsBucket{Start: from, End: to, Days: elapsedDays(from, to, today)}
+
+	for i := range series.Buckets {
+		series.Buckets[i].Options = newOptionCounts(habitType, options)
+	}
+	series.Summary.Options = newOptionCounts(habitType, options)
+
+	for _, log := range logs {
+		date := truncateToDay(log.Date.UTC())
+		for i := range series.Buckets {
+			if !date.Before(series.Buckets[i].Start) && !date.After(series.Buckets[i].End) {
+				addLogToBucket(&series.Buckets[i], habitType, log)
+				break
+			}
+		}
+		addLogToBucket(&series.Summary, habitType, log)
+	}
+
+	for i := range series.Buckets {
+		finalizeBucket(&series.Buckets[i])
+	}
+	finalizeBucket(&series.Summary)
+
+	return series
+}
+
+// addLogToBucket adds a single log to the bucket's counters
+func addLogToBucket(bucket *StatsBucket, habitType HabitType, log HabitLog) {
+	switch log.Status {
+	case "done":
+		bucket.Done++
+	case "missed":
+		bucket.Missed++
+	case "skipped":
+		bucket.Skipped++
+	}
+
+	if log.Status != "done" || !log.Value.Valid {
+		return
+	}
+
+	switch habitType {
+	case NumericHabit:
+		var value struct {
+			Value float64 `json:"value"`
+		}
+		if err := json.Unmarshal([]byte(log.Value.String), &value); err == nil {
+			bucket.ValueTotal += value.Value
+		}
+	case OptionSelectHabit:
+		var value HabitOption
+		if err := json.Unmarshal([]byte(log.Value.String), &value); err == nil {
+			for i := range bucket.Options {
+				if bucket.Options[i].Emoji == value.Emoji && bucket.Options[i].Label == value.Label {
+					bucket.Options[i].Count++
+					break
+				}
+			}
+		}
+	case SetRepsHabit:
+		var value SetRepsValue
+		if err := json.Unmarshal([]byte(log.Value.String), &value); err == nil {
+			bucket.Sets += len(value.Sets)
+			for _, set := range value.Sets {
+				bucket.Reps += set.Reps
+				bucket.Volume += float64(set.Reps) * ConvertWeight(set.Value, value.Unit, UnitKg)
+			}
+		}
+	}
+}
+
+// finalizeBucket computes the derived rates and averages of a bucket
+func finalizeBucket(bucket *StatsBucket) {
+	if bucket.Days > 0 {
+		bucket.CompletionRate = roundTo2(float64(bucket.Done) / float64(bucket.Days) * 100)
+	}
+	if bucket.Done > 0 && bucket.ValueTotal != 0 {
+		bucket.ValueAverage = roundTo2(bucket.ValueTotal / float64(bucket.Done))
+	}
+	bucket.ValueTotal = roundTo2(bucket.ValueTotal)
+	bucket.Volume = roundTo2(bucket.Volume)
+}
+
+// compareStatsBuckets computes the change between two summaries
+func compareStatsBuckets(habitType HabitType, current, previous StatsBucket) *StatsComparison {
+	change := &StatsComparison{
+		CompletionRateDelta: roundTo2(current.CompletionRate - previous.CompletionRate),
+		DoneChange:          percentChange(float64(current.Done), float64(previous.Done)),
+	}
+	switch habitType {
+	case NumericHabit:
+		change.ValueTotalChange = percentChange(current.ValueTotal, previous.ValueTotal)
+	case SetRepsHabit:
+		change.VolumeChange = percentChange(current.Volume, previous.Volume)
+	}
+	return change
+}
+
+// newOptionCounts returns zeroed option counters for option-select habits
+func newOptionCounts(habitType HabitType, options []HabitOption) []ChoiceOption {
+	if habitType != OptionSelectHabit {
+		return nil
+	}
+	counts := make([]ChoiceOption, len(options))
+	for i, opt := range options {
+		counts[i] = ChoiceOption{Emoji: opt.Emoji, Label: opt.Label}
+	}
+	return counts
+}
+
+// bucketStart returns the start of the bucket containing t
+func bucketStart(t time.Time, granularity StatsGranularity) time.Time {
+	t = truncateToDay(t)
+	switch granularity {
+	case GranularityWeek:
+		// Weeks start on Monday
+		return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+	case GranularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case GranularityYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return t
+	}
+}
+
+// nextBucketStart returns the start of the bucket following the one starting at start
+func nextBucketStart(start time.Time, granularity StatsGranularity) time.Time {
+	switch granularity {
+	case GranularityWeek:
+		return start.AddDate(0, 0, 7)
+	case GranularityMonth:
+		return start.AddDate(0, 1, 0)
+	case GranularityYear:
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// countBuckets returns how many buckets a range produces
+func countBuckets(from, to time.Time, granularity StatsGranularity) int {
+	count := 0
+	for start := bucketStart(from, granularity); !start.After(truncateToDay(to)); start = nextBucketStart(start, granularity) {
+		count++
+		if count > maxStatsBuckets {
+			break
+		}
+	}
+	return count
+}
+
+// elapsedDays counts the days between start and end inclusive, ignoring days after today
+func elapsedDays(start, end, today time.Time) int {
+	end = minTime(end, today)
+	if end.Before(start) {
+		return 0
+	}
+	return int(end.Sub(start).Hours()/24) + 1
+}
+
+// percentChange returns the percentage change from previous to current, or nil if previous is zero
+func percentChange(current, previous float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := roundTo2((current - previous) / previous * 100)
+	return &change
+}
+
+func truncateToDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func roundTo2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestQueryHabitStats tests bucketing, summaries and period comparison
+func TestQueryHabitStats(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+
+	date := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatalf("Failed to parse date: %v", err)
+		}
+		return d
+	}
+
+	t.Run("numeric habit by week with previous period", func(t *testing.T) {
+		habit := createTestHabitForTests(t, db, userID, NumericHabit, "Pages read")
+
+		// 2024-01-01 is a Monday
+		createHabitLog(t, db, habit.ID, date("2024-01-01"), "done", map[string]interface{}{"value": 10})
+		createHabitLog(t, db, habit.ID, date("2024-01-03"), "done", map[string]interface{}{"value": 20})
+		createHabitLog(t, db, habit.ID, date("2024-01-09"), "done", map[string]interface{}{"value": 30})
+		createHabitLog(t, db, habit.ID, date("2024-01-10"), "missed", nil)
+		// Previous period
+		createHabitLog(t, db, habit.ID, date("2023-12-20"), "done", map[string]interface{}{"value": 40})
+
+		from, to := date("2024-01-01"), date("2024-01-14")
+		compareFrom, compareTo := PreviousPeriod(from, to)
+		if !compareFrom.Equal(date("2023-12-18")) || !compareTo.Equal(date("2023-12-31")) {
+			t.Fatalf("Unexpected previous period %s - %s", compareFrom, compareTo)
+		}
+
+		result, err := QueryHabitStats(db, StatsQuery{
+			HabitID:     habit.ID,
+			From:        from,
+			To:          to,
+			Granularity: GranularityWeek,
+			CompareFrom: &compareFrom,
+			CompareTo:   &compareTo,
+		})
+		if err != nil {
+			t.Fatalf("QueryHabitStats failed: %v", err)
+		}
+
+		if len(result.Current.Buckets) != 2 {
+			t.Fatalf("Expected 2 weekly buckets, got %d", len(result.Current.Buckets))
+		}
+		first := result.Current.Buckets[0]
+		if first.Done != 2 || first.ValueTotal != 30 || first.ValueAverage != 15 {
+			t.Errorf("Unexpected first bucket: %+v", first)
+		}
+		if first.CompletionRate != roundTo2(2.0/7*100) {
+			t.Errorf("Expected completion rate %.2f, got %.2f", roundTo2(2.0/7*100), first.CompletionRate)
+		}
+		second := result.Current.Buckets[1]
+		if second.Done != 1 || second.Missed != 1 || second.ValueTotal != 30 {
+			t.Errorf("Unexpected second bucket: %+v", second)
+		}
+
+		if result.Current.Summary.ValueTotal != 60 || result.Current.Summary.Days != 14 {
+			t.Errorf("Unexpected summary: %+v", result.Current.Summary)
+		}
+		if result.Comparison == nil || result.Comparison.Summary.ValueTotal != 40 {
+			t.Fatalf("Unexpected comparison: %+v", result.Comparison)
+		}
+		if result.Change == nil || result.Change.ValueTotalChange == nil || *result.Change.ValueTotalChange != 50 {
+			t.Errorf("Expected value total change of 50%%, got %+v", result.Change)
+		}
+		if result.Change.DoneChange == nil || *result.Change.DoneChange != 200 {
+			t.Errorf("Expected done change of 200%%, got %+v", result.Change.DoneChange)
+		}
+	})
+
+	t.Run("buckets are clipped to the range", func(t *testing.T) {
+		habit := createTestHabitForTests(t, db, userID, BinaryHabit, "Clipped")
+
+		result, err := QueryHabitStats(db, StatsQuery{
+			HabitID:     habit.ID,
+			From:        date("2024-01-15"),
+			To:          date("2024-03-10"),
+			Granularity: GranularityMonth,
+		})
+		if err != nil {
+			t.Fatalf("QueryHabitStats failed: %v", err)
+		}
+		if len(result.Current.Buckets) != 3 {
+			t.Fatalf("Expected 3 monthly buckets, got %d", len(result.Current.Buckets))
+		}
+		if !result.Current.Buckets[0].Start.Equal(date("2024-01-15")) || result.Current.Buckets[0].Days != 17 {
+			t.Errorf("Unexpected first bucket: %+v", result.Current.Buckets[0])
+		}
+		if !result.Current.Buckets[2].End.Equal(date("2024-03-10")) || result.Current.Buckets[2].Days != 10 {
+			t.Errorf("Unexpected last bucket: %+v", result.Current.Buckets[2])
+		}
+		if result.Comparison != nil || result.Change != nil {
+			t.Error("Expected no comparison without a comparison period")
+		}
+	})
+
+	t.Run("option distribution and set-reps volume", func(t *testing.T) {
+		optionHabit := createTestHabitForTests(t, db, userID, OptionSelectHabit, "Mood")
+		createHabitLog(t, db, optionHabit.ID, date("2024-02-01"), "done", HabitOption{Emoji: "🙂", Label: "Good"})
+		createHabitLog(t, db, optionHabit.ID, date("2024-02-02"), "done", HabitOption{Emoji: "🙂", Label: "Good"})
+		createHabitLog(t, db, optionHabit.ID, date("2024-02-03"), "done", HabitOption{Emoji: "☹️", Label: "Bad"})
+
+		result, err := QueryHabitStats(db, StatsQuery{
+			HabitID:     optionHabit.ID,
+			From:        date("2024-02-01"),
+			To:          date("2024-02-29"),
+			Granularity: GranularityMonth,
+		})
+		if err != nil {
+			t.Fatalf("QueryHabitStats failed: %v", err)
+		}
+		options := result.Current.Summary.Options
+		if len(options) != 3 || options[0].Count != 2 || options[1].Count != 0 || options[2].Count != 1 {
+			t.Errorf("Unexpected option distribution: %+v", options)
+		}
+
+		setRepsHabit := createTestHabitForTests(t, db, userID, SetRepsHabit, "Squats")
+		createHabitLog(t, db, setRepsHabit.ID, date("2024-02-01"), "done", SetRepsValue{
+			Sets: []SetRep{{Set: 1, Reps: 10, Value: 50}, {Set: 2, Reps: 8, Value: 60}},
+			Unit: "kg",
+		})
+		createHabitLog(t, db, setRepsHabit.ID, date("2024-02-02"), "done", SetRepsValue{
+			Sets: []SetRep{{Set: 1, Reps: 10, Value: 100}},
+			Unit: "lbs",
+		})
+
+		result, err = QueryHabitStats(db, StatsQuery{
+			HabitID:     setRepsHabit.ID,
+			From:        date("2024-02-01"),
+			To:          date("2024-02-07"),
+			Granularity: GranularityDay,
+		})
+		if err != nil {
+			t.Fatalf("QueryHabitStats failed: %v", err)
+		}
+		if len(result.Current.Buckets) != 7 {
+			t.Fatalf("Expected 7 daily buckets, got %d", len(result.Current.Buckets))
+		}
+		first := result.Current.Buckets[0]
+		if first.Sets != 2 || first.Reps != 18 || first.Volume != 980 {
+			t.Errorf("Unexpected set-reps bucket: %+v", first)
+		}
+		// Sets logged in lbs are converted so the volume stays in kg
+		if volume := result.Current.Summary.Volume; math.Abs(volume-(980+1000*KgPerLb)) > 0.01 {
+			t.Errorf("Expected the lbs sets converted to kg, got a volume of %.2f", volume)
+		}
+	})
+
+	t.Run("invalid queries", func(t *testing.T) {
+		habit := createTestHabitForTests(t, db, userID, BinaryHabit, "Invalid")
+
+		if _, err := QueryHabitStats(db, StatsQuery{HabitID: habit.ID, From: date("2024-02-01"), To: date("2024-01-01"), Granularity: GranularityDay}); err == nil {
+			t.Error("Expected error for reversed range")
+		}
+		if _, err := QueryHabitStats(db, StatsQuery{HabitID: habit.ID, From: date("2000-01-01"), To: date("2024-01-01"), Granularity: GranularityDay}); err == nil {
+			t.Error("Expected error for too many buckets")
+		}
+		if _, err := ParseStatsGranularity("hour"); err == nil {
+			t.Error("Expected error for unknown granularity")
+		}
+	})
+}
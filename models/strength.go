@@ -0,0 +1,355 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Weight units for set-reps logs
+const (
+	UnitKg  = "kg"
+	UnitLbs = "lbs"
+)
+
+// KgPerLb is the exact conversion factor between pounds and kilograms
+const KgPerLb = 0.45359237
+
+const (
+	// maxRepsForOneRepMax is the highest rep count the 1RM formulas are reasonably accurate for
+	maxRepsForOneRepMax = 12
+	// overloadRepTarget is the reps per set at which the suggestion moves to a heavier weight
+	overloadRepTarget = 12
+	// overloadRepFloor is the reps per set suggested after a weight increase
+	overloadRepFloor = 8
+)
+
+// IsValidWeightUnit reports whether unit is a supported weight unit; empty means the default (kg)
+func IsValidWeightUnit(unit string) bool {
+	return unit == "" || unit == UnitKg || unit == UnitLbs
+}
+
+// ConvertWeight converts a weight between kg and lbs; an empty unit is treated as kg
+func ConvertWeight(value float64, from, to string) float64 {
+	if from == "" {
+		from = UnitKg
+	}
+	if to == "" {
+		to = UnitKg
+	}
+	switch {
+	case from == to:
+		return value
+	case from == UnitLbs && to == UnitKg:
+		return value * KgPerLb
+	default:
+		return value / KgPerLb
+	}
+}
+
+// EpleyOneRepMax estimates a one-rep max as weight * (1 + reps/30)
+func EpleyOneRepMax(weight float64, reps int) float64 {
+	if reps <= 1 {
+		return weight
+	}
+	return weight * (1 + float64(reps)/30)
+}
+
+// BrzyckiOneRepMax estimates a one-rep max as weight * 36 / (37 - reps)
+func BrzyckiOneRepMax(weight float64, reps int) float64 {
+	if reps <= 1 {
+		return weight
+	}
+	if reps >= 37 {
+		return 0
+	}
+	return weight * 36 / (37 - float64(reps))
+}
+
+// StrengthRecord is a single best set
+type StrengthRecord struct {
+	Weight float64   `json:"weight"`
+	Reps   int       `json:"reps"`
+	Date   time.Time `json:"date"`
+}
+
+// VolumeRecord is the volume load (reps x weight) of a day or week
+type VolumeRecord struct {
+	Date   time.Time `json:"date"` // the day, or the Monday starting the week
+	Volume float64   `json:"volume"`
+	Sets   int       `json:"sets"`
+	Reps   int       `json:"reps"`
+}
+
+// OneRepMaxPoint is the best estimated one-rep max of a session
+type OneRepMaxPoint struct {
+	Date    time.Time `json:"date"`
+	Weight  float64   `json:"weight"`
+	Reps    int       `json:"reps"`
+	Epley   float64   `json:"epley"`
+	Brzycki float64   `json:"brzycki"`
+}
+
+// OverloadSuggestion is the recommended target for the next session
+type OverloadSuggestion struct {
+	Weight float64 `json:"weight"`
+	Reps   int     `json:"reps"`
+	Sets   int     `json:"sets"`
+	Reason string  `json:"reason"`
+}
+
+// StrengthStats holds strength-training analytics for a set-reps habit.
+// All weights are expressed in Unit, regardless of the unit each log was recorded in.
+type StrengthStats struct {
+	HabitID          int                 `json:"habit_id"`
+	Unit             string              `json:"unit"`
+	Sessions         int                 `json:"sessions"`
+	MaxWeight        *StrengthRecord     `json:"max_weight,omitempty"`
+	MaxRepsAtWeight  []StrengthRecord    `json:"max_reps_at_weight"` // heaviest first
+	BestVolumeDay    *VolumeRecord       `json:"best_volume_day,omitempty"`
+	BestOneRepMax    *OneRepMaxPoint     `json:"best_one_rep_max,omitempty"`
+	OneRepMaxTrend   []OneRepMaxPoint    `json:"one_rep_max_trend"`
+	WeeklyVolume     []VolumeRecord      `json:"weekly_volume"`
+	NextSession      *OverloadSuggestion `json:"next_session,omitempty"`
+	WeightIncrement  float64             `json:"weight_increment"`
+	IsBodyweightOnly bool                `json:"is_bodyweight_only"`
+}
+
+// strengthSession is one day's sets with weights already converted to the target unit
+type strengthSession struct {
+	date time.Time
+	sets []SetRep
+}
+
+// GetStrengthStats computes personal records, 1RM trends, weekly volume and an overload suggestion for a set-reps habit.
+// If unit is empty, the unit used most often in the habit's logs is used.
+func GetStrengthStats(db *sql.DB, habitID int, unit string) (StrengthStats, error) {
+	if !IsValidWeightUnit(unit) {
+		return StrengthStats{}, fmt.Errorf("invalid unit: %s", unit)
+	}
+
+	var habitType HabitType
+	err := db.QueryRow("SELECT habit_type FROM habits WHERE id = ?", habitID).Scan(&habitType)
+	if err != nil {
+		return StrengthStats{}, fmt.Errorf("habit not found: %v", err)
+	}
+	if habitType != SetRepsHabit {
+		return StrengthStats{}, fmt.Errorf("habit is not set-reps type")
+	}
+
+	rows, err := db.Query(`
+		SELECT date, value
+		FROM habit_logs
+		WHERE habit_id = ? AND status = 'done' AND value IS NOT NULL
+		ORDER BY date ASC
+	`, habitID)
+	if err != nil {
+		return StrengthStats{}, fmt.Errorf("error getting habit logs: %v", err)
+	}
+	defer rows.Close()
+
+	type rawSession struct {
+		date  time.Time
+		value SetRepsValue
+	}
+	raw := []rawSession{}
+	unitCounts := map[string]int{}
+	for rows.Next() {
+		var date time.Time
+		var value string
+		if err := rows.Scan(&date, &value); err != nil {
+			return StrengthStats{}, fmt.Errorf("error scanning habit log: %v", err)
+		}
+		var v SetRepsValue
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			continue
+		}
+		if v.Unit == "" {
+			v.Unit = UnitKg
+		}
+		unitCounts[v.Unit]++
+		raw = append(raw, rawSession{date: truncateToDay(date.UTC()), value: v})
+	}
+	if err := rows.Err(); err != nil {
+		return StrengthStats{}, err
+	}
+
+	if unit == "" {
+		unit = UnitKg
+		if unitCounts[UnitLbs] > unitCounts[UnitKg] {
+			unit = UnitLbs
+		}
+	}
+
+	sessions := make([]strengthSession, 0, len(raw))
+	for _, r := range raw {
+		session := strengthSession{date: r.date}
+		for _, set := range r.value.Sets {
+			set.Value = ConvertWeight(set.Value, r.value.Unit, unit)
+			session.sets = append(session.sets, set)
+		}
+		sessions = append(sessions, session)
+	}
+
+	stats := computeStrengthStats(sessions, unit)
+	stats.HabitID = habitID
+	return stats, nil
+}
+
+// computeStrengthStats derives all strength analytics from sessions in date order
+func computeStrengthStats(sessions []strengthSession, unit string) StrengthStats {
+	stats := StrengthStats{
+		Unit:             unit,
+		Sessions:         len(sessions),
+		MaxRepsAtWeight:  []StrengthRecord{},
+		OneRepMaxTrend:   []OneRepMaxPoint{},
+		WeeklyVolume:     []VolumeRecord{},
+		WeightIncrement:  2.5,
+		IsBodyweightOnly: true,
+	}
+	if unit == UnitLbs {
+		stats.WeightIncrement = 5
+	}
+
+	repsAtWeight := map[float64]StrengthRecord{}
+	weekly := map[time.Time]*VolumeRecord{}
+
+	for _, session := range sessions {
+		day := VolumeRecord{Date: session.date}
+		var best *OneRepMaxPoint
+
+		for _, set := range session.sets {
+			weight := roundTo2(set.Value)
+			day.Sets++
+			day.Reps += set.Reps
+			day.Volume += float64(set.Reps) * weight
+
+			if weight > 0 {
+				stats.IsBodyweightOnly = false
+			}
+
+			if stats.MaxWeight == nil || weight > stats.MaxWeight.Weight ||
+				(weight == stats.MaxWeight.Weight && set.Reps > stats.MaxWeight.Reps) {
+				stats.MaxWeight = &StrengthRecord{Weight: weight, Reps: set.Reps, Date: session.date}
+			}
+
+			if record, ok := repsAtWeight[weight]; !ok || set.Reps > record.Reps {
+				repsAtWeight[weight] = StrengthRecord{Weight: weight, Reps: set.Reps, Date: session.date}
+			}
+
+			if weight > 0 && set.Reps > 0 && set.Reps <= maxRepsForOneRepMax {
+				epley := EpleyOneRepMax(weight, set.Reps)
+				if best == nil || epley > best.Epley {
+					best = &OneRepMaxPoint{
+						Date:    session.date,
+						Weight:  weight,
+						Reps:    set.Reps,
+						Epley:   roundTo2(epley),
+						Brzycki: roundTo2(BrzyckiOneRepMax(weight, set.Reps)),
+					}
+				}
+			}
+		}
+
+		day.Volume = roundTo2(day.Volume)
+		if stats.BestVolumeDay == nil || day.Volume > stats.BestVolumeDay.Volume {
+			d := day
+			stats.BestVolumeDay = &d
+		}
+
+		if best != nil {
+			stats.OneRepMaxTrend = append(stats.OneRepMaxTrend, *best)
+			if stats.BestOneRepMax == nil || best.Epley > stats.BestOneRepMax.Epley {
+				b := *best
+				stats.BestOneRepMax = &b
+			}
+		}
+
+		weekStart := bucketStart(session.date, GranularityWeek)
+		week, ok := weekly[weekStart]
+		if !ok {
+			week = &VolumeRecord{Date: weekStart}
+			weekly[weekStart] = week
+		}
+		week.Sets += day.Sets
+		week.Reps += day.Reps
+		week.Volume = roundTo2(week.Volume + day.Volume)
+	}
+
+	for _, record := range repsAtWeight {
+		stats.MaxRepsAtWeight = append(stats.MaxRepsAtWeight, record)
+	}
+	sort.Slice(stats.MaxRepsAtWeight, func(i, j int) bool {
+		return stats.MaxRepsAtWeight[i].Weight > stats.MaxRepsAtWeight[j].Weight
+	})
+
+	for _, week := range weekly {
+		stats.WeeklyVolume = append(stats.WeeklyVolume, *week)
+	}
+	sort.Slice(stats.WeeklyVolume, func(i, j int) bool {
+		return stats.WeeklyVolume[i].Date.Before(stats.WeeklyVolume[j].Date)
+	})
+
+	if len(sessions) > 0 {
+		stats.NextSession = suggestOverload(sessions[len(sessions)-1], stats.WeightIncrement, unit)
+	}
+
+	return stats
+}
+
+// suggestOverload applies double progression to the last session: add reps at the working weight
+// until every working set reaches the rep target, then add weight and drop back to the rep floor
+func suggestOverload(last strengthSession, increment float64, unit string) *OverloadSuggestion {
+	if len(last.sets) == 0 {
+		return nil
+	}
+
+	working := 0.0
+	for _, set := range last.sets {
+		working = math.Max(working, roundTo2(set.Value))
+	}
+
+	workingSets, minReps, maxReps := 0, math.MaxInt32, 0
+	for _, set := range last.sets {
+		if roundTo2(set.Value) != working {
+			continue
+		}
+		workingSets++
+		if set.Reps < minReps {
+			minReps = set.Reps
+		}
+		if set.Reps > maxReps {
+			maxReps = set.Reps
+		}
+	}
+
+	if working == 0 {
+		return &OverloadSuggestion{
+			Reps:   maxReps + 1,
+			Sets:   workingSets,
+			Reason: fmt.Sprintf("Aim for %d reps on every set, one more than your best set last time.", maxReps+1),
+		}
+	}
+
+	if minReps >= overloadRepTarget {
+		next := roundTo2(working + increment)
+		return &OverloadSuggestion{
+			Weight: next,
+			Reps:   overloadRepFloor,
+			Sets:   workingSets,
+			Reason: fmt.Sprintf("You hit %d+ reps on every set at %g %s. Move up to %g %s for %d reps.",
+				overloadRepTarget, working, unit, next, unit, overloadRepFloor),
+		}
+	}
+
+	return &OverloadSuggestion{
+		Weight: working,
+		Reps:   minReps + 1,
+		Sets:   workingSets,
+		Reason: fmt.Sprintf("Stay at %g %s and aim for %d reps on every set. Add weight once every set reaches %d.",
+			working, unit, minReps+1, overloadRepTarget),
+	}
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestOneRepMaxFormulas tests the Epley and Brzycki estimates
+func TestOneRepMaxFormulas(t *testing.T) {
+	if got := EpleyOneRepMax(100, 10); math.Abs(got-133.33) > 0.01 {
+		t.Errorf("Expected Epley 133.33, got %.2f", got)
+	}
+	if got := BrzyckiOneRepMax(100, 10); math.Abs(got-133.33) > 0.01 {
+		t.Errorf("Expected Brzycki 133.33, got %.2f", got)
+	}
+	if EpleyOneRepMax(100, 1) != 100 || BrzyckiOneRepMax(100, 1) != 100 {
+		t.Error("Expected a single rep to equal the weight")
+	}
+	if got := ConvertWeight(100, UnitLbs, UnitKg); math.Abs(got-45.359237) > 1e-9 {
+		t.Errorf("Expected 45.36 kg, got %f", got)
+	}
+	if got := ConvertWeight(ConvertWeight(80, UnitKg, UnitLbs), UnitLbs, ""); math.Abs(got-80) > 1e-9 {
+		t.Errorf("Expected round trip to 80 kg, got %f", got)
+	}
+}
+
+// TestGetStrengthStats tests records, normalization, weekly volume and overload suggestions
+func TestGetStrengthStats(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	userID := createTestUserForHabits(t, db)
+	habit := createTestHabitForTests(t, db, userID, SetRepsHabit, "Bench")
+
+	monday := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	createHabitLog(t, db, habit.ID, monday, "done", SetRepsValue{
+		Sets: []SetRep{{Set: 1, Reps: 10, Value: 60}, {Set: 2, Reps: 8, Value: 60}},
+		Unit: UnitKg,
+	})
+	// Logged in pounds: 143.3 lbs is 65 kg
+	createHabitLog(t, db, habit.ID, monday.AddDate(0, 0, 2), "done", SetRepsValue{
+		Sets: []SetRep{{Set: 1, Reps: 12, Value: 143.3}, {Set: 2, Reps: 12, Value: 143.3}},
+		Unit: UnitLbs,
+	})
+	createHabitLog(t, db, habit.ID, monday.AddDate(0, 0, 7), "done", SetRepsValue{
+		Sets: []SetRep{{Set: 1, Reps: 12, Value: 65}, {Set: 2, Reps: 12, Value: 65}, {Set: 3, Reps: 5, Value: 50}},
+	})
+
+	stats, err := GetStrengthStats(db, habit.ID, "")
+	if err != nil {
+		t.Fatalf("GetStrengthStats failed: %v", err)
+	}
+
+	if stats.Unit != UnitKg || stats.Sessions != 3 {
+		t.Errorf("Expected 3 sessions in kg, got %d in %s", stats.Sessions, stats.Unit)
+	}
+	if stats.MaxWeight == nil || stats.MaxWeight.Weight != 65 || stats.MaxWeight.Reps != 12 {
+		t.Errorf("Unexpected max weight: %+v", stats.MaxWeight)
+	}
+	if len(stats.MaxRepsAtWeight) != 3 || stats.MaxRepsAtWeight[0].Weight != 65 || stats.MaxRepsAtWeight[1].Reps != 10 {
+		t.Errorf("Unexpected rep records: %+v", stats.MaxRepsAtWeight)
+	}
+	if stats.BestVolumeDay == nil || !stats.BestVolumeDay.Date.Equal(monday.AddDate(0, 0, 7)) || stats.BestVolumeDay.Volume != 1810 {
+		t.Errorf("Unexpected best volume day: %+v", stats.BestVolumeDay)
+	}
+	if len(stats.OneRepMaxTrend) != 3 || stats.BestOneRepMax == nil || stats.BestOneRepMax.Epley != 91 {
+		t.Errorf("Unexpected 1RM: %+v trend %+v", stats.BestOneRepMax, stats.OneRepMaxTrend)
+	}
+	if len(stats.WeeklyVolume) != 2 || stats.WeeklyVolume[0].Volume != 2640 || !stats.WeeklyVolume[1].Date.Equal(monday.AddDate(0, 0, 7)) {
+		t.Errorf("Unexpected weekly volume: %+v", stats.WeeklyVolume)
+	}
+
+	// Every working set hit 12 reps, so the suggestion adds weight
+	if stats.NextSession == nil || stats.NextSession.Weight != 67.5 || stats.NextSession.Reps != 8 || stats.NextSession.Sets != 2 {
+		t.Errorf("Unexpected suggestion: %+v", stats.NextSession)
+	}
+
+	// The same data in pounds
+	lbs, err := GetStrengthStats(db, habit.ID, UnitLbs)
+	if err != nil {
+		t.Fatalf("GetStrengthStats failed: %v", err)
+	}
+	if lbs.MaxWeight == nil || math.Abs(lbs.MaxWeight.Weight-143.3) > 0.01 || lbs.WeightIncrement != 5 {
+		t.Errorf("Unexpected max weight in lbs: %+v", lbs.MaxWeight)
+	}
+
+	// A missed rep target keeps the weight and adds a rep
+	createHabitLog(t, db, habit.ID, monday.AddDate(0, 0, 9), "done", SetRepsValue{
+		Sets: []SetRep{{Set: 1, Reps: 9, Value: 67.5}, {Set: 2, Reps: 7, Value: 67.5}},
+		Unit: UnitKg,
+	})
+	stats, err = GetStrengthStats(db, habit.ID, UnitKg)
+	if err != nil {
+		t.Fatalf("GetStrengthStats failed: %v", err)
+	}
+	if stats.NextSession == nil || stats.NextSession.Weight != 67.5 || stats.NextSession.Reps != 8 ||
+		!strings.HasPrefix(stats.NextSession.Reason, "Stay at 67.5 kg") {
+		t.Errorf("Unexpected suggestion: %+v", stats.NextSession)
+	}
+
+	// Unknown units are rejected on write
+	log := &HabitLog{HabitID: habit.ID, Date: monday.AddDate(0, 0, 10), Status: "done"}
+	if err := log.SetValue(SetRepsValue{Sets: []SetRep{{Set: 1, Reps: 5, Value: 10}}, Unit: "stone"}); err != nil {
+		t.Fatalf("Failed to set value: %v", err)
+	}
+	if err := log.CreateOrUpdate(db); err == nil {
+		t.Error("Expected error for unknown unit")
+	}
+
+	// Other habit types are rejected
+	binary := createTestHabitForTests(t, db, userID, BinaryHabit, "Not strength")
+	if _, err := GetStrengthStats(db, binary.ID, ""); err == nil {
+		t.Error("Expected error for a binary habit")
+	}
+}
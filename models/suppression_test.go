@@ -0,0 +1,225 @@
+package models
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mad/models/email"
+)
+
+const testBounce = `From: Mail Delivery System <MAILER-DAEMON@mx.example.com>
+To: hello@habits.co
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="BOUNDARY"
+
+--BOUNDARY
+Content-Type: text/plain
+
+I'm sorry to have to inform you that your message could not be delivered.
+From the bounce: this line isn't a separator.
+
+--BOUNDARY
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.com
+
+Final-Recipient: rfc822; Gone@Example.com
+Action: failed
+Status: 5.1.1
+Diagnostic-Code: smtp; 550 5.1.1 user unknown
+
+Final-Recipient: rfc822; full@example.com
+Action: delayed
+Status: 4.2.2
+Diagnostic-Code: smtp; 452 4.2.2 mailbox full
+
+--BOUNDARY
+Content-Type: text/rfc822-headers
+
+To: gone@example.com
+Subject: Your daily habits
+
+--BOUNDARY--
+`
+
+const testComplaint = `From: feedback@isp.example.net
+To: abuse@habits.co
+Subject: Complaint
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=feedback-report; boundary="ARF"
+
+--ARF
+Content-Type: text/plain
+
+This is an email abuse report.
+
+--ARF
+Content-Type: message/feedback-report
+
+Feedback-Type: abuse
+User-Agent: ISP-FBL/1.0
+Version: 1
+
+--ARF
+Content-Type: message/rfc822
+
+From: The Habits Company <hello@habits.co>
+To: Annoyed Person <annoyed@example.com>
+Subject: Your daily habits
+
+Hello
+--ARF--
+`
+
+func TestParseFeedbackMessage(t *testing.T) {
+	feedback, err := email.ParseFeedbackMessage(strings.NewReader(testBounce))
+	if err != nil {
+		t.Fatalf("ParseFeedbackMessage failed: %v", err)
+	}
+	if len(feedback) != 2 {
+		t.Fatalf("Expected 2 bounces, got %+v", feedback)
+	}
+	hard, soft := feedback[0], feedback[1]
+	if hard.Email != "gone@example.com" || hard.Kind != email.SuppressBounce || !hard.Permanent || hard.Status != "5.1.1" ||
+		hard.Diagnostic != "550 5.1.1 user unknown" {
+		t.Errorf("Unexpected hard bounce %+v", hard)
+	}
+	if soft.Email != "full@example.com" || soft.Permanent {
+		t.Errorf("Unexpected soft bounce %+v", soft)
+	}
+
+	// Complaints without the recipient in the report take it from the returned message
+	feedback, err = email.ParseFeedbackMessage(strings.NewReader(testComplaint))
+	if err != nil || len(feedback) != 1 {
+		t.Fatalf("Expected 1 complaint, got %+v (%v)", feedback, err)
+	}
+	if feedback[0].Email != "annoyed@example.com" || feedback[0].Kind != email.SuppressComplaint || feedback[0].Diagnostic != "abuse" {
+		t.Errorf("Unexpected complaint %+v", feedback[0])
+	}
+
+	// Ordinary mail has no feedback
+	feedback, err = email.ParseFeedbackMessage(strings.NewReader("From: sam@example.com\nSubject: Hi\n\nThanks!\n"))
+	if err != nil || len(feedback) != 0 {
+		t.Errorf("Expected no feedback from ordinary mail, got %+v (%v)", feedback, err)
+	}
+
+	// The webhook takes one event or several, and soft bounces are told apart by type or status
+	feedback, err = email.ParseWebhookEvents([]byte(`[
+		{"type": "bounce", "email": "a@example.com", "bounce_type": "hard"},
+		{"type": "bounce", "email": "b@example.com", "status": "4.4.1"},
+		{"type": "complaint", "email": "c@example.com", "feedback_type": "abuse"}
+	]`))
+	if err != nil || len(feedback) != 3 || !feedback[0].Permanent || feedback[1].Permanent || feedback[2].Kind != email.SuppressComplaint {
+		t.Errorf("Unexpected webhook feedback %+v (%v)", feedback, err)
+	}
+	if feedback, err := email.ParseWebhookEvents([]byte(`{"type": "bounce", "email": "d@example.com", "status": "5.0.0"}`)); err != nil || len(feedback) != 1 || !feedback[0].Permanent {
+		t.Errorf("Expected a single hard bounce, got %+v (%v)", feedback, err)
+	}
+	if _, err := email.ParseWebhookEvents([]byte(`{"type": "delivered", "email": "d@example.com"}`)); err == nil {
+		t.Error("Expected an error for an unknown event type")
+	}
+}
+
+func TestSuppressionList(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	// An mbox is processed and emptied, suppressing hard bounces and complaints only
+	dir := t.TempDir()
+	mbox := filepath.Join(dir, "bounces")
+	// Body lines starting with From are quoted in an mbox
+	quoted := strings.ReplaceAll(testBounce, "\nFrom the", "\n>From the")
+	content := "From MAILER-DAEMON Mon Oct 19 09:00:00 2026\n" + quoted + "\nFrom feedback@isp.example.net Mon Oct 19 09:01:00 2026\n" + testComplaint
+	if err := os.WriteFile(mbox, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	suppressed, err := email.ProcessBounceMailbox(db, mbox)
+	if err != nil || suppressed != 2 {
+		t.Fatalf("Expected 2 addresses suppressed from the mbox, got %d (%v)", suppressed, err)
+	}
+	if _, err := os.Stat(mbox); !os.IsNotExist(err) {
+		t.Error("Expected the mbox to be emptied")
+	}
+	for address, want := range map[string]bool{"gone@example.com": true, "GONE@example.com": true, "annoyed@example.com": true, "full@example.com": false} {
+		if got, err := email.IsSuppressed(db, address); err != nil || got != want {
+			t.Errorf("Expected %s suppressed %v, got %v (%v)", address, want, got, err)
+		}
+	}
+
+	// Maildir messages are moved to cur once processed
+	maildir := filepath.Join(dir, "Maildir")
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		os.MkdirAll(filepath.Join(maildir, sub), 0o755)
+	}
+	os.WriteFile(filepath.Join(maildir, "new", "1.bounce"), []byte(strings.ReplaceAll(testBounce, "Gone@Example.com", "moved@example.com")), 0o644)
+	if suppressed, err := email.ProcessBounceMailbox(db, maildir); err != nil || suppressed != 1 {
+		t.Errorf("Expected 1 address suppressed from the maildir, got %d (%v)", suppressed, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(maildir, "cur")); len(entries) != 1 {
+		t.Errorf("Expected the message moved to cur, got %v", entries)
+	}
+	if suppressed, _ := email.ProcessBounceMailbox(db, maildir); suppressed != 0 {
+		t.Error("Expected processed messages not to be read again")
+	}
+
+	// The email service refuses suppressed addresses for every kind of email, and delivery of queued ones fails
+	// permanently
+	svc, err := email.NewSMTPEmailService(email.SMTPConfig{Transport: email.TransportMemory, TemplateDir: "../ui/email"})
+	if err != nil {
+		t.Fatalf("Failed to create email service: %v", err)
+	}
+	smtpService := svc.(*email.SMTPEmailService)
+	smtpService.SetSuppressionList(db)
+	if err := svc.SendPasswordResetEmail("gone@example.com", "https://habits.co/reset", time.Now()); !errors.Is(err, email.ErrSuppressed) {
+		t.Errorf("Expected the password reset to be suppressed, got %v", err)
+	}
+	if err := svc.SendReminderEmail("Annoyed@example.com", "Sam", nil, email.QuoteInfo{}, "", ""); !errors.Is(err, email.ErrSuppressed) {
+		t.Errorf("Expected the reminder to be suppressed, got %v", err)
+	}
+	var permanent *email.PermanentError
+	if err := smtpService.Deliver(&email.Message{To: "gone@example.com"}); !errors.As(err, &permanent) {
+		t.Errorf("Expected a permanent failure delivering to a suppressed address, got %v", err)
+	}
+	if err := svc.SendReminderEmail("full@example.com", "Sam", nil, email.QuoteInfo{}, "", ""); err != nil {
+		t.Errorf("Expected soft-bounced addresses to still be sent email, got %v", err)
+	}
+
+	// Campaign emails wait while the address is suppressed
+	if err := email.LoadCampaigns("../ui/email"); err != nil {
+		t.Fatalf("LoadCampaigns failed: %v", err)
+	}
+	cm := email.NewCampaignManager(db, svc)
+	if err := cm.SubscribeUser("gone@example.com", "onboarding", 0); err != nil {
+		t.Fatalf("SubscribeUser failed: %v", err)
+	}
+	if pending, err := cm.GetPendingEmails(); err != nil || len(pending) != 0 {
+		t.Errorf("Expected no pending campaign emails for a suppressed address, got %d (%v)", len(pending), err)
+	}
+
+	// Lifting the suppression sends email again
+	suppressions, err := email.GetSuppressions(db, 10)
+	if err != nil || len(suppressions) != 3 {
+		t.Fatalf("Expected 3 suppressions, got %d (%v)", len(suppressions), err)
+	}
+	if err := email.LiftSuppression(db, "Gone@example.com"); err != nil {
+		t.Fatalf("LiftSuppression failed: %v", err)
+	}
+	if err := email.LiftSuppression(db, "gone@example.com"); err == nil {
+		t.Error("Expected an error lifting a suppression twice")
+	}
+	if pending, err := cm.GetPendingEmails(); err != nil || len(pending) != 1 {
+		t.Errorf("Expected the campaign to resume, got %d pending (%v)", len(pending), err)
+	}
+	if err := svc.SendPasswordResetEmail("gone@example.com", "https://habits.co/reset", time.Now()); err != nil {
+		t.Errorf("Expected the password reset to be sent after lifting, got %v", err)
+	}
+	counts, err := email.CountSuppressions(db)
+	if err != nil || counts[email.SuppressBounce] != 1 || counts[email.SuppressComplaint] != 1 {
+		t.Errorf("Unexpected suppression counts %v (%v)", counts, err)
+	}
+}
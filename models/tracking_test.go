@@ -0,0 +1,201 @@
+package models
+
+import (
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mad/models/email"
+)
+
+func TestCampaignTracking(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "courses", "tips"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"courses/tips/campaign.yaml": "name: Tips\ntracking: true\n",
+		"courses/tips/1-hello.md":    "---\nnumber: 1\nsubject: Hello\n---\nRead [the guide](https://example.com/guide?a=1&b=2).\n",
+		"courses/tips/2-again.md":    "---\nnumber: 2\nsubject: Again\nsend_day: 3\n---\nHi again\n",
+	}
+	for _, name := range []string{"base.html", "base.txt"} {
+		content, err := os.ReadFile(filepath.Join("../ui/email", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = string(content)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := email.LoadCampaigns(dir); err != nil {
+		t.Fatalf("LoadCampaigns failed: %v", err)
+	}
+	t.Cleanup(func() { email.LoadCampaigns("../ui/email") })
+
+	svc, err := email.NewSMTPEmailService(email.SMTPConfig{Transport: email.TransportMemory, TemplateDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create email service: %v", err)
+	}
+	cm := email.NewCampaignManager(db, svc)
+	reader := createTestUserForHabits(t, db, "reader")
+	leaver := createTestUserForHabits(t, db, "leaver")
+	cm.SubscribeUser("testhabitreader@example.com", "tips", int(reader))
+	cm.SubscribeUser("testhabitleaver@example.com", "tips", int(leaver))
+	cm.UnsubscribeUser("testhabitleaver@example.com", "tips")
+	if err := cm.SendPendingCampaignEmails(); err != nil {
+		t.Fatalf("SendPendingCampaignEmails failed: %v", err)
+	}
+
+	var sendID int64
+	var target, sig string
+	var unsubscribe url.Values
+	// Links go through the click tracker, except the unsubscribe link, and the open pixel is added
+	t.Run("links are tracked", func(t *testing.T) {
+		messages, err := svc.(*email.SMTPEmailService).Mailbox().Messages()
+		if err != nil || len(messages) != 1 {
+			t.Fatalf("Expected 1 email, got %d (%v)", len(messages), err)
+		}
+		body := messages[0].HTML
+		if strings.Contains(body, `href="https://example.com/guide`) {
+			t.Error("Expected the link to go through the click tracker")
+		}
+		if !strings.Contains(body, "/unsubscribe?") || strings.Contains(body, "u=http%3A%2F%2Flocalhost") {
+			t.Error("Expected the unsubscribe link to be left alone")
+		}
+		if !strings.Contains(body, "/email/open?") {
+			t.Error("Expected the open pixel")
+		}
+		if strings.Contains(messages[0].Text, "/email/") {
+			t.Error("Expected the text part without tracking")
+		}
+
+		match := regexp.MustCompile(`href="([^"]*/email/click\?[^"]*)"`).FindStringSubmatch(body)
+		if match == nil {
+			t.Fatalf("Expected a click link in %s", body)
+		}
+		link, err := url.Parse(html.UnescapeString(match[1]))
+		if err != nil {
+			t.Fatalf("Failed to parse click link: %v", err)
+		}
+		query := link.Query()
+		sendID, _ = strconv.ParseInt(query.Get("s"), 10, 64)
+		target, sig = query.Get("u"), query.Get("sig")
+		if target != "https://example.com/guide?a=1&b=2" {
+			t.Errorf("Expected the original link as the target, got %q", target)
+		}
+
+		match = regexp.MustCompile(`href="([^"]*/unsubscribe\?[^"]*)"`).FindStringSubmatch(body)
+		if match == nil {
+			t.Fatalf("Expected an unsubscribe link in %s", body)
+		}
+		link, err = url.Parse(html.UnescapeString(match[1]))
+		if err != nil {
+			t.Fatalf("Failed to parse unsubscribe link: %v", err)
+		}
+		unsubscribe = link.Query()
+	})
+
+	t.Run("click links are signed", func(t *testing.T) {
+		if !email.ValidTrackingClick(sendID, target, sig) {
+			t.Error("Expected the click link's signature to be valid")
+		}
+		if email.ValidTrackingClick(sendID, "https://evil.example.com", sig) || email.ValidTrackingClick(sendID+1, target, sig) {
+			t.Error("Expected the signature to cover the send and the target")
+		}
+		if email.ValidTrackingPixel(sendID, sig) {
+			t.Error("Expected a click signature not to work for the pixel")
+		}
+		if email.UnsubscribeSend(unsubscribe) != sendID {
+			t.Errorf("Expected the unsubscribe link to carry send %d, got %v", sendID, unsubscribe)
+		}
+		forged := url.Values{"s": {strconv.FormatInt(sendID+1, 10)}, "ssig": {unsubscribe.Get("ssig")}}
+		if email.UnsubscribeSend(forged) != 0 {
+			t.Error("Expected the unsubscribe link's signature to cover the send")
+		}
+	})
+
+	// Events are recorded per send, and ignored for unknown sends
+	t.Run("events are recorded per send", func(t *testing.T) {
+		for _, event := range []struct {
+			sendID int64
+			kind   string
+			target string
+		}{{sendID, email.EventOpen, ""}, {sendID, email.EventOpen, ""}, {sendID, email.EventClick, target}, {sendID + 100, email.EventClick, target}} {
+			if err := email.RecordEmailEvent(db, event.sendID, event.kind, event.target); err != nil {
+				t.Fatalf("RecordEmailEvent failed: %v", err)
+			}
+		}
+		var events int
+		db.QueryRow("SELECT COUNT(*) FROM email_events").Scan(&events)
+		if events != 3 {
+			t.Errorf("Expected 3 events, got %d", events)
+		}
+
+	})
+
+	// Analytics count each send once however often it's opened, unsubscribes go to the email they came from and
+	// the funnel follows the subscribers
+	t.Run("analytics count each send once", func(t *testing.T) {
+		cm.UnsubscribeUser("testhabitreader@example.com", "tips")
+		if err := email.RecordEmailEvent(db, email.UnsubscribeSend(unsubscribe), email.EventUnsubscribe, ""); err != nil {
+			t.Fatalf("RecordEmailEvent failed: %v", err)
+		}
+		_, err := db.Exec(`
+			INSERT INTO email_sends (subscription_id, email_number, template_name, subject, status, retry_count)
+			SELECT subscription_id, 2, 'again', 'Again', 'retry', 1 FROM email_sends WHERE id = ?`, sendID)
+		if err != nil {
+			t.Fatalf("Failed to add a retrying send: %v", err)
+		}
+		analytics, err := email.GetCampaignAnalytics(db)
+		if err != nil || len(analytics) != 1 {
+			t.Fatalf("Expected analytics for 1 campaign, got %d (%v)", len(analytics), err)
+		}
+		tips := analytics[0]
+		if tips.Subscribers != 2 || tips.Active != 0 || tips.Unsubscribed != 1 || len(tips.Emails) != 2 {
+			t.Fatalf("Unexpected campaign analytics %+v", tips)
+		}
+		first, second := tips.Emails[0], tips.Emails[1]
+		if first.Sent != 1 || first.Failed != 0 || first.Opened != 1 || first.Clicked != 1 || first.Unsubscribed != 1 {
+			t.Errorf("Unexpected analytics for the first email %+v", first)
+		}
+		if second.Sent != 0 || second.Pending != 1 || second.Unsubscribed != 0 {
+			t.Errorf("Expected the retrying second email pending rather than sent, got %+v", second)
+		}
+		if first.OpenRate() != 100 || first.UnsubscribeRate() != 100 || second.ClickRate() != 0 {
+			t.Errorf("Unexpected rates for %+v and %+v", first, second)
+		}
+		if first.Reached != 1 || second.Reached != 0 || tips.ReachedRate(first) != 50 {
+			t.Errorf("Unexpected funnel %+v", tips.Emails)
+		}
+	})
+}
+
+func TestSigningKeyRequiredInProduction(t *testing.T) {
+	for _, tc := range []struct {
+		env, key string
+		ok       bool
+	}{
+		{"production", "", false},
+		{"production", "secret", true},
+		{"development", "", true},
+		{"", "", true},
+	} {
+		t.Setenv("APP_ENV", tc.env)
+		t.Setenv("EMAIL_SIGNING_KEY", tc.key)
+		if err := email.CheckSigningKey(); (err == nil) != tc.ok {
+			t.Errorf("CheckSigningKey with APP_ENV=%q and key %q: got %v", tc.env, tc.key, err)
+		}
+	}
+}
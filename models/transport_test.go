@@ -0,0 +1,75 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"mad/models/email"
+)
+
+func TestMailTransports(t *testing.T) {
+	for _, transport := range []string{email.TransportMemory, email.TransportFile} {
+		t.Run(transport, func(t *testing.T) {
+			svc, err := email.NewSMTPEmailService(email.SMTPConfig{
+				Transport:   transport,
+				FromName:    "The Habits Company",
+				FromEmail:   "hello@example.com",
+				TemplateDir: "../ui/email",
+				MailDir:     t.TempDir(),
+			})
+			if err != nil {
+				t.Fatalf("Failed to create email service: %v", err)
+			}
+			habits := []email.HabitInfo{{Name: "Read", Emoji: "📚", Streak: 3}}
+			if err := svc.SendReminderEmail("sam@example.com", "Sam", habits, email.QuoteInfo{}, "", ""); err != nil {
+				t.Fatalf("SendReminderEmail failed: %v", err)
+			}
+
+			// The dev inbox gets the rendered parts back
+			mailbox := svc.(*email.SMTPEmailService).Mailbox()
+			if mailbox == nil {
+				t.Fatal("Expected the transport to keep messages")
+			}
+			messages, err := mailbox.Messages()
+			if err != nil || len(messages) != 1 {
+				t.Fatalf("Expected 1 captured message, got %d (%v)", len(messages), err)
+			}
+			reminder := messages[0]
+			if reminder.To != "sam@example.com" || reminder.Subject != email.ReminderEmail.Subject {
+				t.Errorf("Unexpected message %+v", reminder)
+			}
+			if !strings.Contains(reminder.HTML, "<html") || !strings.Contains(reminder.HTML, "Read") {
+				t.Errorf("Expected the HTML part, got %q", reminder.HTML)
+			}
+			if !strings.Contains(reminder.Text, "Read") || strings.Contains(reminder.Text, "<html") {
+				t.Errorf("Expected the text part, got %q", reminder.Text)
+			}
+			if reminder.Date.IsZero() {
+				t.Error("Expected the message date")
+			}
+
+			found, err := mailbox.Message(reminder.ID)
+			if err != nil || found.Subject != reminder.Subject {
+				t.Errorf("Expected to find the message by ID, got %+v (%v)", found, err)
+			}
+			if _, err := mailbox.Message("../../etc/passwd"); err == nil {
+				t.Error("Expected an error for an unknown message")
+			}
+		})
+	}
+
+	// Transports that deliver mail have no mailbox
+	svc, err := email.NewSMTPEmailService(email.SMTPConfig{Transport: email.TransportLog, TemplateDir: "../ui/email"})
+	if err != nil {
+		t.Fatalf("Failed to create email service: %v", err)
+	}
+	if svc.(*email.SMTPEmailService).Mailbox() != nil {
+		t.Error("Expected no mailbox for the log transport")
+	}
+	if _, err := email.NewSMTPEmailService(email.SMTPConfig{Transport: "pigeon"}); err == nil {
+		t.Error("Expected an error for an unknown transport")
+	}
+	if _, err := email.NewSMTPEmailService(email.SMTPConfig{Transport: email.TransportSMTP, Host: "localhost", TLS: "sometimes"}); err == nil {
+		t.Error("Expected an error for an unknown TLS policy")
+	}
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mad/models/email"
+)
+
+func TestOneClickUnsubscribe(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := email.LoadCampaigns("../ui/email"); err != nil {
+		t.Fatalf("LoadCampaigns failed: %v", err)
+	}
+	userID := createTestUserForHabits(t, db, "sam")
+	list := email.NotificationList(NotificationReminder)
+
+	// Bulk email carries the one-click headers and transactional email doesn't
+	var reminderURL string
+	t.Run("bulk email carries the one-click headers", func(t *testing.T) {
+		mailDir := t.TempDir()
+		svc, err := email.NewSMTPEmailService(email.SMTPConfig{
+			Transport:   email.TransportFile,
+			FromName:    "The Habits Company",
+			FromEmail:   "hello@example.com",
+			TemplateDir: "../ui/email",
+			MailDir:     mailDir,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create email service: %v", err)
+		}
+		if err := svc.SendReminderEmail("testhabitsam@example.com", "Sam", nil, email.QuoteInfo{}, "", ""); err != nil {
+			t.Fatalf("SendReminderEmail failed: %v", err)
+		}
+		if err := svc.SendPasswordResetEmail("testhabitsam@example.com", "https://habits.co/reset", time.Now()); err != nil {
+			t.Fatalf("SendPasswordResetEmail failed: %v", err)
+		}
+		files, _ := filepath.Glob(filepath.Join(mailDir, "*.eml"))
+		var withHeaders int
+		for _, file := range files {
+			content, _ := os.ReadFile(file)
+			if strings.Contains(string(content), "List-Unsubscribe-Post: List-Unsubscribe=One-Click") {
+				withHeaders++
+				if strings.Contains(string(content), "Reset Your Password") {
+					t.Error("Expected no List-Unsubscribe header on the password reset")
+				}
+			}
+		}
+		if len(files) != 2 || withHeaders != 1 {
+			t.Errorf("Expected the headers on 1 of 2 emails, got %d of %d", withHeaders, len(files))
+		}
+		messages, err := svc.(*email.SMTPEmailService).Mailbox().Messages()
+		if err != nil {
+			t.Fatalf("Failed to read mailbox: %v", err)
+		}
+		for _, m := range messages {
+			if m.Subject == email.ReminderEmail.Subject {
+				reminderURL = m.Unsubscribe
+			}
+		}
+		link, err := url.Parse(reminderURL)
+		if err != nil || link.Path != "/unsubscribe/one-click" || link.Query().Get("list") != list {
+			t.Fatalf("Unexpected one-click URL %q (%v)", reminderURL, err)
+		}
+	})
+
+	// Tokens are signed for the address and the list
+	t.Run("tokens are signed for the address and the list", func(t *testing.T) {
+		link, _ := url.Parse(reminderURL)
+		token := link.Query().Get("token")
+		if !email.ValidUnsubscribeToken("TestHabitSam@example.com", list, token) {
+			t.Error("Expected the token to be valid whatever the address's case")
+		}
+		if email.ValidUnsubscribeToken("other@example.com", list, token) || email.ValidUnsubscribeToken("testhabitsam@example.com", email.NotificationList(NotificationGoal), token) {
+			t.Error("Expected the token to be valid only for its address and list")
+		}
+
+	})
+
+	// Campaign unsubscribe links carry a signed token, and the stored token of older links still works
+	t.Run("campaign links carry a signed token", func(t *testing.T) {
+		cm := email.NewCampaignManager(db, nil)
+		if err := cm.SubscribeUser("testhabitsam@example.com", "onboarding", int(userID)); err != nil {
+			t.Fatalf("SubscribeUser failed: %v", err)
+		}
+		data, err := email.CampaignEmailData("Sam", "testhabitsam@example.com", "onboarding", 1)
+		if err != nil {
+			t.Fatalf("CampaignEmailData failed: %v", err)
+		}
+		link, _ := url.Parse(data["UnsubscribeLink"].(string))
+		if valid, err := cm.ValidateUnsubscribeToken("testhabitsam@example.com", "onboarding", link.Query().Get("token")); !valid || err != nil {
+			t.Errorf("Expected the signed campaign token to be valid, got %v (%v)", valid, err)
+		}
+		var stored string
+		db.QueryRow("SELECT token FROM email_subscriptions WHERE email = 'testhabitsam@example.com'").Scan(&stored)
+		if valid, _ := cm.ValidateUnsubscribeToken("testhabitsam@example.com", "onboarding", stored); !valid {
+			t.Error("Expected the stored token to still be valid")
+		}
+		if valid, _ := cm.ValidateUnsubscribeToken("testhabitsam@example.com", "onboarding", "guess"); valid {
+			t.Error("Expected a wrong token to be invalid")
+		}
+
+	})
+
+	// One-click unsubscribes work for campaigns, notification types and the digest, and can be repeated
+	t.Run("one-click unsubscribes can be repeated", func(t *testing.T) {
+		prefs, _ := GetNotificationPreferences(db, int(userID))
+		prefs.DigestFrequency = DigestWeekly
+		if err := prefs.Save(db); err != nil {
+			t.Fatalf("Failed to save preferences: %v", err)
+		}
+		for _, list := range []string{
+			email.CampaignList("onboarding"), email.CampaignList("onboarding"), list,
+			email.NotificationList("digest"), email.CampaignList("digital-detox"),
+		} {
+			if err := UnsubscribeFromList(db, "testhabitsam@example.com", list); err != nil {
+				t.Errorf("UnsubscribeFromList %s failed: %v", list, err)
+			}
+		}
+		prefs, _ = GetNotificationPreferences(db, int(userID))
+		if prefs.Allows(NotificationReminder, ChannelEmail) || !prefs.Allows(NotificationGoal, ChannelEmail) || prefs.DigestFrequency != DigestOff {
+			t.Errorf("Expected only reminder email and the digest off, got %+v", prefs)
+		}
+		var status string
+		db.QueryRow("SELECT status FROM email_subscriptions WHERE email = 'testhabitsam@example.com'").Scan(&status)
+		if status != "unsubscribed" {
+			t.Errorf("Expected the campaign subscription to end, got %s", status)
+		}
+		if err := UnsubscribeFromList(db, "nobody@example.com", list); err != nil {
+			t.Errorf("Expected no error for an address without an account, got %v", err)
+		}
+		if err := UnsubscribeFromList(db, "testhabitsam@example.com", "newsletter"); err == nil {
+			t.Error("Expected an error for an invalid list")
+		}
+
+	})
+
+	// Queued email keeps its one-click URL
+	t.Run("queued email keeps its one-click URL", func(t *testing.T) {
+		var delivered *email.Message
+		outbox := email.NewOutbox(db, func(m *email.Message) error { delivered = m; return nil }, email.DefaultOutboxConfig())
+		if _, err := outbox.Enqueue(&email.Message{To: "testhabitsam@example.com", Subject: "Hi", Unsubscribe: reminderURL}); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		if _, err := outbox.ProcessDue(time.Now().Add(time.Second)); err != nil || delivered == nil || delivered.Unsubscribe != reminderURL {
+			t.Errorf("Expected the queued email to keep its one-click URL, got %+v (%v)", delivered, err)
+		}
+	})
+}
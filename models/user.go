@@ -3,7 +3,6 @@ package models
 import (
 	"database/sql"
 	"log"
-	"os"
 	"strings"
 	"time"
 
@@ -24,13 +23,17 @@ type User struct {
 	HabitsCount         int       `json:"habits_count"`
 	LogsCount           int       `json:"logs_count"`
 	NotificationEnabled bool      `json:"notification_enabled"`
+	GoalDeadlineDays    int       `json:"goal_deadline_days"` // days before a goal ends to send the deadline email
+	Timezone            string    `json:"timezone"`
+	EmailVerified       bool      `json:"email_verified"`
 }
 
 // GetUserByID retrieves a user from the database by their ID
 func GetUserByID(db *sql.DB, id int64) (*User, error) {
 	user := &User{}
 	err := db.QueryRow(`
-		SELECT id, first_name, last_name, email, show_confetti, show_weekdays, created_at, is_admin, notification_enabled 
+		SELECT id, first_name, last_name, email, show_confetti, show_weekdays, created_at, is_admin, notification_enabled,
+			goal_deadline_days, timezone, email_verified
 		FROM users 
 		WHERE id = ?
 	`, id).Scan(
@@ -43,6 +46,9 @@ func GetUserByID(db *sql.DB, id int64) (*User, error) {
 		&user.CreatedAt,
 		&user.IsAdmin,
 		&user.NotificationEnabled,
+		&user.GoalDeadlineDays,
+		&user.Timezone,
+		&user.EmailVerified,
 	)
 
 	if err != nil {
@@ -78,9 +84,9 @@ func (u *User) Create(db *sql.DB, passwordHash string) error {
 	u.Email = strings.ToLower(u.Email)
 
 	result, err := db.Exec(`
-		INSERT INTO users (first_name, last_name, email, password_hash, show_confetti, created_at, is_admin, notification_enabled) 
-		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?, ?)
-	`, u.FirstName, u.LastName, u.Email, passwordHash, true, false, true)
+		INSERT INTO users (first_name, last_name, email, password_hash, show_confetti, created_at, is_admin, notification_enabled, email_verified) 
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`, u.FirstName, u.LastName, u.Email, passwordHash, true, false, true, false)
 
 	if err != nil {
 		log.Println("Error executing insert:", err)
@@ -95,16 +101,24 @@ func (u *User) Create(db *sql.DB, passwordHash string) error {
 
 	u.ID = id
 	log.Println("User created with ID:", u.ID)
+
+	if err := CreateDefaultReminder(db, int(u.ID), time.Now()); err != nil {
+		log.Println("Error creating default reminder:", err)
+	}
+	if _, err := PreferencesToken(db, int(u.ID)); err != nil {
+		log.Println("Error creating preferences token:", err)
+	}
 	return nil
 }
 
-// Update modifies an existing user in the database
+// Update modifies an existing user in the database. A new email address needs verifying again.
 func (u *User) Update(db *sql.DB) error {
 	_, err := db.Exec(`
 		UPDATE users 
-		SET first_name = ?, last_name = ?, email = ?, show_confetti = ?, notification_enabled = ?
+		SET first_name = ?, last_name = ?, email = ?, show_confetti = ?, notification_enabled = ?,
+			email_verified = email_verified AND LOWER(email) = LOWER(?)
 		WHERE id = ?
-	`, u.FirstName, u.LastName, u.Email, u.ShowConfetti, u.NotificationEnabled, u.ID)
+	`, u.FirstName, u.LastName, u.Email, u.ShowConfetti, u.NotificationEnabled, u.Email, u.ID)
 
 	return err
 }
@@ -183,15 +197,7 @@ func DeleteUserAndData(db *sql.DB, userID int64) error {
 
 	// Handle email campaign subscriptions
 	// Create a minimal emailService just to initialize the campaign manager
-	emailService, err := email.NewSMTPEmailService(email.SMTPConfig{
-		Host:        os.Getenv("SMTP_HOST"),
-		Port:        587, // Default SMTP port
-		Username:    os.Getenv("SMTP_USERNAME"),
-		Password:    os.Getenv("SMTP_PASSWORD"),
-		FromName:    os.Getenv("SMTP_FROM_NAME"),
-		FromEmail:   os.Getenv("SMTP_FROM_EMAIL"),
-		TemplateDir: "./ui/email",
-	})
+	emailService, err := email.NewSMTPEmailService(email.SMTPConfigFromEnv("./ui/email"))
 
 	// If we can't initialize email service, log warning but continue with deletion
 	if err != nil {
@@ -234,6 +240,12 @@ func DeleteUserAndData(db *sql.DB, userID int64) error {
 		return err
 	}
 
+	// Delete lifecycle campaign triggers
+	_, err = tx.Exec("DELETE FROM lifecycle_triggers WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+
 	// Delete user
 	_, err = tx.Exec("DELETE FROM users WHERE id = ?", userID)
 	if err != nil {
@@ -381,13 +393,15 @@ func GetUsersWithNotificationsEnabled(db *sql.DB) ([]*User, error) {
 	return users, nil
 }
 
-// GetUsersWithHabitsAndNotificationsEnabled retrieves all users who have habits and notifications enabled
+// GetUsersWithHabitsAndNotificationsEnabled retrieves all verified users who have habits and notifications enabled
 func GetUsersWithHabitsAndNotificationsEnabled(db *sql.DB) ([]*User, error) {
 	rows, err := db.Query(`
-		SELECT DISTINCT u.id, u.first_name, u.last_name, u.email, u.show_confetti, u.show_weekdays, u.created_at, u.is_admin, u.notification_enabled
+		SELECT DISTINCT u.id, u.first_name, u.last_name, u.email, u.show_confetti, u.show_weekdays, u.created_at, u.is_admin, u.notification_enabled,
+			u.timezone
 		FROM users u
 		JOIN habits h ON u.id = h.user_id
 		WHERE u.notification_enabled = true
+		AND u.email_verified = true
 	`)
 	if err != nil {
 		return nil, err
@@ -407,6 +421,7 @@ func GetUsersWithHabitsAndNotificationsEnabled(db *sql.DB) ([]*User, error) {
 			&user.CreatedAt,
 			&user.IsAdmin,
 			&user.NotificationEnabled,
+			&user.Timezone,
 		)
 		if err != nil {
 			return nil, err
@@ -416,13 +431,14 @@ func GetUsersWithHabitsAndNotificationsEnabled(db *sql.DB) ([]*User, error) {
 	return users, nil
 }
 
-// GetUsersWithNoHabitsAndNotificationsEnabled retrieves all users who have no habits but have notifications enabled
+// GetUsersWithNoHabitsAndNotificationsEnabled retrieves all verified users who have no habits but have notifications enabled
 func GetUsersWithNoHabitsAndNotificationsEnabled(db *sql.DB) ([]*User, error) {
 	rows, err := db.Query(`
 		SELECT u.id, u.first_name, u.last_name, u.email, u.show_confetti, u.show_weekdays, u.created_at, u.is_admin, u.notification_enabled
 		FROM users u
 		LEFT JOIN habits h ON u.id = h.user_id
 		WHERE h.id IS NULL AND u.notification_enabled = true
+		AND u.email_verified = true
 	`)
 	if err != nil {
 		return nil, err
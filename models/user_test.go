@@ -37,16 +37,11 @@ func (m *MockEmailService) SendPasswordResetSuccessEmail(to, username string) er
 	return nil
 }
 
-func (m *MockEmailService) SendReminderEmail(to string, firstName string, habits []email.HabitInfo, quote email.QuoteInfo) error {
+func (m *MockEmailService) SendReminderEmail(to string, firstName string, habits []email.HabitInfo, quote email.QuoteInfo, insight, preferencesLink string) error {
 	m.sentEmails[to+"-reminder"] = true
 	return nil
 }
 
-func (m *MockEmailService) SendFirstHabitEmail(to string, firstName string, quote email.QuoteInfo) error {
-	m.sentEmails[to+"-first-habit"] = true
-	return nil
-}
-
 func (m *MockEmailService) SendSimpleEmail(to, subject, content string) error {
 	m.sentEmails[to+"-"+subject] = true
 	return nil
@@ -654,6 +649,11 @@ func TestNotificationPreferences(t *testing.T) {
 	passHash, _ := HashPassword("password123")
 	user2.Create(db, passHash)
 
+	// Reminders only go to verified addresses
+	if _, err := db.Exec("UPDATE users SET email_verified = true"); err != nil {
+		t.Fatalf("Failed to verify users: %v", err)
+	}
+
 	// Create a habit for user1
 	createTestHabit(t, db, user1.ID)
 
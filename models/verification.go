@@ -0,0 +1,208 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"mad/models/email"
+)
+
+const (
+	// VerificationTokenExpiry is how long a verification or subscription confirmation link works
+	VerificationTokenExpiry = 48 * time.Hour
+	// MaxVerificationEmails is how many verification emails an address gets for one account or campaign a day
+	MaxVerificationEmails = 3
+)
+
+var (
+	// ErrTooManyVerificationEmails is returned when an address has had MaxVerificationEmails today
+	ErrTooManyVerificationEmails = errors.New("too many verification emails")
+	// ErrInvalidVerificationToken is returned for tokens that don't exist, were used or have expired
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification link")
+)
+
+// VerificationToken is a link sent to confirm an email address: a new account's, or an anonymous campaign
+// subscription's
+type VerificationToken struct {
+	Token      string
+	UserID     sql.NullInt64
+	Email      string
+	CampaignID string // empty for account verification
+	Expiry     time.Time
+	Used       bool
+	CreatedAt  time.Time
+}
+
+// CreateVerificationToken stores a new verification token for the address and campaign, or for the account when
+// campaignID is empty. Earlier tokens keep working until they expire, so it doesn't matter which email is opened.
+func CreateVerificationToken(db *sql.DB, userID int64, emailAddr, campaignID string, now time.Time) (string, error) {
+	emailAddr = strings.ToLower(emailAddr)
+
+	var sent int
+	err := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM email_verification_tokens
+		WHERE email = ? AND campaign_id = ? AND created_at > ?
+	`, emailAddr, campaignID, now.Add(-24*time.Hour).UTC()).Scan(&sent)
+	if err != nil {
+		return "", fmt.Errorf("error counting verification emails: %v", err)
+	}
+	if sent >= MaxVerificationEmails {
+		return "", ErrTooManyVerificationEmails
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating verification token: %v", err)
+	}
+	token := base64.URLEncoding.EncodeToString(b)
+
+	var userIDValue sql.NullInt64
+	if userID > 0 {
+		userIDValue = sql.NullInt64{Int64: userID, Valid: true}
+	}
+	_, err = db.Exec(`
+		INSERT INTO email_verification_tokens (token, user_id, email, campaign_id, expiry, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, token, userIDValue, emailAddr, campaignID, now.Add(VerificationTokenExpiry).UTC(), now.UTC())
+	if err != nil {
+		return "", fmt.Errorf("error storing verification token: %v", err)
+	}
+	return token, nil
+}
+
+// GetVerificationToken retrieves a verification token
+func GetVerificationToken(db *sql.DB, token string) (*VerificationToken, error) {
+	var vt VerificationToken
+	err := db.QueryRow(`
+		SELECT token, user_id, email, campaign_id, expiry, used, created_at
+		FROM email_verification_tokens
+		WHERE token = ?
+	`, token).Scan(&vt.Token, &vt.UserID, &vt.Email, &vt.CampaignID, &vt.Expiry, &vt.Used, &vt.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &vt, nil
+}
+
+// ConfirmEmail uses a verification token: it verifies the account, or activates the pending campaign
+// subscription, the token was sent for
+func ConfirmEmail(db *sql.DB, token string, now time.Time) (*VerificationToken, error) {
+	vt, err := GetVerificationToken(db, token)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting verification token: %v", err)
+	}
+	if vt.Used || !now.Before(vt.Expiry) {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	// The token is only used up if what it confirms goes through
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE email_verification_tokens SET used = true WHERE token = ? AND used = false", token)
+	if err != nil {
+		return nil, fmt.Errorf("error using verification token: %v", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	if vt.CampaignID != "" {
+		cm := email.NewCampaignManager(db, nil)
+		if err := cm.ConfirmSubscriptionTx(tx, vt.Email, vt.CampaignID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("error confirming subscription: %v", err)
+		}
+		return vt, nil
+	}
+	// A token sent before the user changed their address doesn't verify the new one
+	result, err = tx.Exec("UPDATE users SET email_verified = true WHERE id = ? AND LOWER(email) = ?",
+		vt.UserID, strings.ToLower(vt.Email))
+	if err != nil {
+		return nil, fmt.Errorf("error verifying email: %v", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return nil, ErrInvalidVerificationToken
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error verifying email: %v", err)
+	}
+	log.Printf("Verified email of user %d", vt.UserID.Int64)
+	return vt, nil
+}
+
+// SendAccountVerification emails a user a link to verify their email address. Verified users get nothing.
+func SendAccountVerification(db *sql.DB, emailSvc email.EmailService, userID int64, now time.Time) error {
+	user, err := GetUserByID(db, userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return nil
+	}
+
+	token, err := CreateVerificationToken(db, user.ID, user.Email, "", now)
+	if err != nil {
+		return err
+	}
+	return emailSvc.SendTypedEmail(user.Email, email.VerifyEmail, email.VerifyEmailData{
+		FirstName:   user.FirstName,
+		VerifyLink:  email.GenerateVerificationLink(token),
+		ExpiryHours: int(VerificationTokenExpiry.Hours()),
+		AppName:     "The Habits Company",
+	})
+}
+
+// SubscribeWithConfirmation subscribes an address to a campaign pending double opt-in and emails it a link to
+// confirm. Addresses that are already subscribed get nothing.
+func SubscribeWithConfirmation(db *sql.DB, emailSvc email.EmailService, emailAddr, firstName, campaignID string, userID int, now time.Time) error {
+	campaign, err := email.GetCampaign(campaignID)
+	if err != nil {
+		return err
+	}
+	emailAddr = strings.ToLower(emailAddr)
+
+	cm := email.NewCampaignManager(db, nil)
+	pending, err := cm.SubscribePending(emailAddr, campaignID, userID)
+	if err != nil || !pending {
+		return err
+	}
+
+	token, err := CreateVerificationToken(db, int64(userID), emailAddr, campaignID, now)
+	if err != nil {
+		return err
+	}
+	return emailSvc.SendTypedEmail(emailAddr, email.ConfirmSubscriptionEmail, email.ConfirmSubscriptionEmailData{
+		FirstName:     firstName,
+		CampaignName:  campaign.Name,
+		CampaignEmoji: campaign.Emoji,
+		ConfirmLink:   email.GenerateVerificationLink(token),
+		ExpiryHours:   int(VerificationTokenExpiry.Hours()),
+		AppName:       "The Habits Company",
+	})
+}
+
+// PurgeExpiredVerificationTokens deletes verification tokens that expired more than a day ago, once they no
+// longer count towards MaxVerificationEmails
+func PurgeExpiredVerificationTokens(db *sql.DB, now time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM email_verification_tokens WHERE expiry < ?", now.Add(-24*time.Hour).UTC())
+	if err != nil {
+		return 0, fmt.Errorf("error purging verification tokens: %v", err)
+	}
+	return result.RowsAffected()
+}
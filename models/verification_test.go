@@ -0,0 +1,261 @@
+package models
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"mad/models/email"
+)
+
+var verificationLinkPattern = regexp.MustCompile(`/verify-email\?token=(\S+)`)
+
+// lastVerificationToken returns the token in the last verification link emailed to the address
+func lastVerificationToken(t *testing.T, svc email.EmailService, to string) string {
+	t.Helper()
+	messages, err := svc.(*email.SMTPEmailService).Mailbox().Messages()
+	if err != nil {
+		t.Fatalf("Failed to read mailbox: %v", err)
+	}
+	for _, m := range messages {
+		if m.To != to {
+			continue
+		}
+		if match := verificationLinkPattern.FindStringSubmatch(m.Text); match != nil {
+			token, err := url.QueryUnescape(match[1])
+			if err != nil {
+				t.Fatalf("Invalid token in %q: %v", match[0], err)
+			}
+			return token
+		}
+	}
+	t.Fatalf("No verification link emailed to %s", to)
+	return ""
+}
+
+func TestEmailVerification(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := email.LoadCampaigns("../ui/email"); err != nil {
+		t.Fatalf("LoadCampaigns failed: %v", err)
+	}
+	svc, err := email.NewSMTPEmailService(email.SMTPConfig{
+		Transport:   email.TransportMemory,
+		FromName:    "The Habits Company",
+		FromEmail:   "hello@example.com",
+		TemplateDir: "../ui/email",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create email service: %v", err)
+	}
+	now := time.Now()
+
+	// New accounts start unverified and get no reminders or campaign emails
+	user := &User{FirstName: "Sam", LastName: "Smith", Email: "TestHabitSam@example.com"}
+	if err := user.Create(db, "hash"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	createTestHabitForTests(t, db, user.ID, BinaryHabit, "Read")
+	cm := email.NewCampaignManager(db, nil)
+	if err := cm.SubscribeUser(user.Email, "onboarding", int(user.ID)); err != nil {
+		t.Fatalf("SubscribeUser failed: %v", err)
+	}
+	assertReminderRecipient := func(t *testing.T, want bool) {
+		t.Helper()
+		users, err := GetUsersWithHabitsAndNotificationsEnabled(db)
+		if err != nil {
+			t.Fatalf("GetUsersWithHabitsAndNotificationsEnabled failed: %v", err)
+		}
+		due, err := GetDueReminders(db, now.Add(48*time.Hour))
+		if err != nil || len(due) == 0 {
+			t.Fatalf("Expected due reminders, got %d (%v)", len(due), err)
+		}
+		if got := len(users) == 1; got != want || due[0].EmailVerified != want {
+			t.Errorf("Expected reminder recipient %v, got %v (due reminder verified %v)", want, got, due[0].EmailVerified)
+		}
+	}
+	assertCampaignRecipients := func(t *testing.T, want int) {
+		t.Helper()
+		pending, err := cm.GetPendingEmails()
+		if err != nil {
+			t.Fatalf("GetPendingEmails failed: %v", err)
+		}
+		if len(pending) != want {
+			t.Errorf("Expected %d campaign recipients, got %d", want, len(pending))
+		}
+	}
+	t.Run("new accounts start unverified", func(t *testing.T) {
+		assertReminderRecipient(t, false)
+		assertCampaignRecipients(t, 0)
+	})
+
+	// The verification link verifies the account once
+	t.Run("the verification link verifies the account once", func(t *testing.T) {
+		if err := SendAccountVerification(db, svc, user.ID, now); err != nil {
+			t.Fatalf("SendAccountVerification failed: %v", err)
+		}
+		token := lastVerificationToken(t, svc, "testhabitsam@example.com")
+		if _, err := ConfirmEmail(db, "not-a-token", now); err != ErrInvalidVerificationToken {
+			t.Errorf("Expected ErrInvalidVerificationToken for an unknown token, got %v", err)
+		}
+		if _, err := ConfirmEmail(db, token, now.Add(VerificationTokenExpiry+time.Minute)); err != ErrInvalidVerificationToken {
+			t.Errorf("Expected ErrInvalidVerificationToken for an expired token, got %v", err)
+		}
+		vt, err := ConfirmEmail(db, token, now)
+		if err != nil {
+			t.Fatalf("ConfirmEmail failed: %v", err)
+		}
+		if vt.CampaignID != "" || vt.UserID.Int64 != user.ID {
+			t.Errorf("Expected an account token for user %d, got %+v", user.ID, vt)
+		}
+		if _, err := ConfirmEmail(db, token, now); err != ErrInvalidVerificationToken {
+			t.Errorf("Expected ErrInvalidVerificationToken for a used token, got %v", err)
+		}
+		assertReminderRecipient(t, true)
+		assertCampaignRecipients(t, 1)
+
+	})
+
+	// Changing the address needs verifying again; keeping it doesn't
+	t.Run("changing the address needs verifying again", func(t *testing.T) {
+		verified, _ := GetUserByID(db, user.ID)
+		verified.FirstName = "Samantha"
+		if err := verified.Update(db); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		if u, _ := GetUserByID(db, user.ID); !u.EmailVerified {
+			t.Error("Expected the account to stay verified after a name change")
+		}
+		verified.Email = "testhabitsamantha@example.com"
+		if err := verified.Update(db); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		if u, _ := GetUserByID(db, user.ID); u.EmailVerified {
+			t.Error("Expected a new email address to need verifying")
+		}
+
+		// A link sent to the old address doesn't verify the new one
+		stale, err := CreateVerificationToken(db, user.ID, "testhabitsam@example.com", "", now)
+		if err != nil {
+			t.Fatalf("CreateVerificationToken failed: %v", err)
+		}
+		if _, err := ConfirmEmail(db, stale, now); err != ErrInvalidVerificationToken {
+			t.Errorf("Expected ErrInvalidVerificationToken for the old address, got %v", err)
+		}
+		if u, _ := GetUserByID(db, user.ID); u.EmailVerified {
+			t.Error("Expected the new address to stay unverified")
+		}
+		if vt, _ := GetVerificationToken(db, stale); vt == nil || vt.Used {
+			t.Error("Expected a token that verified nothing to stay unused")
+		}
+	})
+
+	// Resends are limited per address
+	t.Run("resends are limited per address", func(t *testing.T) {
+		for i := 0; i < MaxVerificationEmails; i++ {
+			if err := SendAccountVerification(db, svc, user.ID, now); err != nil {
+				t.Fatalf("Resend %d failed: %v", i+1, err)
+			}
+		}
+		if err := SendAccountVerification(db, svc, user.ID, now); err != ErrTooManyVerificationEmails {
+			t.Errorf("Expected ErrTooManyVerificationEmails, got %v", err)
+		}
+		if err := SendAccountVerification(db, svc, user.ID, now.Add(25*time.Hour)); err != nil {
+			t.Errorf("Expected a resend the next day to work, got %v", err)
+		}
+
+	})
+
+	// Anonymous subscriptions wait for the confirmation link
+	t.Run("anonymous subscriptions wait for the confirmation link", func(t *testing.T) {
+		if err := SubscribeWithConfirmation(db, svc, "Reader@example.com", "Robin", "digital-detox", 0, now); err != nil {
+			t.Fatalf("SubscribeWithConfirmation failed: %v", err)
+		}
+		var status string
+		db.QueryRow("SELECT status FROM email_subscriptions WHERE email = 'reader@example.com'").Scan(&status)
+		if status != "pending" {
+			t.Errorf("Expected a pending subscription, got %q", status)
+		}
+		assertCampaignRecipients(t, 0)
+		vt, err := ConfirmEmail(db, lastVerificationToken(t, svc, "reader@example.com"), now)
+		if err != nil {
+			t.Fatalf("ConfirmEmail failed: %v", err)
+		}
+		if vt.CampaignID != "digital-detox" {
+			t.Errorf("Expected a digital-detox token, got %q", vt.CampaignID)
+		}
+		db.QueryRow("SELECT status FROM email_subscriptions WHERE email = 'reader@example.com'").Scan(&status)
+		if status != "active" {
+			t.Errorf("Expected the confirmed subscription to be active, got %q", status)
+		}
+		assertCampaignRecipients(t, 1)
+
+	})
+
+	// Subscribing again once active sends nothing
+	t.Run("subscribing again once active sends nothing", func(t *testing.T) {
+		before, _ := svc.(*email.SMTPEmailService).Mailbox().Messages()
+		if err := SubscribeWithConfirmation(db, svc, "reader@example.com", "Robin", "digital-detox", 0, now); err != nil {
+			t.Fatalf("SubscribeWithConfirmation failed: %v", err)
+		}
+		if after, _ := svc.(*email.SMTPEmailService).Mailbox().Messages(); len(after) != len(before) {
+			t.Errorf("Expected no email for an active subscription, got %d new", len(after)-len(before))
+		}
+
+	})
+
+	// Tokens are purged a day after they expire
+	t.Run("expired tokens are purged", func(t *testing.T) {
+		purged, err := PurgeExpiredVerificationTokens(db, now.Add(VerificationTokenExpiry+25*time.Hour))
+		if err != nil {
+			t.Fatalf("PurgeExpiredVerificationTokens failed: %v", err)
+		}
+		if purged == 0 {
+			t.Error("Expected expired tokens to be purged")
+		}
+	})
+}
+
+func TestMigrateEmailSubscriptionStatuses(t *testing.T) {
+	db := setupHabitTestDB(t)
+	defer db.Close()
+
+	// A table from before double opt-in only allowed active and unsubscribed
+	_, err := db.Exec(`
+		DROP TABLE email_subscriptions;
+		CREATE TABLE email_subscriptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NULL REFERENCES users(id) ON DELETE CASCADE,
+			email TEXT NOT NULL,
+			campaign_id TEXT NOT NULL,
+			token TEXT NOT NULL,
+			subscribed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			status TEXT NOT NULL CHECK (status IN ('active', 'unsubscribed')) DEFAULT 'active',
+			last_email_sent INTEGER DEFAULT 0,
+			unsubscribed_at TIMESTAMP NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(email, campaign_id)
+		);
+		INSERT INTO email_subscriptions (email, campaign_id, token, last_email_sent) VALUES ('reader@example.com', 'tips', 'x', 2);
+		INSERT INTO email_sends (subscription_id, email_number, template_name, subject, status) VALUES (1, 2, 't', 's', 'success');
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create old table: %v", err)
+	}
+
+	if err := MigrateDB(db); err != nil {
+		t.Fatalf("MigrateDB failed: %v", err)
+	}
+	if _, err := db.Exec("UPDATE email_subscriptions SET status = 'pending' WHERE id = 1"); err != nil {
+		t.Errorf("Expected the pending status to be allowed, got %v", err)
+	}
+	var lastSent, sends int
+	db.QueryRow("SELECT last_email_sent FROM email_subscriptions WHERE id = 1").Scan(&lastSent)
+	db.QueryRow("SELECT COUNT(*) FROM email_sends s JOIN email_subscriptions es ON es.id = s.subscription_id").Scan(&sends)
+	if lastSent != 2 || sends != 1 {
+		t.Errorf("Expected the subscription and its sends to be kept, got last email %d and %d sends", lastSent, sends)
+	}
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// webhookClient posts notifications to users' webhooks; a slow endpoint mustn't hold up the scheduler.
+// The URLs are user supplied, so it only connects to public addresses.
+var webhookClient = &http.Client{
+	Timeout:   5 * time.Second,
+	Transport: &http.Transport{DialContext: dialPublic, TLSHandshakeTimeout: 5 * time.Second},
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which net.IP doesn't count as private
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether the address is reachable on the internet rather than loopback, private,
+// link-local (cloud metadata endpoints live there) or otherwise internal
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+// dialPublic resolves the host and dials it only when every address is public. The vetted address is the one
+// dialled, so the name can't resolve somewhere else in between.
+func dialPublic(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+	for _, ip := range ips {
+		if !publicIP(ip.IP) {
+			return nil, fmt.Errorf("refusing to connect to %s: %s is not a public address", host, ip.IP)
+		}
+	}
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+}
+
+// WebhookNotification is the JSON body posted to a user's webhook
+type WebhookNotification struct {
+	Type    string                 `json:"type"` // one of the notification types, e.g. "reminder"
+	Message string                 `json:"message"`
+	Link    string                 `json:"link"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	SentAt  time.Time              `json:"sent_at"`
+}
+
+// webhookHabit is a habit in a webhook notification
+type webhookHabit struct {
+	Name   string `json:"name"`
+	Emoji  string `json:"emoji"`
+	Streak int    `json:"streak"`
+}
+
+// postWebhook delivers the notification, failing on anything but a 2xx response
+func postWebhook(url string, n WebhookNotification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "TheHabitsCompany-Webhook/1.0")
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting webhook: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// reminderWebhook builds the webhook notification for a reminder or streak nudge about the habits
+func reminderWebhook(kind string, habits []Habit, now time.Time) WebhookNotification {
+	names := make([]string, 0, len(habits))
+	data := make([]webhookHabit, 0, len(habits))
+	for _, h := range habits {
+		name := strings.TrimSpace(h.Emoji + " " + h.Name)
+		if kind == NotificationStreakNudge {
+			name = fmt.Sprintf("%s (%d days)", name, h.CurrentStreak)
+		}
+		names = append(names, name)
+		data = append(data, webhookHabit{Name: h.Name, Emoji: h.Emoji, Streak: h.CurrentStreak})
+	}
+
+	message := fmt.Sprintf("Still to log today: %s", strings.Join(names, ", "))
+	if kind == NotificationStreakNudge {
+		message = fmt.Sprintf("Streaks ending tonight: %s", strings.Join(names, ", "))
+	}
+	return WebhookNotification{
+		Type:    kind,
+		Message: message,
+		Link:    "https://habits.co",
+		Data:    map[string]interface{}{"habits": data},
+		SentAt:  now.UTC(),
+	}
+}
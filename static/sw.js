@@ -1,12 +1,22 @@
-const CACHE_NAME = 'habits-v1';
+const CACHE_NAME = 'habits-v3';
+const QUEUE_DB_NAME = 'habits-offline';
+const QUEUE_STORE = 'habit-log-queue';
+const QUEUE_SYNC_TAG = 'habit-log-replay';
+const HABIT_LOGS_URL = '/api/habits/logs';
+
+// App shell needed to render the home grid without a connection
 const ASSETS_TO_CACHE = [
   '/',
+  '/manifest.json',
   '/static/manifest.json',
+  '/static/favicon.png',
+  '/icons/icon-192.png',
+  '/icons/icon-512.png',
   'https://cdn.jsdelivr.net/npm/alpinejs@3.x.x/dist/cdn.min.js',
+  'https://cdn.jsdelivr.net/npm/@alpinejs/collapse@3.x.x/dist/cdn.min.js',
   'https://cdn.tailwindcss.com',
-  'https://unpkg.com/@emoji-mart/data@latest/sets/14/native.json',
-  'https://unpkg.com/emoji-mart@latest/dist/browser.js',
-  'https://unpkg.com/sortablejs@latest/Sortable.min.js',
+  'https://cdn.jsdelivr.net/npm/sortablejs@latest/Sortable.min.js',
+  'https://cdn.jsdelivr.net/npm/canvas-confetti@1.6.0/dist/confetti.browser.min.js',
   '/static/js/browser.js',
   '/static/js/native.json'
 ];
@@ -15,7 +25,13 @@ const ASSETS_TO_CACHE = [
 self.addEventListener('install', (event) => {
   event.waitUntil(
     caches.open(CACHE_NAME)
-      .then((cache) => cache.addAll(ASSETS_TO_CACHE))
+      .then((cache) => Promise.all(
+        // Cache what we can; one unreachable CDN must not block installation
+        ASSETS_TO_CACHE.map((url) => cache.add(url).catch((err) => {
+          console.warn('Failed to cache', url, err);
+        }))
+      ))
+      .then(() => self.skipWaiting())
   );
 });
 
@@ -31,29 +47,220 @@ self.addEventListener('activate', (event) => {
         })
       );
     })
+    .then(() => self.clients.claim())
+    .then(() => replayQueue())
   );
 });
 
 // Fetch event
 self.addEventListener('fetch', (event) => {
-  event.respondWith(
-    caches.match(event.request)
-      .then((response) => {
-        if (response) {
-          return response;
-        }
-        return fetch(event.request)
-          .then((response) => {
-            if (!response || response.status !== 200 || response.type !== 'basic') {
-              return response;
-            }
-            const responseToCache = response.clone();
-            caches.open(CACHE_NAME)
-              .then((cache) => {
-                cache.put(event.request, responseToCache);
-              });
-            return response;
-          });
-      })
-  );
-}); 
\ No newline at end of file
+  const request = event.request;
+  const url = new URL(request.url);
+
+  // Habit log writes are queued when offline
+  if (request.method === 'POST' && url.origin === self.location.origin && url.pathname === HABIT_LOGS_URL) {
+    event.respondWith(sendOrQueueHabitLog(request));
+    return;
+  }
+
+  if (request.method !== 'GET') {
+    return;
+  }
+
+  // API reads belong to the signed-in user, so they're never cached where the next user of the browser could
+  // read them
+  if (url.origin === self.location.origin && url.pathname.startsWith('/api/')) {
+    return;
+  }
+
+  // Pages: network first so data stays fresh, cache as fallback
+  if (request.mode === 'navigate') {
+    event.respondWith(networkFirst(request));
+    return;
+  }
+
+  // Static assets: cache first
+  event.respondWith(cacheFirst(request));
+});
+
+// Replay the queue when the browser regains connectivity (Background Sync)
+self.addEventListener('sync', (event) => {
+  if (event.tag === QUEUE_SYNC_TAG) {
+    event.waitUntil(replayQueue());
+  }
+});
+
+// Pages post a message when they come back online, for browsers without Background Sync
+self.addEventListener('message', (event) => {
+  if (event.data && event.data.type === 'replay-habit-logs') {
+    event.waitUntil(replayQueue());
+  }
+});
+
+async function networkFirst(request) {
+  try {
+    const response = await fetch(request);
+    if (response && response.status === 200 && response.type === 'basic') {
+      const cache = await caches.open(CACHE_NAME);
+      cache.put(request, response.clone());
+    }
+    return response;
+  } catch (err) {
+    const cached = await caches.match(request);
+    if (cached) {
+      return cached;
+    }
+    if (request.mode === 'navigate') {
+      const shell = await caches.match('/');
+      if (shell) {
+        return shell;
+      }
+    }
+    throw err;
+  }
+}
+
+async function cacheFirst(request) {
+  const cached = await caches.match(request);
+  if (cached) {
+    return cached;
+  }
+  const response = await fetch(request);
+  if (response && response.status === 200 && (response.type === 'basic' || response.type === 'cors')) {
+    const cache = await caches.open(CACHE_NAME);
+    cache.put(request, response.clone());
+  }
+  return response;
+}
+
+// sendOrQueueHabitLog sends a habit log write, queueing it with its idempotency key if the network is down
+async function sendOrQueueHabitLog(request) {
+  const body = await request.text();
+  const headers = {};
+  request.headers.forEach((value, key) => {
+    headers[key] = value;
+  });
+  // The key is fixed before the first attempt so a write that reached the server is never applied twice
+  if (!headers['idempotency-key']) {
+    headers['idempotency-key'] = self.crypto.randomUUID();
+  }
+
+  try {
+    return await fetch(HABIT_LOGS_URL, {
+      method: 'POST',
+      headers: headers,
+      body: body,
+      credentials: 'same-origin'
+    });
+  } catch (err) {
+    await enqueue({ headers: headers, body: body, queuedAt: Date.now() });
+    if (self.registration.sync) {
+      self.registration.sync.register(QUEUE_SYNC_TAG).catch(() => {});
+    }
+
+    let data;
+    try {
+      data = JSON.parse(body);
+    } catch (parseErr) {
+      data = null;
+    }
+    return new Response(JSON.stringify({
+      success: true,
+      queued: true,
+      message: 'Saved offline. It will sync when you are back online.',
+      data: data
+    }), {
+      status: 202,
+      headers: { 'Content-Type': 'application/json' }
+    });
+  }
+}
+
+// replayQueue sends queued habit logs in the order they were recorded. Activation, Background Sync and pages
+// coming back online can all ask for a replay at once, so only one runs at a time.
+let replaying = null;
+
+function replayQueue() {
+  if (!replaying) {
+    replaying = replayQueuedLogs().finally(() => {
+      replaying = null;
+    });
+  }
+  return replaying;
+}
+
+async function replayQueuedLogs() {
+  const entries = await readQueue();
+  let replayed = 0;
+  for (const entry of entries) {
+    let response;
+    try {
+      response = await fetch(HABIT_LOGS_URL, {
+        method: 'POST',
+        headers: entry.headers,
+        body: entry.body,
+        credentials: 'same-origin',
+        // An expired session redirects to the login page, which must not count as the log being saved
+        redirect: 'manual'
+      });
+    } catch (err) {
+      // Still offline; keep the rest of the queue for the next attempt
+      break;
+    }
+
+    // Expired sessions, writes still in progress and server errors are retried later; other client errors
+    // will never succeed so they are dropped
+    if (response.type === 'opaqueredirect' || response.status === 401 || response.status === 403 ||
+        response.status === 409 || response.status >= 500) {
+      break;
+    }
+    await dequeue(entry.id);
+    replayed++;
+  }
+
+  if (replayed > 0) {
+    const clients = await self.clients.matchAll({ type: 'window' });
+    clients.forEach((client) => client.postMessage({ type: 'habit-logs-replayed', count: replayed }));
+  }
+}
+
+function openQueue() {
+  return new Promise((resolve, reject) => {
+    const open = indexedDB.open(QUEUE_DB_NAME, 1);
+    open.onupgradeneeded = () => {
+      open.result.createObjectStore(QUEUE_STORE, { keyPath: 'id', autoIncrement: true });
+    };
+    open.onsuccess = () => resolve(open.result);
+    open.onerror = () => reject(open.error);
+  });
+}
+
+async function enqueue(entry) {
+  const db = await openQueue();
+  return new Promise((resolve, reject) => {
+    const tx = db.transaction(QUEUE_STORE, 'readwrite');
+    tx.objectStore(QUEUE_STORE).add(entry);
+    tx.oncomplete = () => resolve();
+    tx.onerror = () => reject(tx.error);
+  });
+}
+
+async function readQueue() {
+  const db = await openQueue();
+  return new Promise((resolve, reject) => {
+    const tx = db.transaction(QUEUE_STORE, 'readonly');
+    const req = tx.objectStore(QUEUE_STORE).getAll();
+    req.onsuccess = () => resolve(req.result || []);
+    req.onerror = () => reject(req.error);
+  });
+}
+
+async function dequeue(id) {
+  const db = await openQueue();
+  return new Promise((resolve, reject) => {
+    const tx = db.transaction(QUEUE_STORE, 'readwrite');
+    tx.objectStore(QUEUE_STORE).delete(id);
+    tx.oncomplete = () => resolve();
+    tx.onerror = () => reject(tx.error);
+  });
+}
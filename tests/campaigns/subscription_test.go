@@ -68,6 +68,9 @@ func TestSubscriptionFlow(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error initializing email service: %v", err)
 	}
+	if err := email.LoadCampaigns("../../ui/email"); err != nil {
+		t.Fatalf("Error loading campaigns: %v", err)
+	}
 
 	// Create campaign manager
 	campaignManager := email.NewCampaignManager(db, emailService)
@@ -70,6 +70,9 @@ func TestUnsubscribeFlow(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error initializing email service: %v", err)
 	}
+	if err := email.LoadCampaigns("../../ui/email"); err != nil {
+		t.Fatalf("Error loading campaigns: %v", err)
+	}
 
 	// Create campaign manager
 	campaignManager := email.NewCampaignManager(db, emailService)
@@ -124,6 +124,9 @@ func subscribeUserToCampaignForDeletion(db *sql.DB, user *models.User) error {
 	if err != nil {
 		return fmt.Errorf("could not initialize email service: %w", err)
 	}
+	if err := email.LoadCampaigns("../../ui/email"); err != nil {
+		return fmt.Errorf("could not load campaigns: %w", err)
+	}
 
 	// Create campaign manager
 	campaignManager := email.NewCampaignManager(db, emailService)
@@ -49,23 +49,13 @@ func (s *TestEmailService) SendPasswordResetSuccessEmail(to, firstName string) e
 }
 
 // SendReminderEmail redirects the reminder email to the test recipient
-func (s *TestEmailService) SendReminderEmail(to, firstName string, habits []email.HabitInfo, quote email.QuoteInfo) error {
+func (s *TestEmailService) SendReminderEmail(to, firstName string, habits []email.HabitInfo, quote email.QuoteInfo, insight, preferencesLink string) error {
 	fmt.Printf("📧 Sending daily reminder email to %s (originally for: %s)\n", s.testRecipient, to)
 	s.emailsSent["daily_reminder"]++
 
 	// Create a modified first name that includes the original recipient
 	modifiedFirstName := fmt.Sprintf("%s (Original: %s)", firstName, to)
-	return s.baseService.SendReminderEmail(s.testRecipient, modifiedFirstName, habits, quote)
-}
-
-// SendFirstHabitEmail redirects the first habit email to the test recipient
-func (s *TestEmailService) SendFirstHabitEmail(to, firstName string, quote email.QuoteInfo) error {
-	fmt.Printf("📧 Sending first habit email to %s (originally for: %s)\n", s.testRecipient, to)
-	s.emailsSent["first_habit"]++
-
-	// Create a modified first name that includes the original recipient
-	modifiedFirstName := fmt.Sprintf("%s (Original: %s)", firstName, to)
-	return s.baseService.SendFirstHabitEmail(s.testRecipient, modifiedFirstName, quote)
+	return s.baseService.SendReminderEmail(s.testRecipient, modifiedFirstName, habits, quote, insight, preferencesLink)
 }
 
 // SendSimpleEmail redirects a simple email to the test recipient
@@ -184,12 +174,6 @@ func runSimulation(scheduler *models.Scheduler, days, timeScale int) {
 		fmt.Println("🔔 Running daily habit reminders...")
 		scheduler.RunDailyRemindersNow()
 
-		// On Sundays, also run the weekly job
-		if simulatedDate.Weekday() == time.Sunday {
-			fmt.Println("🔔 Running weekly first habit reminders (it's Sunday)...")
-			scheduler.RunWeeklyFirstHabitRemindersNow()
-		}
-
 		// Wait for the specified time scale before moving to the next day
 		if day < days {
 			fmt.Printf("⏳ Waiting %d seconds before next day...\n", timeScale)
@@ -213,9 +197,6 @@ func printSummary(stats map[string]int) {
 	if count, ok := stats["daily_reminder"]; ok {
 		fmt.Printf("📅 Daily reminder emails: %d\n", count)
 	}
-	if count, ok := stats["first_habit"]; ok {
-		fmt.Printf("🌱 First habit emails: %d\n", count)
-	}
 	if count, ok := stats["welcome"]; ok {
 		fmt.Printf("👋 Welcome emails: %d\n", count)
 	}
@@ -232,25 +213,8 @@ func printSummary(stats map[string]int) {
 
 // createEmailService creates the base email service
 func createEmailService(rootDir string) (email.EmailService, error) {
-	// Get SMTP settings from environment variables
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpUsername := os.Getenv("SMTP_USERNAME")
-	smtpPassword := os.Getenv("SMTP_PASSWORD")
-	fromEmail := os.Getenv("SMTP_FROM_EMAIL")
-	fromName := os.Getenv("SMTP_FROM_NAME")
-
 	// Create email service
-	return email.NewSMTPEmailService(email.SMTPConfig{
-		Host:        smtpHost,
-		Port:        587,
-		Username:    smtpUsername,
-		Password:    smtpPassword,
-		FromName:    fromName,
-		FromEmail:   fromEmail,
-		TemplateDir: filepath.Join(rootDir, "ui/email"),
-		Secure:      true,
-		RequireTLS:  true,
-	})
+	return email.NewSMTPEmailService(email.SMTPConfigFromEnv(filepath.Join(rootDir, "ui/email")))
 }
 
 // findRootDir attempts to find the root directory of the project
@@ -6,8 +6,10 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"mad/models"
+	"mad/models/email"
 )
 
 // AdminDashboardHandler handles the admin dashboard page
@@ -56,22 +58,67 @@ func AdminDashboardHandler(db *sql.DB, templates *template.Template) http.Handle
 			allowSignups = true // Default to allowing signups
 		}
 
+		outboxStats, err := email.GetOutboxStats(db, time.Now())
+		if err != nil {
+			log.Printf("Error getting outbox stats: %v", err)
+		}
+		outboxFailures, err := email.GetOutboxFailures(db, 20)
+		if err != nil {
+			log.Printf("Error getting outbox failures: %v", err)
+			outboxFailures = []email.OutboxFailure{}
+		}
+
+		suppressions, err := email.GetSuppressions(db, 50)
+		if err != nil {
+			log.Printf("Error getting suppressions: %v", err)
+			suppressions = []email.Suppression{}
+		}
+		suppressionCounts, err := email.CountSuppressions(db)
+		if err != nil {
+			log.Printf("Error counting suppressions: %v", err)
+		}
+
+		segments, err := models.GetSegmentSummaries(db, time.Now())
+		if err != nil {
+			log.Printf("Error getting segments: %v", err)
+		}
+		triggeredCampaigns, err := models.GetTriggeredCampaignSummaries(db, time.Now())
+		if err != nil {
+			log.Printf("Error getting triggered campaigns: %v", err)
+		}
+
 		data := struct {
-			User           *models.User
-			Users          []*models.User
-			TotalUsers     int
-			TotalHabits    int
-			TotalHabitLogs int
-			TotalGoals     int
-			AllowSignups   bool
+			User               *models.User
+			Users              []*models.User
+			TotalUsers         int
+			TotalHabits        int
+			TotalHabitLogs     int
+			TotalGoals         int
+			AllowSignups       bool
+			Outbox             email.OutboxStats
+			OutboxFailures     []email.OutboxFailure
+			Suppressions       []email.Suppression
+			SuppressionCounts  map[string]int
+			Segments           []models.SegmentSummary
+			Campaigns          []email.EmailCampaign
+			TriggeredCampaigns []models.TriggeredCampaignSummary
+			FrequencyCap       email.FrequencyCap
 		}{
-			User:           user,
-			Users:          users,
-			TotalUsers:     totalUsers,
-			TotalHabits:    totalHabits,
-			TotalHabitLogs: totalHabitLogs,
-			TotalGoals:     totalGoals,
-			AllowSignups:   allowSignups,
+			User:               user,
+			Users:              users,
+			TotalUsers:         totalUsers,
+			TotalHabits:        totalHabits,
+			TotalHabitLogs:     totalHabitLogs,
+			TotalGoals:         totalGoals,
+			AllowSignups:       allowSignups,
+			Outbox:             outboxStats,
+			OutboxFailures:     outboxFailures,
+			Suppressions:       suppressions,
+			SuppressionCounts:  suppressionCounts,
+			Segments:           segments,
+			Campaigns:          email.GetAllCampaigns(),
+			TriggeredCampaigns: triggeredCampaigns,
+			FrequencyCap:       email.GetFrequencyCap(),
 		}
 
 		renderTemplate(w, templates, "admin.html", data)
@@ -91,3 +138,169 @@ func AdminDownloadDBHandler() http.HandlerFunc {
 		http.ServeFile(w, r, dbPath)
 	}
 }
+
+// AdminMailHandler shows the emails kept by the memory and file mail transports
+func AdminMailHandler(db *sql.DB, templates *template.Template, emailService email.EmailService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := getAuthenticatedUser(r, db)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := struct {
+			User      *models.User
+			Transport string
+			Captures  bool
+			Messages  []email.CapturedMessage
+			Selected  email.CapturedMessage
+			Error     string
+		}{
+			User:      user,
+			Transport: "none",
+		}
+
+		var mailbox email.Mailbox
+		if smtpService, ok := emailService.(*email.SMTPEmailService); ok {
+			data.Transport = smtpService.TransportName()
+			mailbox = smtpService.Mailbox()
+		}
+		if mailbox != nil {
+			data.Captures = true
+			data.Messages, err = mailbox.Messages()
+			if err != nil {
+				log.Printf("Error reading captured emails: %v", err)
+				data.Error = "Could not read the captured emails"
+			}
+		}
+
+		if id := r.URL.Query().Get("id"); id != "" && mailbox != nil {
+			data.Selected, err = mailbox.Message(id)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+		} else if len(data.Messages) > 0 {
+			data.Selected = data.Messages[0]
+		}
+
+		renderTemplate(w, templates, "admin-mail.html", data)
+	}
+}
+
+// AdminCampaignAnalyticsHandler shows sends, failures, opens, clicks and unsubscribes for each campaign email,
+// and how far subscribers get through each campaign
+func AdminCampaignAnalyticsHandler(db *sql.DB, templates *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := getAuthenticatedUser(r, db)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		campaigns, err := email.GetCampaignAnalytics(db)
+		if err != nil {
+			log.Printf("Error getting campaign analytics: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := struct {
+			User      *models.User
+			Campaigns []email.CampaignAnalytics
+		}{
+			User:      user,
+			Campaigns: campaigns,
+		}
+
+		renderTemplate(w, templates, "admin-campaigns.html", data)
+	}
+}
+
+// AdminBroadcastsHandler shows the broadcast composer and the progress of recent broadcasts
+func AdminBroadcastsHandler(db *sql.DB, templates *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := getAuthenticatedUser(r, db)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		broadcasts, err := models.GetBroadcasts(db, 20)
+		if err != nil {
+			log.Printf("Error getting broadcasts: %v", err)
+			broadcasts = []models.Broadcast{}
+		}
+		inProgress := false
+		for _, b := range broadcasts {
+			if b.Status == models.BroadcastScheduled || b.Status == models.BroadcastSending {
+				inProgress = true
+			}
+		}
+
+		data := struct {
+			User       *models.User
+			Broadcasts []models.Broadcast
+			InProgress bool
+			Segments   []email.Segment
+		}{
+			User:       user,
+			Broadcasts: broadcasts,
+			InProgress: inProgress,
+			Segments:   models.BroadcastSegments(),
+		}
+
+		renderTemplate(w, templates, "admin-broadcasts.html", data)
+	}
+}
+
+// AdminTemplatesHandler previews every email template and campaign email with sample data, flagging missing
+// text parts and templates that don't render
+func AdminTemplatesHandler(db *sql.DB, templates *template.Template, emailService email.EmailService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := getAuthenticatedUser(r, db)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		smtpService, ok := emailService.(*email.SMTPEmailService)
+		if !ok {
+			http.Error(w, "Email service not available", http.StatusInternalServerError)
+			return
+		}
+		previews, err := smtpService.PreviewTemplates()
+		if err != nil {
+			log.Printf("Error previewing email templates: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := struct {
+			User     *models.User
+			Previews []email.TemplatePreview
+			Problems int
+			Selected email.TemplatePreview
+		}{
+			User:     user,
+			Previews: previews,
+		}
+		for _, preview := range previews {
+			if len(preview.Problems) > 0 {
+				data.Problems++
+			}
+		}
+
+		if id := r.URL.Query().Get("id"); id != "" {
+			data.Selected, err = smtpService.PreviewTemplate(id)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+		} else if len(previews) > 0 {
+			data.Selected = previews[0]
+		}
+
+		renderTemplate(w, templates, "admin-templates.html", data)
+	}
+}
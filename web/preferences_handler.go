@@ -0,0 +1,128 @@
+package web
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"mad/models"
+)
+
+// preferencesPageData is the data for the public notification preferences page
+type preferencesPageData struct {
+	Email       string
+	Token       string
+	Types       []models.NotificationType
+	Preferences *models.NotificationPreferences
+	Campaigns   []models.CampaignPreference
+	Saved       bool
+	Quote       struct {
+		Text   string
+		Author string
+	}
+}
+
+// renderPreferencesPage shows the notification preferences of the user the email link was sent to
+func renderPreferencesPage(w http.ResponseWriter, db *sql.DB, templates *template.Template, userID int, userEmail, token string, saved bool) {
+	prefs, err := models.GetNotificationPreferences(db, userID)
+	if err != nil {
+		log.Printf("Error getting notification preferences: %v", err)
+		http.Error(w, "Error loading preferences", http.StatusInternalServerError)
+		return
+	}
+	campaigns, err := models.GetCampaignPreferences(db, userEmail)
+	if err != nil {
+		log.Printf("Error getting campaign preferences: %v", err)
+		http.Error(w, "Error loading preferences", http.StatusInternalServerError)
+		return
+	}
+
+	data := preferencesPageData{
+		Email:       userEmail,
+		Token:       token,
+		Types:       models.NotificationTypes,
+		Preferences: prefs,
+		Campaigns:   campaigns,
+		Saved:       saved,
+	}
+	data.Quote.Text = "Small habits make big changes."
+	data.Quote.Author = "The Habits Company"
+	renderTemplate(w, templates, "preferences.html", data)
+}
+
+// preferencesPageHandler handles the public preferences page linked from email footers. It is reached through
+// /unsubscribe without a campaign, and authenticated by the user's preferences token instead of a session.
+func preferencesPageHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, templates *template.Template) {
+	if r.Method == http.MethodGet {
+		userEmail := r.URL.Query().Get("email")
+		token := r.URL.Query().Get("token")
+		userID, err := models.GetUserIDByPreferencesToken(db, userEmail, token)
+		if err != nil {
+			log.Printf("Invalid preferences link for email=%s", userEmail)
+			http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+			return
+		}
+		renderPreferencesPage(w, db, templates, userID, userEmail, token, false)
+		return
+	}
+
+	// Apply rate limiting - 10 attempts per hour per IP
+	remaining, resetTime, err := UnsubscribeLimiter.CheckLimit(r)
+	if err != nil {
+		log.Printf("Rate limit check error: %v", err)
+		http.Error(w, "Error processing request", http.StatusInternalServerError)
+		return
+	}
+	if remaining == 0 {
+		waitDuration := time.Until(resetTime)
+		http.Error(w, fmt.Sprintf("Too many attempts. Please try again in %d minutes.", int(waitDuration.Minutes())+1), http.StatusTooManyRequests)
+		return
+	}
+
+	formEmail := r.PostFormValue("email")
+	formToken := r.PostFormValue("token")
+	userID, err := models.GetUserIDByPreferencesToken(db, formEmail, formToken)
+	if err != nil {
+		log.Printf("Invalid preferences token for email=%s", formEmail)
+		http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+
+	prefs, err := models.GetNotificationPreferences(db, userID)
+	if err != nil {
+		log.Printf("Error getting notification preferences: %v", err)
+		http.Error(w, "Error saving preferences", http.StatusInternalServerError)
+		return
+	}
+
+	// The page manages email only; other channels and quiet hours need the settings page
+	prefs.Enabled = r.PostFormValue("enabled") == "on"
+	for _, t := range models.NotificationTypes {
+		prefs.Channels[t.Key][models.ChannelEmail] = r.PostFormValue("email_"+t.Key) == "on"
+	}
+	prefs.DigestFrequency = r.PostFormValue("digest_frequency")
+	if err := prefs.Save(db); err != nil {
+		log.Printf("Error saving notification preferences: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	campaigns, err := models.GetCampaignPreferences(db, formEmail)
+	if err != nil {
+		log.Printf("Error getting campaign preferences: %v", err)
+		http.Error(w, "Error saving preferences", http.StatusInternalServerError)
+		return
+	}
+	for _, c := range campaigns {
+		subscribed := r.PostFormValue("campaign_"+c.ID) == "on"
+		if err := models.SetCampaignPreference(db, formEmail, c.ID, userID, subscribed); err != nil {
+			log.Printf("Error updating campaign %s for %s: %v", c.ID, formEmail, err)
+		}
+	}
+
+	log.Printf("Updated notification preferences for user %d", userID)
+	renderPreferencesPage(w, db, templates, userID, formEmail, formToken, true)
+}
@@ -37,6 +37,7 @@ func SetupRoutes(db *sql.DB, templates *template.Template, emailService email.Em
 	http.Handle("/roadmap", sessionMiddleware(RoadmapHandler(db, templates)))
 	http.Handle("/forgot", sessionMiddleware(ForgotPasswordHandler(db, templates)))
 	http.Handle("/reset", sessionMiddleware(ResetPasswordHandler(db, templates)))
+	http.Handle("/verify-email", sessionMiddleware(VerifyEmailHandler(db, templates)))
 
 	// New routes for module and lesson pages
 	http.Handle("/masterclass/", sessionMiddleware(authMiddleware(MasterclassModuleHandler(db, templates))))
@@ -45,11 +46,25 @@ func SetupRoutes(db *sql.DB, templates *template.Template, emailService email.Em
 	// Admin routes
 	http.Handle("/admin", sessionMiddleware(adminMiddleware(AdminDashboardHandler(db, templates))))
 	http.Handle("/admin/download-db", sessionMiddleware(adminMiddleware(AdminDownloadDBHandler())))
+	http.Handle("/admin/mail", sessionMiddleware(adminMiddleware(AdminMailHandler(db, templates, emailService))))
+	http.Handle("/admin/campaigns", sessionMiddleware(adminMiddleware(AdminCampaignAnalyticsHandler(db, templates))))
+	http.Handle("/admin/broadcasts", sessionMiddleware(adminMiddleware(AdminBroadcastsHandler(db, templates))))
+	http.Handle("/admin/templates", sessionMiddleware(adminMiddleware(AdminTemplatesHandler(db, templates, emailService))))
 
 	// Admin API routes
 	http.Handle("/admin/api/user/password", sessionMiddleware(adminMiddleware(api.AdminResetPasswordHandler(db))))
 	http.Handle("/admin/api/user/delete", sessionMiddleware(adminMiddleware(api.AdminDeleteUserHandler(db))))
 	http.Handle("/admin/api/toggle-signups", sessionMiddleware(adminMiddleware(api.ToggleSignupStatusHandler(db))))
+	http.Handle("/admin/api/outbox/retry", sessionMiddleware(adminMiddleware(api.AdminRetryOutboxHandler(db))))
+	http.Handle("/admin/api/segments/subscribe", sessionMiddleware(adminMiddleware(api.AdminSubscribeSegmentHandler(db))))
+	http.Handle("/admin/api/suppressions/lift", sessionMiddleware(adminMiddleware(api.AdminLiftSuppressionHandler(db))))
+	http.Handle("/admin/api/suppressions/add", sessionMiddleware(adminMiddleware(api.AdminSuppressHandler(db))))
+	http.Handle("/admin/api/broadcasts/preview", sessionMiddleware(adminMiddleware(api.AdminPreviewBroadcastHandler(db))))
+	http.Handle("/admin/api/broadcasts/test", sessionMiddleware(adminMiddleware(api.AdminTestBroadcastHandler(db))))
+	http.Handle("/admin/api/broadcasts/schedule", sessionMiddleware(adminMiddleware(api.AdminScheduleBroadcastHandler(db))))
+	http.Handle("/admin/api/broadcasts/cancel", sessionMiddleware(adminMiddleware(api.AdminCancelBroadcastHandler(db))))
+	http.Handle("/admin/api/broadcasts/progress", sessionMiddleware(adminMiddleware(api.AdminBroadcastProgressHandler(db))))
+	http.Handle("/admin/api/templates/test", sessionMiddleware(adminMiddleware(api.AdminTestTemplateHandler(db))))
 
 	// Utility routes
 	http.HandleFunc("/health", HealthCheckHandler(db))
@@ -62,7 +77,14 @@ func SetupRoutes(db *sql.DB, templates *template.Template, emailService email.Em
 	http.Handle("/api/user/settings", sessionMiddleware(authMiddleware(api.UpdateSettingsHandler(db))))
 	http.Handle("/api/user/reset-data", sessionMiddleware(authMiddleware(api.ResetDataHandler(db))))
 	http.Handle("/api/user/notifications", sessionMiddleware(authMiddleware(api.UpdateNotificationPreferenceHandler(db))))
+	http.Handle("/api/user/verify-email/resend", sessionMiddleware(authMiddleware(api.ResendVerificationHandler(db))))
 	http.Handle("/unsubscribe", sessionMiddleware(UnsubscribeHandler(db, emailService, templates)))
+	http.HandleFunc("/unsubscribe/one-click", OneClickUnsubscribeHandler(db))
+
+	// Email tracking routes, without sessions so opening an email sets no cookies
+	http.HandleFunc("/email/open", api.EmailOpenHandler(db))
+	http.HandleFunc("/email/click", api.EmailClickHandler(db))
+	http.HandleFunc("/api/email/feedback", api.EmailFeedbackHandler(db))
 
 	// Password reset API routes
 	http.Handle("/api/forgot-password", sessionMiddleware(api.ForgotPasswordHandler(db)))
@@ -20,7 +20,9 @@ func SetupStaticFileHandlers() {
 	})
 	http.HandleFunc("/sw.js", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Service-Worker-Allowed", "/")
-		http.ServeFile(w, r, "static/sw.js")
+		// Browsers must always revalidate the worker so cache and queue changes roll out promptly
+		w.Header().Set("Cache-Control", "no-cache")
+		serveStaticFileWithContentType(w, r, "static/sw.js", "application/javascript")
 	})
 
 	// Sitemap
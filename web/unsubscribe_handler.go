@@ -6,8 +6,10 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"time"
 
+	"mad/models"
 	"mad/models/email"
 )
 
@@ -29,6 +31,12 @@ func UnsubscribeHandler(db *sql.DB, emailService email.EmailService, templates *
 
 			log.Printf("Unsubscribe GET request: email=%s, campaign=%s, token=%s", userEmail, campaignID, token)
 
+			// Links without a campaign come from email footers and open the preferences page
+			if campaignID == "" && userEmail != "" && token != "" {
+				preferencesPageHandler(w, r, db, templates)
+				return
+			}
+
 			if userEmail == "" || campaignID == "" {
 				log.Printf("Missing query parameters: email=%s, campaign=%s", userEmail, campaignID)
 				http.Error(w, "Missing required parameters", http.StatusBadRequest)
@@ -49,6 +57,8 @@ func UnsubscribeHandler(db *sql.DB, emailService email.EmailService, templates *
 				CampaignName  string
 				CampaignEmoji string
 				Token         string
+				Send          string // the send the link came in and its signature, to count the unsubscribe against
+				SendSignature string
 				Quote         struct {
 					Text   string
 					Author string
@@ -60,6 +70,8 @@ func UnsubscribeHandler(db *sql.DB, emailService email.EmailService, templates *
 				CampaignName:  campaign.Name,
 				CampaignEmoji: campaign.Emoji,
 				Token:         token,
+				Send:          r.URL.Query().Get("s"),
+				SendSignature: r.URL.Query().Get("ssig"),
 				Quote: struct {
 					Text   string
 					Author string
@@ -89,6 +101,11 @@ func UnsubscribeHandler(db *sql.DB, emailService email.EmailService, templates *
 
 			log.Printf("Unsubscribe POST request: email=%s, campaign=%s, token=%s", formEmail, formCampaignID, formToken)
 
+			if formCampaignID == "" && formEmail != "" && formToken != "" {
+				preferencesPageHandler(w, r, db, templates)
+				return
+			}
+
 			if formEmail == "" || formCampaignID == "" || formToken == "" {
 				log.Printf("Missing form parameters: email=%s, campaign=%s, token=%s", formEmail, formCampaignID, formToken)
 				http.Error(w, "Missing required parameters", http.StatusBadRequest)
@@ -138,6 +155,7 @@ func UnsubscribeHandler(db *sql.DB, emailService email.EmailService, templates *
 			}
 
 			log.Printf("Successfully unsubscribed %s from campaign %s", formEmail, formCampaignID)
+			recordUnsubscribe(db, r.PostForm)
 
 			data := struct {
 				Success       bool
@@ -149,8 +167,10 @@ func UnsubscribeHandler(db *sql.DB, emailService email.EmailService, templates *
 					Text   string
 					Author string
 				}
-				Unsubscribed bool
-				Token        string
+				Unsubscribed  bool
+				Token         string
+				Send          string
+				SendSignature string
 			}{
 				Success:       true,
 				Email:         formEmail,
@@ -174,3 +194,67 @@ func UnsubscribeHandler(db *sql.DB, emailService email.EmailService, templates *
 		HandleNotAllowed(w, http.MethodGet, http.MethodPost)
 	}
 }
+
+// OneClickUnsubscribeHandler handles the RFC 8058 one-click unsubscribe URL in the List-Unsubscribe header of
+// bulk email. Mail clients POST to it and the address leaves the list straight away. It isn't rate limited,
+// since those POSTs come from a few mail provider servers, and the signed token already stops guessing.
+// Opening the URL in a browser shows the campaign's unsubscribe page or the preferences page instead, so link
+// scanners can't unsubscribe anyone.
+func OneClickUnsubscribeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userEmail := r.URL.Query().Get("email")
+		list := r.URL.Query().Get("list")
+		token := r.URL.Query().Get("token")
+		kind, id, err := email.ParseList(list)
+		if err != nil || !email.ValidUnsubscribeToken(userEmail, list, token) {
+			log.Printf("Invalid one-click unsubscribe for email=%s, list=%s", userEmail, list)
+			http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			if err := models.UnsubscribeFromList(db, userEmail, list); err != nil {
+				log.Printf("Error unsubscribing %s from %s: %v", userEmail, list, err)
+				http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("One-click unsubscribed %s from %s", userEmail, list)
+			if kind == email.ListCampaign {
+				recordUnsubscribe(db, r.URL.Query())
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprintln(w, "You have been unsubscribed.")
+
+		case http.MethodGet:
+			if kind == email.ListCampaign {
+				link := email.GenerateUnsubscribeLink(userEmail, id, token)
+				if sendID := email.UnsubscribeSend(r.URL.Query()); sendID != 0 {
+					link = email.UnsubscribeSendLink(link, sendID)
+				}
+				http.Redirect(w, r, link, http.StatusSeeOther)
+				return
+			}
+			user, err := models.GetUserByEmail(db, userEmail)
+			if err != nil {
+				http.Error(w, "Invalid or expired link", http.StatusNotFound)
+				return
+			}
+			http.Redirect(w, r, models.PreferencesLink(db, int(user.ID), user.Email), http.StatusSeeOther)
+
+		default:
+			HandleNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	}
+}
+
+// recordUnsubscribe counts an unsubscribe against the campaign email its link came in, if the link says which
+func recordUnsubscribe(db *sql.DB, values url.Values) {
+	sendID := email.UnsubscribeSend(values)
+	if sendID == 0 {
+		return
+	}
+	if err := email.RecordEmailEvent(db, sendID, email.EventUnsubscribe, ""); err != nil {
+		log.Printf("Error recording unsubscribe from send %d: %v", sendID, err)
+	}
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"mad/models"
+	"mad/models/email"
+)
+
+// verifyPageData is the data for the page confirming an email address
+type verifyPageData struct {
+	Token         string
+	Email         string
+	CampaignName  string // empty for account verification
+	CampaignEmoji string
+	ExpiryHours   int
+	Confirmed     bool
+	Error         string
+	Quote         models.Quote
+}
+
+// VerifyEmailHandler handles the links in verification and subscription confirmation emails. Opening the link
+// asks the reader to confirm with a button, so link scanners that follow it can't confirm an address for them.
+func VerifyEmailHandler(db *sql.DB, templates *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			HandleNotAllowed(w, http.MethodGet, http.MethodPost)
+			return
+		}
+
+		data := verifyPageData{
+			Token:       r.FormValue("token"),
+			ExpiryHours: int(models.VerificationTokenExpiry.Hours()),
+		}
+		if quote, err := models.GetRandomQuote(); err == nil {
+			data.Quote = quote
+		}
+
+		vt, err := models.GetVerificationToken(db, data.Token)
+		if err != nil || vt.Used || time.Now().After(vt.Expiry) {
+			if err != nil && err != sql.ErrNoRows {
+				log.Printf("Error getting verification token: %v", err)
+			}
+			data.Error = "This link is invalid, has already been used, or has expired."
+			w.WriteHeader(http.StatusBadRequest)
+			renderTemplate(w, templates, "verify.html", data)
+			return
+		}
+		data.Email = vt.Email
+		if vt.CampaignID != "" {
+			campaign, err := email.GetCampaign(vt.CampaignID)
+			if err != nil {
+				http.Error(w, "Invalid campaign", http.StatusNotFound)
+				return
+			}
+			data.CampaignName, data.CampaignEmoji = campaign.Name, campaign.Emoji
+		}
+
+		if r.Method == http.MethodPost {
+			if _, err := models.ConfirmEmail(db, data.Token, time.Now()); err != nil {
+				log.Printf("Error confirming %s: %v", vt.Email, err)
+				data.Error = "This link is invalid, has already been used, or has expired."
+				w.WriteHeader(http.StatusBadRequest)
+				renderTemplate(w, templates, "verify.html", data)
+				return
+			}
+			data.Confirmed = true
+		}
+		renderTemplate(w, templates, "verify.html", data)
+	}
+}